@@ -0,0 +1,459 @@
+// Command admin manages the chat backend's database: schema migrations,
+// fixtures, user accounts, and export and import of data. It reads the same
+// configuration as the server: the file named by -config or CONFIG_FILE.
+//
+//	go run ./cmd/admin [-config file] <command> [flags] [arguments]
+package main
+
+import (
+	"bufio"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"cs-socket/internal/config"
+	"cs-socket/internal/database"
+	"cs-socket/internal/repository"
+	"cs-socket/internal/services"
+)
+
+// admin is what the commands work with.
+type admin struct {
+	cfg   *config.Config
+	db    *sql.DB
+	users repository.UserRepository
+	in    *bufio.Reader
+}
+
+type command struct {
+	usage       string
+	description string
+	run         func(a *admin, args []string) error
+	// offline commands run without a database connection
+	offline bool
+}
+
+// commands is filled in by init, as the commands refer to it for their usage.
+var commands map[string]command
+
+func init() {
+	commands = map[string]command{
+		"config": {
+			usage:       "config print",
+			description: "Show the effective configuration with secrets redacted",
+			run:         (*admin).config,
+			offline:     true,
+		},
+		"migrate": {
+			usage:       "migrate up | down [-yes] [-force] [steps] | to [-yes] [-force] <version> | status",
+			description: "Apply, roll back or list schema migrations",
+			run:         (*admin).migrate,
+		},
+		"seed": {
+			usage:       "seed [-set name | -file path | -generate [-seed n] [-now time] [-customers n] [-agents n] [-chats n]]",
+			description: "Insert a fixture set (" + strings.Join(database.FixtureSetNames(), ", ") + "), a fixture file or generated data",
+			run:         (*admin).seed,
+		},
+		"reset": {
+			usage:       "reset [-yes] [-force] [-seed name]",
+			description: "Drop all data and recreate the schema",
+			run:         (*admin).reset,
+		},
+		"create-user": {
+			usage:       "create-user -username name -email address -name \"Full Name\" [-role role] [-password password]",
+			description: "Create a user account",
+			run:         (*admin).createUser,
+		},
+		"set-role": {
+			usage:       "set-role <username> <role>",
+			description: "Change a user's role",
+			run:         (*admin).setRole,
+		},
+		"reset-password": {
+			usage:       "reset-password [-password password] <username>",
+			description: "Set a new password for a user",
+			run:         (*admin).resetPassword,
+		},
+		"export": {
+			usage:       "export [-o file]",
+			description: "Write all data as JSON",
+			run:         (*admin).export,
+		},
+		"import": {
+			usage:       "import [-i file]",
+			description: "Load data written by export into an empty database",
+			run:         (*admin).importData,
+		},
+	}
+}
+
+func main() {
+	log.SetFlags(0)
+
+	global := flag.NewFlagSet("admin", flag.ExitOnError)
+	configFile := global.String("config", "", "YAML or TOML config file (default $CONFIG_FILE)")
+	global.Usage = func() { usage(global.Output()) }
+	global.Parse(os.Args[1:])
+	args := global.Args()
+
+	if len(args) == 0 || args[0] == "help" {
+		usage(os.Stdout)
+		return
+	}
+	cmd, ok := commands[args[0]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n", args[0])
+		usage(os.Stderr)
+		os.Exit(2)
+	}
+
+	// An invalid configuration can still be printed to find the problem
+	cfg, err := config.Load(*configFile)
+	if cfg == nil || (err != nil && !cmd.offline) {
+		log.Fatal(err)
+	}
+	a := &admin{cfg: cfg, in: bufio.NewReader(os.Stdin)}
+	if !cmd.offline {
+		db, err := database.Connect(cfg.Database)
+		if err != nil {
+			log.Fatal("Failed to connect to database: ", err)
+		}
+		defer db.Close()
+		a.db, a.users = db, repository.NewPostgres(db).Users
+	}
+
+	runErr := cmd.run(a, args[1:])
+	if errors.Is(runErr, flag.ErrHelp) {
+		return
+	}
+	if runErr == nil {
+		// Offline commands still fail on an invalid configuration
+		runErr = err
+	}
+	if runErr != nil {
+		if a.db != nil {
+			a.db.Close()
+		}
+		log.Fatal(runErr)
+	}
+}
+
+func usage(w io.Writer) {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Fprintln(w, "Usage: admin [-config file] <command> [flags] [arguments]")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "  -config file    YAML or TOML config file (default $CONFIG_FILE)")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Commands:")
+	for _, name := range names {
+		fmt.Fprintf(w, "  %-15s %s\n", name, commands[name].description)
+		fmt.Fprintf(w, "  %-15s   %s\n", "", commands[name].usage)
+	}
+}
+
+// flags returns a flag set for a command that reports errors instead of
+// exiting.
+func flags(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: admin "+commands[name].usage)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+func (a *admin) config(args []string) error {
+	if len(args) != 1 || args[0] != "print" {
+		return errors.New("usage: admin " + commands["config"].usage)
+	}
+	return a.cfg.Print(os.Stdout)
+}
+
+func (a *admin) migrate(args []string) error {
+	migrator, err := database.NewMigrator(a.db)
+	if err != nil {
+		return err
+	}
+	usage := errors.New("usage: admin " + commands["migrate"].usage)
+	if len(args) == 0 {
+		return usage
+	}
+
+	switch args[0] {
+	case "down", "to":
+		fs := flags("migrate")
+		yes := fs.Bool("yes", false, "do not ask for confirmation")
+		force := fs.Bool("force", false, "allow rolling back the baseline in release mode")
+		if err := fs.Parse(args[1:]); err != nil {
+			return err
+		}
+		rest := fs.Args()
+		applied, baseline, err := appliedMigrations(migrator)
+		if err != nil {
+			return err
+		}
+
+		var reverts bool
+		var run func() error
+		switch {
+		case args[0] == "down" && len(rest) <= 1:
+			steps := 1
+			if len(rest) == 1 {
+				if steps, err = strconv.Atoi(rest[0]); err != nil {
+					return fmt.Errorf("invalid number of steps %q", rest[0])
+				}
+			}
+			reverts = baseline && steps >= applied
+			run = func() error { return migrator.Down(steps) }
+		case args[0] == "to" && len(rest) == 1:
+			version, err := strconv.ParseInt(rest[0], 10, 64)
+			if err != nil {
+				return fmt.Errorf("invalid version %q", rest[0])
+			}
+			reverts = baseline && version == 0
+			run = func() error { return migrator.To(version) }
+		default:
+			return usage
+		}
+
+		// Rolling back the baseline drops every table
+		if reverts {
+			if err := a.confirmDestroy("roll back the baseline migration of", *yes, *force); err != nil {
+				return err
+			}
+		}
+		return run()
+	}
+
+	switch {
+	case args[0] == "up" && len(args) == 1:
+		return migrator.Up()
+	case args[0] == "status" && len(args) == 1:
+		statuses, err := migrator.Status()
+		if err != nil {
+			return err
+		}
+		for _, status := range statuses {
+			appliedAt := "-"
+			if status.AppliedAt != nil {
+				appliedAt = status.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Printf("%04d  %-30s  %-8s  %s\n", status.Version, status.Name, status.State, appliedAt)
+		}
+		return nil
+	}
+	return usage
+}
+
+func (a *admin) seed(args []string) error {
+	fs := flags("seed")
+	set := fs.String("set", "default", "fixture set to insert")
+	file := fs.String("file", "", "YAML or JSON fixture file to insert instead of a set")
+	generate := fs.Bool("generate", false, "insert generated data instead of a set")
+	opts := database.DefaultGenerateOptions
+	fs.Int64Var(&opts.Seed, "seed", opts.Seed, "random seed of the generated data")
+	fs.IntVar(&opts.Customers, "customers", opts.Customers, "number of generated customers")
+	fs.IntVar(&opts.Agents, "agents", opts.Agents, "number of generated agents")
+	fs.IntVar(&opts.Chats, "chats", opts.Chats, "number of generated chats")
+	fs.IntVar(&opts.MaxMessages, "messages", opts.MaxMessages, "maximum number of messages per generated chat")
+	fs.IntVar(&opts.Days, "days", opts.Days, "number of days the generated chats are spread over")
+	fs.Func("now", "time the generated history ends at, RFC 3339 or YYYY-MM-DD (default the current time)", func(value string) error {
+		for _, layout := range []string{time.RFC3339, "2006-01-02"} {
+			if t, err := time.Parse(layout, value); err == nil {
+				opts.Now = t
+				return nil
+			}
+		}
+		return fmt.Errorf("%q is not an RFC 3339 time or a YYYY-MM-DD date", value)
+	})
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	switch {
+	case *file != "" && *generate:
+		return errors.New("-file and -generate cannot be combined")
+	case *file != "":
+		fixture, err := database.LoadFixture(*file)
+		if err != nil {
+			return fmt.Errorf("%s: %w", *file, err)
+		}
+		return a.insert(*file, fixture)
+	case *generate:
+		fixture, err := database.Generate(opts)
+		if err != nil {
+			return err
+		}
+		return a.insert(fmt.Sprintf("generated data (seed %d)", opts.Seed), fixture)
+	}
+	return a.seedSet(*set)
+}
+
+func (a *admin) seedSet(name string) error {
+	fixture, err := database.FixtureSet(name)
+	if err != nil {
+		return err
+	}
+	return a.insert(fmt.Sprintf("fixture set %q", name), fixture)
+}
+
+func (a *admin) insert(source string, fixture database.Fixture) error {
+	started := time.Now()
+	if err := database.Seed(a.db, fixture); err != nil {
+		return err
+	}
+	messages := 0
+	for _, chat := range fixture.Chats {
+		messages += len(chat.Messages)
+	}
+	log.Printf("Seeded %s: %d users, %d chats, %d messages in %s", source,
+		len(fixture.Users), len(fixture.Chats), messages, time.Since(started).Round(time.Millisecond))
+	return nil
+}
+
+// reset rolls back every migration and applies them again, which leaves an
+// empty database with a current schema and the system user.
+func (a *admin) reset(args []string) error {
+	fs := flags("reset")
+	yes := fs.Bool("yes", false, "do not ask for confirmation")
+	force := fs.Bool("force", false, "allow resetting a database in release mode")
+	seed := fs.String("seed", "", "fixture set to insert after the reset")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if *seed != "" {
+		if _, err := database.FixtureSet(*seed); err != nil {
+			return err
+		}
+	}
+	if err := a.confirmDestroy("reset", *yes, *force); err != nil {
+		return err
+	}
+	name := a.cfg.Database.Name
+
+	migrator, err := database.NewMigrator(a.db)
+	if err != nil {
+		return err
+	}
+	if err := migrator.To(0); err != nil {
+		return err
+	}
+	if err := migrator.Up(); err != nil {
+		return err
+	}
+	if err := services.EnsureSystemUser(a.users); err != nil {
+		return err
+	}
+	log.Printf("Database %q reset", name)
+
+	if *seed != "" {
+		return a.seedSet(*seed)
+	}
+	return nil
+}
+
+// confirmDestroy refuses to run an action that deletes all data in release
+// mode unless force is set, and asks for the database name unless yes is.
+func (a *admin) confirmDestroy(action string, yes, force bool) error {
+	if a.cfg.Server.Release() && !force {
+		return fmt.Errorf("refusing to %s a database in release mode (GIN_MODE=release or production); pass -force if you really mean it", action)
+	}
+	if yes {
+		return nil
+	}
+
+	name := a.cfg.Database.Name
+	fmt.Fprintf(os.Stderr, "This deletes all data in database %q on %s. Type the database name to confirm: ",
+		name, a.cfg.Database.Host)
+	answer, err := a.readLine()
+	if err != nil {
+		return err
+	}
+	if answer != name {
+		return errors.New("cancelled")
+	}
+	return nil
+}
+
+// appliedMigrations returns how many of the known migrations are applied,
+// and whether the first of them, the baseline, is.
+func appliedMigrations(migrator *database.Migrator) (applied int, baseline bool, err error) {
+	statuses, err := migrator.Status()
+	if err != nil {
+		return 0, false, err
+	}
+	for i, status := range statuses {
+		if status.State == database.MigrationApplied || status.State == database.MigrationModified {
+			applied++
+			baseline = baseline || i == 0
+		}
+	}
+	return applied, baseline, nil
+}
+
+func (a *admin) export(args []string) error {
+	fs := flags("export")
+	output := fs.String("o", "-", "file to write, - for standard output")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if *output == "-" {
+		return database.Export(a.db, os.Stdout)
+	}
+	file, err := os.Create(*output)
+	if err != nil {
+		return err
+	}
+	if err := database.Export(a.db, file); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+func (a *admin) importData(args []string) error {
+	fs := flags("import")
+	input := fs.String("i", "-", "file to read, - for standard input")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	var r io.Reader = a.in
+	if *input != "-" {
+		file, err := os.Open(*input)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		r = file
+	}
+	if err := database.Import(a.db, r); err != nil {
+		return err
+	}
+	log.Println("Data imported")
+	return nil
+}
+
+// readLine reads a line from standard input without its line ending.
+func (a *admin) readLine() (string, error) {
+	line, err := a.in.ReadString('\n')
+	if err != nil && (err != io.EOF || line == "") {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
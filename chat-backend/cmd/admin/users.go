@@ -0,0 +1,161 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+
+	"cs-socket/internal/models"
+	"cs-socket/internal/repository"
+	"cs-socket/internal/services"
+
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// minPasswordLength matches what registration requires.
+const minPasswordLength = 6
+
+var roles = []string{"customer", "agent", "super-agent"}
+
+func (a *admin) createUser(args []string) error {
+	fs := flags("create-user")
+	username := fs.String("username", "", "login name")
+	email := fs.String("email", "", "email address")
+	name := fs.String("name", "", "display name")
+	role := fs.String("role", "customer", "customer, agent or super-agent")
+	password := fs.String("password", "", "password; asked for when not given")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *username == "" || *email == "" || *name == "" || fs.NArg() > 0 {
+		fs.Usage()
+		return errors.New("-username, -email and -name are required")
+	}
+	if err := checkRole(*role); err != nil {
+		return err
+	}
+
+	hash, err := a.passwordHash(*password)
+	if err != nil {
+		return err
+	}
+
+	user, err := a.users.Create(models.User{
+		ID:       uuid.New().String(),
+		Username: *username,
+		Email:    *email,
+		Password: hash,
+		Name:     *name,
+		Role:     *role,
+	})
+	if errors.Is(err, repository.ErrDuplicate) {
+		return fmt.Errorf("username %q or email %q is already taken", *username, *email)
+	}
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Created %s %s (%s)", user.Role, user.Username, user.ID)
+	return nil
+}
+
+func (a *admin) setRole(args []string) error {
+	fs := flags("set-role")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return errors.New("expected a username and a role")
+	}
+	if err := checkRole(fs.Arg(1)); err != nil {
+		return err
+	}
+
+	user, err := a.user(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	if err := a.users.SetRole(user.ID, fs.Arg(1)); err != nil {
+		return err
+	}
+
+	log.Printf("Changed the role of %s from %s to %s", user.Username, user.Role, fs.Arg(1))
+	return nil
+}
+
+func (a *admin) resetPassword(args []string) error {
+	fs := flags("reset-password")
+	password := fs.String("password", "", "new password; asked for when not given")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errors.New("expected a username")
+	}
+
+	user, err := a.user(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	hash, err := a.passwordHash(*password)
+	if err != nil {
+		return err
+	}
+	if err := a.users.SetPassword(user.ID, hash); err != nil {
+		return err
+	}
+
+	log.Printf("Password of %s changed", user.Username)
+	return nil
+}
+
+// user looks up an account to change. The system user is refused, as the
+// server relies on its role and on nobody being able to log in as it.
+func (a *admin) user(username string) (*models.User, error) {
+	user, err := a.users.GetByUsername(username)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("no user named %q", username)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if user.ID == services.SystemUserID {
+		return nil, fmt.Errorf("%s is the system user and cannot be changed", username)
+	}
+	return user, nil
+}
+
+// passwordHash hashes the given password, or one read from standard input
+// when it is empty.
+func (a *admin) passwordHash(password string) (string, error) {
+	if password == "" {
+		fmt.Fprint(os.Stderr, "Password: ")
+		var err error
+		if password, err = a.readLine(); err != nil {
+			return "", err
+		}
+	}
+	if len(password) < minPasswordLength {
+		return "", fmt.Errorf("password must be at least %d characters", minPasswordLength)
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+func checkRole(role string) error {
+	for _, known := range roles {
+		if role == known {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown role %q, expected customer, agent or super-agent", role)
+}
@@ -1,31 +1,60 @@
 package config
 
 import (
-	"os"
-	"strings"
-
-	"github.com/joho/godotenv"
+	"time"
 )
 
 type Config struct {
-	Server   ServerConfig
-	Database DatabaseConfig
-	JWT      JWTConfig
-	CORS     CORSConfig
+	Server      ServerConfig
+	Database    DatabaseConfig
+	JWT         JWTConfig
+	CORS        CORSConfig
+	Idle        IdleConfig
+	SLA         SLAConfig
+	Priority    PriorityConfig
+	Messages    MessagesConfig
+	Storage     StorageConfig
+	Attachments AttachmentsConfig
+	Scanner     ScannerConfig
+	RateLimit   RateLimitConfig
+	WebSocket   WebSocketConfig
+
+	// sources records where each setting's value came from, for Print
+	sources map[string]string
 }
 
 type ServerConfig struct {
 	Port string
+	// Mode is debug, test, release or production, an alias of release
 	Mode string
+	// Timeouts of the HTTP server; zero means none
+	ReadHeaderTimeout time.Duration
+	ReadTimeout       time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+}
+
+// Release reports whether the server runs in release mode, where insecure
+// settings are refused instead of warned about.
+func (c ServerConfig) Release() bool {
+	return c.Mode == "release" || c.Mode == "production"
 }
 
 type DatabaseConfig struct {
+	// URL is a postgres:// connection URL. The parts it contains take
+	// precedence over the separate fields.
+	URL      string
 	Host     string
 	Port     string
 	User     string
 	Password string
 	Name     string
 	SSLMode  string
+	// Connection pool limits; zero means no limit
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+	ConnMaxIdleTime time.Duration
 }
 
 type JWTConfig struct {
@@ -37,43 +66,142 @@ type CORSConfig struct {
 	AllowedOrigins []string
 }
 
-func Load() *Config {
-	// Load .env file if it exists
-	godotenv.Load()
-
-	return &Config{
-		Server: ServerConfig{
-			Port: getEnv("PORT", "8080"),
-			Mode: getEnv("GIN_MODE", "debug"),
-		},
-		Database: DatabaseConfig{
-			Host:     getEnv("DB_HOST", "localhost"),
-			Port:     getEnv("DB_PORT", "5432"),
-			User:     getEnv("DB_USER", "postgres"),
-			Password: getEnv("DB_PASSWORD", ""),
-			Name:     getEnv("DB_NAME", "cs_socket"),
-			SSLMode:  getEnv("DB_SSLMODE", "disable"),
-		},
-		JWT: JWTConfig{
-			Secret: getEnv("JWT_SECRET", "your-secret-key-change-in-production"),
-		},
-		CORS: CORSConfig{
-			Enabled:        getEnvBool("CORS_ENABLED", true),
-			AllowedOrigins: strings.Split(getEnv("CORS_ALLOWED_ORIGINS", "http://localhost:3000,http://localhost:3001"), ","),
-		},
-	}
+// IdleConfig controls the nudging and auto-resolution of chats whose
+// customer has gone quiet.
+type IdleConfig struct {
+	Enabled      bool
+	Interval     time.Duration
+	NudgeMessage string
+	Default      IdleTimings
+	// Topics overrides the default timings per chat topic
+	Topics map[string]IdleTimings
+}
+
+// IdleTimings says how long a customer may stay silent before being nudged,
+// and how long after the nudge the chat is resolved.
+type IdleTimings struct {
+	NudgeAfter   time.Duration
+	ResolveAfter time.Duration
 }
 
-func getEnv(key, defaultValue string) string {
-	if value := os.Getenv(key); value != "" {
-		return value
+// ForTopic returns the timings for a chat topic, falling back to the default.
+func (c IdleConfig) ForTopic(topic string) IdleTimings {
+	if t, ok := c.Topics[topic]; ok {
+		return t
 	}
-	return defaultValue
+	return c.Default
+}
+
+type SLAConfig struct {
+	Enabled  bool
+	Interval time.Duration
+}
+
+// PriorityConfig controls how the priority of a new chat is derived.
+type PriorityConfig struct {
+	// ElevatedTopics raise a chat one priority level above what the
+	// customer's tier alone would give it
+	ElevatedTopics []string
+}
+
+// MessagesConfig controls what users may do with messages after sending.
+type MessagesConfig struct {
+	// EditWindow is how long after sending a message its sender may edit or
+	// delete it. Zero means no limit.
+	EditWindow time.Duration
+	// Reactions is the set of emoji users may react to messages with
+	Reactions []string
+}
+
+// StorageConfig selects where attachment contents are stored: "local" keeps
+// them below LocalPath, "s3" in an S3-compatible bucket.
+type StorageConfig struct {
+	Driver    string
+	LocalPath string
+	S3        S3Config
+}
+
+type S3Config struct {
+	Endpoint  string
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+	// PathStyle addresses objects as endpoint/bucket/key, as MinIO expects
+	PathStyle bool
+}
+
+type AttachmentsConfig struct {
+	MaxSizeCustomer int64
+	MaxSizeStaff    int64
+	// AllowedTypes are the sniffed MIME types that may be uploaded
+	AllowedTypes []string
+	// URLSecret signs download URLs, which expire after URLTTL
+	URLSecret string
+	URLTTL    time.Duration
+	// Thumbnails are rendered for uploaded images larger than their size
+	Thumbnails []ThumbnailSize
+	// ImageMaxPixels rejects images whose decoded size would use too much
+	// memory
+	ImageMaxPixels int64
+	// ServeUnscanned lets files uploaded while scanning was off be
+	// downloaded. It defaults to true outside release mode only.
+	ServeUnscanned bool
+}
+
+// ScannerConfig controls the malware scanning of uploaded files by clamd.
+// While a file is being scanned it cannot be downloaded.
+type ScannerConfig struct {
+	Enabled bool
+	// ClamdAddress is "tcp://host:port" or "unix:///path/to/clamd.sock"
+	ClamdAddress string
+	Timeout      time.Duration
+	// Files that could not be scanned are retried every RetryInterval, up
+	// to MaxAttempts scans in total
+	RetryInterval time.Duration
+	MaxAttempts   int
+}
+
+// ThumbnailSize names a thumbnail variant that fits in a square of
+// MaxDimension pixels.
+type ThumbnailSize struct {
+	Name         string
+	MaxDimension int
+}
+
+// RateLimitConfig limits how many requests a client may make. Auth applies
+// to login and registration per client IP, API to authenticated requests
+// per user.
+type RateLimitConfig struct {
+	Enabled bool
+	Auth    Rate
+	API     Rate
+}
+
+// Rate is a number of requests per period, written as "10/1m".
+type Rate struct {
+	Requests int
+	Per      time.Duration
+}
+
+// WebSocketConfig controls the connections of the WebSocket hub.
+type WebSocketConfig struct {
+	// WriteTimeout bounds writing a single message to a client
+	WriteTimeout time.Duration
+	// Clients are pinged every PingInterval and dropped when no pong has
+	// arrived for PongTimeout
+	PingInterval time.Duration
+	PongTimeout  time.Duration
+	// MaxMessageSize is the largest message in bytes a client may send
+	MaxMessageSize int64
+	// SendBuffer is how many outgoing messages are queued per client
+	SendBuffer int
 }
 
-func getEnvBool(key string, defaultValue bool) bool {
-	if value := os.Getenv(key); value != "" {
-		return strings.ToLower(value) == "true" || value == "1"
+// MaxSize returns the upload size limit in bytes for a role.
+func (c AttachmentsConfig) MaxSize(role string) int64 {
+	if role == "customer" {
+		return c.MaxSizeCustomer
 	}
-	return defaultValue
+	return c.MaxSizeStaff
 }
@@ -0,0 +1,287 @@
+package config
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/joho/godotenv"
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// Where a setting's value came from, as shown by Print.
+const (
+	sourceDefault = "default"
+	sourceFile    = "file"
+	sourceEnv     = "env"
+	sourceURL     = "DATABASE_URL"
+)
+
+// insecureSecrets are placeholder secrets from examples that must not be
+// used to sign anything.
+var insecureSecrets = []string{"your-secret-key-change-in-production"}
+
+// Load reads the configuration in layers: defaults, then the YAML or TOML
+// file at path, then environment variables, which may also come from a .env
+// file. An empty path means the file named by CONFIG_FILE, if any. Parts of
+// DATABASE_URL take precedence over the separate database settings.
+//
+// The configuration is validated, and returned along with the error when
+// it is invalid so that it can still be printed.
+func Load(path string) (*Config, error) {
+	// Load .env file if it exists
+	godotenv.Load()
+
+	if path == "" {
+		path = os.Getenv("CONFIG_FILE")
+	}
+	var file map[string]string
+	if path != "" {
+		var err error
+		if file, err = readFile(path); err != nil {
+			return nil, err
+		}
+	}
+
+	cfg := &Config{sources: make(map[string]string)}
+	var problems []string
+	known := make(map[string]bool)
+	for _, s := range settings(cfg) {
+		known[s.key] = true
+		value, source := s.def, sourceDefault
+		if v, ok := file[s.key]; ok {
+			value, source = v, sourceFile
+		}
+		// Empty variables count as unset, as in .env.example
+		if v := os.Getenv(s.env); v != "" {
+			value, source = v, sourceEnv
+		}
+
+		if err := s.value.Set(value); err != nil {
+			where := s.env
+			if source == sourceFile {
+				where = s.key + " in " + path
+			}
+			problems = append(problems, fmt.Sprintf("%s: %v", where, err))
+		}
+		cfg.sources[s.key] = source
+	}
+	for key := range file {
+		if !known[key] {
+			problems = append(problems, fmt.Sprintf("%s: unknown setting %s", path, key))
+		}
+	}
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return cfg, invalid(problems)
+	}
+
+	if cfg.Database.URL != "" {
+		if err := cfg.applyDatabaseURL(); err != nil {
+			return cfg, invalid([]string{"DATABASE_URL: " + err.Error()})
+		}
+	}
+	cfg.defaultSecrets()
+	cfg.defaultServeUnscanned()
+
+	return cfg, cfg.Validate()
+}
+
+func invalid(problems []string) error {
+	return fmt.Errorf("invalid configuration:\n  %s", strings.Join(problems, "\n  "))
+}
+
+// readFile reads a YAML or TOML config file into settings keyed like
+// "database.host". Lists become comma-separated values, and maps of a
+// setting such as idle.topic_timings become "key=value" entries.
+func readFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	tree := make(map[string]interface{})
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &tree)
+	case ".toml":
+		err = toml.Unmarshal(data, &tree)
+	default:
+		return nil, fmt.Errorf("config file %s must end in .yaml, .yml or .toml", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid config file %s: %w", path, err)
+	}
+
+	values := make(map[string]string)
+	if err := flatten("", tree, values); err != nil {
+		return nil, fmt.Errorf("invalid config file %s: %w", path, err)
+	}
+	return values, nil
+}
+
+func flatten(prefix string, tree map[string]interface{}, values map[string]string) error {
+	for key, node := range tree {
+		key = prefix + key
+		switch node := node.(type) {
+		case map[string]interface{}:
+			if !isMapSetting(key) {
+				if err := flatten(key+".", node, values); err != nil {
+					return err
+				}
+				continue
+			}
+			entries := make([]string, 0, len(node))
+			for name, v := range node {
+				entries = append(entries, fmt.Sprintf("%s=%v", name, v))
+			}
+			sort.Strings(entries)
+			values[key] = strings.Join(entries, ",")
+		case []interface{}:
+			items := make([]string, 0, len(node))
+			for _, item := range node {
+				switch item.(type) {
+				case map[string]interface{}, []interface{}:
+					return fmt.Errorf("%s must be a list of plain values", key)
+				}
+				items = append(items, fmt.Sprint(item))
+			}
+			values[key] = strings.Join(items, ",")
+		case nil:
+			values[key] = ""
+		default:
+			values[key] = fmt.Sprint(node)
+		}
+	}
+	return nil
+}
+
+// isMapSetting reports whether a setting may be written as a map in the
+// config file.
+func isMapSetting(key string) bool {
+	return key == "idle.topic_timings" || key == "attachments.thumbnail_sizes"
+}
+
+// applyDatabaseURL fills the database settings from the parts of the URL.
+func (c *Config) applyDatabaseURL() error {
+	u, err := url.Parse(c.Database.URL)
+	if err != nil {
+		return fmt.Errorf("not a valid URL")
+	}
+	if u.Scheme != "postgres" && u.Scheme != "postgresql" {
+		return fmt.Errorf("scheme must be postgres or postgresql, not %q", u.Scheme)
+	}
+
+	set := func(key string, field *string, value string) {
+		if value != "" {
+			*field = value
+			c.sources[key] = sourceURL
+		}
+	}
+	db := &c.Database
+	set("database.host", &db.Host, u.Hostname())
+	set("database.port", &db.Port, u.Port())
+	if u.User != nil {
+		password, _ := u.User.Password()
+		set("database.user", &db.User, u.User.Username())
+		set("database.password", &db.Password, password)
+	}
+	set("database.name", &db.Name, strings.TrimPrefix(u.Path, "/"))
+
+	for param, values := range u.Query() {
+		if param != "sslmode" {
+			return fmt.Errorf("unsupported parameter %q, only sslmode is", param)
+		}
+		set("database.sslmode", &db.SSLMode, values[0])
+	}
+	return nil
+}
+
+// DSN returns the connection string for lib/pq.
+func (c DatabaseConfig) DSN() string {
+	quote := func(s string) string {
+		return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(s) + "'"
+	}
+	return fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=%s",
+		quote(c.Host), quote(c.Port), quote(c.User), quote(c.Password), quote(c.Name), quote(c.SSLMode))
+}
+
+// defaultSecrets fills in the secrets that were left out. Outside release
+// mode a missing JWT secret is replaced by a random one, which logs
+// everyone out on restart; in release mode Validate refuses it.
+func (c *Config) defaultSecrets() {
+	if c.JWT.Secret == "" && !c.Server.Release() {
+		key := make([]byte, 32)
+		rand.Read(key)
+		c.JWT.Secret = hex.EncodeToString(key)
+		c.sources["jwt.secret"] = "generated"
+		log.Println("Warning: JWT_SECRET is not set, using a random secret; sessions end when the server restarts")
+	}
+	if c.JWT.Secret != "" && isInsecure(c.JWT.Secret) && !c.Server.Release() {
+		log.Println("Warning: JWT_SECRET is the example placeholder, which is refused in release mode")
+	}
+	if c.Attachments.URLSecret == "" {
+		c.Attachments.URLSecret = c.JWT.Secret
+		c.sources["attachments.url_secret"] = "jwt.secret"
+	}
+}
+
+// defaultServeUnscanned lets files uploaded without a malware scan be
+// downloaded outside release mode, unless ATTACHMENT_SERVE_UNSCANNED says
+// otherwise. In release mode they are refused unless it is set to true.
+func (c *Config) defaultServeUnscanned() {
+	if c.sources["attachments.serve_unscanned"] == sourceDefault && !c.Server.Release() {
+		c.Attachments.ServeUnscanned = true
+		c.sources["attachments.serve_unscanned"] = "development"
+	}
+}
+
+func isInsecure(secret string) bool {
+	for _, insecure := range insecureSecrets {
+		if secret == insecure {
+			return true
+		}
+	}
+	return false
+}
+
+// Print writes every setting with its effective value, environment
+// variable and source. Secrets are redacted.
+func (c *Config) Print(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "SETTING\tVALUE\tENV\tSOURCE")
+	for _, s := range settings(c) {
+		value := s.value.String()
+		switch {
+		case s.key == "database.url" && value != "":
+			value = redactURL(value)
+		case s.secret && value != "":
+			value = "<redacted>"
+		case value == "":
+			value = `""`
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", s.key, value, s.env, c.sources[s.key])
+	}
+	return tw.Flush()
+}
+
+// redactURL hides the password of a connection URL.
+func redactURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "<redacted>"
+	}
+	if _, ok := u.User.Password(); ok {
+		u.User = url.UserPassword(u.User.Username(), "redacted")
+	}
+	return u.String()
+}
@@ -0,0 +1,288 @@
+package config
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// setting binds a field of Config to its key in the config file and its
+// environment variable.
+type setting struct {
+	key   string
+	env   string
+	def   string
+	value value
+	// secret values are redacted when printed
+	secret bool
+}
+
+// value parses a setting into its field and formats it back, like
+// flag.Value.
+type value interface {
+	Set(s string) error
+	String() string
+}
+
+// settings lists every setting of c with its default.
+func settings(c *Config) []setting {
+	return []setting{
+		{key: "server.port", env: "PORT", def: "8080", value: stringValue{&c.Server.Port}},
+		{key: "server.mode", env: "GIN_MODE", def: "debug", value: stringValue{&c.Server.Mode}},
+		{key: "server.read_header_timeout", env: "SERVER_READ_HEADER_TIMEOUT", def: "10s", value: durationValue{&c.Server.ReadHeaderTimeout}},
+		{key: "server.read_timeout", env: "SERVER_READ_TIMEOUT", def: "5m", value: durationValue{&c.Server.ReadTimeout}},
+		{key: "server.write_timeout", env: "SERVER_WRITE_TIMEOUT", def: "0s", value: durationValue{&c.Server.WriteTimeout}},
+		{key: "server.idle_timeout", env: "SERVER_IDLE_TIMEOUT", def: "2m", value: durationValue{&c.Server.IdleTimeout}},
+
+		{key: "database.url", env: "DATABASE_URL", value: stringValue{&c.Database.URL}, secret: true},
+		{key: "database.host", env: "DB_HOST", def: "localhost", value: stringValue{&c.Database.Host}},
+		{key: "database.port", env: "DB_PORT", def: "5432", value: stringValue{&c.Database.Port}},
+		{key: "database.user", env: "DB_USER", def: "postgres", value: stringValue{&c.Database.User}},
+		{key: "database.password", env: "DB_PASSWORD", value: stringValue{&c.Database.Password}, secret: true},
+		{key: "database.name", env: "DB_NAME", def: "cs_socket", value: stringValue{&c.Database.Name}},
+		{key: "database.sslmode", env: "DB_SSLMODE", def: "disable", value: stringValue{&c.Database.SSLMode}},
+		{key: "database.max_open_conns", env: "DB_MAX_OPEN_CONNS", def: "25", value: intValue[int]{&c.Database.MaxOpenConns}},
+		{key: "database.max_idle_conns", env: "DB_MAX_IDLE_CONNS", def: "10", value: intValue[int]{&c.Database.MaxIdleConns}},
+		{key: "database.conn_max_lifetime", env: "DB_CONN_MAX_LIFETIME", def: "30m", value: durationValue{&c.Database.ConnMaxLifetime}},
+		{key: "database.conn_max_idle_time", env: "DB_CONN_MAX_IDLE_TIME", def: "5m", value: durationValue{&c.Database.ConnMaxIdleTime}},
+
+		{key: "jwt.secret", env: "JWT_SECRET", value: stringValue{&c.JWT.Secret}, secret: true},
+
+		{key: "cors.enabled", env: "CORS_ENABLED", def: "true", value: boolValue{&c.CORS.Enabled}},
+		{key: "cors.allowed_origins", env: "CORS_ALLOWED_ORIGINS", def: "http://localhost:3000,http://localhost:3001", value: listValue{&c.CORS.AllowedOrigins}},
+
+		{key: "idle.enabled", env: "IDLE_ENABLED", def: "true", value: boolValue{&c.Idle.Enabled}},
+		{key: "idle.check_interval", env: "IDLE_CHECK_INTERVAL", def: "1m", value: durationValue{&c.Idle.Interval}},
+		{key: "idle.nudge_message", env: "IDLE_NUDGE_MESSAGE", def: "Are you still there? This chat will be closed soon if we don't hear from you.", value: stringValue{&c.Idle.NudgeMessage}},
+		{key: "idle.nudge_after", env: "IDLE_NUDGE_AFTER", def: "10m", value: durationValue{&c.Idle.Default.NudgeAfter}},
+		{key: "idle.resolve_after", env: "IDLE_RESOLVE_AFTER", def: "5m", value: durationValue{&c.Idle.Default.ResolveAfter}},
+		{key: "idle.topic_timings", env: "IDLE_TOPIC_TIMINGS", value: idleTopicsValue{&c.Idle.Topics}},
+
+		{key: "sla.enabled", env: "SLA_ENABLED", def: "true", value: boolValue{&c.SLA.Enabled}},
+		{key: "sla.check_interval", env: "SLA_CHECK_INTERVAL", def: "30s", value: durationValue{&c.SLA.Interval}},
+
+		{key: "priority.elevated_topics", env: "PRIORITY_ELEVATED_TOPICS", def: "withdrawal,payment,security", value: topicsValue{&c.Priority.ElevatedTopics}},
+
+		{key: "messages.edit_window", env: "MESSAGE_EDIT_WINDOW", def: "15m", value: durationValue{&c.Messages.EditWindow}},
+		{key: "messages.reactions", env: "MESSAGE_REACTIONS", def: "👍,👎,❤️,😂,😮,🙏", value: listValue{&c.Messages.Reactions}},
+
+		{key: "storage.driver", env: "STORAGE_DRIVER", def: "local", value: stringValue{&c.Storage.Driver}},
+		{key: "storage.local_path", env: "STORAGE_LOCAL_PATH", def: "./uploads", value: stringValue{&c.Storage.LocalPath}},
+		{key: "storage.s3.endpoint", env: "S3_ENDPOINT", value: stringValue{&c.Storage.S3.Endpoint}},
+		{key: "storage.s3.region", env: "S3_REGION", def: "us-east-1", value: stringValue{&c.Storage.S3.Region}},
+		{key: "storage.s3.bucket", env: "S3_BUCKET", value: stringValue{&c.Storage.S3.Bucket}},
+		{key: "storage.s3.access_key", env: "S3_ACCESS_KEY", value: stringValue{&c.Storage.S3.AccessKey}, secret: true},
+		{key: "storage.s3.secret_key", env: "S3_SECRET_KEY", value: stringValue{&c.Storage.S3.SecretKey}, secret: true},
+		{key: "storage.s3.path_style", env: "S3_PATH_STYLE", def: "true", value: boolValue{&c.Storage.S3.PathStyle}},
+
+		{key: "attachments.max_size_customer", env: "ATTACHMENT_MAX_SIZE_CUSTOMER", def: "10MB", value: sizeValue{&c.Attachments.MaxSizeCustomer}},
+		{key: "attachments.max_size_staff", env: "ATTACHMENT_MAX_SIZE_STAFF", def: "25MB", value: sizeValue{&c.Attachments.MaxSizeStaff}},
+		{key: "attachments.allowed_types", env: "ATTACHMENT_ALLOWED_TYPES", def: "image/jpeg,image/png,image/gif,image/webp,application/pdf,text/plain", value: listValue{&c.Attachments.AllowedTypes}},
+		// An empty URL secret falls back to the JWT secret
+		{key: "attachments.url_secret", env: "ATTACHMENT_URL_SECRET", value: stringValue{&c.Attachments.URLSecret}, secret: true},
+		{key: "attachments.url_ttl", env: "ATTACHMENT_URL_TTL", def: "15m", value: durationValue{&c.Attachments.URLTTL}},
+		{key: "attachments.thumbnail_sizes", env: "ATTACHMENT_THUMBNAIL_SIZES", def: "small=160,medium=480,large=1024", value: thumbnailsValue{&c.Attachments.Thumbnails}},
+		{key: "attachments.image_max_pixels", env: "ATTACHMENT_IMAGE_MAX_PIXELS", def: "50000000", value: intValue[int64]{&c.Attachments.ImageMaxPixels}},
+		// Unset, it is true outside release mode only
+		{key: "attachments.serve_unscanned", env: "ATTACHMENT_SERVE_UNSCANNED", def: "false", value: boolValue{&c.Attachments.ServeUnscanned}},
+
+		{key: "scanner.enabled", env: "SCANNER_ENABLED", def: "false", value: boolValue{&c.Scanner.Enabled}},
+		{key: "scanner.clamd_address", env: "CLAMD_ADDRESS", def: "tcp://localhost:3310", value: stringValue{&c.Scanner.ClamdAddress}},
+		{key: "scanner.timeout", env: "SCANNER_TIMEOUT", def: "2m", value: durationValue{&c.Scanner.Timeout}},
+		{key: "scanner.retry_interval", env: "SCANNER_RETRY_INTERVAL", def: "1m", value: durationValue{&c.Scanner.RetryInterval}},
+		{key: "scanner.max_attempts", env: "SCANNER_MAX_ATTEMPTS", def: "5", value: intValue[int]{&c.Scanner.MaxAttempts}},
+
+		{key: "rate_limit.enabled", env: "RATE_LIMIT_ENABLED", def: "true", value: boolValue{&c.RateLimit.Enabled}},
+		{key: "rate_limit.auth", env: "RATE_LIMIT_AUTH", def: "10/1m", value: rateValue{&c.RateLimit.Auth}},
+		{key: "rate_limit.api", env: "RATE_LIMIT_API", def: "300/1m", value: rateValue{&c.RateLimit.API}},
+
+		{key: "websocket.write_timeout", env: "WS_WRITE_TIMEOUT", def: "10s", value: durationValue{&c.WebSocket.WriteTimeout}},
+		{key: "websocket.ping_interval", env: "WS_PING_INTERVAL", def: "50s", value: durationValue{&c.WebSocket.PingInterval}},
+		{key: "websocket.pong_timeout", env: "WS_PONG_TIMEOUT", def: "60s", value: durationValue{&c.WebSocket.PongTimeout}},
+		{key: "websocket.max_message_size", env: "WS_MAX_MESSAGE_SIZE", def: "64KB", value: sizeValue{&c.WebSocket.MaxMessageSize}},
+		{key: "websocket.send_buffer", env: "WS_SEND_BUFFER", def: "256", value: intValue[int]{&c.WebSocket.SendBuffer}},
+	}
+}
+
+type stringValue struct{ p *string }
+
+func (v stringValue) Set(s string) error { *v.p = s; return nil }
+func (v stringValue) String() string     { return *v.p }
+
+type boolValue struct{ p *bool }
+
+func (v boolValue) Set(s string) error {
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		return fmt.Errorf("%q is not true or false", s)
+	}
+	*v.p = b
+	return nil
+}
+
+func (v boolValue) String() string { return strconv.FormatBool(*v.p) }
+
+type intValue[T int | int64] struct{ p *T }
+
+func (v intValue[T]) Set(s string) error {
+	n, err := strconv.ParseInt(strings.TrimSpace(s), 10, 64)
+	if err != nil {
+		return fmt.Errorf("%q is not a whole number", s)
+	}
+	*v.p = T(n)
+	return nil
+}
+
+func (v intValue[T]) String() string { return strconv.FormatInt(int64(*v.p), 10) }
+
+type durationValue struct{ p *time.Duration }
+
+func (v durationValue) Set(s string) error {
+	d, err := time.ParseDuration(strings.TrimSpace(s))
+	if err != nil {
+		return fmt.Errorf("%q is not a duration such as 30s or 15m", s)
+	}
+	*v.p = d
+	return nil
+}
+
+func (v durationValue) String() string { return v.p.String() }
+
+// sizeValue is a size in bytes with an optional KB, MB or GB suffix, e.g.
+// "10MB".
+type sizeValue struct{ p *int64 }
+
+var sizeUnits = []struct {
+	suffix     string
+	multiplier int64
+}{{"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10}}
+
+func (v sizeValue) Set(s string) error {
+	value := strings.ToUpper(strings.TrimSpace(s))
+	multiplier := int64(1)
+	for _, unit := range sizeUnits {
+		if strings.HasSuffix(value, unit.suffix) {
+			value, multiplier = strings.TrimSpace(strings.TrimSuffix(value, unit.suffix)), unit.multiplier
+			break
+		}
+	}
+
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || n < 0 {
+		return fmt.Errorf("%q is not a size such as 512KB or 10MB", s)
+	}
+	*v.p = n * multiplier
+	return nil
+}
+
+func (v sizeValue) String() string {
+	for _, unit := range sizeUnits {
+		if *v.p != 0 && *v.p%unit.multiplier == 0 {
+			return strconv.FormatInt(*v.p/unit.multiplier, 10) + unit.suffix
+		}
+	}
+	return strconv.FormatInt(*v.p, 10)
+}
+
+// listValue is a comma-separated list; blank entries are dropped.
+type listValue struct{ p *[]string }
+
+func (v listValue) Set(s string) error {
+	var list []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	*v.p = list
+	return nil
+}
+
+func (v listValue) String() string { return strings.Join(*v.p, ",") }
+
+// topicsValue is a list of chat topics, which are lowercased like the
+// topics of new chats.
+type topicsValue struct{ p *[]string }
+
+func (v topicsValue) Set(s string) error { return listValue{v.p}.Set(strings.ToLower(s)) }
+
+func (v topicsValue) String() string { return listValue{v.p}.String() }
+
+// thumbnailsValue is a list of named thumbnail sizes in the form
+// "small=160,medium=480".
+type thumbnailsValue struct{ p *[]ThumbnailSize }
+
+func (v thumbnailsValue) Set(s string) error {
+	var sizes []ThumbnailSize
+	var entries []string
+	listValue{&entries}.Set(s)
+	for _, entry := range entries {
+		name, size, ok := strings.Cut(entry, "=")
+		n, err := strconv.Atoi(strings.TrimSpace(size))
+		name = strings.ToLower(strings.TrimSpace(name))
+		if !ok || err != nil || n <= 0 || name == "" {
+			return fmt.Errorf("malformed thumbnail size %q, expected name=pixels", entry)
+		}
+		sizes = append(sizes, ThumbnailSize{Name: name, MaxDimension: n})
+	}
+	*v.p = sizes
+	return nil
+}
+
+func (v thumbnailsValue) String() string {
+	entries := make([]string, 0, len(*v.p))
+	for _, size := range *v.p {
+		entries = append(entries, fmt.Sprintf("%s=%d", size.Name, size.MaxDimension))
+	}
+	return strings.Join(entries, ",")
+}
+
+// idleTopicsValue holds per-topic idle timings in the form
+// "withdrawal=20m/10m,kyc=30m/15m" (nudge after / resolve after).
+type idleTopicsValue struct{ p *map[string]IdleTimings }
+
+func (v idleTopicsValue) Set(s string) error {
+	topics := make(map[string]IdleTimings)
+	var entries []string
+	listValue{&entries}.Set(s)
+	for _, entry := range entries {
+		topic, timings, ok := strings.Cut(entry, "=")
+		nudge, resolve, ok2 := strings.Cut(timings, "/")
+		nudgeAfter, err1 := time.ParseDuration(strings.TrimSpace(nudge))
+		resolveAfter, err2 := time.ParseDuration(strings.TrimSpace(resolve))
+		if !ok || !ok2 || err1 != nil || err2 != nil {
+			return fmt.Errorf("malformed topic timings %q, expected topic=nudge/resolve such as withdrawal=20m/10m", entry)
+		}
+		topics[strings.ToLower(strings.TrimSpace(topic))] = IdleTimings{NudgeAfter: nudgeAfter, ResolveAfter: resolveAfter}
+	}
+	*v.p = topics
+	return nil
+}
+
+func (v idleTopicsValue) String() string {
+	entries := make([]string, 0, len(*v.p))
+	for topic, timings := range *v.p {
+		entries = append(entries, fmt.Sprintf("%s=%s/%s", topic, timings.NudgeAfter, timings.ResolveAfter))
+	}
+	sort.Strings(entries)
+	return strings.Join(entries, ",")
+}
+
+type rateValue struct{ p *Rate }
+
+func (v rateValue) Set(s string) error {
+	requests, per, ok := strings.Cut(strings.TrimSpace(s), "/")
+	n, err1 := strconv.Atoi(strings.TrimSpace(requests))
+	d, err2 := time.ParseDuration(strings.TrimSpace(per))
+	if !ok || err1 != nil || err2 != nil {
+		return fmt.Errorf("%q is not a rate such as 10/1m", s)
+	}
+	*v.p = Rate{Requests: n, Per: d}
+	return nil
+}
+
+func (v rateValue) String() string { return v.p.String() }
+
+func (r Rate) String() string { return fmt.Sprintf("%d/%s", r.Requests, r.Per) }
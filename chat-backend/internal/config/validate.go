@@ -0,0 +1,125 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// minSecretLength is the shortest signing secret accepted in release mode.
+const minSecretLength = 32
+
+// Validate checks the configuration and reports every problem at once,
+// naming the environment variables to fix.
+func (c *Config) Validate() error {
+	var problems []string
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			problems = append(problems, fmt.Sprintf(format, args...))
+		}
+	}
+	positive := func(d time.Duration, env string) {
+		check(d > 0, "%s must be positive, got %s", env, d)
+	}
+	notNegative := func(d time.Duration, env string) {
+		check(d >= 0, "%s must not be negative, got %s", env, d)
+	}
+
+	port, err := strconv.Atoi(c.Server.Port)
+	check(err == nil && port > 0 && port < 65536, "PORT must be a port number, got %q", c.Server.Port)
+	switch c.Server.Mode {
+	case "debug", "test", "release", "production":
+	default:
+		check(false, "GIN_MODE must be debug, test, release or production, got %q", c.Server.Mode)
+	}
+	notNegative(c.Server.ReadHeaderTimeout, "SERVER_READ_HEADER_TIMEOUT")
+	notNegative(c.Server.ReadTimeout, "SERVER_READ_TIMEOUT")
+	notNegative(c.Server.WriteTimeout, "SERVER_WRITE_TIMEOUT")
+	notNegative(c.Server.IdleTimeout, "SERVER_IDLE_TIMEOUT")
+
+	db := c.Database
+	check(db.Host != "" && db.Name != "" && db.User != "", "DB_HOST, DB_NAME and DB_USER or DATABASE_URL must name the database")
+	port, err = strconv.Atoi(db.Port)
+	check(err == nil && port > 0 && port < 65536, "DB_PORT must be a port number, got %q", db.Port)
+	switch db.SSLMode {
+	case "disable", "allow", "prefer", "require", "verify-ca", "verify-full":
+	default:
+		check(false, "DB_SSLMODE must be disable, allow, prefer, require, verify-ca or verify-full, got %q", db.SSLMode)
+	}
+	check(db.MaxOpenConns >= 0, "DB_MAX_OPEN_CONNS must not be negative")
+	check(db.MaxIdleConns >= 0, "DB_MAX_IDLE_CONNS must not be negative")
+	check(db.MaxOpenConns == 0 || db.MaxIdleConns <= db.MaxOpenConns,
+		"DB_MAX_IDLE_CONNS (%d) must not exceed DB_MAX_OPEN_CONNS (%d)", db.MaxIdleConns, db.MaxOpenConns)
+	notNegative(db.ConnMaxLifetime, "DB_CONN_MAX_LIFETIME")
+	notNegative(db.ConnMaxIdleTime, "DB_CONN_MAX_IDLE_TIME")
+
+	if c.Server.Release() {
+		check(c.JWT.Secret != "", "JWT_SECRET is required in release mode")
+		check(!isInsecure(c.JWT.Secret), "JWT_SECRET is the example placeholder; generate one with: openssl rand -hex 32")
+		check(c.JWT.Secret == "" || len(c.JWT.Secret) >= minSecretLength,
+			"JWT_SECRET must be at least %d characters in release mode", minSecretLength)
+		// A URL secret inherited from the JWT secret was checked above
+		if c.Attachments.URLSecret != c.JWT.Secret {
+			check(!isInsecure(c.Attachments.URLSecret), "ATTACHMENT_URL_SECRET is the example placeholder")
+			check(len(c.Attachments.URLSecret) >= minSecretLength,
+				"ATTACHMENT_URL_SECRET must be at least %d characters in release mode", minSecretLength)
+		}
+	}
+
+	check(!c.CORS.Enabled || len(c.CORS.AllowedOrigins) > 0, "CORS_ALLOWED_ORIGINS must list at least one origin when CORS is enabled")
+
+	if c.Idle.Enabled {
+		positive(c.Idle.Interval, "IDLE_CHECK_INTERVAL")
+		positive(c.Idle.Default.NudgeAfter, "IDLE_NUDGE_AFTER")
+		positive(c.Idle.Default.ResolveAfter, "IDLE_RESOLVE_AFTER")
+		for topic, timings := range c.Idle.Topics {
+			check(timings.NudgeAfter > 0 && timings.ResolveAfter > 0, "IDLE_TOPIC_TIMINGS for %s must be positive", topic)
+		}
+	}
+	if c.SLA.Enabled {
+		positive(c.SLA.Interval, "SLA_CHECK_INTERVAL")
+	}
+	notNegative(c.Messages.EditWindow, "MESSAGE_EDIT_WINDOW")
+	check(len(c.Messages.Reactions) > 0, "MESSAGE_REACTIONS must list at least one emoji")
+
+	switch c.Storage.Driver {
+	case "local":
+		check(c.Storage.LocalPath != "", "STORAGE_LOCAL_PATH is required for the local storage driver")
+	case "s3":
+		check(c.Storage.S3.Endpoint != "" && c.Storage.S3.Bucket != "", "S3_ENDPOINT and S3_BUCKET are required for the s3 storage driver")
+	default:
+		check(false, "STORAGE_DRIVER must be local or s3, got %q", c.Storage.Driver)
+	}
+
+	check(c.Attachments.MaxSizeCustomer > 0, "ATTACHMENT_MAX_SIZE_CUSTOMER must be positive")
+	check(c.Attachments.MaxSizeStaff > 0, "ATTACHMENT_MAX_SIZE_STAFF must be positive")
+	check(len(c.Attachments.AllowedTypes) > 0, "ATTACHMENT_ALLOWED_TYPES must list at least one type")
+	positive(c.Attachments.URLTTL, "ATTACHMENT_URL_TTL")
+	check(c.Attachments.ImageMaxPixels > 0, "ATTACHMENT_IMAGE_MAX_PIXELS must be positive")
+
+	if c.Scanner.Enabled {
+		check(strings.HasPrefix(c.Scanner.ClamdAddress, "tcp://") || strings.HasPrefix(c.Scanner.ClamdAddress, "unix://"),
+			"CLAMD_ADDRESS must start with tcp:// or unix://, got %q", c.Scanner.ClamdAddress)
+		positive(c.Scanner.Timeout, "SCANNER_TIMEOUT")
+		positive(c.Scanner.RetryInterval, "SCANNER_RETRY_INTERVAL")
+		check(c.Scanner.MaxAttempts > 0, "SCANNER_MAX_ATTEMPTS must be at least 1")
+	}
+
+	if c.RateLimit.Enabled {
+		check(c.RateLimit.Auth.Requests > 0 && c.RateLimit.Auth.Per > 0, "RATE_LIMIT_AUTH must allow requests, got %s", c.RateLimit.Auth)
+		check(c.RateLimit.API.Requests > 0 && c.RateLimit.API.Per > 0, "RATE_LIMIT_API must allow requests, got %s", c.RateLimit.API)
+	}
+
+	ws := c.WebSocket
+	positive(ws.WriteTimeout, "WS_WRITE_TIMEOUT")
+	positive(ws.PingInterval, "WS_PING_INTERVAL")
+	check(ws.PongTimeout > ws.PingInterval, "WS_PONG_TIMEOUT (%s) must be longer than WS_PING_INTERVAL (%s)", ws.PongTimeout, ws.PingInterval)
+	check(ws.MaxMessageSize > 0, "WS_MAX_MESSAGE_SIZE must be positive")
+	check(ws.SendBuffer > 0, "WS_SEND_BUFFER must be positive")
+
+	if len(problems) > 0 {
+		return invalid(problems)
+	}
+	return nil
+}
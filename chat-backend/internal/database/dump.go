@@ -0,0 +1,282 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// importBatch is the number of rows inserted per statement on import.
+var importBatch = 500
+
+// exportOrder orders the rows of tables that reference themselves, so that
+// Import inserts a row after the rows it references even across batches.
+// Replies quote an earlier message of the same chat.
+var exportOrder = map[string]string{
+	"messages": "chat_id, seq",
+}
+
+// seededTables hold rows inserted by migrations. Import replaces them
+// rather than requiring them to be empty.
+var seededTables = map[string]bool{
+	"sla_policies": true,
+}
+
+// Dump is the data of every table, as written by Export and read by Import.
+type Dump struct {
+	// SchemaVersion is the migration the data was exported at. Data can only
+	// be imported into a database at the same version.
+	SchemaVersion int64       `json:"schemaVersion"`
+	Tables        []DumpTable `json:"tables"`
+}
+
+// DumpTable holds the rows of one table as JSON objects keyed by column.
+type DumpTable struct {
+	Name string            `json:"name"`
+	Rows []json.RawMessage `json:"rows"`
+}
+
+// Export writes the rows of every table but schema_migrations as JSON. Tables
+// come after the tables they reference, so Import can insert them in order.
+func Export(db *sql.DB, w io.Writer) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// One snapshot for every table, so the dump is consistent
+	if _, err := tx.Exec(`SET TRANSACTION ISOLATION LEVEL REPEATABLE READ READ ONLY`); err != nil {
+		return err
+	}
+
+	dump := Dump{}
+	if err := tx.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&dump.SchemaVersion); err != nil {
+		return err
+	}
+
+	tables, err := dataTables(tx)
+	if err != nil {
+		return err
+	}
+	for _, table := range tables {
+		query := `SELECT row_to_json(t) FROM ` + pq.QuoteIdentifier(table) + ` t`
+		if order, ok := exportOrder[table]; ok {
+			query += ` ORDER BY ` + order
+		}
+		rows, err := tx.Query(query)
+		if err != nil {
+			return err
+		}
+		dumped := DumpTable{Name: table, Rows: []json.RawMessage{}}
+		for rows.Next() {
+			var row []byte
+			if err := rows.Scan(&row); err != nil {
+				rows.Close()
+				return err
+			}
+			dumped.Rows = append(dumped.Rows, row)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		dump.Tables = append(dump.Tables, dumped)
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(dump)
+}
+
+// Import inserts the rows written by Export into an empty database at the
+// same schema version, in one transaction. Rows that migrations inserted are
+// replaced by those of the dump.
+func Import(db *sql.DB, r io.Reader) error {
+	var dump Dump
+	if err := json.NewDecoder(r).Decode(&dump); err != nil {
+		return fmt.Errorf("failed to read dump: %w", err)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var version int64
+	if err := tx.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&version); err != nil {
+		return err
+	}
+	if version != dump.SchemaVersion {
+		return fmt.Errorf("dump is at schema version %d but the database is at %d", dump.SchemaVersion, version)
+	}
+
+	tables, err := dataTables(tx)
+	if err != nil {
+		return err
+	}
+	known := make(map[string]bool, len(tables))
+	for _, table := range tables {
+		known[table] = true
+		if seededTables[table] {
+			continue
+		}
+		var nonEmpty bool
+		if err := tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM ` + pq.QuoteIdentifier(table) + `)`).Scan(&nonEmpty); err != nil {
+			return err
+		}
+		if nonEmpty {
+			return fmt.Errorf("table %s is not empty; reset the database before importing", table)
+		}
+	}
+	// Nothing references the seeded rows, as every other table is empty
+	for _, table := range tables {
+		if seededTables[table] {
+			if _, err := tx.Exec(`DELETE FROM ` + pq.QuoteIdentifier(table)); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, table := range dump.Tables {
+		if !known[table.Name] {
+			return fmt.Errorf("dump has rows for unknown table %q", table.Name)
+		}
+		columns, err := insertableColumns(tx, table.Name)
+		if err != nil {
+			return err
+		}
+		// json_populate_recordset turns the JSON objects back into rows of
+		// the table's type, converting each column from its JSON form
+		query := fmt.Sprintf(`INSERT INTO %[1]s (%[2]s) SELECT %[2]s FROM json_populate_recordset(NULL::%[1]s, $1)`,
+			pq.QuoteIdentifier(table.Name), strings.Join(columns, ", "))
+		for start := 0; start < len(table.Rows); start += importBatch {
+			end := start + importBatch
+			if end > len(table.Rows) {
+				end = len(table.Rows)
+			}
+			batch, err := json.Marshal(table.Rows[start:end])
+			if err != nil {
+				return err
+			}
+			if _, err := tx.Exec(query, string(batch)); err != nil {
+				return fmt.Errorf("failed to import %s: %w", table.Name, err)
+			}
+		}
+	}
+
+	return tx.Commit()
+}
+
+// dataTables returns the tables of the current schema but schema_migrations,
+// ordered so that every table comes after the tables it references.
+func dataTables(tx *sql.Tx) ([]string, error) {
+	rows, err := tx.Query(`
+		SELECT table_name FROM information_schema.tables
+		WHERE table_schema = current_schema() AND table_type = 'BASE TABLE'
+			AND table_name <> 'schema_migrations'
+		ORDER BY table_name`)
+	if err != nil {
+		return nil, err
+	}
+	var tables []string
+	for rows.Next() {
+		var table string
+		if err := rows.Scan(&table); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		tables = append(tables, table)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = tx.Query(`
+		SELECT DISTINCT child.relname, parent.relname
+		FROM pg_constraint c
+		JOIN pg_class child ON child.oid = c.conrelid
+		JOIN pg_class parent ON parent.oid = c.confrelid
+		WHERE c.contype = 'f' AND child.relnamespace = current_schema()::regnamespace`)
+	if err != nil {
+		return nil, err
+	}
+	references := make(map[string][]string)
+	for rows.Next() {
+		var child, parent string
+		if err := rows.Scan(&child, &parent); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		references[child] = append(references[child], parent)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return referenceOrder(tables, references), nil
+}
+
+// referenceOrder sorts tables so that each comes after the tables it
+// references, and alphabetically otherwise. References of a table to itself
+// are ignored, as they are checked at the end of each statement.
+func referenceOrder(tables []string, references map[string][]string) []string {
+	sorted := append([]string(nil), tables...)
+	sort.Strings(sorted)
+
+	known := make(map[string]bool, len(sorted))
+	for _, table := range sorted {
+		known[table] = true
+	}
+
+	ordered := make([]string, 0, len(sorted))
+	visited := make(map[string]bool, len(sorted))
+	var visit func(table string)
+	visit = func(table string) {
+		if visited[table] || !known[table] {
+			return
+		}
+		visited[table] = true
+		parents := append([]string(nil), references[table]...)
+		sort.Strings(parents)
+		for _, parent := range parents {
+			visit(parent)
+		}
+		ordered = append(ordered, table)
+	}
+	for _, table := range sorted {
+		visit(table)
+	}
+	return ordered
+}
+
+// insertableColumns returns the quoted columns of a table that can be
+// inserted into, leaving out generated ones.
+func insertableColumns(tx *sql.Tx, table string) ([]string, error) {
+	rows, err := tx.Query(`
+		SELECT column_name FROM information_schema.columns
+		WHERE table_schema = current_schema() AND table_name = $1 AND is_generated = 'NEVER'
+		ORDER BY ordinal_position`, table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var columns []string
+	for rows.Next() {
+		var column string
+		if err := rows.Scan(&column); err != nil {
+			return nil, err
+		}
+		columns = append(columns, pq.QuoteIdentifier(column))
+	}
+	return columns, rows.Err()
+}
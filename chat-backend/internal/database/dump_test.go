@@ -0,0 +1,145 @@
+package database
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"os"
+	"reflect"
+	"testing"
+
+	"cs-socket/internal/config"
+)
+
+func TestReferenceOrder(t *testing.T) {
+	tables := []string{"messages", "users", "chats", "message_reactions", "tags", "chat_tags"}
+	references := map[string][]string{
+		"chats":             {"users"},
+		"messages":          {"chats", "users", "messages"},
+		"message_reactions": {"users", "messages"},
+		"chat_tags":         {"tags", "chats", "users"},
+		// Tables outside the dump are left out
+		"tags": {"audit_log"},
+	}
+
+	got := referenceOrder(tables, references)
+	want := []string{"users", "chats", "tags", "chat_tags", "messages", "message_reactions"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("order = %v, want %v", got, want)
+	}
+}
+
+// openTestDB connects to the scratch PostgreSQL database named by
+// TEST_DB_NAME, reached with the usual DB_* settings, and skips the test if
+// there is none. Tests using it delete its data.
+func openTestDB(t *testing.T) (*sql.DB, *Migrator) {
+	t.Helper()
+
+	name := os.Getenv("TEST_DB_NAME")
+	if name == "" {
+		t.Skip("TEST_DB_NAME is not set")
+	}
+
+	loaded, err := config.Load("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := loaded.Database
+	cfg.Name = name
+	db, err := Connect(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	migrator, err := NewMigrator(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db, migrator
+}
+
+// TestExportImportRoundTrip exports seeded data with a reply, resets the
+// database and imports it again. It needs a scratch database, see openTestDB.
+//
+//	TEST_DB_NAME=chat_test go test -run RoundTrip ./internal/database
+func TestExportImportRoundTrip(t *testing.T) {
+	db, migrator := openTestDB(t)
+	reset := func() {
+		t.Helper()
+		if err := migrator.To(0); err != nil {
+			t.Fatalf("rolling back: %v", err)
+		}
+		if err := migrator.Up(); err != nil {
+			t.Fatalf("migrating: %v", err)
+		}
+	}
+
+	reset()
+	fixture := Fixture{
+		Users: []FixtureUser{
+			{Username: "alice", Email: "alice@example.com", Password: "password", Name: "Alice", Role: "customer"},
+			{Username: "bob", Email: "bob@example.com", Password: "password", Name: "Bob", Role: "agent"},
+		},
+		Chats: []FixtureChat{{
+			Customer: "alice", Agent: "bob", Status: "active",
+			Messages: []FixtureMessage{
+				{Sender: "alice", Content: "My withdrawal is stuck"},
+				{Sender: "bob", Content: "Let me check"},
+				{Sender: "bob", Content: "Which withdrawal?"},
+			},
+		}},
+	}
+	if err := Seed(db, fixture); err != nil {
+		t.Fatalf("Seed: %v", err)
+	}
+	// The last message replies to the first, which is then updated so that
+	// its row is stored after the reply's
+	if _, err := db.Exec(`UPDATE messages SET reply_to_id = (SELECT id FROM messages WHERE seq = 1) WHERE seq = 3`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`UPDATE messages SET content = content || '!' WHERE seq = 1`); err != nil {
+		t.Fatal(err)
+	}
+
+	var exported bytes.Buffer
+	if err := Export(db, &exported); err != nil {
+		t.Fatalf("Export: %v", err)
+	}
+
+	// One row per statement, so that no batch holds a reply and its quote
+	defer func(batch int) { importBatch = batch }(importBatch)
+	importBatch = 1
+	reset()
+	if err := Import(db, bytes.NewReader(exported.Bytes())); err != nil {
+		t.Fatalf("Import: %v", err)
+	}
+
+	var quoted string
+	err := db.QueryRow(`SELECT q.content FROM messages m JOIN messages q ON q.id = m.reply_to_id WHERE m.seq = 3`).Scan(&quoted)
+	if err != nil {
+		t.Fatalf("loading the reply: %v", err)
+	}
+	if quoted != "My withdrawal is stuck!" {
+		t.Errorf("reply quotes %q, want the first message", quoted)
+	}
+
+	var reexported bytes.Buffer
+	if err := Export(db, &reexported); err != nil {
+		t.Fatalf("Export after Import: %v", err)
+	}
+	var before, after Dump
+	if err := json.Unmarshal(exported.Bytes(), &before); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(reexported.Bytes(), &after); err != nil {
+		t.Fatal(err)
+	}
+	if len(before.Tables) != len(after.Tables) {
+		t.Fatalf("exported %d tables, then %d", len(before.Tables), len(after.Tables))
+	}
+	for i, table := range before.Tables {
+		if len(table.Rows) != len(after.Tables[i].Rows) {
+			t.Errorf("%s: exported %d rows, then %d", table.Name, len(table.Rows), len(after.Tables[i].Rows))
+		}
+	}
+}
@@ -0,0 +1,186 @@
+package database
+
+import (
+	"bytes"
+	"embed"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"cs-socket/internal/models"
+
+	"gopkg.in/yaml.v3"
+)
+
+// fixtureFiles holds the named fixture sets, one YAML file per set.
+//
+//go:embed fixtures/*.yaml
+var fixtureFiles embed.FS
+
+// Fixture is a set of users and chats to seed a database with. Chats and
+// messages refer to users by username. Fixtures are read from YAML or JSON
+// with the field names of the tags below.
+type Fixture struct {
+	Users []FixtureUser `json:"users" yaml:"users"`
+	Chats []FixtureChat `json:"chats" yaml:"chats"`
+}
+
+type FixtureUser struct {
+	Username string `json:"username" yaml:"username"`
+	Email    string `json:"email" yaml:"email"`
+	Password string `json:"password" yaml:"password"`
+	Name     string `json:"name" yaml:"name"`
+	Role     string `json:"role" yaml:"role"`
+	// Tier defaults to regular
+	Tier string `json:"tier,omitempty" yaml:"tier,omitempty"`
+	Team string `json:"team,omitempty" yaml:"team,omitempty"`
+	// CreatedAt defaults to the time of seeding
+	CreatedAt time.Time `json:"createdAt" yaml:"createdAt,omitempty"`
+}
+
+type FixtureChat struct {
+	Customer string `json:"customer" yaml:"customer"`
+	// Agent is empty for queued chats
+	Agent  string `json:"agent,omitempty" yaml:"agent,omitempty"`
+	Status string `json:"status" yaml:"status"`
+	// Topic and Priority default to general and normal
+	Topic    string           `json:"topic,omitempty" yaml:"topic,omitempty"`
+	Priority string           `json:"priority,omitempty" yaml:"priority,omitempty"`
+	Messages []FixtureMessage `json:"messages,omitempty" yaml:"messages,omitempty"`
+	// CreatedAt defaults to the time of seeding. Resolved and closed chats
+	// are resolved at their last message.
+	CreatedAt time.Time `json:"createdAt" yaml:"createdAt,omitempty"`
+}
+
+type FixtureMessage struct {
+	Sender  string `json:"sender" yaml:"sender"`
+	Content string `json:"content" yaml:"content"`
+	// Visibility defaults to public; staff messages are whispers
+	Visibility string `json:"visibility,omitempty" yaml:"visibility,omitempty"`
+	// SentAt defaults to the chat's creation time
+	SentAt time.Time `json:"sentAt" yaml:"sentAt,omitempty"`
+}
+
+// FixtureSetNames returns the names of the built-in fixture sets in order.
+func FixtureSetNames() []string {
+	entries, _ := fs.ReadDir(fixtureFiles, "fixtures")
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		names = append(names, strings.TrimSuffix(entry.Name(), ".yaml"))
+	}
+	sort.Strings(names)
+	return names
+}
+
+// FixtureSet returns a built-in fixture set by name.
+func FixtureSet(name string) (Fixture, error) {
+	data, err := fs.ReadFile(fixtureFiles, "fixtures/"+name+".yaml")
+	if err != nil {
+		return Fixture{}, fmt.Errorf("unknown fixture set %q, expected one of %s", name, strings.Join(FixtureSetNames(), ", "))
+	}
+	return parseFixture(data, "yaml")
+}
+
+// LoadFixture reads a fixture from a .yaml, .yml or .json file.
+func LoadFixture(path string) (Fixture, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Fixture{}, err
+	}
+
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".yaml", ".yml":
+		return parseFixture(data, "yaml")
+	case ".json":
+		return parseFixture(data, "json")
+	default:
+		return Fixture{}, fmt.Errorf("fixture file %s must end in .yaml, .yml or .json", path)
+	}
+}
+
+// parseFixture decodes a fixture, rejecting unknown fields so that typos in
+// hand-written files don't go unnoticed.
+func parseFixture(data []byte, format string) (Fixture, error) {
+	var fixture Fixture
+	var err error
+	if format == "json" {
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		err = decoder.Decode(&fixture)
+	} else {
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		err = decoder.Decode(&fixture)
+	}
+	if err != nil {
+		return Fixture{}, fmt.Errorf("invalid fixture: %w", err)
+	}
+	return fixture, fixture.Validate()
+}
+
+// Validate checks that the fixture's users are unique and valid, and that
+// its chats and messages refer to them.
+func (f Fixture) Validate() error {
+	roles := make(map[string]string, len(f.Users))
+	emails := make(map[string]bool, len(f.Users))
+	for _, user := range f.Users {
+		switch {
+		case user.Username == "" || user.Email == "" || user.Name == "" || user.Password == "":
+			return fmt.Errorf("user %q needs a username, email, name and password", user.Username)
+		case user.Role != "customer" && user.Role != "agent" && user.Role != "super-agent":
+			return fmt.Errorf("user %s has unknown role %q", user.Username, user.Role)
+		case user.Tier != "" && user.Tier != models.TierRegular && user.Tier != models.TierVIP && user.Tier != models.TierHighRoller:
+			return fmt.Errorf("user %s has unknown tier %q", user.Username, user.Tier)
+		case roles[user.Username] != "":
+			return fmt.Errorf("user %s is listed twice", user.Username)
+		case emails[user.Email]:
+			return fmt.Errorf("email %s is used twice", user.Email)
+		}
+		roles[user.Username] = user.Role
+		emails[user.Email] = true
+	}
+
+	for i, chat := range f.Chats {
+		switch {
+		case roles[chat.Customer] != "customer":
+			return fmt.Errorf("chat %d: %q is not a customer of the fixture", i+1, chat.Customer)
+		case chat.Agent != "" && roles[chat.Agent] != "agent" && roles[chat.Agent] != "super-agent":
+			return fmt.Errorf("chat %d: %q is not an agent of the fixture", i+1, chat.Agent)
+		case !validChatStatus(chat.Status):
+			return fmt.Errorf("chat %d: unknown status %q", i+1, chat.Status)
+		case chat.Agent == "" && chat.Status != models.ChatStatusQueued:
+			return fmt.Errorf("chat %d: only queued chats can be without an agent", i+1)
+		}
+		switch chat.Priority {
+		case "", models.PriorityLow, models.PriorityNormal, models.PriorityHigh, models.PriorityUrgent:
+		default:
+			return fmt.Errorf("chat %d: unknown priority %q", i+1, chat.Priority)
+		}
+
+		for j, message := range chat.Messages {
+			switch {
+			case roles[message.Sender] == "":
+				return fmt.Errorf("chat %d, message %d: unknown sender %q", i+1, j+1, message.Sender)
+			case message.Visibility != "" && message.Visibility != models.VisibilityPublic && message.Visibility != models.VisibilityStaff:
+				return fmt.Errorf("chat %d, message %d: unknown visibility %q", i+1, j+1, message.Visibility)
+			case message.Visibility == models.VisibilityStaff && roles[message.Sender] == "customer":
+				return fmt.Errorf("chat %d, message %d: customers cannot send staff messages", i+1, j+1)
+			}
+		}
+	}
+	return nil
+}
+
+func validChatStatus(status string) bool {
+	switch status {
+	case models.ChatStatusQueued, models.ChatStatusActive, models.ChatStatusPendingCustomer,
+		models.ChatStatusResolved, models.ChatStatusClosed, models.ChatStatusArchived:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,201 @@
+package database
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFixtureSets(t *testing.T) {
+	names := FixtureSetNames()
+	if !reflect.DeepEqual(names, []string{"default", "demo", "staff"}) {
+		t.Fatalf("FixtureSetNames() = %v", names)
+	}
+	for _, name := range names {
+		fixture, err := FixtureSet(name)
+		if err != nil {
+			t.Errorf("FixtureSet(%q): %v", name, err)
+			continue
+		}
+		if len(fixture.Users) == 0 {
+			t.Errorf("fixture set %q has no users", name)
+		}
+	}
+
+	if _, err := FixtureSet("missing"); err == nil || !strings.Contains(err.Error(), "default, demo, staff") {
+		t.Errorf("FixtureSet(missing) error = %v", err)
+	}
+}
+
+func TestLoadFixture(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"chats.yaml": `
+users:
+  - {username: ann, email: ann@example.com, password: secret1, name: Ann, role: customer, tier: vip}
+  - {username: bob, email: bob@example.com, password: secret1, name: Bob, role: agent}
+chats:
+  - customer: ann
+    agent: bob
+    status: active
+    messages:
+      - {sender: ann, content: Hi}
+      - {sender: bob, content: Noted, visibility: staff}
+`,
+		"chats.json": `{
+  "users": [
+    {"username": "ann", "email": "ann@example.com", "password": "secret1", "name": "Ann", "role": "customer", "tier": "vip"},
+    {"username": "bob", "email": "bob@example.com", "password": "secret1", "name": "Bob", "role": "agent"}
+  ],
+  "chats": [
+    {"customer": "ann", "agent": "bob", "status": "active", "messages": [
+      {"sender": "ann", "content": "Hi"},
+      {"sender": "bob", "content": "Noted", "visibility": "staff"}
+    ]}
+  ]
+}`,
+		"typo.yaml": "users:\n  - {username: ann, emial: ann@example.com}\n",
+		"chats.txt": "users: []\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	yamlFixture, err := LoadFixture(filepath.Join(dir, "chats.yaml"))
+	if err != nil {
+		t.Fatalf("LoadFixture(yaml): %v", err)
+	}
+	jsonFixture, err := LoadFixture(filepath.Join(dir, "chats.json"))
+	if err != nil {
+		t.Fatalf("LoadFixture(json): %v", err)
+	}
+	if !reflect.DeepEqual(yamlFixture, jsonFixture) {
+		t.Errorf("YAML and JSON fixtures differ:\n%+v\n%+v", yamlFixture, jsonFixture)
+	}
+	if len(yamlFixture.Chats) != 1 || len(yamlFixture.Chats[0].Messages) != 2 || yamlFixture.Chats[0].Messages[1].Visibility != "staff" {
+		t.Errorf("fixture = %+v", yamlFixture)
+	}
+
+	for _, name := range []string{"typo.yaml", "chats.txt", "missing.yaml"} {
+		if _, err := LoadFixture(filepath.Join(dir, name)); err == nil {
+			t.Errorf("LoadFixture(%s) succeeded", name)
+		}
+	}
+}
+
+func TestFixtureValidate(t *testing.T) {
+	users := func() []FixtureUser {
+		return []FixtureUser{
+			{Username: "ann", Email: "ann@example.com", Password: "secret1", Name: "Ann", Role: "customer"},
+			{Username: "bob", Email: "bob@example.com", Password: "secret1", Name: "Bob", Role: "agent"},
+		}
+	}
+	chat := func() FixtureChat {
+		return FixtureChat{Customer: "ann", Agent: "bob", Status: "active",
+			Messages: []FixtureMessage{{Sender: "ann", Content: "Hi"}}}
+	}
+
+	tests := []struct {
+		name   string
+		change func(f *Fixture)
+		want   string
+	}{
+		{"valid", func(f *Fixture) {}, ""},
+		{"queued without agent", func(f *Fixture) { f.Chats[0].Agent, f.Chats[0].Status = "", "queued" }, ""},
+		{"missing email", func(f *Fixture) { f.Users[0].Email = "" }, "needs a username"},
+		{"unknown role", func(f *Fixture) { f.Users[0].Role = "admin" }, "unknown role"},
+		{"unknown tier", func(f *Fixture) { f.Users[0].Tier = "gold" }, "unknown tier"},
+		{"duplicate username", func(f *Fixture) { f.Users[1].Username = "ann" }, "listed twice"},
+		{"duplicate email", func(f *Fixture) { f.Users[1].Email = "ann@example.com" }, "used twice"},
+		{"agent as customer", func(f *Fixture) { f.Chats[0].Customer = "bob" }, "not a customer"},
+		{"customer as agent", func(f *Fixture) { f.Chats[0].Agent = "ann" }, "not an agent"},
+		{"unknown status", func(f *Fixture) { f.Chats[0].Status = "open" }, "unknown status"},
+		{"active without agent", func(f *Fixture) { f.Chats[0].Agent = "" }, "without an agent"},
+		{"unknown priority", func(f *Fixture) { f.Chats[0].Priority = "critical" }, "unknown priority"},
+		{"unknown sender", func(f *Fixture) { f.Chats[0].Messages[0].Sender = "eve" }, "unknown sender"},
+		{"unknown visibility", func(f *Fixture) { f.Chats[0].Messages[0].Visibility = "private" }, "unknown visibility"},
+		{"customer whisper", func(f *Fixture) { f.Chats[0].Messages[0].Visibility = "staff" }, "customers cannot"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fixture := Fixture{Users: users(), Chats: []FixtureChat{chat()}}
+			tt.change(&fixture)
+			err := fixture.Validate()
+			if tt.want == "" {
+				if err != nil {
+					t.Fatalf("Validate() = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("Validate() = %v, want an error containing %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestGenerate(t *testing.T) {
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	opts := GenerateOptions{Seed: 42, Customers: 50, Agents: 10, Chats: 200, MaxMessages: 12, Days: 30, Now: now}
+
+	fixture, err := Generate(opts)
+	if err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+	again, err := Generate(opts)
+	if err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+	if !reflect.DeepEqual(fixture, again) {
+		t.Error("Generate gave different fixtures for the same options")
+	}
+	opts.Seed = 43
+	other, err := Generate(opts)
+	if err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+	if reflect.DeepEqual(fixture, other) {
+		t.Error("Generate gave the same fixture for different seeds")
+	}
+
+	roles := make(map[string]int)
+	for _, user := range fixture.Users {
+		roles[user.Role]++
+	}
+	if roles["customer"] != 50 || roles["agent"] != 10 || roles["super-agent"] != 1 {
+		t.Errorf("roles = %v", roles)
+	}
+	if len(fixture.Chats) != 200 {
+		t.Fatalf("got %d chats, want 200", len(fixture.Chats))
+	}
+
+	statuses := make(map[string]int)
+	for i, chat := range fixture.Chats {
+		statuses[chat.Status]++
+		if len(chat.Messages) == 0 || len(chat.Messages) > opts.MaxMessages {
+			t.Errorf("chat %d has %d messages", i, len(chat.Messages))
+		}
+		if chat.CreatedAt.Before(now.AddDate(0, 0, -opts.Days)) || chat.CreatedAt.After(now) {
+			t.Errorf("chat %d created at %v", i, chat.CreatedAt)
+		}
+		previous := chat.CreatedAt
+		for j, message := range chat.Messages {
+			if message.SentAt.Before(previous) || message.SentAt.After(now) {
+				t.Errorf("chat %d, message %d sent at %v after %v", i, j, message.SentAt, previous)
+			}
+			previous = message.SentAt
+		}
+	}
+	if statuses["resolved"]+statuses["closed"]+statuses["archived"] < len(fixture.Chats)/2 {
+		t.Errorf("expected mostly finished chats, got %v", statuses)
+	}
+
+	if _, err := Generate(GenerateOptions{MaxMessages: 1}); err == nil {
+		t.Error("Generate accepted MaxMessages 1")
+	}
+}
@@ -0,0 +1,385 @@
+package database
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"time"
+
+	"cs-socket/internal/models"
+)
+
+// GenerateOptions sizes a synthetic fixture. Zero fields take the defaults
+// of DefaultGenerateOptions.
+type GenerateOptions struct {
+	// Seed makes the output reproducible: the same options give the same
+	// fixture
+	Seed      int64
+	Customers int
+	// Agents is the number of agents; a tenth as many super-agents, at least
+	// one, are added
+	Agents int
+	Chats  int
+	// MaxMessages bounds the messages of a chat
+	MaxMessages int
+	// Days spreads the chats over the days before Now
+	Days int
+	// Now is the time the history ends at, the time of generation if zero
+	Now time.Time
+	// Password is shared by every generated user
+	Password string
+}
+
+// DefaultGenerateOptions gives a data set big enough to exercise listing,
+// search and reporting.
+var DefaultGenerateOptions = GenerateOptions{
+	Seed:        1,
+	Customers:   1000,
+	Agents:      20,
+	Chats:       5000,
+	MaxMessages: 30,
+	Days:        90,
+	Password:    "password123",
+}
+
+var (
+	firstNames = []string{"Mike", "Lisa", "Omar", "Anna", "James", "Sofia", "Liam", "Emma", "Noah", "Mia",
+		"Lucas", "Chloe", "Ethan", "Zara", "Mateo", "Aisha", "Leo", "Hannah", "Ivan", "Priya"}
+	lastNames = []string{"Smith", "Garcia", "Khan", "Müller", "Rossi", "Johnson", "Novak", "Silva", "Chen",
+		"Kowalski", "Brown", "Dubois", "Jensen", "Okafor", "Tanaka", "Murphy", "Costa", "Nielsen"}
+	teams = []string{"support", "payments", "verification"}
+)
+
+// generatedTopic describes how customers open and pursue chats on a topic.
+type generatedTopic struct {
+	name      string
+	weight    int
+	openers   []string
+	followUps []string
+}
+
+var generatedTopics = []generatedTopic{
+	{"general", 30, []string{
+		"Hi, I have a question about my account",
+		"Hello, how do I change my email address?",
+		"Can you tell me how the loyalty program works?",
+	}, []string{
+		"Where can I find that in the app?",
+		"Is there a way to do it from my phone?",
+		"I don't see that option on my profile page",
+	}},
+	{"withdrawal", 20, []string{
+		"My withdrawal of %d EUR has been pending for %d days",
+		"Why was my withdrawal request #%d cancelled?",
+		"How long does a withdrawal to my bank card take?",
+	}, []string{
+		"I already sent my documents last week",
+		"The money still hasn't arrived on my card",
+		"Can you speed it up? I need the money by Friday",
+	}},
+	{"payment", 15, []string{
+		"My deposit of %d EUR was charged twice",
+		"My card payment keeps getting declined",
+		"I paid %d EUR but my balance didn't change",
+	}, []string{
+		"My bank says the payment went through",
+		"I tried another card and got the same error",
+		"Here is the transaction reference: TX%d",
+	}},
+	{"account", 15, []string{
+		"I can't log in to my account",
+		"My account was locked after %d login attempts",
+		"The password reset email never arrives",
+	}, []string{
+		"I checked my spam folder too",
+		"I'm using the same email as always",
+		"It says my session has expired every time",
+	}},
+	{"security", 10, []string{
+		"I got a login alert from a country I've never been to",
+		"Someone changed my password without my permission",
+		"I think my account has been hacked",
+	}, []string{
+		"I didn't share my password with anyone",
+		"Please lock my account until this is sorted",
+		"I've turned on two-factor authentication now",
+	}},
+	{"bonus", 10, []string{
+		"My welcome bonus of %d EUR wasn't credited",
+		"How do the wagering requirements work?",
+		"The free spins from the promotion are missing",
+	}, []string{
+		"I met all the conditions in the terms",
+		"A friend got the same bonus yesterday",
+		"Which games count towards the requirement?",
+	}},
+}
+
+var (
+	agentReplies = []string{
+		"Thanks for reaching out! Let me look into this for you.",
+		"I'm sorry about the trouble. Could you give me a moment to check?",
+		"I can see your account here. Let me check the details.",
+		"Thanks for your patience, I'm checking with the relevant team.",
+		"I understand, that must be frustrating. Let me see what I can do.",
+	}
+	agentQuestions = []string{
+		"Could you confirm the last four digits of your card?",
+		"Could you send me a screenshot of the error?",
+		"Can you confirm the date and amount of the transaction?",
+		"Could you upload a photo of your ID in the verification section?",
+	}
+	agentClosings = []string{
+		"That's sorted now. Is there anything else I can help with?",
+		"I've fixed it on our side, it should work now.",
+		"The issue is resolved. Have a great day!",
+	}
+	customerThanks = []string{"Thanks, that worked!", "Great, thank you for your help", "Perfect, thanks a lot"}
+	whispers       = []string{
+		"VIP customer, please handle with care",
+		"Checked with payments, the transfer is on our side",
+		"This looks like a duplicate of an earlier ticket",
+		"Customer has asked about this twice already",
+	}
+)
+
+// Generate builds a synthetic fixture of customers, agents and chats with
+// message histories spread over the options' days. Older chats are mostly
+// resolved or closed, recent ones are still open, and priorities follow the
+// customers' tiers and the chat topics like for chats opened through the API.
+func Generate(opts GenerateOptions) (Fixture, error) {
+	defaults := DefaultGenerateOptions
+	if opts.Customers == 0 {
+		opts.Customers = defaults.Customers
+	}
+	if opts.Agents == 0 {
+		opts.Agents = defaults.Agents
+	}
+	if opts.Chats == 0 {
+		opts.Chats = defaults.Chats
+	}
+	if opts.MaxMessages == 0 {
+		opts.MaxMessages = defaults.MaxMessages
+	}
+	if opts.Days == 0 {
+		opts.Days = defaults.Days
+	}
+	if opts.Now.IsZero() {
+		opts.Now = time.Now()
+	}
+	if opts.Password == "" {
+		opts.Password = defaults.Password
+	}
+	if opts.Customers < 0 || opts.Agents < 0 || opts.Chats < 0 || opts.MaxMessages < 2 || opts.Days < 0 {
+		return Fixture{}, fmt.Errorf("invalid generator options: %+v", opts)
+	}
+
+	g := &generator{rng: rand.New(rand.NewSource(opts.Seed)), opts: opts}
+	fixture := Fixture{}
+
+	superAgents := opts.Agents / 10
+	if superAgents == 0 {
+		superAgents = 1
+	}
+	for i := 1; i <= superAgents; i++ {
+		fixture.Users = append(fixture.Users, g.user(fmt.Sprintf("superagent%02d", i), "super-agent"))
+	}
+	agents := make([]string, 0, opts.Agents)
+	for i := 1; i <= opts.Agents; i++ {
+		user := g.user(fmt.Sprintf("agent%03d", i), "agent")
+		agents = append(agents, user.Username)
+		fixture.Users = append(fixture.Users, user)
+	}
+	customers := make([]FixtureUser, 0, opts.Customers)
+	for i := 1; i <= opts.Customers; i++ {
+		user := g.user(fmt.Sprintf("customer%05d", i), "customer")
+		user.Tier = g.tier()
+		customers = append(customers, user)
+		fixture.Users = append(fixture.Users, user)
+	}
+
+	if len(customers) > 0 && len(agents) > 0 {
+		for i := 0; i < opts.Chats; i++ {
+			customer := customers[g.rng.Intn(len(customers))]
+			fixture.Chats = append(fixture.Chats, g.chat(customer, agents[g.rng.Intn(len(agents))]))
+		}
+	}
+
+	return fixture, fixture.Validate()
+}
+
+type generator struct {
+	rng  *rand.Rand
+	opts GenerateOptions
+}
+
+func (g *generator) user(username, role string) FixtureUser {
+	user := FixtureUser{
+		Username: username,
+		Email:    username + "@example.com",
+		Password: g.opts.Password,
+		Name:     g.pick(firstNames) + " " + g.pick(lastNames),
+		Role:     role,
+		// Accounts predate the chats of the history
+		CreatedAt: g.opts.Now.AddDate(0, 0, -g.opts.Days-1-g.rng.Intn(365)),
+	}
+	if role != "customer" {
+		user.Team = g.pick(teams)
+	}
+	return user
+}
+
+func (g *generator) tier() string {
+	switch n := g.rng.Intn(100); {
+	case n < 3:
+		return models.TierHighRoller
+	case n < 15:
+		return models.TierVIP
+	default:
+		return models.TierRegular
+	}
+}
+
+func (g *generator) chat(customer FixtureUser, agent string) FixtureChat {
+	topic := g.topic()
+	window := time.Duration(g.opts.Days) * 24 * time.Hour
+	age := time.Duration(g.rng.Int63n(int64(window) + 1))
+	chat := FixtureChat{
+		Customer:  customer.Username,
+		Agent:     agent,
+		Status:    g.status(age),
+		Topic:     topic.name,
+		Priority:  generatedPriority(customer.Tier, topic.name),
+		CreatedAt: g.opts.Now.Add(-age),
+	}
+
+	// Replies come seconds to minutes apart, but never after now
+	at := chat.CreatedAt
+	send := func(sender, content, visibility string) {
+		at = at.Add(time.Duration(10+g.rng.Intn(600)) * time.Second)
+		if at.After(g.opts.Now) {
+			at = g.opts.Now
+		}
+		chat.Messages = append(chat.Messages, FixtureMessage{
+			Sender: sender, Content: content, Visibility: visibility, SentAt: at,
+		})
+	}
+
+	send(customer.Username, g.fill(g.pick(topic.openers)), "")
+	if chat.Status == models.ChatStatusQueued {
+		// Nobody has picked the chat up yet
+		chat.Agent = ""
+		if g.rng.Intn(2) == 0 {
+			send(customer.Username, g.fill(g.pick(topic.followUps)), "")
+		}
+		return chat
+	}
+
+	closing := chat.Status == models.ChatStatusResolved || chat.Status == models.ChatStatusClosed ||
+		chat.Status == models.ChatStatusArchived
+	// Room for the greeting and the ending
+	exchanges := g.rng.Intn((g.opts.MaxMessages-1)/2 + 1)
+	if closing {
+		exchanges = g.rng.Intn((g.opts.MaxMessages-3)/2 + 1)
+	}
+
+	send(agent, g.pick(agentReplies), "")
+	if customer.Tier != models.TierRegular || g.rng.Intn(10) == 0 {
+		send(agent, g.pick(whispers), models.VisibilityStaff)
+	}
+	for i := 0; i < exchanges && len(chat.Messages) < g.opts.MaxMessages-1; i++ {
+		send(customer.Username, g.fill(g.pick(topic.followUps)), "")
+		if g.rng.Intn(2) == 0 {
+			send(agent, g.pick(agentQuestions), "")
+		} else {
+			send(agent, g.pick(agentReplies), "")
+		}
+	}
+
+	switch {
+	case closing && len(chat.Messages) <= g.opts.MaxMessages-2:
+		send(agent, g.pick(agentClosings), "")
+		send(customer.Username, g.pick(customerThanks), "")
+	case chat.Status == models.ChatStatusPendingCustomer:
+		send(agent, g.pick(agentQuestions), "")
+	case chat.Status == models.ChatStatusActive:
+		send(customer.Username, g.fill(g.pick(topic.followUps)), "")
+	}
+	if len(chat.Messages) > g.opts.MaxMessages {
+		chat.Messages = chat.Messages[:g.opts.MaxMessages]
+	}
+	return chat
+}
+
+// status picks a chat status by age: chats from the last two days are
+// mostly open, older ones are finished.
+func (g *generator) status(age time.Duration) string {
+	n := g.rng.Intn(100)
+	if age < 48*time.Hour {
+		switch {
+		case n < 15:
+			return models.ChatStatusQueued
+		case n < 50:
+			return models.ChatStatusActive
+		case n < 70:
+			return models.ChatStatusPendingCustomer
+		default:
+			return models.ChatStatusResolved
+		}
+	}
+	switch {
+	case n < 55:
+		return models.ChatStatusResolved
+	case n < 90:
+		return models.ChatStatusClosed
+	default:
+		return models.ChatStatusArchived
+	}
+}
+
+func (g *generator) topic() generatedTopic {
+	total := 0
+	for _, topic := range generatedTopics {
+		total += topic.weight
+	}
+	n := g.rng.Intn(total)
+	for _, topic := range generatedTopics {
+		if n < topic.weight {
+			return topic
+		}
+		n -= topic.weight
+	}
+	return generatedTopics[0]
+}
+
+// fill replaces the %d verbs of a template with plausible numbers.
+func (g *generator) fill(template string) string {
+	for strings.Contains(template, "%d") {
+		template = strings.Replace(template, "%d", fmt.Sprint(2+g.rng.Intn(499)), 1)
+	}
+	return template
+}
+
+func (g *generator) pick(options []string) string {
+	return options[g.rng.Intn(len(options))]
+}
+
+// generatedPriority mirrors how the chat service prioritises new chats: by
+// tier, one level higher for the default elevated topics.
+func generatedPriority(tier, topic string) string {
+	levels := []string{models.PriorityLow, models.PriorityNormal, models.PriorityHigh, models.PriorityUrgent}
+	level := 1
+	switch tier {
+	case models.TierVIP:
+		level = 2
+	case models.TierHighRoller:
+		level = 3
+	}
+	if topic == "withdrawal" || topic == "payment" || topic == "security" {
+		level++
+	}
+	if level >= len(levels) {
+		level = len(levels) - 1
+	}
+	return levels[level]
+}
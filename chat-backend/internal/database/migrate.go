@@ -0,0 +1,368 @@
+package database
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"embed"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"log"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	"cs-socket/internal/config"
+)
+
+// migrationFiles holds the numbered migrations, one NNNN_name.up.sql and one
+// NNNN_name.down.sql file per version.
+//
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// migrationLockKey is the advisory lock held while migrating, so that
+// replicas starting at the same time migrate one after the other.
+const migrationLockKey int64 = 4715092386
+
+var migrationFileName = regexp.MustCompile(`^(\d+)_([a-z0-9_]+)\.(up|down)\.sql$`)
+
+// Migration is one version of the schema.
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+	// Checksum is the SHA-256 of Up, recorded when the migration is applied
+	// to detect migrations edited afterwards.
+	Checksum string
+}
+
+// Migration states reported by Migrator.Status.
+const (
+	MigrationApplied = "applied"
+	MigrationPending = "pending"
+	// MigrationModified is an applied migration whose file has changed since.
+	MigrationModified = "modified"
+	// MigrationMissing is an applied migration this build has no file for.
+	MigrationMissing = "missing"
+)
+
+type MigrationStatus struct {
+	Version   int64
+	Name      string
+	State     string
+	AppliedAt *time.Time
+}
+
+// appliedMigration is a row of schema_migrations.
+type appliedMigration struct {
+	Name      string
+	Checksum  string
+	AppliedAt time.Time
+}
+
+// Migrator applies and rolls back the embedded migrations, recording them in
+// the schema_migrations table.
+type Migrator struct {
+	db         *sql.DB
+	migrations []Migration
+}
+
+func NewMigrator(db *sql.DB) (*Migrator, error) {
+	migrations, err := loadMigrations(migrationFiles)
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{db: db, migrations: migrations}, nil
+}
+
+// RunMigrations brings the schema up to the latest version.
+func RunMigrations(cfg config.DatabaseConfig) error {
+	db, err := Connect(cfg)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	migrator, err := NewMigrator(db)
+	if err != nil {
+		return err
+	}
+	return migrator.Up()
+}
+
+// Latest returns the newest version, or 0 when there are no migrations.
+func (m *Migrator) Latest() int64 {
+	if len(m.migrations) == 0 {
+		return 0
+	}
+	return m.migrations[len(m.migrations)-1].Version
+}
+
+// Up applies every pending migration.
+func (m *Migrator) Up() error {
+	return m.To(m.Latest())
+}
+
+// Down rolls back the given number of most recently applied migrations.
+func (m *Migrator) Down(steps int) error {
+	if steps <= 0 {
+		return fmt.Errorf("number of migrations to roll back must be positive, got %d", steps)
+	}
+
+	return m.locked(func(conn *sql.Conn, applied map[int64]appliedMigration) error {
+		for i := len(m.migrations) - 1; i >= 0 && steps > 0; i-- {
+			migration := m.migrations[i]
+			if _, ok := applied[migration.Version]; !ok {
+				continue
+			}
+			if err := m.revert(conn, migration); err != nil {
+				return err
+			}
+			steps--
+		}
+		return nil
+	})
+}
+
+// To migrates up or down to the given version: migrations after it are rolled
+// back, newest first, and pending ones up to it are applied, oldest first.
+// Version 0 rolls back everything.
+func (m *Migrator) To(version int64) error {
+	if version != 0 && m.find(version) == nil {
+		return fmt.Errorf("unknown migration version %d", version)
+	}
+
+	return m.locked(func(conn *sql.Conn, applied map[int64]appliedMigration) error {
+		for i := len(m.migrations) - 1; i >= 0; i-- {
+			migration := m.migrations[i]
+			if _, ok := applied[migration.Version]; ok && migration.Version > version {
+				if err := m.revert(conn, migration); err != nil {
+					return err
+				}
+			}
+		}
+		for _, migration := range m.migrations {
+			if _, ok := applied[migration.Version]; !ok && migration.Version <= version {
+				if err := m.apply(conn, migration); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// Status lists the known migrations, oldest first, followed by the applied
+// migrations this build has no file for.
+func (m *Migrator) Status() ([]MigrationStatus, error) {
+	ctx := context.Background()
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	var exists bool
+	if err := conn.QueryRowContext(ctx, `SELECT to_regclass('schema_migrations') IS NOT NULL`).Scan(&exists); err != nil {
+		return nil, err
+	}
+	applied := map[int64]appliedMigration{}
+	if exists {
+		if applied, err = loadApplied(conn); err != nil {
+			return nil, err
+		}
+	}
+
+	statuses := make([]MigrationStatus, 0, len(m.migrations))
+	for _, migration := range m.migrations {
+		status := MigrationStatus{Version: migration.Version, Name: migration.Name, State: MigrationPending}
+		if row, ok := applied[migration.Version]; ok {
+			status.State = MigrationApplied
+			if row.Checksum != migration.Checksum {
+				status.State = MigrationModified
+			}
+			appliedAt := row.AppliedAt
+			status.AppliedAt = &appliedAt
+			delete(applied, migration.Version)
+		}
+		statuses = append(statuses, status)
+	}
+
+	missing := make([]MigrationStatus, 0, len(applied))
+	for version, row := range applied {
+		appliedAt := row.AppliedAt
+		missing = append(missing, MigrationStatus{Version: version, Name: row.Name, State: MigrationMissing, AppliedAt: &appliedAt})
+	}
+	sort.Slice(missing, func(i, j int) bool { return missing[i].Version < missing[j].Version })
+
+	return append(statuses, missing...), nil
+}
+
+// locked runs fn on a connection holding the migration lock, after checking
+// that the applied migrations match this build.
+func (m *Migrator) locked(fn func(conn *sql.Conn, applied map[int64]appliedMigration) error) error {
+	ctx := context.Background()
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	// Advisory locks belong to the session, so the lock and the migrations
+	// must share one connection
+	if _, err := conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, migrationLockKey); err != nil {
+		return fmt.Errorf("failed to take migration lock: %w", err)
+	}
+	defer conn.ExecContext(ctx, `SELECT pg_advisory_unlock($1)`, migrationLockKey)
+
+	if _, err := conn.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version BIGINT PRIMARY KEY,
+		name VARCHAR(255) NOT NULL,
+		checksum CHAR(64) NOT NULL,
+		applied_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+	)`); err != nil {
+		return err
+	}
+
+	applied, err := loadApplied(conn)
+	if err != nil {
+		return err
+	}
+	for version, row := range applied {
+		migration := m.find(version)
+		if migration == nil {
+			return fmt.Errorf("migration %04d_%s is applied but not part of this build", version, row.Name)
+		}
+		if row.Checksum != migration.Checksum {
+			return fmt.Errorf("migration %04d_%s has changed since it was applied", version, migration.Name)
+		}
+	}
+
+	return fn(conn, applied)
+}
+
+func (m *Migrator) apply(conn *sql.Conn, migration Migration) error {
+	err := inTx(conn, migration.Up,
+		`INSERT INTO schema_migrations (version, name, checksum) VALUES ($1, $2, $3)`,
+		migration.Version, migration.Name, migration.Checksum)
+	if err != nil {
+		return fmt.Errorf("failed to apply migration %04d_%s: %w", migration.Version, migration.Name, err)
+	}
+	log.Printf("Applied migration %04d_%s", migration.Version, migration.Name)
+	return nil
+}
+
+func (m *Migrator) revert(conn *sql.Conn, migration Migration) error {
+	err := inTx(conn, migration.Down, `DELETE FROM schema_migrations WHERE version = $1`, migration.Version)
+	if err != nil {
+		return fmt.Errorf("failed to roll back migration %04d_%s: %w", migration.Version, migration.Name, err)
+	}
+	log.Printf("Rolled back migration %04d_%s", migration.Version, migration.Name)
+	return nil
+}
+
+// inTx runs a migration script and the statement recording it in one
+// transaction, so that a failing migration changes neither the schema nor
+// schema_migrations.
+func inTx(conn *sql.Conn, script, record string, args ...interface{}) error {
+	ctx := context.Background()
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, script); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, record, args...); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (m *Migrator) find(version int64) *Migration {
+	for i := range m.migrations {
+		if m.migrations[i].Version == version {
+			return &m.migrations[i]
+		}
+	}
+	return nil
+}
+
+func loadApplied(conn *sql.Conn) (map[int64]appliedMigration, error) {
+	rows, err := conn.QueryContext(context.Background(),
+		`SELECT version, name, checksum, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := make(map[int64]appliedMigration)
+	for rows.Next() {
+		var version int64
+		var row appliedMigration
+		if err := rows.Scan(&version, &row.Name, &row.Checksum, &row.AppliedAt); err != nil {
+			return nil, err
+		}
+		applied[version] = row
+	}
+	return applied, rows.Err()
+}
+
+// loadMigrations reads the migrations from the migrations directory of fsys,
+// oldest first. Every version needs both an up and a down file.
+func loadMigrations(fsys fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, "migrations")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int64]*Migration)
+	for _, entry := range entries {
+		match := migrationFileName.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil, fmt.Errorf("migration file %s is not named NNNN_name.up.sql or NNNN_name.down.sql", entry.Name())
+		}
+		version, err := strconv.ParseInt(match[1], 10, 64)
+		if err != nil || version <= 0 {
+			return nil, fmt.Errorf("migration file %s has an invalid version", entry.Name())
+		}
+		content, err := fs.ReadFile(fsys, path.Join("migrations", entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = migration
+		}
+		if migration.Name != match[2] {
+			return nil, fmt.Errorf("migration version %d is used by both %s and %s", version, migration.Name, match[2])
+		}
+		if match[3] == "up" {
+			sum := sha256.Sum256(content)
+			migration.Up = string(content)
+			migration.Checksum = hex.EncodeToString(sum[:])
+		} else {
+			migration.Down = string(content)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		if migration.Up == "" || migration.Down == "" {
+			return nil, fmt.Errorf("migration %04d_%s needs both an up and a down file", migration.Version, migration.Name)
+		}
+		migrations = append(migrations, *migration)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+
+	return migrations, nil
+}
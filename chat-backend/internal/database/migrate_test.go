@@ -0,0 +1,131 @@
+package database
+
+import (
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestEmbeddedMigrations(t *testing.T) {
+	migrations, err := loadMigrations(migrationFiles)
+	if err != nil {
+		t.Fatalf("loading embedded migrations: %v", err)
+	}
+	if len(migrations) == 0 {
+		t.Fatal("no migrations embedded")
+	}
+
+	for i, migration := range migrations {
+		if migration.Version != int64(i+1) {
+			t.Errorf("migration %d has version %d, want versions numbered from 1 without gaps", i, migration.Version)
+		}
+		if len(migration.Checksum) != 64 {
+			t.Errorf("migration %d checksum = %q", migration.Version, migration.Checksum)
+		}
+	}
+}
+
+func TestLoadMigrations(t *testing.T) {
+	migrations, err := loadMigrations(fstest.MapFS{
+		"migrations/0002_add_notes.up.sql":   {Data: []byte("ALTER TABLE chats ADD COLUMN notes TEXT;")},
+		"migrations/0002_add_notes.down.sql": {Data: []byte("ALTER TABLE chats DROP COLUMN notes;")},
+		"migrations/0001_init.up.sql":        {Data: []byte("CREATE TABLE chats (id UUID);")},
+		"migrations/0001_init.down.sql":      {Data: []byte("DROP TABLE chats;")},
+	})
+	if err != nil {
+		t.Fatalf("loadMigrations: %v", err)
+	}
+	if len(migrations) != 2 || migrations[0].Name != "init" || migrations[1].Version != 2 {
+		t.Fatalf("migrations = %+v", migrations)
+	}
+	if !strings.HasPrefix(migrations[1].Down, "ALTER TABLE chats DROP") {
+		t.Errorf("down = %q", migrations[1].Down)
+	}
+	if migrations[0].Checksum == migrations[1].Checksum {
+		t.Error("different migrations have the same checksum")
+	}
+}
+
+func TestLoadMigrationsErrors(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		files fstest.MapFS
+	}{
+		{"missing down", fstest.MapFS{
+			"migrations/0001_init.up.sql": {Data: []byte("SELECT 1;")},
+		}},
+		{"bad name", fstest.MapFS{
+			"migrations/init.sql": {Data: []byte("SELECT 1;")},
+		}},
+		{"version zero", fstest.MapFS{
+			"migrations/0000_init.up.sql":   {Data: []byte("SELECT 1;")},
+			"migrations/0000_init.down.sql": {Data: []byte("SELECT 1;")},
+		}},
+		{"duplicate version", fstest.MapFS{
+			"migrations/0001_init.up.sql":    {Data: []byte("SELECT 1;")},
+			"migrations/0001_init.down.sql":  {Data: []byte("SELECT 1;")},
+			"migrations/0001_other.up.sql":   {Data: []byte("SELECT 2;")},
+			"migrations/0001_other.down.sql": {Data: []byte("SELECT 2;")},
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := loadMigrations(tc.files); err == nil {
+				t.Error("loadMigrations succeeded")
+			}
+		})
+	}
+}
+
+// TestTimestamptzMigration applies and rolls back the migration that stores
+// timestamps as timestamptz, and checks that stored times keep their value
+// both ways. It needs a scratch database, see openTestDB.
+//
+//	TEST_DB_NAME=chat_test go test -run Timestamptz ./internal/database
+func TestTimestamptzMigration(t *testing.T) {
+	db, migrator := openTestDB(t)
+	defer migrator.Up()
+
+	const stored = "2024-03-01 12:34:56"
+	check := func(wantType string) {
+		t.Helper()
+		var dataType, value string
+		err := db.QueryRow(`SELECT data_type FROM information_schema.columns
+			WHERE table_schema = current_schema() AND table_name = 'users' AND column_name = 'created_at'`).Scan(&dataType)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if dataType != wantType {
+			t.Errorf("users.created_at is %s, want %s", dataType, wantType)
+		}
+		err = db.QueryRow(`SELECT to_char(created_at, 'YYYY-MM-DD HH24:MI:SS') FROM users WHERE username = 'tz'`).Scan(&value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if value != stored {
+			t.Errorf("created_at = %s, want %s", value, stored)
+		}
+	}
+
+	if err := migrator.To(0); err != nil {
+		t.Fatalf("rolling back: %v", err)
+	}
+	if err := migrator.To(1); err != nil {
+		t.Fatalf("migrating to the baseline: %v", err)
+	}
+	_, err := db.Exec(`INSERT INTO users (username, email, password_hash, name, created_at)
+		VALUES ('tz', 'tz@example.com', '-', 'TZ', $1::timestamp)`, stored)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check("timestamp without time zone")
+
+	if err := migrator.To(2); err != nil {
+		t.Fatalf("migrating to timestamptz: %v", err)
+	}
+	check("timestamp with time zone")
+
+	if err := migrator.To(1); err != nil {
+		t.Fatalf("rolling back timestamptz: %v", err)
+	}
+	check("timestamp without time zone")
+}
@@ -0,0 +1,21 @@
+-- Drops every table of the baseline schema, with all of its data.
+
+DROP TABLE IF EXISTS attachment_thumbnails;
+DROP TABLE IF EXISTS attachments;
+DROP TABLE IF EXISTS chat_reads;
+DROP TABLE IF EXISTS message_reactions;
+DROP TABLE IF EXISTS message_edits;
+DROP TABLE IF EXISTS canned_response_usage;
+DROP TABLE IF EXISTS canned_responses;
+DROP TABLE IF EXISTS chat_tags;
+DROP TABLE IF EXISTS tags;
+DROP TABLE IF EXISTS csat_ratings;
+DROP TABLE IF EXISTS chat_escalations;
+DROP TABLE IF EXISTS chat_participants;
+DROP TABLE IF EXISTS sla_events;
+DROP TABLE IF EXISTS sla_policies;
+DROP TABLE IF EXISTS chat_status_history;
+DROP TABLE IF EXISTS message_mentions;
+DROP TABLE IF EXISTS messages;
+DROP TABLE IF EXISTS chats;
+DROP TABLE IF EXISTS users;
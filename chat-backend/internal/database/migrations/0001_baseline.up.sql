@@ -0,0 +1,279 @@
+-- The schema as it stood when versioned migrations were introduced, with
+-- every table and column the startup schema setup had created up to then.
+-- Every statement is idempotent, so databases created by earlier releases
+-- adopt this version without changes, while new databases are created from
+-- scratch. Later schema changes go in their own numbered migrations.
+
+CREATE TABLE IF NOT EXISTS users (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    username VARCHAR(50) UNIQUE NOT NULL,
+    email VARCHAR(100) UNIQUE NOT NULL,
+    password_hash VARCHAR(255) NOT NULL,
+    name VARCHAR(100) NOT NULL,
+    role VARCHAR(20) NOT NULL DEFAULT 'customer',
+    avatar VARCHAR(255),
+    is_online BOOLEAN DEFAULT false,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS chats (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    customer_id UUID NOT NULL REFERENCES users(id),
+    agent_id UUID REFERENCES users(id),
+    status VARCHAR(20) DEFAULT 'active',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS messages (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    chat_id UUID NOT NULL REFERENCES chats(id) ON DELETE CASCADE,
+    sender_id UUID NOT NULL REFERENCES users(id),
+    content TEXT NOT NULL,
+    message_type VARCHAR(20) DEFAULT 'text',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS tier VARCHAR(20) NOT NULL DEFAULT 'regular';
+ALTER TABLE users ADD COLUMN IF NOT EXISTS team VARCHAR(50);
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS visibility VARCHAR(20) NOT NULL DEFAULT 'public';
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS metadata JSONB;
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS edited_at TIMESTAMP;
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP;
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS deleted_by UUID REFERENCES users(id) ON DELETE SET NULL;
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS reply_to_id UUID REFERENCES messages(id) ON DELETE SET NULL;
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS seq BIGINT;
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS public_seq BIGINT;
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP;
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS search_vector tsvector
+    GENERATED ALWAYS AS (to_tsvector('english', content)) STORED;
+
+CREATE TABLE IF NOT EXISTS message_mentions (
+    message_id UUID NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (message_id, user_id)
+);
+
+ALTER TABLE chats ADD COLUMN IF NOT EXISTS resolution_code VARCHAR(50);
+ALTER TABLE chats ADD COLUMN IF NOT EXISTS wrap_up_notes TEXT;
+ALTER TABLE chats ADD COLUMN IF NOT EXISTS resolved_at TIMESTAMP;
+ALTER TABLE chats ADD COLUMN IF NOT EXISTS closed_at TIMESTAMP;
+ALTER TABLE chats ADD COLUMN IF NOT EXISTS topic VARCHAR(50) NOT NULL DEFAULT 'general';
+ALTER TABLE chats ADD COLUMN IF NOT EXISTS idle_nudged_at TIMESTAMP;
+ALTER TABLE chats ADD COLUMN IF NOT EXISTS priority VARCHAR(20) NOT NULL DEFAULT 'normal';
+ALTER TABLE chats ADD COLUMN IF NOT EXISTS is_escalated BOOLEAN NOT NULL DEFAULT false;
+ALTER TABLE chats ADD COLUMN IF NOT EXISTS last_seq BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE chats ADD COLUMN IF NOT EXISTS last_public_seq BIGINT NOT NULL DEFAULT 0;
+
+-- Number the messages sent before sequence numbers existed in the
+-- order they were sent, then continue each chat's counters from there
+UPDATE messages m SET seq = n.seq, public_seq = n.public_seq
+    FROM (SELECT id,
+        ROW_NUMBER() OVER (PARTITION BY chat_id ORDER BY created_at, id) AS seq,
+        CASE WHEN visibility = 'public' THEN
+            COUNT(*) FILTER (WHERE visibility = 'public') OVER (PARTITION BY chat_id ORDER BY created_at, id)
+        END AS public_seq
+        FROM messages
+        WHERE chat_id IN (SELECT chat_id FROM messages WHERE seq IS NULL)) n
+    WHERE m.id = n.id AND m.seq IS NULL;
+
+UPDATE chats c SET last_seq = n.last_seq, last_public_seq = n.last_public_seq
+    FROM (SELECT chat_id, MAX(seq) AS last_seq, COALESCE(MAX(public_seq), 0) AS last_public_seq
+        FROM messages GROUP BY chat_id) n
+    WHERE c.id = n.chat_id AND c.last_seq < n.last_seq;
+ALTER TABLE messages ALTER COLUMN seq SET NOT NULL;
+
+CREATE TABLE IF NOT EXISTS chat_status_history (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    chat_id UUID NOT NULL REFERENCES chats(id) ON DELETE CASCADE,
+    from_status VARCHAR(20),
+    to_status VARCHAR(20) NOT NULL,
+    changed_by UUID REFERENCES users(id),
+    resolution_code VARCHAR(50),
+    note TEXT,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS sla_policies (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    name VARCHAR(100) NOT NULL,
+    priority VARCHAR(20),
+    topic VARCHAR(50),
+    first_response_warning_secs INTEGER NOT NULL DEFAULT 0,
+    first_response_breach_secs INTEGER NOT NULL,
+    resolution_warning_secs INTEGER NOT NULL DEFAULT 0,
+    resolution_breach_secs INTEGER NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+INSERT INTO sla_policies (name, first_response_warning_secs, first_response_breach_secs,
+    resolution_warning_secs, resolution_breach_secs)
+    SELECT 'Default', 120, 300, 1800, 3600
+    WHERE NOT EXISTS (SELECT 1 FROM sla_policies);
+
+CREATE TABLE IF NOT EXISTS sla_events (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    chat_id UUID NOT NULL REFERENCES chats(id) ON DELETE CASCADE,
+    policy_id UUID REFERENCES sla_policies(id) ON DELETE SET NULL,
+    agent_id UUID REFERENCES users(id),
+    metric VARCHAR(20) NOT NULL,
+    level VARCHAR(20) NOT NULL,
+    threshold_secs INTEGER NOT NULL,
+    elapsed_secs INTEGER NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (chat_id, metric, level)
+);
+
+CREATE TABLE IF NOT EXISTS chat_participants (
+    chat_id UUID NOT NULL REFERENCES chats(id) ON DELETE CASCADE,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    role VARCHAR(20) NOT NULL DEFAULT 'assistant',
+    added_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (chat_id, user_id)
+);
+
+CREATE TABLE IF NOT EXISTS chat_escalations (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    chat_id UUID NOT NULL REFERENCES chats(id) ON DELETE CASCADE,
+    requested_by UUID NOT NULL REFERENCES users(id),
+    reason TEXT NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'open',
+    claimed_by UUID REFERENCES users(id),
+    claimed_at TIMESTAMP,
+    outcome TEXT,
+    resolved_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS csat_ratings (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    chat_id UUID NOT NULL UNIQUE REFERENCES chats(id) ON DELETE CASCADE,
+    customer_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    agent_id UUID REFERENCES users(id) ON DELETE SET NULL,
+    team VARCHAR(50),
+    score SMALLINT NOT NULL CHECK (score BETWEEN 1 AND 5),
+    comment TEXT,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS tags (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    name VARCHAR(50) NOT NULL UNIQUE,
+    description TEXT,
+    color VARCHAR(20),
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS chat_tags (
+    chat_id UUID NOT NULL REFERENCES chats(id) ON DELETE CASCADE,
+    tag_id UUID NOT NULL REFERENCES tags(id) ON DELETE CASCADE,
+    added_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    added_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (chat_id, tag_id)
+);
+
+CREATE TABLE IF NOT EXISTS canned_responses (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    owner_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    scope VARCHAR(20) NOT NULL DEFAULT 'personal',
+    team VARCHAR(50),
+    shortcut VARCHAR(50) NOT NULL,
+    title VARCHAR(100) NOT NULL,
+    content TEXT NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS canned_response_usage (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    canned_response_id UUID NOT NULL REFERENCES canned_responses(id) ON DELETE CASCADE,
+    chat_id UUID NOT NULL REFERENCES chats(id) ON DELETE CASCADE,
+    agent_id UUID REFERENCES users(id) ON DELETE SET NULL,
+    message_id UUID REFERENCES messages(id) ON DELETE SET NULL,
+    used_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS message_edits (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    message_id UUID NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+    content TEXT NOT NULL,
+    edited_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    edited_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS message_reactions (
+    message_id UUID NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    emoji VARCHAR(32) NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (message_id, user_id, emoji)
+);
+
+CREATE TABLE IF NOT EXISTS chat_reads (
+    chat_id UUID NOT NULL REFERENCES chats(id) ON DELETE CASCADE,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    last_read_seq BIGINT NOT NULL DEFAULT 0,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (chat_id, user_id)
+);
+
+CREATE TABLE IF NOT EXISTS attachments (
+    id UUID PRIMARY KEY,
+    chat_id UUID NOT NULL REFERENCES chats(id) ON DELETE CASCADE,
+    message_id UUID REFERENCES messages(id) ON DELETE SET NULL,
+    uploader_id UUID REFERENCES users(id) ON DELETE SET NULL,
+    file_name VARCHAR(255) NOT NULL,
+    content_type VARCHAR(100) NOT NULL,
+    size_bytes BIGINT NOT NULL,
+    storage_key VARCHAR(255) NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+ALTER TABLE attachments ADD COLUMN IF NOT EXISTS width INTEGER;
+ALTER TABLE attachments ADD COLUMN IF NOT EXISTS height INTEGER;
+ALTER TABLE attachments ADD COLUMN IF NOT EXISTS scan_status VARCHAR(20) NOT NULL DEFAULT 'unscanned';
+ALTER TABLE attachments ADD COLUMN IF NOT EXISTS scan_signature VARCHAR(255);
+ALTER TABLE attachments ADD COLUMN IF NOT EXISTS scan_attempts INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE attachments ADD COLUMN IF NOT EXISTS scan_attempted_at TIMESTAMP;
+ALTER TABLE attachments ADD COLUMN IF NOT EXISTS scanned_at TIMESTAMP;
+
+CREATE TABLE IF NOT EXISTS attachment_thumbnails (
+    attachment_id UUID NOT NULL REFERENCES attachments(id) ON DELETE CASCADE,
+    name VARCHAR(20) NOT NULL,
+    content_type VARCHAR(100) NOT NULL,
+    size_bytes BIGINT NOT NULL,
+    width INTEGER NOT NULL,
+    height INTEGER NOT NULL,
+    storage_key VARCHAR(255) NOT NULL,
+    PRIMARY KEY (attachment_id, name)
+);
+
+CREATE INDEX IF NOT EXISTS idx_chats_customer_id ON chats(customer_id);
+CREATE INDEX IF NOT EXISTS idx_chats_agent_id ON chats(agent_id);
+CREATE INDEX IF NOT EXISTS idx_messages_chat_id ON messages(chat_id);
+CREATE INDEX IF NOT EXISTS idx_messages_created_at ON messages(created_at);
+CREATE INDEX IF NOT EXISTS idx_messages_search ON messages USING GIN (search_vector);
+DROP INDEX IF EXISTS idx_messages_chat_page;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_messages_chat_seq ON messages(chat_id, seq);
+CREATE INDEX IF NOT EXISTS idx_messages_updated_at ON messages(updated_at);
+CREATE INDEX IF NOT EXISTS idx_chats_updated_at ON chats(updated_at);
+CREATE INDEX IF NOT EXISTS idx_chats_created_at ON chats(created_at);
+CREATE INDEX IF NOT EXISTS idx_chats_status ON chats(status);
+CREATE INDEX IF NOT EXISTS idx_chat_status_history_chat_id ON chat_status_history(chat_id, created_at);
+CREATE INDEX IF NOT EXISTS idx_chat_escalations_chat_id ON chat_escalations(chat_id);
+CREATE INDEX IF NOT EXISTS idx_chat_escalations_status ON chat_escalations(status, created_at);
+CREATE INDEX IF NOT EXISTS idx_sla_events_created_at ON sla_events(created_at);
+CREATE INDEX IF NOT EXISTS idx_message_mentions_user_id ON message_mentions(user_id, created_at);
+CREATE INDEX IF NOT EXISTS idx_csat_ratings_created_at ON csat_ratings(created_at);
+CREATE INDEX IF NOT EXISTS idx_chat_tags_tag_id ON chat_tags(tag_id);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_canned_responses_personal_shortcut ON canned_responses(owner_id, shortcut) WHERE scope = 'personal';
+CREATE UNIQUE INDEX IF NOT EXISTS idx_canned_responses_team_shortcut ON canned_responses(team, shortcut) WHERE scope = 'team';
+CREATE INDEX IF NOT EXISTS idx_attachments_message_id ON attachments(message_id);
+CREATE INDEX IF NOT EXISTS idx_attachments_scan_pending ON attachments(created_at) WHERE scan_status = 'pending';
+CREATE INDEX IF NOT EXISTS idx_message_edits_message_id ON message_edits(message_id, edited_at);
+CREATE INDEX IF NOT EXISTS idx_canned_response_usage_response_id ON canned_response_usage(canned_response_id, used_at);
@@ -0,0 +1,15 @@
+-- Turns the timestamptz columns back into TIMESTAMP in the session time zone.
+
+DO $$
+DECLARE
+    col RECORD;
+BEGIN
+    FOR col IN
+        SELECT table_name, column_name FROM information_schema.columns
+        WHERE table_schema = current_schema()
+          AND table_name != 'schema_migrations'
+          AND data_type = 'timestamp with time zone'
+    LOOP
+        EXECUTE format('ALTER TABLE %I ALTER COLUMN %I TYPE TIMESTAMP', col.table_name, col.column_name);
+    END LOOP;
+END $$;
@@ -0,0 +1,18 @@
+-- Stores every timestamp as timestamptz. TIMESTAMP columns drop the zone, so
+-- times written by the database in its session time zone and times written by
+-- the server in UTC could not be compared. Existing values were mostly written
+-- with CURRENT_TIMESTAMP and are read in the session time zone.
+
+DO $$
+DECLARE
+    col RECORD;
+BEGIN
+    FOR col IN
+        SELECT table_name, column_name FROM information_schema.columns
+        WHERE table_schema = current_schema()
+          AND table_name != 'schema_migrations'
+          AND data_type = 'timestamp without time zone'
+    LOOP
+        EXECUTE format('ALTER TABLE %I ALTER COLUMN %I TYPE TIMESTAMPTZ', col.table_name, col.column_name);
+    END LOOP;
+END $$;
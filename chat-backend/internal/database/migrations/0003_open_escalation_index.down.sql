@@ -0,0 +1 @@
+DROP INDEX IF EXISTS chat_escalations_one_pending;
@@ -0,0 +1,14 @@
+-- A chat has at most one open or claimed escalation. Duplicates left by
+-- concurrent requests are cancelled, keeping the earliest.
+
+UPDATE chat_escalations e SET status = 'cancelled', resolved_at = CURRENT_TIMESTAMP
+WHERE status IN ('open', 'claimed')
+  AND EXISTS (
+    SELECT 1 FROM chat_escalations earlier
+    WHERE earlier.chat_id = e.chat_id
+      AND earlier.status IN ('open', 'claimed')
+      AND (earlier.created_at, earlier.id) < (e.created_at, e.id)
+  );
+
+CREATE UNIQUE INDEX chat_escalations_one_pending ON chat_escalations (chat_id)
+    WHERE status IN ('open', 'claimed');
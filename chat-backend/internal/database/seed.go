@@ -4,11 +4,24 @@ import (
 	"database/sql"
 	"fmt"
 	"log"
+	"time"
 
+	"cs-socket/internal/config"
+	"cs-socket/internal/models"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
 	"golang.org/x/crypto/bcrypt"
 )
 
-func SeedDatabase(db *sql.DB) error {
+// SeedDatabase seeds an empty database with the default fixture set. It does
+// nothing in release mode, since the fixture accounts have known passwords.
+func SeedDatabase(db *sql.DB, server config.ServerConfig) error {
+	if server.Release() {
+		log.Println("Release mode, not seeding the database")
+		return nil
+	}
+
 	// Check if users already exist
 	var count int
 	err := db.QueryRow("SELECT COUNT(*) FROM users").Scan(&count)
@@ -22,78 +35,191 @@ func SeedDatabase(db *sql.DB) error {
 	}
 
 	log.Println("Seeding database with initial data...")
+	fixture, err := FixtureSet("default")
+	if err != nil {
+		return err
+	}
+	if err := Seed(db, fixture); err != nil {
+		return err
+	}
+	log.Println("Database seeded successfully!")
+	return nil
+}
 
-	// Create test users
-	users := []struct {
-		username string
-		email    string
-		password string
-		name     string
-		role     string
-	}{
-		{"agent1", "agent1@example.com", "password123", "Agent John", "agent"},
-		{"agent2", "agent2@example.com", "password123", "Agent Sarah", "agent"},
-		{"agent3", "agent3@example.com", "password123", "Agent David", "agent"},
-		{"superagent1", "superagent1@example.com", "password123", "Super Agent Admin", "super-agent"},
-		{"customer1", "customer1@example.com", "password123", "Customer Mike", "customer"},
-		{"customer2", "customer2@example.com", "password123", "Customer Lisa", "customer"},
+// Seed inserts a fixture in one transaction, so that a fixture clashing with
+// existing users inserts nothing. Rows are loaded with COPY, which keeps
+// generated fixtures of thousands of chats fast to seed.
+//
+// Messages are numbered in fixture order, and every participant has read
+// their chat up to the last message they sent, as when sending through the
+// API.
+func Seed(db *sql.DB, fixture Fixture) error {
+	if err := fixture.Validate(); err != nil {
+		return err
 	}
+	now := time.Now()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Hashing is slow on purpose, so users sharing a password share a hash
+	hashes := make(map[string]string)
+	userIDs := make(map[string]string, len(fixture.Users))
+	roles := make(map[string]string, len(fixture.Users))
+	users := make([][]interface{}, 0, len(fixture.Users))
+	for _, user := range fixture.Users {
+		hash, ok := hashes[user.Password]
+		if !ok {
+			hashed, err := bcrypt.GenerateFromPassword([]byte(user.Password), bcrypt.DefaultCost)
+			if err != nil {
+				return fmt.Errorf("failed to hash password for %s: %w", user.Username, err)
+			}
+			hash = string(hashed)
+			hashes[user.Password] = hash
+		}
+
+		tier := user.Tier
+		if tier == "" {
+			tier = models.TierRegular
+		}
+		createdAt := orTime(user.CreatedAt, now)
+
+		id := uuid.New().String()
+		userIDs[user.Username] = id
+		roles[user.Username] = user.Role
+		users = append(users, []interface{}{
+			id, user.Username, user.Email, hash, user.Name, user.Role, tier, nullString(user.Team), false, createdAt, createdAt,
+		})
+	}
+	if err := copyRows(tx, "users", []string{
+		"id", "username", "email", "password_hash", "name", "role", "tier", "team", "is_online", "created_at", "updated_at",
+	}, users); err != nil {
+		return fmt.Errorf("failed to create users: %w", err)
+	}
+
+	var chats, history, messages, reads [][]interface{}
+	for _, chat := range fixture.Chats {
+		chatID := uuid.New().String()
+		createdAt := orTime(chat.CreatedAt, now)
+		updatedAt := createdAt
 
-	// Store user IDs for creating chats
-	userIDs := make(map[string]string)
+		var seq, publicSeq int64
+		lastRead := make(map[string]int64)
+		for _, message := range chat.Messages {
+			sentAt := orTime(message.SentAt, createdAt)
+			visibility := message.Visibility
+			if visibility == "" {
+				visibility = models.VisibilityPublic
+			}
 
-	for _, user := range users {
-		hashedPassword, err := bcrypt.GenerateFromPassword([]byte(user.password), bcrypt.DefaultCost)
-		if err != nil {
-			return fmt.Errorf("failed to hash password for %s: %w", user.username, err)
+			seq++
+			var messagePublicSeq interface{}
+			if visibility == models.VisibilityPublic {
+				publicSeq++
+				messagePublicSeq = publicSeq
+				if sentAt.After(updatedAt) {
+					updatedAt = sentAt
+				}
+			}
+
+			// Read positions count in the numbering the reader sees
+			if roles[message.Sender] == "customer" {
+				lastRead[message.Sender] = publicSeq
+			} else {
+				lastRead[message.Sender] = seq
+			}
+
+			messages = append(messages, []interface{}{
+				uuid.New().String(), chatID, seq, messagePublicSeq, userIDs[message.Sender],
+				message.Content, "text", visibility, sentAt, sentAt,
+			})
+		}
+
+		var resolvedAt interface{}
+		if chat.Status == models.ChatStatusResolved || chat.Status == models.ChatStatusClosed {
+			resolvedAt = updatedAt
+		}
+		topic := chat.Topic
+		if topic == "" {
+			topic = "general"
+		}
+		priority := chat.Priority
+		if priority == "" {
+			priority = models.PriorityNormal
+		}
+		var agentID interface{}
+		if chat.Agent != "" {
+			agentID = userIDs[chat.Agent]
 		}
 
-		var userID string
-		err = db.QueryRow(`
-			INSERT INTO users (username, email, password_hash, name, role, is_online) 
-			VALUES ($1, $2, $3, $4, $5, false)
-			RETURNING id`,
-			user.username, user.email, string(hashedPassword), user.name, user.role).Scan(&userID)
-		if err != nil {
-			return fmt.Errorf("failed to create user %s: %w", user.username, err)
+		chats = append(chats, []interface{}{
+			chatID, userIDs[chat.Customer], agentID, chat.Status, topic, priority, seq, publicSeq,
+			resolvedAt, createdAt, updatedAt,
+		})
+		history = append(history, []interface{}{chatID, chat.Status, createdAt})
+		for username, position := range lastRead {
+			reads = append(reads, []interface{}{chatID, userIDs[username], position, updatedAt})
 		}
-		userIDs[user.username] = userID
 	}
 
-	// Create sample chats
-	chats := []struct {
-		customerUsername string
-		agentUsername    string
-		status           string
+	// Chats go before the rows that reference them
+	for _, load := range []struct {
+		table   string
+		columns []string
+		rows    [][]interface{}
 	}{
-		{"customer1", "agent1", "active"},
-		{"customer2", "agent2", "active"},
-	}
-
-	for _, chat := range chats {
-		customerID := userIDs[chat.customerUsername]
-		agentID := userIDs[chat.agentUsername]
-
-		var chatID string
-		err := db.QueryRow(`
-			INSERT INTO chats (customer_id, agent_id, status, created_at, updated_at)
-			VALUES ($1, $2, $3, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
-			RETURNING id`,
-			customerID, agentID, chat.status).Scan(&chatID)
-		if err != nil {
-			return fmt.Errorf("failed to create chat: %w", err)
+		{"chats", []string{"id", "customer_id", "agent_id", "status", "topic", "priority", "last_seq", "last_public_seq",
+			"resolved_at", "created_at", "updated_at"}, chats},
+		{"chat_status_history", []string{"chat_id", "to_status", "created_at"}, history},
+		{"messages", []string{"id", "chat_id", "seq", "public_seq", "sender_id", "content", "message_type", "visibility",
+			"created_at", "updated_at"}, messages},
+		{"chat_reads", []string{"chat_id", "user_id", "last_read_seq", "updated_at"}, reads},
+	} {
+		if err := copyRows(tx, load.table, load.columns, load.rows); err != nil {
+			return fmt.Errorf("failed to create %s: %w", load.table, err)
 		}
+	}
+
+	return tx.Commit()
+}
+
+// copyRows loads rows into a table with COPY.
+func copyRows(tx *sql.Tx, table string, columns []string, rows [][]interface{}) error {
+	if len(rows) == 0 {
+		return nil
+	}
 
-		// Add a sample message to each chat
-		_, err = db.Exec(`
-			INSERT INTO messages (chat_id, sender_id, content, message_type, created_at)
-			VALUES ($1, $2, $3, 'text', CURRENT_TIMESTAMP)`,
-			chatID, customerID, "Hello, I need help with my account")
-		if err != nil {
-			return fmt.Errorf("failed to create sample message: %w", err)
+	stmt, err := tx.Prepare(pq.CopyIn(table, columns...))
+	if err != nil {
+		return err
+	}
+	for _, row := range rows {
+		if _, err := stmt.Exec(row...); err != nil {
+			stmt.Close()
+			return err
 		}
 	}
+	// Executing without arguments flushes the buffered rows
+	if _, err := stmt.Exec(); err != nil {
+		stmt.Close()
+		return err
+	}
+	return stmt.Close()
+}
 
-	log.Println("Database seeded successfully!")
-	return nil
+func orTime(t, fallback time.Time) time.Time {
+	if t.IsZero() {
+		return fallback
+	}
+	return t
+}
+
+func nullString(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
 }
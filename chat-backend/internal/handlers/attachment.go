@@ -0,0 +1,124 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"cs-socket/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// multipartOverhead allows for the multipart framing and form fields around
+// an uploaded file.
+const multipartOverhead = 1 << 20
+
+type AttachmentHandler struct {
+	attachmentService *services.AttachmentService
+}
+
+func NewAttachmentHandler(attachmentService *services.AttachmentService) *AttachmentHandler {
+	return &AttachmentHandler{
+		attachmentService: attachmentService,
+	}
+}
+
+// Upload accepts a multipart form with a "file" part and optional "content"
+// (caption) and "replyToId" fields.
+func (h *AttachmentHandler) Upload(c *gin.Context) {
+	role := c.GetString("role")
+	limit := h.attachmentService.MaxSize(role)
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, limit+multipartOverhead)
+
+	fileHeader, err := c.FormFile("file")
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("files may be at most %d bytes", limit)})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	file, err := fileHeader.Open()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	defer file.Close()
+
+	var replyToID *string
+	if id := c.PostForm("replyToId"); id != "" {
+		replyToID = &id
+	}
+
+	message, err := h.attachmentService.Upload(c.Request.Context(), services.Upload{
+		ChatID:    c.Param("id"),
+		UserID:    c.GetString("userID"),
+		Role:      role,
+		FileName:  fileHeader.Filename,
+		Size:      fileHeader.Size,
+		Content:   file,
+		Caption:   c.PostForm("content"),
+		ReplyToID: replyToID,
+	})
+	if err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"success": true,
+		"data":    message,
+	})
+}
+
+// GetAttachment returns an attachment with a fresh download URL, for clients
+// whose URL has expired.
+func (h *AttachmentHandler) GetAttachment(c *gin.Context) {
+	attachment, err := h.attachmentService.GetAttachment(c.Param("id"), c.Param("attachmentId"), c.GetString("userID"), c.GetString("role"))
+	if err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    attachment,
+	})
+}
+
+// Download serves a file through a signed URL. It needs no Authorization
+// header, so that browsers can load images and links directly. Thumbnails of
+// images are requested with ?variant=<name>.
+func (h *AttachmentHandler) Download(c *gin.Context) {
+	download, err := h.attachmentService.Open(c.Request.Context(), c.Param("id"), c.Query("variant"), c.Query("expires"), c.Query("signature"))
+	if err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	defer download.Content.Close()
+
+	disposition := "attachment"
+	if strings.HasPrefix(download.ContentType, "image/") {
+		disposition = "inline"
+	}
+
+	c.Header("Content-Type", download.ContentType)
+	c.Header("Content-Length", strconv.FormatInt(download.Size, 10))
+	c.Header("Content-Disposition", mime.FormatMediaType(disposition, map[string]string{"filename": download.FileName}))
+	c.Header("X-Content-Type-Options", "nosniff")
+	c.Header("Cache-Control", "private, max-age=300")
+	c.Status(http.StatusOK)
+
+	if _, err := io.Copy(c.Writer, download.Content); err != nil {
+		log.Printf("Error sending attachment %s: %v", c.Param("id"), err)
+	}
+}
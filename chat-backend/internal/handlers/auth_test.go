@@ -0,0 +1,73 @@
+package handlers
+
+import (
+	"net/http"
+	"testing"
+
+	"cs-socket/internal/models"
+)
+
+func TestRegister(t *testing.T) {
+	s := newTestServer(t)
+	user, token := s.register(t, "alice", "")
+	if user.Role != "customer" || token == "" {
+		t.Errorf("registered %+v with token %q", user, token)
+	}
+
+	var resp struct{ Error string }
+	status := s.do(t, http.MethodPost, "/api/auth/register", "", models.RegisterRequest{
+		Username: "alice",
+		Email:    "alice2@example.com",
+		Password: "secret123",
+		Name:     "Alice",
+	}, &resp)
+	if status != http.StatusConflict || resp.Error == "" {
+		t.Errorf("duplicate username: status %d, error %q", status, resp.Error)
+	}
+
+	status = s.do(t, http.MethodPost, "/api/auth/register", "", map[string]string{
+		"username": "bob",
+		"email":    "not-an-email",
+		"password": "secret123",
+		"name":     "Bob",
+	}, nil)
+	if status != http.StatusBadRequest {
+		t.Errorf("invalid email: status %d, want 400", status)
+	}
+}
+
+func TestLogin(t *testing.T) {
+	s := newTestServer(t)
+	registered, _ := s.register(t, "alice", "")
+
+	var resp authResponse
+	status := s.do(t, http.MethodPost, "/api/auth/login", "", models.LoginRequest{
+		Username: "alice",
+		Password: "secret123",
+	}, &resp)
+	if status != http.StatusOK {
+		t.Fatalf("status %d, want 200", status)
+	}
+	if resp.Data.User.ID != registered.ID || resp.Data.Token == "" {
+		t.Errorf("login response %+v", resp.Data)
+	}
+
+	status = s.do(t, http.MethodPost, "/api/auth/login", "", models.LoginRequest{
+		Username: "alice",
+		Password: "wrong-password",
+	}, nil)
+	if status != http.StatusUnauthorized {
+		t.Errorf("wrong password: status %d, want 401", status)
+	}
+}
+
+func TestProtectedRoutesRequireToken(t *testing.T) {
+	s := newTestServer(t)
+
+	if status := s.do(t, http.MethodGet, "/api/users", "", nil, nil); status != http.StatusUnauthorized {
+		t.Errorf("no token: status %d, want 401", status)
+	}
+	if status := s.do(t, http.MethodGet, "/api/users", "not-a-token", nil, nil); status != http.StatusUnauthorized {
+		t.Errorf("bad token: status %d, want 401", status)
+	}
+}
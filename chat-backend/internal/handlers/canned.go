@@ -0,0 +1,143 @@
+package handlers
+
+import (
+	"net/http"
+
+	"cs-socket/internal/models"
+	"cs-socket/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+type CannedHandler struct {
+	cannedService *services.CannedService
+}
+
+func NewCannedHandler(cannedService *services.CannedService) *CannedHandler {
+	return &CannedHandler{
+		cannedService: cannedService,
+	}
+}
+
+func (h *CannedHandler) GetResponses(c *gin.Context) {
+	responses, err := h.cannedService.GetResponses(c.GetString("userID"), c.GetString("role"), c.Query("q"))
+	if err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    responses,
+	})
+}
+
+func (h *CannedHandler) CreateResponse(c *gin.Context) {
+	var req models.CannedResponseRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	response, err := h.cannedService.CreateResponse(c.GetString("userID"), c.GetString("role"), req)
+	if err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"success": true,
+		"data":    response,
+	})
+}
+
+func (h *CannedHandler) UpdateResponse(c *gin.Context) {
+	var req models.CannedResponseRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	response, err := h.cannedService.UpdateResponse(c.Param("id"), c.GetString("userID"), c.GetString("role"), req)
+	if err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    response,
+	})
+}
+
+func (h *CannedHandler) DeleteResponse(c *gin.Context) {
+	if err := h.cannedService.DeleteResponse(c.Param("id"), c.GetString("userID"), c.GetString("role")); err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Canned response deleted successfully",
+	})
+}
+
+func (h *CannedHandler) PreviewResponse(c *gin.Context) {
+	content, err := h.cannedService.Render(c.Param("id"), c.Param("responseId"), c.GetString("userID"), c.GetString("role"))
+	if err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    gin.H{"content": content},
+	})
+}
+
+func (h *CannedHandler) SendResponse(c *gin.Context) {
+	var req models.UseCannedResponseRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	message, err := h.cannedService.Send(c.Param("id"), req.CannedResponseID, c.GetString("userID"), c.GetString("role"))
+	if err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"success": true,
+		"data":    message,
+	})
+}
+
+func (h *CannedHandler) GetStats(c *gin.Context) {
+	from, err := parseTimeQuery(c, "from")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	to, err := parseTimeQuery(c, "to")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	stats, err := h.cannedService.GetStats(c.GetString("role"), models.CannedStatsFilter{
+		Team: c.Query("team"),
+		From: from,
+		To:   to,
+	})
+	if err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    stats,
+	})
+}
@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"database/sql"
 	"net/http"
 	"strconv"
 
@@ -24,18 +25,44 @@ func (h *ChatHandler) GetChats(c *gin.Context) {
 	userID := c.GetString("userID")
 	role := c.GetString("role")
 
-	chats, err := h.chatService.GetChats(userID, role)
+	chats, paging, err := h.chatService.GetChats(userID, role, chatFilter(c))
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
 		"data":    chats,
+		"paging":  paging,
 	})
 }
 
+// chatFilter reads the filters, sorting and paging of a chat listing from the
+// query string.
+func chatFilter(c *gin.Context) models.ChatFilter {
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if err != nil {
+		limit = 50
+	}
+	offset, err := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if err != nil {
+		offset = 0
+	}
+
+	return models.ChatFilter{
+		Tag:        c.Query("tag"),
+		Status:     c.Query("status"),
+		AgentID:    c.Query("agentId"),
+		Unassigned: c.Query("unassigned") == "true",
+		HasUnread:  c.Query("hasUnread") == "true",
+		Sort:       c.Query("sort"),
+		Order:      c.Query("order"),
+		Limit:      limit,
+		Offset:     offset,
+	}
+}
+
 func (h *ChatHandler) GetAvailableAgents(c *gin.Context) {
 	userID := c.GetString("userID")
 	role := c.GetString("role")
@@ -82,10 +109,12 @@ func (h *ChatHandler) GetAvailableCustomers(c *gin.Context) {
 
 func (h *ChatHandler) GetChat(c *gin.Context) {
 	chatID := c.Param("id")
+	userID := c.GetString("userID")
+	role := c.GetString("role")
 
-	chat, err := h.chatService.GetChat(chatID)
+	chat, err := h.chatService.GetChatForUser(chatID, userID, role)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Chat not found"})
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
 		return
 	}
 
@@ -102,15 +131,15 @@ func (h *ChatHandler) CreateChat(c *gin.Context) {
 		return
 	}
 
-	chat, err := h.chatService.CreateChat(req.CustomerID, req.AgentID)
+	chat, err := h.chatService.CreateChat(req.CustomerID, req.AgentID, req.Topic)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
 		return
 	}
 
 	c.JSON(http.StatusCreated, gin.H{
 		"success": true,
-		"data":    chat,
+		"data":    services.ChatForRole(chat, c.GetString("role")),
 	})
 }
 
@@ -128,9 +157,32 @@ func (h *ChatHandler) SendMessage(c *gin.Context) {
 		req.MessageType = "text"
 	}
 
-	message, err := h.chatService.SendMessage(chatID, senderID, req.Content, req.MessageType)
+	message, err := h.chatService.SendMessage(chatID, senderID, c.GetString("role"), req.Content, req.MessageType, req.ReplyToID)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"success": true,
+		"data":    services.MessageForRole(message, c.GetString("role")),
+	})
+}
+
+func (h *ChatHandler) SendWhisper(c *gin.Context) {
+	chatID := c.Param("id")
+	senderID := c.GetString("userID")
+	role := c.GetString("role")
+
+	var req models.WhisperRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	message, err := h.chatService.SendWhisper(chatID, senderID, role, req.Content)
+	if err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
 		return
 	}
 
@@ -140,36 +192,121 @@ func (h *ChatHandler) SendMessage(c *gin.Context) {
 	})
 }
 
-func (h *ChatHandler) GetMessages(c *gin.Context) {
+func (h *ChatHandler) AddNote(c *gin.Context) {
 	chatID := c.Param("id")
+	senderID := c.GetString("userID")
+	role := c.GetString("role")
+
+	var req models.NoteRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	note, err := h.chatService.AddNote(chatID, senderID, role, req.Content)
+	if err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"success": true,
+		"data":    note,
+	})
+}
 
-	limitStr := c.DefaultQuery("limit", "50")
-	offsetStr := c.DefaultQuery("offset", "0")
+func (h *ChatHandler) GetMentions(c *gin.Context) {
+	userID := c.GetString("userID")
 
-	limit, err := strconv.Atoi(limitStr)
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "50"))
 	if err != nil {
 		limit = 50
 	}
 
-	offset, err := strconv.Atoi(offsetStr)
+	mentions, err := h.chatService.GetMentions(userID, limit)
 	if err != nil {
-		offset = 0
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    mentions,
+	})
+}
+
+func (h *ChatHandler) ObserveChat(c *gin.Context) {
+	chatID := c.Param("id")
+	userID := c.GetString("userID")
+	role := c.GetString("role")
+
+	if err := h.chatService.ObserveChat(chatID, userID, role); err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Observing chat",
+	})
+}
+
+func (h *ChatHandler) UnobserveChat(c *gin.Context) {
+	chatID := c.Param("id")
+	userID := c.GetString("userID")
+
+	h.chatService.UnobserveChat(chatID, userID)
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Stopped observing chat",
+	})
+}
+
+// GetMessages returns a page of messages. Pass the paging cursors back as
+// ?before= to load older messages or ?after= to load newer ones.
+func (h *ChatHandler) GetMessages(c *gin.Context) {
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if err != nil {
+		limit = 50
 	}
 
-	messages, err := h.chatService.GetMessages(chatID, limit, offset)
+	messages, paging, err := h.chatService.GetMessages(c.Param("id"), c.GetString("userID"), c.GetString("role"), models.MessagePage{
+		Before: c.Query("before"),
+		After:  c.Query("after"),
+		Limit:  limit,
+	})
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
 		"data":    messages,
+		"paging":  paging,
+	})
+}
+
+// Sync returns what changed since the cursor from a previous sync, so that a
+// reconnecting client can catch up without reloading every chat.
+func (h *ChatHandler) Sync(c *gin.Context) {
+	result, err := h.chatService.Sync(c.GetString("userID"), c.GetString("role"), c.Query("since"))
+	if err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    result,
 	})
 }
 
 func (h *ChatHandler) UpdateChatStatus(c *gin.Context) {
 	chatID := c.Param("id")
+	userID := c.GetString("userID")
+	role := c.GetString("role")
 
 	var req models.UpdateChatStatusRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -177,15 +314,154 @@ func (h *ChatHandler) UpdateChatStatus(c *gin.Context) {
 		return
 	}
 
-	err := h.chatService.UpdateChatStatus(chatID, req.Status)
+	chat, err := h.chatService.UpdateChatStatus(chatID, userID, role, req)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
 		"message": "Chat status updated successfully",
+		"data":    chat,
+	})
+}
+
+func (h *ChatHandler) GetStatusHistory(c *gin.Context) {
+	chatID := c.Param("id")
+	role := c.GetString("role")
+
+	history, err := h.chatService.GetStatusHistory(chatID, c.GetString("userID"), role)
+	if err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    history,
+	})
+}
+
+func (h *ChatHandler) EscalatePriority(c *gin.Context) {
+	chat, err := h.chatService.EscalatePriority(c.Param("id"), c.GetString("userID"), c.GetString("role"))
+	if err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    chat,
+	})
+}
+
+func (h *ChatHandler) DeescalatePriority(c *gin.Context) {
+	chat, err := h.chatService.DeescalatePriority(c.Param("id"), c.GetString("userID"), c.GetString("role"))
+	if err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    chat,
+	})
+}
+
+func (h *ChatHandler) GetQueue(c *gin.Context) {
+	chats, err := h.chatService.GetQueue(c.GetString("role"))
+	if err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    chats,
+	})
+}
+
+func (h *ChatHandler) TakeNextChat(c *gin.Context) {
+	chat, err := h.chatService.TakeNextChat(c.GetString("userID"), c.GetString("role"))
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No chats waiting in the queue"})
+		return
+	}
+	if err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    chat,
+	})
+}
+
+func (h *ChatHandler) EscalateChat(c *gin.Context) {
+	var req models.EscalateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	escalation, err := h.chatService.EscalateChat(c.Param("id"), c.GetString("userID"), c.GetString("role"), req.Reason)
+	if err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"success": true,
+		"data":    escalation,
+	})
+}
+
+func (h *ChatHandler) GetEscalations(c *gin.Context) {
+	escalations, err := h.chatService.GetEscalations(
+		c.GetString("userID"), c.GetString("role"), c.Query("status"), c.Query("chatId"),
+	)
+	if err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    escalations,
+	})
+}
+
+func (h *ChatHandler) ClaimEscalation(c *gin.Context) {
+	escalation, err := h.chatService.ClaimEscalation(c.Param("id"), c.GetString("userID"), c.GetString("role"))
+	if err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    escalation,
+	})
+}
+
+func (h *ChatHandler) ResolveEscalation(c *gin.Context) {
+	var req models.ResolveEscalationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	escalation, err := h.chatService.ResolveEscalation(c.Param("id"), c.GetString("userID"), c.GetString("role"), req.Outcome)
+	if err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    escalation,
 	})
 }
 
@@ -193,15 +469,16 @@ func (h *ChatHandler) GetArchivedChats(c *gin.Context) {
 	userID := c.GetString("userID")
 	role := c.GetString("role")
 
-	chats, err := h.chatService.GetArchivedChats(userID, role)
+	chats, paging, err := h.chatService.GetArchivedChats(userID, role, chatFilter(c))
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
 		"data":    chats,
+		"paging":  paging,
 	})
 }
 
@@ -212,7 +489,7 @@ func (h *ChatHandler) ArchiveChat(c *gin.Context) {
 
 	err := h.chatService.ArchiveChat(chatID, userID, role)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
 		return
 	}
 
@@ -229,7 +506,7 @@ func (h *ChatHandler) UnarchiveChat(c *gin.Context) {
 
 	err := h.chatService.UnarchiveChat(chatID, userID, role)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
 		return
 	}
 
@@ -253,3 +530,267 @@ func (h *ChatHandler) DeleteChat(c *gin.Context) {
 		"message": "Chat deleted successfully",
 	})
 }
+
+func (h *ChatHandler) GetTags(c *gin.Context) {
+	tags, err := h.chatService.GetTags(c.GetString("role"))
+	if err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    tags,
+	})
+}
+
+func (h *ChatHandler) CreateTag(c *gin.Context) {
+	var req models.TagRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	tag, err := h.chatService.CreateTag(c.GetString("role"), req)
+	if err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"success": true,
+		"data":    tag,
+	})
+}
+
+func (h *ChatHandler) UpdateTag(c *gin.Context) {
+	var req models.TagRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	tag, err := h.chatService.UpdateTag(c.Param("id"), c.GetString("role"), req)
+	if err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    tag,
+	})
+}
+
+func (h *ChatHandler) DeleteTag(c *gin.Context) {
+	if err := h.chatService.DeleteTag(c.Param("id"), c.GetString("role")); err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Tag deleted successfully",
+	})
+}
+
+func (h *ChatHandler) GetTagStats(c *gin.Context) {
+	from, err := parseTimeQuery(c, "from")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	to, err := parseTimeQuery(c, "to")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	stats, err := h.chatService.GetTagStats(c.GetString("role"), models.TagStatsFilter{
+		Interval: c.Query("interval"),
+		Tag:      c.Query("tag"),
+		From:     from,
+		To:       to,
+	})
+	if err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    stats,
+	})
+}
+
+func (h *ChatHandler) AddChatTag(c *gin.Context) {
+	var req models.ChatTagRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	tags, err := h.chatService.AddChatTag(c.Param("id"), req.TagID, c.GetString("userID"), c.GetString("role"))
+	if err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    tags,
+	})
+}
+
+func (h *ChatHandler) RemoveChatTag(c *gin.Context) {
+	tags, err := h.chatService.RemoveChatTag(c.Param("id"), c.Param("tagId"), c.GetString("userID"), c.GetString("role"))
+	if err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    tags,
+	})
+}
+
+func (h *ChatHandler) EditMessage(c *gin.Context) {
+	var req models.EditMessageRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	message, err := h.chatService.EditMessage(c.Param("id"), c.Param("messageId"), c.GetString("userID"), c.GetString("role"), req.Content)
+	if err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    services.MessageForRole(message, c.GetString("role")),
+	})
+}
+
+func (h *ChatHandler) DeleteMessage(c *gin.Context) {
+	if err := h.chatService.DeleteMessage(c.Param("id"), c.Param("messageId"), c.GetString("userID"), c.GetString("role")); err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Message deleted successfully",
+	})
+}
+
+func (h *ChatHandler) GetMessageHistory(c *gin.Context) {
+	history, err := h.chatService.GetMessageHistory(c.Param("id"), c.Param("messageId"), c.GetString("role"))
+	if err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    history,
+	})
+}
+
+// MarkRead moves the user's read position in a chat up to a message, which
+// clears the chat's unread count in listings.
+func (h *ChatHandler) MarkRead(c *gin.Context) {
+	var req models.MarkReadRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.chatService.MarkRead(c.Param("id"), c.GetString("userID"), c.GetString("role"), req.MessageID); err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Chat marked as read",
+	})
+}
+
+func (h *ChatHandler) AddReaction(c *gin.Context) {
+	var req models.ReactionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	reactions, err := h.chatService.AddReaction(c.Param("id"), c.Param("messageId"), c.GetString("userID"), c.GetString("role"), req.Emoji)
+	if err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    reactions,
+	})
+}
+
+func (h *ChatHandler) RemoveReaction(c *gin.Context) {
+	reactions, err := h.chatService.RemoveReaction(c.Param("id"), c.Param("messageId"), c.GetString("userID"), c.GetString("role"), c.Param("emoji"))
+	if err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    reactions,
+	})
+}
+
+// SearchMessages runs a full-text search over the messages of the chats the
+// caller can see.
+func (h *ChatHandler) SearchMessages(c *gin.Context) {
+	from, err := parseTimeQuery(c, "from")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	to, err := parseTimeQuery(c, "to")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	if err != nil {
+		limit = 20
+	}
+	offset, err := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if err != nil {
+		offset = 0
+	}
+
+	results, err := h.chatService.SearchMessages(c.GetString("userID"), c.GetString("role"), models.MessageSearchFilter{
+		Query:      c.Query("q"),
+		From:       from,
+		To:         to,
+		AgentID:    c.Query("agentId"),
+		CustomerID: c.Query("customerId"),
+		Status:     c.Query("status"),
+		Limit:      limit,
+		Offset:     offset,
+	})
+	if err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    results,
+	})
+}
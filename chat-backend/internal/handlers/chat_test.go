@@ -0,0 +1,137 @@
+package handlers
+
+import (
+	"net/http"
+	"testing"
+
+	"cs-socket/internal/models"
+
+	"github.com/google/uuid"
+)
+
+func TestCreateChat(t *testing.T) {
+	s := newTestServer(t)
+	customer, customerToken := s.register(t, "carol", "customer")
+	_, agentToken := s.register(t, "bob", "agent")
+
+	tests := []struct {
+		name         string
+		token        string
+		wantPriority string
+	}{
+		{"customer", customerToken, ""},
+		{"agent", agentToken, models.PriorityNormal},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var created struct{ Data models.Chat }
+			status := s.do(t, http.MethodPost, "/api/chats", tt.token, models.CreateChatRequest{CustomerID: customer.ID}, &created)
+			if status != http.StatusCreated {
+				t.Fatalf("status %d, want 201", status)
+			}
+			if created.Data.CustomerID != customer.ID || created.Data.Priority != tt.wantPriority {
+				t.Errorf("created chat: customer %s, priority %q; want priority %q",
+					created.Data.CustomerID, created.Data.Priority, tt.wantPriority)
+			}
+		})
+	}
+
+	unknown := models.CreateChatRequest{CustomerID: uuid.New().String()}
+	if status := s.do(t, http.MethodPost, "/api/chats", agentToken, unknown, nil); status != http.StatusNotFound {
+		t.Errorf("unknown customer: status %d, want 404", status)
+	}
+}
+
+func TestSendAndGetMessages(t *testing.T) {
+	s := newTestServer(t)
+	customer, customerToken := s.register(t, "carol", "customer")
+	agent, agentToken := s.register(t, "bob", "agent")
+	_, strangerToken := s.register(t, "dave", "customer")
+
+	chat, err := s.chats.CreateChat(customer.ID, &agent.ID, "")
+	if err != nil {
+		t.Fatalf("CreateChat: %v", err)
+	}
+	path := "/api/chats/" + chat.ID + "/messages"
+
+	var sent struct{ Data models.Message }
+	status := s.do(t, http.MethodPost, path, customerToken, models.SendMessageRequest{Content: "hello"}, &sent)
+	if status != http.StatusCreated {
+		t.Fatalf("send: status %d, want 201", status)
+	}
+	if sent.Data.MessageType != "text" || sent.Data.SenderID != customer.ID ||
+		sent.Data.Seq != 0 || sent.Data.PublicSeq == nil || *sent.Data.PublicSeq != 1 {
+		t.Errorf("sent %+v", sent.Data)
+	}
+
+	if status := s.do(t, http.MethodPost, path, agentToken, map[string]string{}, nil); status != http.StatusBadRequest {
+		t.Errorf("send without content: status %d, want 400", status)
+	}
+	missing := "/api/chats/" + uuid.New().String() + "/messages"
+	if status := s.do(t, http.MethodPost, missing, agentToken, models.SendMessageRequest{Content: "hi"}, nil); status != http.StatusNotFound {
+		t.Errorf("send to missing chat: status %d, want 404", status)
+	}
+
+	var page struct {
+		Data   []models.Message
+		Paging models.MessagePaging
+	}
+	if status := s.do(t, http.MethodGet, path, agentToken, nil, &page); status != http.StatusOK {
+		t.Fatalf("get: status %d, want 200", status)
+	}
+	if len(page.Data) != 1 || page.Data[0].ID != sent.Data.ID || page.Paging.HasMore {
+		t.Errorf("page = %+v", page)
+	}
+
+	if status := s.do(t, http.MethodGet, path, strangerToken, nil, nil); status != http.StatusForbidden {
+		t.Errorf("get as another customer: status %d, want 403", status)
+	}
+	if status := s.do(t, http.MethodGet, path+"?before=nope", agentToken, nil, nil); status != http.StatusBadRequest {
+		t.Errorf("get with a bad cursor: status %d, want 400", status)
+	}
+}
+
+func TestCustomerMessagesHideWhispers(t *testing.T) {
+	s := newTestServer(t)
+	customer, customerToken := s.register(t, "carol", "customer")
+	agent, agentToken := s.register(t, "bob", "agent")
+
+	chat, err := s.chats.CreateChat(customer.ID, &agent.ID, "")
+	if err != nil {
+		t.Fatalf("CreateChat: %v", err)
+	}
+	if _, err := s.chats.SendMessage(chat.ID, agent.ID, "agent", "hello", "text", nil); err != nil {
+		t.Fatalf("SendMessage: %v", err)
+	}
+	if _, err := s.chats.SendWhisper(chat.ID, agent.ID, "agent", "VIP, be nice"); err != nil {
+		t.Fatalf("SendWhisper: %v", err)
+	}
+	if _, err := s.chats.SendMessage(chat.ID, agent.ID, "agent", "how can I help?", "text", nil); err != nil {
+		t.Fatalf("SendMessage: %v", err)
+	}
+	path := "/api/chats/" + chat.ID + "/messages"
+
+	// Decoded as maps to tell a missing seq from a zero one
+	var page struct{ Data []map[string]interface{} }
+	if status := s.do(t, http.MethodGet, path, customerToken, nil, &page); status != http.StatusOK {
+		t.Fatalf("get as customer: status %d, want 200", status)
+	}
+	if len(page.Data) != 2 {
+		t.Fatalf("customer got %d messages, want the 2 public ones", len(page.Data))
+	}
+	for i, message := range page.Data {
+		if _, ok := message["seq"]; ok {
+			t.Errorf("customer message %d has seq %v", i, message["seq"])
+		}
+		if message["publicSeq"] != float64(i+1) {
+			t.Errorf("customer message %d has publicSeq %v, want %d", i, message["publicSeq"], i+1)
+		}
+	}
+
+	if status := s.do(t, http.MethodGet, path, agentToken, nil, &page); status != http.StatusOK {
+		t.Fatalf("get as agent: status %d, want 200", status)
+	}
+	if len(page.Data) != 3 || page.Data[2]["seq"] != float64(3) {
+		t.Errorf("agent got %v, want 3 messages numbered up to 3", page.Data)
+	}
+}
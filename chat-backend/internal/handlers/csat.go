@@ -0,0 +1,74 @@
+package handlers
+
+import (
+	"net/http"
+
+	"cs-socket/internal/models"
+	"cs-socket/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+type CSATHandler struct {
+	csatService *services.CSATService
+}
+
+func NewCSATHandler(csatService *services.CSATService) *CSATHandler {
+	return &CSATHandler{
+		csatService: csatService,
+	}
+}
+
+func (h *CSATHandler) SubmitRating(c *gin.Context) {
+	var req models.CSATRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	rating, err := h.csatService.SubmitRating(c.Param("id"), c.GetString("userID"), c.GetString("role"), req)
+	if err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"success": true,
+		"data":    rating,
+	})
+}
+
+func (h *CSATHandler) GetSummary(c *gin.Context) {
+	if c.GetString("role") != "super-agent" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only super-agents can view CSAT reports"})
+		return
+	}
+
+	from, err := parseTimeQuery(c, "from")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	to, err := parseTimeQuery(c, "to")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	summary, err := h.csatService.GetSummary(models.CSATFilter{
+		GroupBy: c.Query("groupBy"),
+		AgentID: c.Query("agentId"),
+		Team:    c.Query("team"),
+		From:    from,
+		To:      to,
+	})
+	if err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    summary,
+	})
+}
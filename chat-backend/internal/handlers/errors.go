@@ -0,0 +1,27 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+
+	"cs-socket/internal/services"
+)
+
+// errorStatus maps service errors to HTTP status codes.
+func errorStatus(err error) int {
+	switch {
+	case errors.Is(err, services.ErrForbidden):
+		return http.StatusForbidden
+	case errors.Is(err, services.ErrInvalidTransition), errors.Is(err, services.ErrConflict):
+		return http.StatusConflict
+	case errors.Is(err, services.ErrInvalidInput):
+		return http.StatusBadRequest
+	case errors.Is(err, services.ErrTooLarge):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, sql.ErrNoRows):
+		return http.StatusNotFound
+	default:
+		return http.StatusInternalServerError
+	}
+}
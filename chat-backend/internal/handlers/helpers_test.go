@@ -0,0 +1,112 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"cs-socket/internal/config"
+	"cs-socket/internal/middleware"
+	"cs-socket/internal/models"
+	"cs-socket/internal/repository"
+	"cs-socket/internal/services"
+	"cs-socket/internal/websocket"
+
+	"github.com/gin-gonic/gin"
+)
+
+const testSecret = "test-secret"
+
+// testServer routes a subset of the API to handlers backed by memory
+// repositories.
+type testServer struct {
+	router *gin.Engine
+	chats  *services.ChatService
+}
+
+func newTestServer(t *testing.T) *testServer {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	repos := repository.NewMemory()
+	authService := services.NewAuthService(repos.Users, testSecret)
+	userService := services.NewUserService(repos.Users)
+	chatService := services.NewChatService(repos, websocket.NewHub(config.WebSocketConfig{}),
+		config.PriorityConfig{}, config.MessagesConfig{}, nil)
+
+	authHandler := NewAuthHandler(authService)
+	userHandler := NewUserHandler(userService)
+	chatHandler := NewChatHandler(chatService)
+
+	router := gin.New()
+	api := router.Group("/api")
+	api.POST("/auth/login", authHandler.Login)
+	api.POST("/auth/register", authHandler.Register)
+
+	protected := api.Group("/")
+	protected.Use(middleware.AuthMiddleware(testSecret))
+	protected.GET("/users", userHandler.GetUsers)
+	protected.PUT("/users/:id/tier", userHandler.UpdateTier)
+	protected.POST("/chats", chatHandler.CreateChat)
+	protected.GET("/chats/:id/messages", chatHandler.GetMessages)
+	protected.POST("/chats/:id/messages", chatHandler.SendMessage)
+
+	return &testServer{router: router, chats: chatService}
+}
+
+// do sends a request with an optional JSON body and bearer token, and
+// decodes the JSON response into out when it is given.
+func (s *testServer) do(t *testing.T, method, path, token string, body, out interface{}) int {
+	t.Helper()
+
+	var payload bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&payload).Encode(body); err != nil {
+			t.Fatalf("encoding body: %v", err)
+		}
+	}
+	req := httptest.NewRequest(method, path, &payload)
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	rec := httptest.NewRecorder()
+	s.router.ServeHTTP(rec, req)
+
+	if out != nil {
+		if err := json.Unmarshal(rec.Body.Bytes(), out); err != nil {
+			t.Fatalf("decoding %s %s response %q: %v", method, path, rec.Body.String(), err)
+		}
+	}
+	return rec.Code
+}
+
+// authResponse is the body of a successful login or registration.
+type authResponse struct {
+	Data struct {
+		User  models.User `json:"user"`
+		Token string      `json:"token"`
+	} `json:"data"`
+}
+
+// register signs up a user with the given role and returns them with a
+// token.
+func (s *testServer) register(t *testing.T, username, role string) (models.User, string) {
+	t.Helper()
+
+	var resp authResponse
+	status := s.do(t, http.MethodPost, "/api/auth/register", "", models.RegisterRequest{
+		Username: username,
+		Email:    username + "@example.com",
+		Password: "secret123",
+		Name:     username,
+		Role:     role,
+	}, &resp)
+	if status != http.StatusCreated {
+		t.Fatalf("registering %s: status %d", username, status)
+	}
+	return resp.Data.User, resp.Data.Token
+}
@@ -0,0 +1,153 @@
+package handlers
+
+import (
+	"net/http"
+
+	"cs-socket/internal/models"
+	"cs-socket/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+type SLAHandler struct {
+	slaService *services.SLAService
+}
+
+func NewSLAHandler(slaService *services.SLAService) *SLAHandler {
+	return &SLAHandler{
+		slaService: slaService,
+	}
+}
+
+func (h *SLAHandler) GetPolicies(c *gin.Context) {
+	role := c.GetString("role")
+
+	// Only agents and super-agents can view SLA policies
+	if role != "agent" && role != "super-agent" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only agents can view SLA policies"})
+		return
+	}
+
+	policies, err := h.slaService.GetPolicies()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    policies,
+	})
+}
+
+func (h *SLAHandler) CreatePolicy(c *gin.Context) {
+	if c.GetString("role") != "super-agent" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only super-agents can manage SLA policies"})
+		return
+	}
+
+	var req models.SLAPolicyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	policy, err := h.slaService.CreatePolicy(req)
+	if err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"success": true,
+		"data":    policy,
+	})
+}
+
+func (h *SLAHandler) UpdatePolicy(c *gin.Context) {
+	if c.GetString("role") != "super-agent" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only super-agents can manage SLA policies"})
+		return
+	}
+
+	var req models.SLAPolicyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	policy, err := h.slaService.UpdatePolicy(c.Param("id"), req)
+	if err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    policy,
+	})
+}
+
+func (h *SLAHandler) DeletePolicy(c *gin.Context) {
+	if c.GetString("role") != "super-agent" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only super-agents can manage SLA policies"})
+		return
+	}
+
+	if err := h.slaService.DeletePolicy(c.Param("id")); err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "SLA policy deleted successfully",
+	})
+}
+
+func (h *SLAHandler) GetChatSLA(c *gin.Context) {
+	sla, err := h.slaService.GetChatSLA(c.Param("id"), c.GetString("userID"), c.GetString("role"))
+	if err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    sla,
+	})
+}
+
+func (h *SLAHandler) GetBreaches(c *gin.Context) {
+	if c.GetString("role") != "super-agent" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only super-agents can view SLA reports"})
+		return
+	}
+
+	from, err := parseTimeQuery(c, "from")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	to, err := parseTimeQuery(c, "to")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	events, err := h.slaService.GetEvents(models.SLAEventFilter{
+		AgentID: c.Query("agentId"),
+		Level:   c.DefaultQuery("level", "breach"),
+		From:    from,
+		To:      to,
+	})
+	if err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    events,
+	})
+}
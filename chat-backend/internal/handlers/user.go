@@ -69,3 +69,41 @@ func (h *UserHandler) UpdateStatus(c *gin.Context) {
 		"message": "Status updated successfully",
 	})
 }
+
+func (h *UserHandler) UpdateTier(c *gin.Context) {
+	var req models.UpdateTierRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, err := h.userService.UpdateTier(c.Param("id"), req.Tier, c.GetString("role"))
+	if err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    user,
+	})
+}
+
+func (h *UserHandler) UpdateTeam(c *gin.Context) {
+	var req models.UpdateTeamRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, err := h.userService.UpdateTeam(c.Param("id"), req.Team, c.GetString("role"))
+	if err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    user,
+	})
+}
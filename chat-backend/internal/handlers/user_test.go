@@ -0,0 +1,69 @@
+package handlers
+
+import (
+	"net/http"
+	"testing"
+
+	"cs-socket/internal/models"
+)
+
+func TestGetUsers(t *testing.T) {
+	s := newTestServer(t)
+	_, customerToken := s.register(t, "carol", "customer")
+	s.register(t, "bob", "agent")
+	_, superToken := s.register(t, "alice", "super-agent")
+
+	for _, tc := range []struct {
+		name  string
+		token string
+		want  int
+	}{
+		{"customer sees staff", customerToken, 2},
+		{"super-agent sees everyone", superToken, 3},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var resp struct {
+				Success bool
+				Data    []models.User
+			}
+			if status := s.do(t, http.MethodGet, "/api/users", tc.token, nil, &resp); status != http.StatusOK {
+				t.Fatalf("status %d, want 200", status)
+			}
+			if !resp.Success || len(resp.Data) != tc.want {
+				t.Errorf("got %d users, want %d", len(resp.Data), tc.want)
+			}
+		})
+	}
+}
+
+func TestUpdateTier(t *testing.T) {
+	s := newTestServer(t)
+	customer, _ := s.register(t, "carol", "customer")
+	agent, agentToken := s.register(t, "bob", "agent")
+	_, superToken := s.register(t, "alice", "super-agent")
+
+	for _, tc := range []struct {
+		name   string
+		userID string
+		token  string
+		body   interface{}
+		want   int
+	}{
+		{"agent", customer.ID, agentToken, models.UpdateTierRequest{Tier: models.TierVIP}, http.StatusForbidden},
+		{"missing tier", customer.ID, superToken, map[string]string{}, http.StatusBadRequest},
+		{"unknown tier", customer.ID, superToken, models.UpdateTierRequest{Tier: "gold"}, http.StatusBadRequest},
+		{"not a customer", agent.ID, superToken, models.UpdateTierRequest{Tier: models.TierVIP}, http.StatusNotFound},
+		{"super-agent", customer.ID, superToken, models.UpdateTierRequest{Tier: models.TierVIP}, http.StatusOK},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var resp struct{ Data models.User }
+			status := s.do(t, http.MethodPut, "/api/users/"+tc.userID+"/tier", tc.token, tc.body, &resp)
+			if status != tc.want {
+				t.Fatalf("status %d, want %d", status, tc.want)
+			}
+			if status == http.StatusOK && resp.Data.Tier != models.TierVIP {
+				t.Errorf("tier = %q, want %q", resp.Data.Tier, models.TierVIP)
+			}
+		})
+	}
+}
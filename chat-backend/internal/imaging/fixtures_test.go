@@ -0,0 +1,203 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/color/palette"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+// The fixtures are built in code from tiny images, with metadata carrying
+// these markers. A stripped image must contain none of them.
+const (
+	gpsSecret     = "GPS-SECRET-48.8584N"
+	xmpSecret     = "XMP-SECRET"
+	commentSecret = "COMMENT-SECRET"
+)
+
+var secrets = []string{gpsSecret, xmpSecret, commentSecret, "Exif\x00\x00"}
+
+// webpPixel is a 1x1 lossless WebP in the simple format.
+const webpPixel = "UklGRhoAAABXRUJQVlA4TA0AAAAvAAAAEAcQERGIiP4HAA=="
+
+// exifTIFF returns a big-endian TIFF structure whose first IFD has the given
+// orientation, if not zero, and points to a GPS IFD holding gpsSecret.
+func exifTIFF(orientation int) []byte {
+	order := binary.BigEndian
+	var entries [][]byte
+	entry := func(tag, typ uint16, count, value uint32) []byte {
+		e := make([]byte, 12)
+		order.PutUint16(e[0:], tag)
+		order.PutUint16(e[2:], typ)
+		order.PutUint32(e[4:], count)
+		order.PutUint32(e[8:], value)
+		return e
+	}
+
+	const ifd0 = 8
+	if orientation != 0 {
+		// SHORT values are left-aligned in the value field
+		entries = append(entries, entry(exifOrientationTag, 3, 1, uint32(orientation)<<16))
+	}
+	gpsIFD := ifd0 + 2 + (len(entries)+1)*12 + 4
+	entries = append(entries, entry(0x8825, 4, 1, uint32(gpsIFD)))
+	gpsData := gpsIFD + 2 + 12 + 4
+	value := gpsSecret + "\x00"
+
+	tiff := []byte("MM\x00\x2A\x00\x00\x00\x08")
+	tiff = order.AppendUint16(tiff, uint16(len(entries)))
+	for _, e := range entries {
+		tiff = append(tiff, e...)
+	}
+	tiff = order.AppendUint32(tiff, 0)
+	// GPSMapDatum, an ASCII tag
+	tiff = order.AppendUint16(tiff, 1)
+	tiff = append(tiff, entry(0x0012, 2, uint32(len(value)), uint32(gpsData))...)
+	tiff = order.AppendUint32(tiff, 0)
+	return append(tiff, value...)
+}
+
+func xmpPacket() string {
+	return `<x:xmpmeta xmlns:x="adobe:ns:meta/"><rdf:Description exif:GPSLatitude="48,51.5N">` +
+		xmpSecret + `</rdf:Description></x:xmpmeta>`
+}
+
+// testImage returns a w×h image whose left half is red and right half blue,
+// which survives JPEG compression well enough to tell orientations apart.
+func testImage(w, h int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			c := color.RGBA{R: 255, A: 255}
+			if x >= w/2 {
+				c = color.RGBA{B: 255, A: 255}
+			}
+			img.Set(x, y, c)
+		}
+	}
+	return img
+}
+
+func jpegSegment(marker byte, payload []byte) []byte {
+	segment := []byte{0xFF, marker, 0, 0}
+	binary.BigEndian.PutUint16(segment[2:], uint16(len(payload)+2))
+	return append(segment, payload...)
+}
+
+// jpegFixture returns a 16×8 JPEG with JFIF, EXIF with GPS data and the
+// orientation, XMP, ICC, IPTC and comment segments.
+func jpegFixture(t *testing.T, orientation int) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, testImage(16, 8), &jpeg.Options{Quality: 95}); err != nil {
+		t.Fatalf("encoding JPEG: %v", err)
+	}
+	encoded := buf.Bytes()
+
+	data := []byte{0xFF, 0xD8}
+	data = append(data, jpegSegment(0xE0, []byte("JFIF\x00\x01\x01\x00\x00\x01\x00\x01\x00\x00"))...)
+	data = append(data, jpegSegment(0xE1, append([]byte("Exif\x00\x00"), exifTIFF(orientation)...))...)
+	data = append(data, jpegSegment(0xE1, []byte("http://ns.adobe.com/xap/1.0/\x00"+xmpPacket()))...)
+	data = append(data, jpegSegment(0xE2, []byte("ICC_PROFILE\x00\x01\x01profile"))...)
+	data = append(data, jpegSegment(0xED, []byte("Photoshop 3.0\x00"+commentSecret))...)
+	data = append(data, jpegSegment(0xFE, []byte(commentSecret))...)
+	return append(data, encoded[2:]...)
+}
+
+func pngChunk(chunkType string, data []byte) []byte {
+	chunk := binary.BigEndian.AppendUint32(nil, uint32(len(data)))
+	chunk = append(chunk, chunkType...)
+	chunk = append(chunk, data...)
+	return binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE(chunk[4:]))
+}
+
+// pngFixture returns a 4×4 PNG with tEXt, iTXt XMP and eXIf chunks, and a
+// gAMA chunk that must be kept.
+func pngFixture(t *testing.T) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, testImage(4, 4)); err != nil {
+		t.Fatalf("encoding PNG: %v", err)
+	}
+	encoded := buf.Bytes()
+
+	// The signature and IHDR come first
+	ihdrEnd := 8 + 12 + int(binary.BigEndian.Uint32(encoded[8:]))
+	data := append([]byte(nil), encoded[:ihdrEnd]...)
+	data = append(data, pngChunk("gAMA", []byte{0, 1, 0x86, 0xA0})...)
+	data = append(data, pngChunk("tEXt", []byte("Comment\x00"+commentSecret))...)
+	data = append(data, pngChunk("iTXt", []byte("XML:com.adobe.xmp\x00\x00\x00\x00\x00"+xmpPacket()))...)
+	data = append(data, pngChunk("eXIf", exifTIFF(0))...)
+	return append(data, encoded[ihdrEnd:]...)
+}
+
+// gifFixture returns a looping two-frame GIF with a comment and an XMP
+// application extension.
+func gifFixture(t *testing.T) []byte {
+	t.Helper()
+
+	frames := []*image.Paletted{image.NewPaletted(image.Rect(0, 0, 4, 4), palette.Plan9), image.NewPaletted(image.Rect(0, 0, 4, 4), palette.Plan9)}
+	frames[0].SetColorIndex(1, 1, 3)
+	frames[1].SetColorIndex(2, 2, 5)
+	var buf bytes.Buffer
+	err := gif.EncodeAll(&buf, &gif.GIF{Image: frames, Delay: []int{10, 10}, LoopCount: 0})
+	if err != nil {
+		t.Fatalf("encoding GIF: %v", err)
+	}
+	encoded := buf.Bytes()
+
+	header := 13
+	if encoded[10]&0x80 != 0 {
+		header += 3 << (encoded[10]&0x07 + 1)
+	}
+	data := append([]byte(nil), encoded[:header]...)
+	data = append(data, 0x21, 0xFE, byte(len(commentSecret)))
+	data = append(data, commentSecret...)
+	data = append(data, 0)
+	data = append(data, 0x21, 0xFF, 11)
+	data = append(data, "XMP DataXMP"...)
+	data = append(data, byte(len(xmpSecret)))
+	data = append(data, xmpSecret...)
+	data = append(data, 0)
+	return append(data, encoded[header:]...)
+}
+
+func webpChunk(fourCC string, data []byte) []byte {
+	chunk := append([]byte(fourCC), 0, 0, 0, 0)
+	binary.LittleEndian.PutUint32(chunk[4:], uint32(len(data)))
+	chunk = append(chunk, data...)
+	if len(data)%2 == 1 {
+		chunk = append(chunk, 0)
+	}
+	return chunk
+}
+
+// webpFixture returns a 1×1 WebP in the extended format with EXIF and XMP
+// chunks.
+func webpFixture(t *testing.T) []byte {
+	t.Helper()
+
+	simple, err := base64.StdEncoding.DecodeString(webpPixel)
+	if err != nil {
+		t.Fatalf("decoding WebP fixture: %v", err)
+	}
+
+	// Flags, reserved bytes, then canvas width and height minus one
+	vp8x := []byte{webpFlagEXIF | webpFlagXMP, 0, 0, 0, 0, 0, 0, 0, 0, 0}
+	data := []byte("RIFF\x00\x00\x00\x00WEBP")
+	data = append(data, webpChunk("VP8X", vp8x)...)
+	data = append(data, simple[12:]...)
+	data = append(data, webpChunk("EXIF", exifTIFF(0))...)
+	data = append(data, webpChunk("XMP ", []byte(xmpPacket()))...)
+	binary.LittleEndian.PutUint32(data[4:], uint32(len(data)-8))
+	return data
+}
@@ -0,0 +1,207 @@
+// Package imaging prepares uploaded images for storage: it removes metadata
+// such as EXIF location data, turns photos upright and renders thumbnails.
+package imaging
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"sort"
+
+	"cs-socket/internal/config"
+
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+const (
+	jpegQuality      = 90
+	thumbnailQuality = 85
+)
+
+var (
+	ErrUnsupported   = errors.New("imaging: unsupported image type")
+	ErrTooManyPixels = errors.New("imaging: image is too large to process")
+)
+
+// Supported reports whether images of the content type can be processed.
+func Supported(contentType string) bool {
+	switch contentType {
+	case "image/jpeg", "image/png", "image/gif", "image/webp":
+		return true
+	}
+	return false
+}
+
+// Image is an uploaded image with its metadata removed.
+type Image struct {
+	Data       []byte
+	Width      int
+	Height     int
+	Thumbnails []Thumbnail
+}
+
+// Thumbnail is a scaled-down copy of an image. Thumbnails are JPEGs unless
+// the image has transparency, in which case they are PNGs.
+type Thumbnail struct {
+	Name        string
+	Data        []byte
+	ContentType string
+	Width       int
+	Height      int
+}
+
+type Processor struct {
+	sizes     []config.ThumbnailSize
+	maxPixels int64
+}
+
+// NewProcessor returns a processor that renders a thumbnail per size and
+// refuses images with more than maxPixels pixels.
+func NewProcessor(sizes []config.ThumbnailSize, maxPixels int64) *Processor {
+	sorted := append([]config.ThumbnailSize(nil), sizes...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].MaxDimension > sorted[j].MaxDimension })
+	return &Processor{
+		sizes:     sorted,
+		maxPixels: maxPixels,
+	}
+}
+
+// Process strips the metadata of an image, applies its EXIF orientation and
+// renders its thumbnails. Thumbnails are only made for sizes smaller than the
+// image itself.
+func (p *Processor) Process(data []byte, contentType string) (*Image, error) {
+	if !Supported(contentType) {
+		return nil, ErrUnsupported
+	}
+
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("imaging: %w", err)
+	}
+	if int64(cfg.Width)*int64(cfg.Height) > p.maxPixels {
+		return nil, ErrTooManyPixels
+	}
+
+	var src image.Image
+	switch contentType {
+	case "image/jpeg":
+		if orientation := jpegOrientation(data); orientation > 1 {
+			// The orientation lives in the EXIF data being removed, so the
+			// pixels have to be turned instead.
+			src, err = jpeg.Decode(bytes.NewReader(data))
+			if err != nil {
+				return nil, fmt.Errorf("imaging: %w", err)
+			}
+			src = orient(src, orientation)
+			var buf bytes.Buffer
+			if err := jpeg.Encode(&buf, src, &jpeg.Options{Quality: jpegQuality}); err != nil {
+				return nil, err
+			}
+			data = buf.Bytes()
+		} else {
+			data, err = stripJPEG(data)
+		}
+	case "image/png":
+		data, err = stripPNG(data)
+	case "image/gif":
+		data, err = stripGIF(data)
+	case "image/webp":
+		data, err = stripWebP(data)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if src == nil {
+		src, _, err = image.Decode(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("imaging: %w", err)
+		}
+	}
+
+	bounds := src.Bounds()
+	img := &Image{
+		Data:   data,
+		Width:  bounds.Dx(),
+		Height: bounds.Dy(),
+	}
+
+	isOpaque := opaque(src)
+
+	// Each thumbnail is scaled from the next larger one, which is much
+	// faster than scaling every size from a full-resolution photo.
+	for _, size := range p.sizes {
+		width, height, ok := fit(src.Bounds().Dx(), src.Bounds().Dy(), size.MaxDimension)
+		if !ok {
+			continue
+		}
+
+		scaled := image.NewRGBA(image.Rect(0, 0, width, height))
+		draw.CatmullRom.Scale(scaled, scaled.Bounds(), src, src.Bounds(), draw.Src, nil)
+
+		thumbnail, err := encodeThumbnail(scaled, isOpaque)
+		if err != nil {
+			return nil, err
+		}
+		thumbnail.Name = size.Name
+		img.Thumbnails = append(img.Thumbnails, *thumbnail)
+		src = scaled
+	}
+
+	return img, nil
+}
+
+// fit scales width and height down so that neither exceeds max. It reports
+// false if the image already fits.
+func fit(width, height, max int) (int, int, bool) {
+	if width <= max && height <= max {
+		return width, height, false
+	}
+	if width >= height {
+		return max, scaleDimension(height, max, width), true
+	}
+	return scaleDimension(width, max, height), max, true
+}
+
+func scaleDimension(n, numerator, denominator int) int {
+	scaled := (n*numerator + denominator/2) / denominator
+	if scaled < 1 {
+		return 1
+	}
+	return scaled
+}
+
+func opaque(img image.Image) bool {
+	if o, ok := img.(interface{ Opaque() bool }); ok {
+		return o.Opaque()
+	}
+	return false
+}
+
+func encodeThumbnail(img image.Image, isOpaque bool) (*Thumbnail, error) {
+	var buf bytes.Buffer
+	contentType := "image/jpeg"
+	if isOpaque {
+		if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: thumbnailQuality}); err != nil {
+			return nil, err
+		}
+	} else {
+		contentType = "image/png"
+		if err := png.Encode(&buf, img); err != nil {
+			return nil, err
+		}
+	}
+
+	bounds := img.Bounds()
+	return &Thumbnail{
+		Data:        buf.Bytes(),
+		ContentType: contentType,
+		Width:       bounds.Dx(),
+		Height:      bounds.Dy(),
+	}, nil
+}
@@ -0,0 +1,234 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+)
+
+var errMalformed = errors.New("imaging: malformed image")
+
+// stripJPEG removes EXIF, XMP, IPTC and vendor segments and comments from a
+// JPEG without re-encoding it. The JFIF header, ICC profile and Adobe colour
+// segment are kept because they affect how the image is displayed.
+func stripJPEG(data []byte) ([]byte, error) {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return nil, errMalformed
+	}
+
+	out := make([]byte, 0, len(data))
+	out = append(out, 0xFF, 0xD8)
+	i := 2
+	for {
+		if i+4 > len(data) || data[i] != 0xFF {
+			return nil, errMalformed
+		}
+		marker := data[i+1]
+		if marker == 0xFF {
+			// Fill byte before a marker
+			i++
+			continue
+		}
+		if marker == 0xDA {
+			// Start of scan: the entropy-coded data runs to the end
+			return append(out, data[i:]...), nil
+		}
+
+		length := int(binary.BigEndian.Uint16(data[i+2:]))
+		end := i + 2 + length
+		if length < 2 || end > len(data) {
+			return nil, errMalformed
+		}
+		if keepJPEGSegment(marker, data[i+4:end]) {
+			out = append(out, data[i:end]...)
+		}
+		i = end
+	}
+}
+
+func keepJPEGSegment(marker byte, payload []byte) bool {
+	switch {
+	case marker == 0xFE:
+		// Comment
+		return false
+	case marker == 0xE0 || marker == 0xEE:
+		// JFIF and Adobe
+		return true
+	case marker == 0xE2:
+		return bytes.HasPrefix(payload, []byte("ICC_PROFILE\x00"))
+	case marker >= 0xE1 && marker <= 0xEF:
+		return false
+	}
+	return true
+}
+
+// pngKeptChunks are the ancillary PNG chunks that affect rendering or
+// animation. Other ancillary chunks, such as text and eXIf, are dropped.
+var pngKeptChunks = map[string]bool{
+	"tRNS": true, "gAMA": true, "cHRM": true, "sRGB": true, "iCCP": true,
+	"sBIT": true, "bKGD": true, "pHYs": true, "acTL": true, "fcTL": true, "fdAT": true,
+}
+
+func stripPNG(data []byte) ([]byte, error) {
+	const signature = "\x89PNG\r\n\x1a\n"
+	if !bytes.HasPrefix(data, []byte(signature)) {
+		return nil, errMalformed
+	}
+
+	out := make([]byte, 0, len(data))
+	out = append(out, signature...)
+	i := len(signature)
+	for i < len(data) {
+		if i+12 > len(data) {
+			return nil, errMalformed
+		}
+		// Compared before adding, so that huge lengths cannot overflow
+		length := int64(binary.BigEndian.Uint32(data[i:]))
+		if length > int64(len(data)-i-12) {
+			return nil, errMalformed
+		}
+		end := i + 12 + int(length)
+
+		chunkType := string(data[i+4 : i+8])
+		// Chunks starting with an upper-case letter are critical
+		critical := chunkType[0] >= 'A' && chunkType[0] <= 'Z'
+		if critical || pngKeptChunks[chunkType] {
+			out = append(out, data[i:end]...)
+		}
+		if chunkType == "IEND" {
+			return out, nil
+		}
+		i = end
+	}
+	// Truncated before the end chunk
+	return nil, errMalformed
+}
+
+// WebP extended-format flags for the chunks removed by stripWebP.
+const (
+	webpFlagXMP  = 0x04
+	webpFlagEXIF = 0x08
+)
+
+func stripWebP(data []byte) ([]byte, error) {
+	if len(data) < 12 || string(data[0:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		return nil, errMalformed
+	}
+	// The RIFF size says where the file ends; anything after it is ignored
+	riffEnd := 8 + int64(binary.LittleEndian.Uint32(data[4:]))
+	if riffEnd > int64(len(data)) {
+		return nil, errMalformed
+	}
+	data = data[:riffEnd]
+
+	out := make([]byte, 0, len(data))
+	out = append(out, data[:12]...)
+	i := 12
+	for i < len(data) {
+		if i+8 > len(data) {
+			return nil, errMalformed
+		}
+		fourCC := string(data[i : i+4])
+		size := int64(binary.LittleEndian.Uint32(data[i+4:]))
+		padded := size + size%2
+		if padded > int64(len(data)-i-8) {
+			return nil, errMalformed
+		}
+		end := i + 8 + int(padded)
+
+		switch fourCC {
+		case "EXIF", "XMP ":
+		case "VP8X":
+			start := len(out)
+			out = append(out, data[i:end]...)
+			if size > 0 {
+				out[start+8] &^= webpFlagXMP | webpFlagEXIF
+			}
+		default:
+			out = append(out, data[i:end]...)
+		}
+		i = end
+	}
+
+	binary.LittleEndian.PutUint32(out[4:], uint32(len(out)-8))
+	return out, nil
+}
+
+// stripGIF removes comments and application extensions, which is where GIFs
+// carry XMP data. The NETSCAPE extension that makes animations loop is kept.
+func stripGIF(data []byte) ([]byte, error) {
+	if len(data) < 13 || !bytes.HasPrefix(data, []byte("GIF8")) {
+		return nil, errMalformed
+	}
+
+	i := 13
+	if data[10]&0x80 != 0 {
+		i += 3 << (data[10]&0x07 + 1)
+	}
+	if i > len(data) {
+		return nil, errMalformed
+	}
+	out := make([]byte, 0, len(data))
+	out = append(out, data[:i]...)
+
+	for i < len(data) {
+		start := i
+		switch data[i] {
+		case 0x3B:
+			// Trailer
+			return append(out, 0x3B), nil
+		case 0x21:
+			if i+2 > len(data) {
+				return nil, errMalformed
+			}
+			label := data[i+1]
+			end, err := skipGIFSubBlocks(data, i+2)
+			if err != nil {
+				return nil, err
+			}
+			keep := label != 0xFE && label != 0xFF
+			if label == 0xFF && i+14 <= len(data) {
+				keep = string(data[i+3:i+14]) == "NETSCAPE2.0"
+			}
+			if keep {
+				out = append(out, data[start:end]...)
+			}
+			i = end
+		case 0x2C:
+			// Image descriptor, optional local colour table, LZW code size
+			if i+10 > len(data) {
+				return nil, errMalformed
+			}
+			j := i + 10
+			if data[i+9]&0x80 != 0 {
+				j += 3 << (data[i+9]&0x07 + 1)
+			}
+			j++
+			end, err := skipGIFSubBlocks(data, j)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, data[start:end]...)
+			i = end
+		default:
+			return nil, errMalformed
+		}
+	}
+	return nil, errMalformed
+}
+
+// skipGIFSubBlocks returns the offset after the sub-blocks starting at i and
+// their terminator.
+func skipGIFSubBlocks(data []byte, i int) (int, error) {
+	for {
+		if i >= len(data) {
+			return 0, errMalformed
+		}
+		size := int(data[i])
+		i++
+		if size == 0 {
+			return i, nil
+		}
+		i += size
+	}
+}
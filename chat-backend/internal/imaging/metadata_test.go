@@ -0,0 +1,185 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/gif"
+	"testing"
+
+	"cs-socket/internal/config"
+)
+
+func TestStripMetadata(t *testing.T) {
+	tests := []struct {
+		name  string
+		data  []byte
+		strip func([]byte) ([]byte, error)
+		kept  []string
+	}{
+		{"jpeg", jpegFixture(t, 0), stripJPEG, []string{"JFIF\x00", "ICC_PROFILE\x00"}},
+		{"png", pngFixture(t), stripPNG, []string{"gAMA", "IEND"}},
+		{"gif", gifFixture(t), stripGIF, []string{"NETSCAPE2.0"}},
+		{"webp", webpFixture(t), stripWebP, []string{"VP8X", "VP8L"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if !bytes.Contains(tt.data, []byte(xmpSecret)) {
+				t.Fatal("fixture has no XMP data")
+			}
+
+			stripped, err := tt.strip(tt.data)
+			if err != nil {
+				t.Fatalf("strip: %v", err)
+			}
+			for _, secret := range secrets {
+				if bytes.Contains(stripped, []byte(secret)) {
+					t.Errorf("stripped image still contains %q", secret)
+				}
+			}
+			for _, keep := range tt.kept {
+				if !bytes.Contains(stripped, []byte(keep)) {
+					t.Errorf("stripped image lost %q", keep)
+				}
+			}
+
+			assertSamePixels(t, tt.data, stripped)
+		})
+	}
+}
+
+func TestStripWebPClearsFlags(t *testing.T) {
+	stripped, err := stripWebP(webpFixture(t))
+	if err != nil {
+		t.Fatalf("stripWebP: %v", err)
+	}
+	if flags := stripped[20]; flags&(webpFlagEXIF|webpFlagXMP) != 0 {
+		t.Errorf("VP8X flags = %#x, want EXIF and XMP cleared", flags)
+	}
+	if size := binary.LittleEndian.Uint32(stripped[4:]); int(size) != len(stripped)-8 {
+		t.Errorf("RIFF size = %d, want %d", size, len(stripped)-8)
+	}
+}
+
+func TestStripGIFKeepsAnimation(t *testing.T) {
+	stripped, err := stripGIF(gifFixture(t))
+	if err != nil {
+		t.Fatalf("stripGIF: %v", err)
+	}
+	animation, err := gif.DecodeAll(bytes.NewReader(stripped))
+	if err != nil {
+		t.Fatalf("decoding stripped GIF: %v", err)
+	}
+	if len(animation.Image) != 2 || animation.LoopCount != 0 {
+		t.Errorf("stripped GIF has %d frames and loop count %d, want 2 looping forever",
+			len(animation.Image), animation.LoopCount)
+	}
+}
+
+func TestStripMalformed(t *testing.T) {
+	hugeJPEG := []byte{0xFF, 0xD8, 0xFF, 0xE1, 0xFF, 0xFF, 'E', 'x', 'i', 'f'}
+	shortJPEG := []byte{0xFF, 0xD8, 0xFF, 0xE1, 0x00, 0x01, 0xFF, 0xDA}
+	png := pngFixture(t)
+	hugePNG := append([]byte(nil), png...)
+	binary.BigEndian.PutUint32(hugePNG[33:], 0xFFFFFFFF)
+	webp := webpFixture(t)
+	hugeWebP := append([]byte(nil), webp...)
+	binary.LittleEndian.PutUint32(hugeWebP[16:], 0xFFFFFFFF)
+	longRIFF := append([]byte(nil), webp...)
+	binary.LittleEndian.PutUint32(longRIFF[4:], 0xFFFFFFF0)
+	gifData := gifFixture(t)
+
+	tests := []struct {
+		name  string
+		data  []byte
+		strip func([]byte) ([]byte, error)
+	}{
+		{"jpeg without SOI", []byte("not a jpeg"), stripJPEG},
+		{"jpeg huge segment length", hugeJPEG, stripJPEG},
+		{"jpeg segment length below 2", shortJPEG, stripJPEG},
+		{"jpeg without scan", jpegFixture(t, 0)[:200], stripJPEG},
+		{"png without signature", []byte("\x89PNX"), stripPNG},
+		{"png huge chunk length", hugePNG, stripPNG},
+		{"png without IEND", png[:len(png)-12], stripPNG},
+		{"webp without header", []byte("RIFF"), stripWebP},
+		{"webp huge chunk size", hugeWebP, stripWebP},
+		{"webp RIFF size past the end", longRIFF, stripWebP},
+		{"gif without trailer", gifData[:len(gifData)-1], stripGIF},
+		{"gif huge colour table", []byte("GIF89a\x01\x00\x01\x00\xF7\x00\x00"), stripGIF},
+		{"gif unknown block", append(append([]byte(nil), gifData[:13+768]...), 0x99), stripGIF},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := tt.strip(tt.data); !errors.Is(err, errMalformed) {
+				t.Errorf("err = %v, want errMalformed", err)
+			}
+		})
+	}
+}
+
+// TestProcessTruncated cuts every fixture at every length. Processing must
+// fail cleanly, without panicking.
+func TestProcessTruncated(t *testing.T) {
+	p := NewProcessor([]config.ThumbnailSize{{Name: "small", MaxDimension: 2}}, 1<<20)
+	fixtures := map[string][]byte{
+		"image/jpeg": jpegFixture(t, 6),
+		"image/png":  pngFixture(t),
+		"image/gif":  gifFixture(t),
+		"image/webp": webpFixture(t),
+	}
+
+	for contentType, data := range fixtures {
+		if _, err := p.Process(data, contentType); err != nil {
+			t.Fatalf("%s: Process of the whole fixture: %v", contentType, err)
+		}
+		for n := 0; n < len(data); n++ {
+			if _, err := p.Process(data[:n], contentType); err == nil {
+				t.Errorf("%s truncated to %d of %d bytes: no error", contentType, n, len(data))
+			}
+		}
+	}
+}
+
+func TestProcessStripsMetadata(t *testing.T) {
+	p := NewProcessor(nil, 1<<20)
+	img, err := p.Process(jpegFixture(t, 0), "image/jpeg")
+	if err != nil {
+		t.Fatalf("Process: %v", err)
+	}
+	for _, secret := range secrets {
+		if bytes.Contains(img.Data, []byte(secret)) {
+			t.Errorf("processed image still contains %q", secret)
+		}
+	}
+	if img.Width != 16 || img.Height != 8 {
+		t.Errorf("size = %dx%d, want 16x8", img.Width, img.Height)
+	}
+}
+
+// assertSamePixels decodes both images and compares every pixel.
+func assertSamePixels(t *testing.T, original, stripped []byte) {
+	t.Helper()
+
+	want, _, err := image.Decode(bytes.NewReader(original))
+	if err != nil {
+		t.Fatalf("decoding original: %v", err)
+	}
+	got, _, err := image.Decode(bytes.NewReader(stripped))
+	if err != nil {
+		t.Fatalf("decoding stripped image: %v", err)
+	}
+	if got.Bounds() != want.Bounds() {
+		t.Fatalf("bounds = %v, want %v", got.Bounds(), want.Bounds())
+	}
+	b := want.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			if got.At(x, y) != want.At(x, y) {
+				t.Fatalf("pixel (%d, %d) = %v, want %v", x, y, got.At(x, y), want.At(x, y))
+			}
+		}
+	}
+}
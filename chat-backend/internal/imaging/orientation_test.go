@@ -0,0 +1,141 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"testing"
+
+	"cs-socket/internal/config"
+)
+
+func TestJPEGOrientation(t *testing.T) {
+	for orientation := 0; orientation <= 8; orientation++ {
+		if got := jpegOrientation(jpegFixture(t, orientation)); got != orientation {
+			t.Errorf("orientation %d read as %d", orientation, got)
+		}
+	}
+}
+
+func TestExifOrientationMalformed(t *testing.T) {
+	valid := exifTIFF(6)
+	little := []byte("II\x2A\x00\x08\x00\x00\x00\x01\x00\x12\x01\x03\x00\x01\x00\x00\x00\x03\x00\x00\x00")
+	hugeOffset := append([]byte(nil), valid...)
+	binary.BigEndian.PutUint32(hugeOffset[4:], 0xFFFFFFFF)
+	// Without an orientation entry, the search runs past the end
+	hugeCount := exifTIFF(0)
+	binary.BigEndian.PutUint16(hugeCount[8:], 0xFFFF)
+	outOfRange := exifTIFF(9)
+
+	tests := []struct {
+		name string
+		tiff []byte
+		want int
+	}{
+		{"big-endian", valid, 6},
+		{"little-endian", little, 3},
+		{"unknown byte order", append([]byte("XX"), valid[2:]...), 0},
+		{"IFD offset past the end", hugeOffset, 0},
+		{"IFD offset inside the header", []byte("MM\x00\x2A\x00\x00\x00\x02\x00\x00"), 0},
+		{"entry count past the end", hugeCount, 0},
+		{"orientation out of range", outOfRange, 0},
+		{"empty", nil, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := exifOrientation(tt.tiff); got != tt.want {
+				t.Errorf("orientation = %d, want %d", got, tt.want)
+			}
+		})
+	}
+
+	// Truncated anywhere, the TIFF has no orientation or still its own
+	for n := 0; n < len(valid); n++ {
+		if got := exifOrientation(valid[:n]); got != 0 && got != 6 {
+			t.Errorf("truncated to %d bytes: orientation %d", n, got)
+		}
+	}
+	data := jpegFixture(t, 6)
+	for n := 0; n < len(data); n++ {
+		if got := jpegOrientation(data[:n]); got != 0 && got != 6 {
+			t.Errorf("JPEG truncated to %d bytes: orientation %d", n, got)
+		}
+	}
+}
+
+func TestOrient(t *testing.T) {
+	// A 3×2 image whose pixels are told apart by their red value:
+	//   A B C
+	//   D E F
+	const A, B, C, D, E, F = 10, 20, 30, 40, 50, 60
+	src := image.NewRGBA(image.Rect(0, 0, 3, 2))
+	for i, v := range []uint8{A, B, C, D, E, F} {
+		src.Set(i%3, i/3, color.RGBA{R: v, A: 255})
+	}
+
+	tests := []struct {
+		orientation int
+		want        [][]uint8
+	}{
+		{1, [][]uint8{{A, B, C}, {D, E, F}}},
+		{2, [][]uint8{{C, B, A}, {F, E, D}}},
+		{3, [][]uint8{{F, E, D}, {C, B, A}}},
+		{4, [][]uint8{{D, E, F}, {A, B, C}}},
+		{5, [][]uint8{{A, D}, {B, E}, {C, F}}},
+		{6, [][]uint8{{D, A}, {E, B}, {F, C}}},
+		{7, [][]uint8{{F, C}, {E, B}, {D, A}}},
+		{8, [][]uint8{{C, F}, {B, E}, {A, D}}},
+	}
+	for _, tt := range tests {
+		got := orient(src, tt.orientation)
+		if got.Bounds().Dx() != len(tt.want[0]) || got.Bounds().Dy() != len(tt.want) {
+			t.Errorf("orientation %d: size %v", tt.orientation, got.Bounds())
+			continue
+		}
+		for y, row := range tt.want {
+			for x, want := range row {
+				if r, _, _, _ := got.At(x, y).RGBA(); uint8(r>>8) != want {
+					t.Errorf("orientation %d: pixel (%d, %d) = %d, want %d", tt.orientation, x, y, r>>8, want)
+				}
+			}
+		}
+	}
+}
+
+// TestProcessOrientation checks that a rotated photo is turned upright and
+// that its EXIF data, orientation included, is dropped.
+func TestProcessOrientation(t *testing.T) {
+	p := NewProcessor([]config.ThumbnailSize{{Name: "small", MaxDimension: 4}}, 1<<20)
+	img, err := p.Process(jpegFixture(t, 6), "image/jpeg")
+	if err != nil {
+		t.Fatalf("Process: %v", err)
+	}
+	if img.Width != 8 || img.Height != 16 {
+		t.Errorf("size = %dx%d, want 8x16", img.Width, img.Height)
+	}
+	for _, secret := range secrets {
+		if bytes.Contains(img.Data, []byte(secret)) {
+			t.Errorf("processed image still contains %q", secret)
+		}
+	}
+	if got := jpegOrientation(img.Data); got != 0 {
+		t.Errorf("processed image has orientation %d", got)
+	}
+
+	// Turned clockwise, the red left half of the photo ends up on top
+	upright, err := jpeg.Decode(bytes.NewReader(img.Data))
+	if err != nil {
+		t.Fatalf("decoding processed image: %v", err)
+	}
+	top, _, _, _ := upright.At(4, 2).RGBA()
+	_, _, bottom, _ := upright.At(4, 13).RGBA()
+	if top>>8 < 200 || bottom>>8 < 200 {
+		t.Errorf("top pixel %v, bottom pixel %v: want red over blue", upright.At(4, 2), upright.At(4, 13))
+	}
+
+	if len(img.Thumbnails) != 1 || img.Thumbnails[0].Width != 2 || img.Thumbnails[0].Height != 4 {
+		t.Errorf("thumbnails = %+v, want one of 2x4", img.Thumbnails)
+	}
+}
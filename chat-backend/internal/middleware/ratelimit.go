@@ -0,0 +1,90 @@
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"cs-socket/internal/config"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RateLimit allows each client rate.Requests requests per rate.Per, counted
+// in fixed windows, and answers further requests with 429. key identifies
+// the client of a request.
+func RateLimit(rate config.Rate, key func(c *gin.Context) string) gin.HandlerFunc {
+	limiter := newLimiter(rate, time.Now)
+	return func(c *gin.Context) {
+		if retryAfter, ok := limiter.allow(key(c)); !ok {
+			c.Header("Retry-After", strconv.Itoa(int((retryAfter+time.Second-1)/time.Second)))
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many requests, try again later"})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// ByClientIP limits requests per client address, for routes used before
+// logging in.
+func ByClientIP(c *gin.Context) string {
+	return c.ClientIP()
+}
+
+// ByUser limits requests per authenticated user. It must run after
+// AuthMiddleware.
+func ByUser(c *gin.Context) string {
+	if userID := c.GetString("userID"); userID != "" {
+		return userID
+	}
+	return c.ClientIP()
+}
+
+type limiter struct {
+	rate config.Rate
+	now  func() time.Time
+
+	mu        sync.Mutex
+	windows   map[string]*window
+	lastSweep time.Time
+}
+
+type window struct {
+	start time.Time
+	count int
+}
+
+func newLimiter(rate config.Rate, now func() time.Time) *limiter {
+	return &limiter{rate: rate, now: now, windows: make(map[string]*window), lastSweep: now()}
+}
+
+// allow counts a request of a client, and says how long to wait when it
+// is over the limit.
+func (l *limiter) allow(key string) (time.Duration, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	// Forget clients whose window has ended, so the map stays small
+	if now.Sub(l.lastSweep) >= l.rate.Per {
+		for k, w := range l.windows {
+			if now.Sub(w.start) >= l.rate.Per {
+				delete(l.windows, k)
+			}
+		}
+		l.lastSweep = now
+	}
+
+	w, ok := l.windows[key]
+	if !ok || now.Sub(w.start) >= l.rate.Per {
+		w = &window{start: now}
+		l.windows[key] = w
+	}
+	if w.count >= l.rate.Requests {
+		return w.start.Add(l.rate.Per).Sub(now), false
+	}
+	w.count++
+	return 0, true
+}
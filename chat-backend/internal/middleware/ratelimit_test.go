@@ -0,0 +1,36 @@
+package middleware
+
+import (
+	"testing"
+	"time"
+
+	"cs-socket/internal/config"
+)
+
+func TestLimiter(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	l := newLimiter(config.Rate{Requests: 2, Per: time.Minute}, func() time.Time { return now })
+
+	for i := 0; i < 2; i++ {
+		if _, ok := l.allow("a"); !ok {
+			t.Fatalf("request %d refused", i+1)
+		}
+	}
+	now = now.Add(20 * time.Second)
+	if retry, ok := l.allow("a"); ok || retry != 40*time.Second {
+		t.Errorf("third request: allowed %v, retry after %s", ok, retry)
+	}
+	// Clients are counted separately
+	if _, ok := l.allow("b"); !ok {
+		t.Error("other client refused")
+	}
+
+	// A new window starts after the period, and ended windows are dropped
+	now = now.Add(time.Minute)
+	if _, ok := l.allow("a"); !ok {
+		t.Error("request in the next window refused")
+	}
+	if _, ok := l.windows["b"]; ok {
+		t.Error("ended window of b was kept")
+	}
+}
@@ -1,9 +1,60 @@
 package models
 
 import (
+	"encoding/json"
 	"time"
 )
 
+// Message visibility levels. Staff messages are only ever shown to agents and
+// super-agents.
+const (
+	VisibilityPublic = "public"
+	VisibilityStaff  = "staff"
+)
+
+// Chat lifecycle states. See services.chatTransitions for the allowed moves.
+const (
+	ChatStatusQueued          = "queued"
+	ChatStatusActive          = "active"
+	ChatStatusPendingCustomer = "pending_customer"
+	ChatStatusResolved        = "resolved"
+	ChatStatusClosed          = "closed"
+	ChatStatusArchived        = "archived"
+)
+
+// IsOpenStatus reports whether a chat in the status still needs an agent.
+func IsOpenStatus(status string) bool {
+	return status == ChatStatusQueued ||
+		status == ChatStatusActive ||
+		status == ChatStatusPendingCustomer
+}
+
+// Chat priorities, lowest first.
+const (
+	PriorityLow    = "low"
+	PriorityNormal = "normal"
+	PriorityHigh   = "high"
+	PriorityUrgent = "urgent"
+)
+
+// Malware scan states of an attachment. Only clean files can be downloaded,
+// and unscanned ones, uploaded while scanning was off, where the config
+// allows it.
+const (
+	ScanPending   = "pending"
+	ScanClean     = "clean"
+	ScanInfected  = "infected"
+	ScanFailed    = "failed"
+	ScanUnscanned = "unscanned"
+)
+
+// Customer tiers.
+const (
+	TierRegular    = "regular"
+	TierVIP        = "vip"
+	TierHighRoller = "high-roller"
+)
+
 type User struct {
 	ID        string    `json:"id" db:"id"`
 	Username  string    `json:"username" db:"username"`
@@ -11,6 +62,8 @@ type User struct {
 	Password  string    `json:"-" db:"password_hash"`
 	Name      string    `json:"name" db:"name"`
 	Role      string    `json:"role" db:"role"`
+	Tier      string    `json:"tier" db:"tier"`
+	Team      *string   `json:"team" db:"team"`
 	Avatar    *string   `json:"avatar" db:"avatar"`
 	IsOnline  bool      `json:"isOnline" db:"is_online"`
 	CreatedAt time.Time `json:"createdAt" db:"created_at"`
@@ -18,27 +71,448 @@ type User struct {
 }
 
 type Chat struct {
+	ID             string     `json:"id" db:"id"`
+	CustomerID     string     `json:"customerId" db:"customer_id"`
+	AgentID        *string    `json:"agentId" db:"agent_id"`
+	Topic          string     `json:"topic" db:"topic"`
+	Priority       string     `json:"priority,omitempty" db:"priority"`
+	IsEscalated    bool       `json:"isEscalated" db:"is_escalated"`
+	Status         string     `json:"status" db:"status"`
+	ResolutionCode *string    `json:"resolutionCode,omitempty" db:"resolution_code"`
+	WrapUpNotes    *string    `json:"wrapUpNotes,omitempty" db:"wrap_up_notes"`
+	ResolvedAt     *time.Time `json:"resolvedAt,omitempty" db:"resolved_at"`
+	ClosedAt       *time.Time `json:"closedAt,omitempty" db:"closed_at"`
+	CreatedAt      time.Time  `json:"createdAt" db:"created_at"`
+	UpdatedAt      time.Time  `json:"updatedAt" db:"updated_at"`
+	Tags           []string   `json:"tags"`
+	Customer       *User      `json:"customer,omitempty"`
+	Agent          *User      `json:"agent,omitempty"`
+	Messages       []Message  `json:"messages,omitempty"`
+	LastMessage    *Message   `json:"lastMessage,omitempty"`
+	UnreadCount    *int64     `json:"unreadCount,omitempty"`
+	IsActive       bool       `json:"isActive"`
+}
+
+// Message is a chat message. Seq numbers every message of a chat from 1
+// without gaps, staff-only ones included; PublicSeq does the same for public
+// messages only, so that customers can detect gaps too. Customers are never
+// sent Seq, since it would reveal the staff-only messages.
+type Message struct {
+	ID          string          `json:"id" db:"id"`
+	ChatID      string          `json:"chatId" db:"chat_id"`
+	Seq         int64           `json:"seq,omitempty" db:"seq"`
+	PublicSeq   *int64          `json:"publicSeq,omitempty" db:"public_seq"`
+	SenderID    string          `json:"senderId" db:"sender_id"`
+	Content     string          `json:"content" db:"content"`
+	MessageType string          `json:"type" db:"message_type"`
+	Visibility  string          `json:"visibility" db:"visibility"`
+	Metadata    json.RawMessage `json:"metadata,omitempty" db:"metadata"`
+	CreatedAt   time.Time       `json:"timestamp" db:"created_at"`
+	EditedAt    *time.Time      `json:"editedAt,omitempty" db:"edited_at"`
+	DeletedAt   *time.Time      `json:"deletedAt,omitempty" db:"deleted_at"`
+	Sender      *User           `json:"sender,omitempty"`
+	Reactions   []Reaction      `json:"reactions,omitempty"`
+	ReplyToID   *string         `json:"replyToId,omitempty" db:"reply_to_id"`
+	ReplyTo     *QuotedMessage  `json:"replyTo,omitempty"`
+	Attachments []Attachment    `json:"attachments,omitempty"`
+}
+
+// Attachment is a file sent with a message. URL is a signed download link
+// that stops working at URLExpiresAt; it is only set for files that may be
+// downloaded, see ScanStatus. Images also have their dimensions and
+// thumbnails, smallest first, whose URLs expire at the same time.
+type Attachment struct {
+	ID           string      `json:"id" db:"id"`
+	ChatID       string      `json:"chatId" db:"chat_id"`
+	MessageID    *string     `json:"messageId" db:"message_id"`
+	UploaderID   *string     `json:"uploaderId" db:"uploader_id"`
+	FileName     string      `json:"fileName" db:"file_name"`
+	ContentType  string      `json:"contentType" db:"content_type"`
+	Size         int64       `json:"size" db:"size_bytes"`
+	Width        *int        `json:"width,omitempty" db:"width"`
+	Height       *int        `json:"height,omitempty" db:"height"`
+	ScanStatus   string      `json:"scanStatus" db:"scan_status"`
+	ScannedAt    *time.Time  `json:"scannedAt,omitempty" db:"scanned_at"`
+	StorageKey   string      `json:"-" db:"storage_key"`
+	CreatedAt    time.Time   `json:"createdAt" db:"created_at"`
+	URL          string      `json:"url,omitempty"`
+	URLExpiresAt *time.Time  `json:"urlExpiresAt,omitempty"`
+	Thumbnails   []Thumbnail `json:"thumbnails,omitempty"`
+}
+
+// Thumbnail is a scaled-down copy of an image attachment.
+type Thumbnail struct {
+	Name        string `json:"name" db:"name"`
+	ContentType string `json:"contentType" db:"content_type"`
+	Size        int64  `json:"size" db:"size_bytes"`
+	Width       int    `json:"width" db:"width"`
+	Height      int    `json:"height" db:"height"`
+	StorageKey  string `json:"-" db:"storage_key"`
+	URL         string `json:"url,omitempty"`
+}
+
+// QuotedMessage is the snippet of a message that another message replies to.
+// The snippet of a deleted message is empty.
+type QuotedMessage struct {
+	ID         string `json:"id"`
+	SenderID   string `json:"senderId"`
+	SenderName string `json:"senderName"`
+	Type       string `json:"type"`
+	Snippet    string `json:"snippet"`
+	IsDeleted  bool   `json:"isDeleted"`
+}
+
+// Reaction summarises the users who reacted to a message with one emoji.
+type Reaction struct {
+	Emoji   string   `json:"emoji"`
+	Count   int      `json:"count"`
+	UserIDs []string `json:"userIds"`
+}
+
+// MessageEdit is an earlier version of an edited message.
+type MessageEdit struct {
+	ID        string    `json:"id" db:"id"`
+	MessageID string    `json:"messageId" db:"message_id"`
+	Content   string    `json:"content" db:"content"`
+	EditedBy  *string   `json:"editedBy" db:"edited_by"`
+	EditedAt  time.Time `json:"editedAt" db:"edited_at"`
+}
+
+// MessageHistory is the compliance view of a message: its last content, even
+// if it was deleted, and every earlier version, oldest first.
+type MessageHistory struct {
+	Message   Message       `json:"message"`
+	DeletedBy *string       `json:"deletedBy"`
+	Edits     []MessageEdit `json:"edits"`
+}
+
+type ChatStatusChange struct {
+	ID             string    `json:"id" db:"id"`
+	ChatID         string    `json:"chatId" db:"chat_id"`
+	FromStatus     *string   `json:"fromStatus" db:"from_status"`
+	ToStatus       string    `json:"toStatus" db:"to_status"`
+	ChangedBy      *string   `json:"changedBy" db:"changed_by"`
+	ResolutionCode *string   `json:"resolutionCode,omitempty" db:"resolution_code"`
+	Note           *string   `json:"note,omitempty" db:"note"`
+	CreatedAt      time.Time `json:"createdAt" db:"created_at"`
+}
+
+// SLAPolicy holds response thresholds in seconds, measured from the creation
+// of the chat. A nil Priority or Topic matches any chat.
+type SLAPolicy struct {
+	ID                   string    `json:"id" db:"id"`
+	Name                 string    `json:"name" db:"name"`
+	Priority             *string   `json:"priority" db:"priority"`
+	Topic                *string   `json:"topic" db:"topic"`
+	FirstResponseWarning int       `json:"firstResponseWarningSeconds" db:"first_response_warning_secs"`
+	FirstResponseBreach  int       `json:"firstResponseBreachSeconds" db:"first_response_breach_secs"`
+	ResolutionWarning    int       `json:"resolutionWarningSeconds" db:"resolution_warning_secs"`
+	ResolutionBreach     int       `json:"resolutionBreachSeconds" db:"resolution_breach_secs"`
+	CreatedAt            time.Time `json:"createdAt" db:"created_at"`
+	UpdatedAt            time.Time `json:"updatedAt" db:"updated_at"`
+}
+
+type SLAEvent struct {
+	ID        string    `json:"id" db:"id"`
+	ChatID    string    `json:"chatId" db:"chat_id"`
+	PolicyID  *string   `json:"policyId" db:"policy_id"`
+	AgentID   *string   `json:"agentId" db:"agent_id"`
+	Metric    string    `json:"metric" db:"metric"`
+	Level     string    `json:"level" db:"level"`
+	Threshold int       `json:"thresholdSeconds" db:"threshold_secs"`
+	Elapsed   int       `json:"elapsedSeconds" db:"elapsed_secs"`
+	CreatedAt time.Time `json:"createdAt" db:"created_at"`
+}
+
+type SLAEventFilter struct {
+	ChatID  string
+	AgentID string
+	Level   string
+	From    *time.Time
+	To      *time.Time
+}
+
+// ChatSLA reports the response metrics of a chat in seconds. Metrics that
+// cannot be measured yet are nil.
+type ChatSLA struct {
+	ChatID        string     `json:"chatId"`
+	Policy        *SLAPolicy `json:"policy"`
+	FirstResponse *float64   `json:"firstResponseSeconds"`
+	AverageReply  *float64   `json:"averageReplySeconds"`
+	Resolution    *float64   `json:"resolutionSeconds"`
+	Events        []SLAEvent `json:"events"`
+}
+
+// Escalation statuses.
+const (
+	EscalationOpen      = "open"
+	EscalationClaimed   = "claimed"
+	EscalationResolved  = "resolved"
+	EscalationCancelled = "cancelled"
+)
+
+type Escalation struct {
+	ID          string     `json:"id" db:"id"`
+	ChatID      string     `json:"chatId" db:"chat_id"`
+	RequestedBy string     `json:"requestedBy" db:"requested_by"`
+	Reason      string     `json:"reason" db:"reason"`
+	Status      string     `json:"status" db:"status"`
+	ClaimedBy   *string    `json:"claimedBy" db:"claimed_by"`
+	ClaimedAt   *time.Time `json:"claimedAt" db:"claimed_at"`
+	Outcome     *string    `json:"outcome" db:"outcome"`
+	ResolvedAt  *time.Time `json:"resolvedAt" db:"resolved_at"`
+	CreatedAt   time.Time  `json:"createdAt" db:"created_at"`
+}
+
+type CSATRating struct {
+	ID         string    `json:"id" db:"id"`
+	ChatID     string    `json:"chatId" db:"chat_id"`
+	CustomerID string    `json:"customerId" db:"customer_id"`
+	AgentID    *string   `json:"agentId" db:"agent_id"`
+	Team       *string   `json:"team" db:"team"`
+	Score      int       `json:"score" db:"score"`
+	Comment    *string   `json:"comment" db:"comment"`
+	CreatedAt  time.Time `json:"createdAt" db:"created_at"`
+	UpdatedAt  time.Time `json:"updatedAt" db:"updated_at"`
+}
+
+// CSATFilter narrows a CSAT summary. GroupBy is "agent", "team" or empty for
+// a single overall row.
+type CSATFilter struct {
+	GroupBy string
+	AgentID string
+	Team    string
+	From    *time.Time
+	To      *time.Time
+}
+
+// CSATSummary aggregates the ratings of one group. Satisfaction is the
+// percentage of ratings of 4 or 5; Distribution counts scores 1 to 5.
+type CSATSummary struct {
+	Key          string  `json:"key"`
+	Label        string  `json:"label"`
+	Responses    int     `json:"responses"`
+	Average      float64 `json:"average"`
+	Satisfaction float64 `json:"satisfaction"`
+	Distribution [5]int  `json:"distribution"`
+}
+
+type Tag struct {
 	ID          string    `json:"id" db:"id"`
-	CustomerID  string    `json:"customerId" db:"customer_id"`
-	AgentID     *string   `json:"agentId" db:"agent_id"`
-	Status      string    `json:"status" db:"status"`
+	Name        string    `json:"name" db:"name"`
+	Description *string   `json:"description" db:"description"`
+	Color       *string   `json:"color" db:"color"`
 	CreatedAt   time.Time `json:"createdAt" db:"created_at"`
 	UpdatedAt   time.Time `json:"updatedAt" db:"updated_at"`
-	Customer    *User     `json:"customer,omitempty"`
-	Agent       *User     `json:"agent,omitempty"`
-	Messages    []Message `json:"messages,omitempty"`
-	LastMessage *Message  `json:"lastMessage,omitempty"`
-	IsActive    bool      `json:"isActive"`
 }
 
-type Message struct {
-	ID          string    `json:"id" db:"id"`
-	ChatID      string    `json:"chatId" db:"chat_id"`
-	SenderID    string    `json:"senderId" db:"sender_id"`
-	Content     string    `json:"content" db:"content"`
-	MessageType string    `json:"type" db:"message_type"`
-	CreatedAt   time.Time `json:"timestamp" db:"created_at"`
-	Sender      *User     `json:"sender,omitempty"`
+// ChatFilter narrows, sorts and pages chat listings. Empty fields match every
+// chat. Sort is "priority" (the default), "updated" or "created"; Order is
+// "desc" (the default) or "asc".
+type ChatFilter struct {
+	Tag        string
+	Status     string
+	AgentID    string
+	Unassigned bool
+	HasUnread  bool
+	Sort       string
+	Order      string
+	Limit      int
+	Offset     int
+}
+
+// ChatPaging describes a page of a chat listing. HasMore says whether there
+// are chats after it.
+type ChatPaging struct {
+	Limit   int  `json:"limit"`
+	Offset  int  `json:"offset"`
+	HasMore bool `json:"hasMore"`
+}
+
+// MessagePage selects a page of a chat's messages. Before and After are
+// message IDs, of which at most one may be set; without either the newest
+// messages are returned.
+type MessagePage struct {
+	Before string
+	After  string
+	Limit  int
+}
+
+// MessagePaging describes a page of messages: Before and After are the
+// cursors for the pages on either side of it, and HasMore says whether there
+// are more messages in the direction the page was fetched.
+type MessagePaging struct {
+	Before  string `json:"before,omitempty"`
+	After   string `json:"after,omitempty"`
+	HasMore bool   `json:"hasMore"`
+}
+
+// SyncResult holds the chats and messages that changed since a sync cursor.
+// Reset means the client must reload everything instead, then sync from
+// Cursor.
+type SyncResult struct {
+	Reset    bool      `json:"reset"`
+	Chats    []Chat    `json:"chats"`
+	Messages []Message `json:"messages"`
+	Cursor   string    `json:"cursor"`
+}
+
+// MessageSearchFilter narrows a full-text message search. Empty fields match
+// every message.
+type MessageSearchFilter struct {
+	Query      string
+	From       *time.Time
+	To         *time.Time
+	AgentID    string
+	CustomerID string
+	Status     string
+	Limit      int
+	Offset     int
+}
+
+// MessageSearchResult is a message matching a search. Headline is the
+// matching passage, HTML-escaped, with the matched words wrapped in <mark>.
+type MessageSearchResult struct {
+	MessageID   string            `json:"messageId"`
+	ChatID      string            `json:"chatId"`
+	SenderID    string            `json:"senderId"`
+	SenderName  string            `json:"senderName"`
+	SenderRole  string            `json:"senderRole"`
+	MessageType string            `json:"type"`
+	Visibility  string            `json:"visibility"`
+	Headline    string            `json:"headline"`
+	Rank        float64           `json:"rank"`
+	CreatedAt   time.Time         `json:"timestamp"`
+	Chat        SearchChatContext `json:"chat"`
+}
+
+// SearchChatContext describes the chat a search result belongs to.
+type SearchChatContext struct {
+	ID           string    `json:"id"`
+	Status       string    `json:"status"`
+	Topic        string    `json:"topic"`
+	CustomerID   string    `json:"customerId"`
+	CustomerName string    `json:"customerName"`
+	AgentID      *string   `json:"agentId"`
+	AgentName    *string   `json:"agentName"`
+	CreatedAt    time.Time `json:"createdAt"`
+}
+
+// TagStatsFilter selects the taggings counted by tag statistics. Interval is
+// "day", "week" or "month".
+type TagStatsFilter struct {
+	Interval string
+	Tag      string
+	From     *time.Time
+	To       *time.Time
+}
+
+// TagCount is the number of chats a tag was added to in a period.
+type TagCount struct {
+	Period time.Time `json:"period"`
+	TagID  string    `json:"tagId"`
+	Tag    string    `json:"tag"`
+	Count  int       `json:"count"`
+}
+
+// Canned response scopes. Personal responses belong to their owner; team
+// responses are shared with every member of the team.
+const (
+	CannedScopePersonal = "personal"
+	CannedScopeTeam     = "team"
+)
+
+type CannedResponse struct {
+	ID        string    `json:"id" db:"id"`
+	OwnerID   string    `json:"ownerId" db:"owner_id"`
+	Scope     string    `json:"scope" db:"scope"`
+	Team      *string   `json:"team" db:"team"`
+	Shortcut  string    `json:"shortcut" db:"shortcut"`
+	Title     string    `json:"title" db:"title"`
+	Content   string    `json:"content" db:"content"`
+	CreatedAt time.Time `json:"createdAt" db:"created_at"`
+	UpdatedAt time.Time `json:"updatedAt" db:"updated_at"`
+}
+
+type CannedStatsFilter struct {
+	Team string
+	From *time.Time
+	To   *time.Time
+}
+
+// CannedResponseStats describes how often a canned response was used and how
+// the chats it was used in turned out.
+type CannedResponseStats struct {
+	ID            string   `json:"id"`
+	Shortcut      string   `json:"shortcut"`
+	Title         string   `json:"title"`
+	Scope         string   `json:"scope"`
+	Team          *string  `json:"team"`
+	Uses          int      `json:"uses"`
+	Chats         int      `json:"chats"`
+	Agents        int      `json:"agents"`
+	ResolvedChats int      `json:"resolvedChats"`
+	CSATResponses int      `json:"csatResponses"`
+	AverageCSAT   *float64 `json:"averageCsat"`
+}
+
+type Mention struct {
+	ChatID    string    `json:"chatId"`
+	Message   Message   `json:"message"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+type SLAPolicyRequest struct {
+	Name                 string  `json:"name" binding:"required"`
+	Priority             *string `json:"priority"`
+	Topic                *string `json:"topic"`
+	FirstResponseWarning int     `json:"firstResponseWarningSeconds" binding:"min=0"`
+	FirstResponseBreach  int     `json:"firstResponseBreachSeconds" binding:"min=0"`
+	ResolutionWarning    int     `json:"resolutionWarningSeconds" binding:"min=0"`
+	ResolutionBreach     int     `json:"resolutionBreachSeconds" binding:"min=0"`
+}
+
+type UpdateTierRequest struct {
+	Tier string `json:"tier" binding:"required"`
+}
+
+type EscalateRequest struct {
+	Reason string `json:"reason" binding:"required"`
+}
+
+type ResolveEscalationRequest struct {
+	Outcome string `json:"outcome" binding:"required"`
+}
+
+type CSATRequest struct {
+	Score   int    `json:"score" binding:"required,min=1,max=5"`
+	Comment string `json:"comment"`
+}
+
+type TagRequest struct {
+	Name        string  `json:"name" binding:"required,max=50"`
+	Description *string `json:"description"`
+	Color       *string `json:"color"`
+}
+
+type ChatTagRequest struct {
+	TagID string `json:"tagId" binding:"required"`
+}
+
+type CannedResponseRequest struct {
+	Scope    string  `json:"scope"`
+	Team     *string `json:"team"`
+	Shortcut string  `json:"shortcut" binding:"required,max=50"`
+	Title    string  `json:"title" binding:"required,max=100"`
+	Content  string  `json:"content" binding:"required"`
+}
+
+type UseCannedResponseRequest struct {
+	CannedResponseID string `json:"cannedResponseId" binding:"required"`
+}
+
+type UpdateTeamRequest struct {
+	Team *string `json:"team"`
 }
 
 type LoginRequest struct {
@@ -55,13 +529,35 @@ type RegisterRequest struct {
 }
 
 type SendMessageRequest struct {
-	Content     string `json:"content" binding:"required"`
-	MessageType string `json:"type"`
+	Content     string  `json:"content" binding:"required"`
+	MessageType string  `json:"type"`
+	ReplyToID   *string `json:"replyToId"`
+}
+
+type EditMessageRequest struct {
+	Content string `json:"content" binding:"required"`
+}
+
+type MarkReadRequest struct {
+	MessageID string `json:"messageId" binding:"required"`
+}
+
+type ReactionRequest struct {
+	Emoji string `json:"emoji" binding:"required"`
+}
+
+type WhisperRequest struct {
+	Content string `json:"content" binding:"required"`
+}
+
+type NoteRequest struct {
+	Content string `json:"content" binding:"required"`
 }
 
 type CreateChatRequest struct {
 	CustomerID string  `json:"customerId" binding:"required"`
 	AgentID    *string `json:"agentId,omitempty"`
+	Topic      string  `json:"topic,omitempty"`
 }
 
 type UpdateStatusRequest struct {
@@ -69,5 +565,7 @@ type UpdateStatusRequest struct {
 }
 
 type UpdateChatStatusRequest struct {
-	Status string `json:"status" binding:"required"`
+	Status         string `json:"status" binding:"required"`
+	ResolutionCode string `json:"resolutionCode"`
+	Note           string `json:"note"`
 }
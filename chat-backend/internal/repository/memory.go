@@ -0,0 +1,2115 @@
+package repository
+
+import (
+	"database/sql"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"cs-socket/internal/models"
+
+	"github.com/google/uuid"
+)
+
+// NewMemory returns empty repositories that keep everything in memory, for
+// tests. They behave like the PostgreSQL ones, except that they count tag
+// statistics in UTC and search messages by whole words rather than with
+// PostgreSQL text search.
+func NewMemory() Repositories {
+	m := &memory{
+		users:          make(map[string]*models.User),
+		chats:          make(map[string]*memoryChat),
+		messages:       make(map[string]*models.Message),
+		messageUpdated: make(map[string]time.Time),
+		deletedBy:      make(map[string]string),
+		reads:          make(map[readKey]int64),
+		attachments:    make(map[string]*models.Attachment),
+		scans:          make(map[string]*memoryScan),
+		tags:           make(map[string]*models.Tag),
+		ratings:        make(map[string]*models.CSATRating),
+		canned:         make(map[string]*models.CannedResponse),
+		slaPolicies:    make(map[string]*models.SLAPolicy),
+	}
+	return Repositories{
+		Users:       &memoryUsers{m},
+		Chats:       &memoryChats{m},
+		Messages:    &memoryMessages{m},
+		Escalations: &memoryEscalations{m},
+		Tags:        &memoryTags{m},
+		CSAT:        &memoryCSAT{m},
+		Canned:      &memoryCanned{m},
+		SLA:         &memorySLA{m},
+	}
+}
+
+// memory is the store shared by the memory repositories. One lock guards
+// everything, like a single database connection would. Slices are kept in
+// insertion order.
+type memory struct {
+	mu       sync.Mutex
+	users    map[string]*models.User
+	chats    map[string]*memoryChat
+	messages map[string]*models.Message
+	// messageUpdated holds when each message last changed, for sync
+	messageUpdated map[string]time.Time
+	// deletedBy holds who deleted each deleted message
+	deletedBy   map[string]string
+	edits       []models.MessageEdit
+	mentions    []memoryMention
+	reactions   []memoryReaction
+	reads       map[readKey]int64
+	history     []models.ChatStatusChange
+	escalations []*models.Escalation
+	attachments map[string]*models.Attachment
+	// attachmentOrder holds attachment IDs in upload order
+	attachmentOrder []string
+	scans           map[string]*memoryScan
+	tags            map[string]*models.Tag
+	chatTags        []memoryChatTag
+	// ratings holds the CSAT rating of each rated chat
+	ratings     map[string]*models.CSATRating
+	canned      map[string]*models.CannedResponse
+	cannedUses  []memoryCannedUse
+	slaPolicies map[string]*models.SLAPolicy
+	slaEvents   []*models.SLAEvent
+}
+
+type memoryChat struct {
+	chat          models.Chat
+	lastSeq       int64
+	lastPublicSeq int64
+	// assistants are the super-agents assisting with the chat
+	assistants   []string
+	idleNudgedAt *time.Time
+}
+
+type memoryMention struct {
+	messageID string
+	userID    string
+	createdAt time.Time
+}
+
+// memoryScan is the scanning state of an attachment.
+type memoryScan struct {
+	attempts    int
+	attemptedAt *time.Time
+	signature   *string
+}
+
+type memoryCannedUse struct {
+	responseID string
+	chatID     string
+	agentID    string
+	messageID  string
+	usedAt     time.Time
+}
+
+type memoryChatTag struct {
+	chatID  string
+	tagID   string
+	addedAt time.Time
+}
+
+type memoryReaction struct {
+	messageID string
+	userID    string
+	emoji     string
+}
+
+type readKey struct {
+	chatID string
+	userID string
+}
+
+// now returns the current time at the precision PostgreSQL stores.
+func now() time.Time {
+	return time.Now().UTC().Truncate(time.Microsecond)
+}
+
+type memoryUsers struct {
+	*memory
+}
+
+func (r *memoryUsers) GetByID(id string) (*models.User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	user, ok := r.users[id]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	copied := *user
+	copied.Password = ""
+	return &copied, nil
+}
+
+func (r *memoryUsers) GetByUsername(username string) (*models.User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, user := range r.users {
+		if user.Username == username {
+			copied := *user
+			return &copied, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (r *memoryUsers) Create(user models.User) (*models.User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, existing := range r.users {
+		if existing.ID == user.ID || existing.Username == user.Username || existing.Email == user.Email {
+			return nil, ErrDuplicate
+		}
+	}
+
+	if user.Tier == "" {
+		user.Tier = models.TierRegular
+	}
+	user.CreatedAt = now()
+	user.UpdatedAt = user.CreatedAt
+	r.users[user.ID] = &user
+
+	created := user
+	created.Password = ""
+	return &created, nil
+}
+
+func (r *memoryUsers) List(staffOnly bool) ([]models.User, error) {
+	return r.listUsers(func(user *models.User) bool {
+		return user.Role != "system" && (!staffOnly || isStaffRole(user.Role))
+	}), nil
+}
+
+func (r *memoryUsers) SetOnline(id string, online bool) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if user, ok := r.users[id]; ok {
+		user.IsOnline = online
+		user.UpdatedAt = now()
+	}
+	return nil
+}
+
+func (r *memoryUsers) SetTier(id, tier string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	user, ok := r.users[id]
+	if !ok || user.Role != "customer" {
+		return sql.ErrNoRows
+	}
+	user.Tier = tier
+	user.UpdatedAt = now()
+	return nil
+}
+
+func (r *memoryUsers) SetTeam(id string, team *string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	user, ok := r.users[id]
+	if !ok || !isStaffRole(user.Role) {
+		return sql.ErrNoRows
+	}
+	user.Team = team
+	user.UpdatedAt = now()
+	return nil
+}
+
+func (r *memoryUsers) SetRole(id, role string) error {
+	return r.update(id, func(user *models.User) { user.Role = role })
+}
+
+func (r *memoryUsers) SetPassword(id, hash string) error {
+	return r.update(id, func(user *models.User) { user.Password = hash })
+}
+
+// update applies change to a stored user and bumps their updated_at.
+func (r *memoryUsers) update(id string, change func(user *models.User)) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	user, ok := r.users[id]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	change(user)
+	user.UpdatedAt = now()
+	return nil
+}
+
+func (r *memoryUsers) AvailableAgents(customerID string) ([]models.User, error) {
+	busy := r.busyUsers(func(c *models.Chat) (string, bool) {
+		return *c.AgentID, c.CustomerID != customerID
+	})
+	return r.listUsers(func(user *models.User) bool {
+		return user.Role == "agent" && user.IsOnline && !busy[user.ID]
+	}), nil
+}
+
+func (r *memoryUsers) AvailableCustomers(agentID string) ([]models.User, error) {
+	busy := r.busyUsers(func(c *models.Chat) (string, bool) {
+		return c.CustomerID, *c.AgentID != agentID
+	})
+	return r.listUsers(func(user *models.User) bool {
+		return user.Role == "customer" && user.IsOnline && !busy[user.ID]
+	}), nil
+}
+
+// busyUsers collects the users that pick returns for active and
+// pending_customer chats with an agent, when it returns true.
+func (r *memoryUsers) busyUsers(pick func(c *models.Chat) (string, bool)) map[string]bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	busy := make(map[string]bool)
+	for _, stored := range r.chats {
+		c := &stored.chat
+		if c.AgentID == nil || (c.Status != models.ChatStatusActive && c.Status != models.ChatStatusPendingCustomer) {
+			continue
+		}
+		if id, ok := pick(c); ok {
+			busy[id] = true
+		}
+	}
+	return busy
+}
+
+// listUsers returns the users that match, by name, without passwords.
+func (r *memoryUsers) listUsers(match func(user *models.User) bool) []models.User {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var users []models.User
+	for _, user := range r.users {
+		if match(user) {
+			copied := *user
+			copied.Password = ""
+			users = append(users, copied)
+		}
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].Name < users[j].Name })
+	return users
+}
+
+func isStaffRole(role string) bool {
+	return role == "agent" || role == "super-agent"
+}
+
+type memoryChats struct {
+	*memory
+}
+
+func (r *memoryChats) Get(id string) (*models.Chat, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored, ok := r.chats[id]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	chat := r.chatView(stored)
+	return &chat, nil
+}
+
+// chatView returns a chat as ChatSelect would. The lock must be held.
+func (r *memory) chatView(stored *memoryChat) models.Chat {
+	chat := stored.chat
+	chat.Tags = []string{}
+	for _, chatTag := range r.chatTags {
+		if chatTag.chatID == chat.ID {
+			chat.Tags = append(chat.Tags, r.tags[chatTag.tagID].Name)
+		}
+	}
+	sort.Strings(chat.Tags)
+	chat.IsActive = models.IsOpenStatus(chat.Status)
+	if customer, ok := r.users[chat.CustomerID]; ok {
+		chat.Customer = &models.User{
+			ID: customer.ID, Username: customer.Username, Name: customer.Name, Role: customer.Role,
+			Tier: customer.Tier, Avatar: customer.Avatar, IsOnline: customer.IsOnline,
+		}
+	}
+	if chat.AgentID != nil {
+		if agent, ok := r.users[*chat.AgentID]; ok {
+			chat.Agent = &models.User{
+				ID: agent.ID, Username: agent.Username, Name: agent.Name, Role: agent.Role,
+				Avatar: agent.Avatar, IsOnline: agent.IsOnline,
+			}
+		}
+	}
+	return chat
+}
+
+func (r *memoryChats) Create(chat *models.Chat) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.chats[chat.ID]; ok {
+		return ErrDuplicate
+	}
+	if _, ok := r.users[chat.CustomerID]; !ok {
+		return sql.ErrNoRows
+	}
+
+	chat.CreatedAt = now()
+	chat.UpdatedAt = chat.CreatedAt
+	r.chats[chat.ID] = &memoryChat{chat: models.Chat{
+		ID:         chat.ID,
+		CustomerID: chat.CustomerID,
+		AgentID:    chat.AgentID,
+		Topic:      chat.Topic,
+		Priority:   chat.Priority,
+		Status:     chat.Status,
+		CreatedAt:  chat.CreatedAt,
+		UpdatedAt:  chat.UpdatedAt,
+	}}
+	r.history = append(r.history, models.ChatStatusChange{
+		ID:        uuid.New().String(),
+		ChatID:    chat.ID,
+		ToStatus:  chat.Status,
+		CreatedAt: chat.CreatedAt,
+	})
+	return nil
+}
+
+func (r *memoryChats) Delete(id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.chats, id)
+	for messageID, message := range r.messages {
+		if message.ChatID == id {
+			delete(r.messages, messageID)
+			delete(r.messageUpdated, messageID)
+			delete(r.deletedBy, messageID)
+		}
+	}
+	keptMessage := func(messageID string) bool {
+		_, ok := r.messages[messageID]
+		return ok
+	}
+	r.edits = filter(r.edits, func(edit models.MessageEdit) bool { return keptMessage(edit.MessageID) })
+	r.mentions = filter(r.mentions, func(mention memoryMention) bool { return keptMessage(mention.messageID) })
+	r.reactions = filter(r.reactions, func(reaction memoryReaction) bool { return keptMessage(reaction.messageID) })
+	for key := range r.reads {
+		if key.chatID == id {
+			delete(r.reads, key)
+		}
+	}
+	for attachmentID, attachment := range r.attachments {
+		if attachment.ChatID == id {
+			delete(r.attachments, attachmentID)
+			delete(r.scans, attachmentID)
+		}
+	}
+	delete(r.ratings, id)
+	r.history = filter(r.history, func(change models.ChatStatusChange) bool { return change.ChatID != id })
+	r.escalations = filter(r.escalations, func(e *models.Escalation) bool { return e.ChatID != id })
+	r.chatTags = filter(r.chatTags, func(chatTag memoryChatTag) bool { return chatTag.chatID != id })
+	r.cannedUses = filter(r.cannedUses, func(use memoryCannedUse) bool { return use.chatID != id })
+	r.slaEvents = filter(r.slaEvents, func(event *models.SLAEvent) bool { return event.ChatID != id })
+	return nil
+}
+
+// filter returns the elements of s for which keep is true, reusing s.
+func filter[T any](s []T, keep func(T) bool) []T {
+	kept := s[:0]
+	for _, v := range s {
+		if keep(v) {
+			kept = append(kept, v)
+		}
+	}
+	return kept
+}
+
+func (r *memoryChats) Assistants(chatID string) ([]string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if chat, ok := r.chats[chatID]; ok {
+		return append([]string(nil), chat.assistants...), nil
+	}
+	return nil, nil
+}
+
+func (r *memoryChats) MarkRead(chatID, userID string, seq int64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key := readKey{chatID, userID}
+	if seq > r.reads[key] {
+		r.reads[key] = seq
+	}
+	return nil
+}
+
+func (r *memoryChats) ChangeStatus(change StatusChange) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored, ok := r.chats[change.ChatID]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	chat := &stored.chat
+	if change.AssignTo != nil && chat.AgentID != nil {
+		return ErrAssigned
+	}
+	if chat.Status != change.From {
+		return ErrStale
+	}
+
+	at := now()
+	if change.AssignTo != nil {
+		agentID := *change.AssignTo
+		chat.AgentID = &agentID
+	}
+	chat.Status = change.To
+	switch change.To {
+	case models.ChatStatusQueued, models.ChatStatusActive, models.ChatStatusPendingCustomer:
+		chat.ResolutionCode, chat.WrapUpNotes = nil, nil
+		chat.ResolvedAt, chat.ClosedAt = nil, nil
+		if change.To == models.ChatStatusQueued {
+			chat.AgentID = nil
+		}
+	default:
+		if change.ResolutionCode != nil {
+			chat.ResolutionCode = change.ResolutionCode
+		}
+		if change.Note != nil {
+			chat.WrapUpNotes = change.Note
+		}
+		if change.To == models.ChatStatusResolved {
+			chat.ResolvedAt = &at
+		}
+		if change.To == models.ChatStatusClosed {
+			chat.ClosedAt = &at
+		}
+	}
+	chat.UpdatedAt = at
+
+	from := change.From
+	r.history = append(r.history, models.ChatStatusChange{
+		ID:             uuid.New().String(),
+		ChatID:         change.ChatID,
+		FromStatus:     &from,
+		ToStatus:       change.To,
+		ChangedBy:      change.ChangedBy,
+		ResolutionCode: change.ResolutionCode,
+		Note:           change.Note,
+		CreatedAt:      at,
+	})
+	return nil
+}
+
+func (r *memoryChats) StatusHistory(chatID string) ([]models.ChatStatusChange, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	history := []models.ChatStatusChange{}
+	for _, change := range r.history {
+		if change.ChatID == chatID {
+			history = append(history, change)
+		}
+	}
+	return history, nil
+}
+
+// inScope reports whether a chat is in a scope.
+func inScope(scope ChatScope, chat *models.Chat) bool {
+	if scope.CustomerID != "" && chat.CustomerID != scope.CustomerID {
+		return false
+	}
+	if scope.AgentID != "" {
+		assigned := chat.AgentID != nil && *chat.AgentID == scope.AgentID
+		return assigned || (scope.WithUnassigned && chat.AgentID == nil)
+	}
+	return true
+}
+
+// priorityRanks rank priorities like priorityRank; other priorities rank 0.
+var priorityRanks = map[string]int{
+	models.PriorityUrgent: 3,
+	models.PriorityHigh:   2,
+	models.PriorityNormal: 1,
+}
+
+// listRank ranks a chat for the priority sort of listings: closed chats
+// below every open one.
+func listRank(chat *models.Chat) int {
+	if !models.IsOpenStatus(chat.Status) {
+		return -1
+	}
+	return priorityRanks[chat.Priority]
+}
+
+// sortChats orders chats like chatOrder.
+func sortChats(chats []models.Chat, f models.ChatFilter) {
+	sort.Slice(chats, func(i, j int) bool {
+		a, b := &chats[i], &chats[j]
+		switch f.Sort {
+		case "updated", "created":
+			ta, tb := a.UpdatedAt, b.UpdatedAt
+			if f.Sort == "created" {
+				ta, tb = a.CreatedAt, b.CreatedAt
+			}
+			if !ta.Equal(tb) {
+				return ta.Before(tb) == (f.Order == "asc")
+			}
+		default:
+			if ra, rb := listRank(a), listRank(b); ra != rb {
+				return (ra < rb) == (f.Order == "asc")
+			}
+			if !a.UpdatedAt.Equal(b.UpdatedAt) {
+				return a.UpdatedAt.Before(b.UpdatedAt) == (f.Order == "asc")
+			}
+		}
+		return a.ID < b.ID
+	})
+}
+
+// page returns the part of s that LIMIT limit OFFSET offset would select.
+func page[T any](s []T, offset, limit int) []T {
+	if offset >= len(s) {
+		return s[:0]
+	}
+	s = s[offset:]
+	if limit < len(s) {
+		s = s[:limit]
+	}
+	return s
+}
+
+func (r *memoryChats) List(listing ChatListing) ([]models.Chat, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	f := listing.Filter
+	chats := []models.Chat{}
+	for _, stored := range r.chats {
+		c := &stored.chat
+		if !inScope(listing.Scope, c) || (c.Status == models.ChatStatusArchived) != listing.Archived ||
+			(f.Status != "" && c.Status != f.Status) ||
+			(f.AgentID != "" && (c.AgentID == nil || *c.AgentID != f.AgentID)) ||
+			(f.Unassigned && c.AgentID != nil) {
+			continue
+		}
+
+		chat := r.chatView(stored)
+		if f.Tag != "" && !contains(chat.Tags, f.Tag) {
+			continue
+		}
+		// Read positions count in the numbering the reader sees
+		last := stored.lastPublicSeq
+		if listing.Staff {
+			last = stored.lastSeq
+		}
+		unread := last - r.reads[readKey{c.ID, listing.ReaderID}]
+		if f.HasUnread && unread <= 0 {
+			continue
+		}
+		chat.UnreadCount = &unread
+		chats = append(chats, chat)
+	}
+
+	sortChats(chats, f)
+	return page(chats, f.Offset, f.Limit), nil
+}
+
+func (r *memoryChats) Queue(limit int) ([]models.Chat, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	chats := []models.Chat{}
+	for _, stored := range r.chats {
+		if stored.chat.Status == models.ChatStatusQueued && stored.chat.AgentID == nil {
+			chats = append(chats, r.chatView(stored))
+		}
+	}
+	sort.Slice(chats, func(i, j int) bool {
+		a, b := &chats[i], &chats[j]
+		if ra, rb := priorityRanks[a.Priority], priorityRanks[b.Priority]; ra != rb {
+			return ra > rb
+		}
+		if !a.CreatedAt.Equal(b.CreatedAt) {
+			return a.CreatedAt.Before(b.CreatedAt)
+		}
+		return a.ID < b.ID
+	})
+
+	if limit > 0 && limit < len(chats) {
+		chats = chats[:limit]
+	}
+	return chats, nil
+}
+
+func (r *memoryChats) SetPriority(id, priority string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored, ok := r.chats[id]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	stored.chat.Priority = priority
+	stored.chat.UpdatedAt = now()
+	return nil
+}
+
+func (r *memoryChats) Now() (time.Time, error) {
+	return now(), nil
+}
+
+func (r *memoryChats) Changed(scope ChatScope, since time.Time, limit int) ([]models.Chat, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	chats := []models.Chat{}
+	for _, stored := range r.chats {
+		if inScope(scope, &stored.chat) && stored.chat.UpdatedAt.After(since) {
+			chats = append(chats, r.chatView(stored))
+		}
+	}
+	sort.Slice(chats, func(i, j int) bool {
+		if !chats[i].UpdatedAt.Equal(chats[j].UpdatedAt) {
+			return chats[i].UpdatedAt.Before(chats[j].UpdatedAt)
+		}
+		return chats[i].ID < chats[j].ID
+	})
+	return page(chats, 0, limit), nil
+}
+
+func (r *memoryChats) IdleChats(systemUserID string) ([]IdleChat, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var chats []IdleChat
+	for _, stored := range r.chats {
+		c := &stored.chat
+		if c.AgentID == nil || (c.Status != models.ChatStatusActive && c.Status != models.ChatStatusPendingCustomer) {
+			continue
+		}
+
+		chat := IdleChat{ID: c.ID, AgentID: *c.AgentID, Topic: c.Topic, NudgedAt: stored.idleNudgedAt}
+		for _, message := range r.messages {
+			if message.ChatID != c.ID {
+				continue
+			}
+			at := message.CreatedAt
+			switch {
+			case message.SenderID == c.CustomerID:
+				if chat.LastCustomerAt == nil || at.After(*chat.LastCustomerAt) {
+					chat.LastCustomerAt = &at
+				}
+			case message.SenderID != systemUserID && message.Visibility == models.VisibilityPublic:
+				if chat.LastStaffAt == nil || at.After(*chat.LastStaffAt) {
+					chat.LastStaffAt = &at
+				}
+			}
+		}
+		chats = append(chats, chat)
+	}
+	return chats, nil
+}
+
+func (r *memoryChats) SetIdleNudged(chatID string, at *time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if stored, ok := r.chats[chatID]; ok {
+		stored.idleNudgedAt = nil
+		if at != nil {
+			nudgedAt := *at
+			stored.idleNudgedAt = &nudgedAt
+		}
+	}
+	return nil
+}
+
+type memoryMessages struct {
+	*memory
+}
+
+func (r *memoryMessages) Get(id string) (*models.Message, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	message, ok := r.messages[id]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	return r.view(message), nil
+}
+
+// view returns a message as MessageSelect would. The lock must be held.
+func (r *memoryMessages) view(stored *models.Message) *models.Message {
+	message := *stored
+	if message.DeletedAt != nil {
+		message.Content = ""
+		message.Metadata = nil
+	}
+	if sender, ok := r.users[message.SenderID]; ok {
+		message.Sender = &models.User{
+			ID: sender.ID, Username: sender.Username, Name: sender.Name, Role: sender.Role,
+			Avatar: sender.Avatar, IsOnline: sender.IsOnline,
+		}
+	} else {
+		message.Sender = &models.User{}
+	}
+
+	if message.ReplyToID != nil {
+		if quoted, ok := r.messages[*message.ReplyToID]; ok {
+			quotedView := *quoted
+			if quotedView.DeletedAt != nil {
+				quotedView.Content = ""
+			}
+			quotedView.Sender = nil
+			if sender, ok := r.users[quoted.SenderID]; ok {
+				quotedView.Sender = &models.User{Name: sender.Name}
+			}
+			message.ReplyTo = Quote(&quotedView)
+		} else {
+			message.ReplyToID = nil
+		}
+	}
+	return &message
+}
+
+func (r *memoryMessages) Insert(m NewMessage) (*models.Message, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.insert(m)
+}
+
+// insert stores a message. The lock must be held.
+func (r *memoryMessages) insert(m NewMessage) (*models.Message, error) {
+	chat, ok := r.chats[m.ChatID]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+
+	message := &models.Message{
+		ID:          uuid.New().String(),
+		ChatID:      m.ChatID,
+		SenderID:    m.SenderID,
+		Content:     m.Content,
+		MessageType: m.MessageType,
+		Visibility:  m.Visibility,
+		Metadata:    m.Metadata,
+		ReplyToID:   m.ReplyToID,
+		CreatedAt:   now(),
+	}
+
+	chat.lastSeq++
+	message.Seq = chat.lastSeq
+	if m.Visibility == models.VisibilityPublic {
+		chat.lastPublicSeq++
+		publicSeq := chat.lastPublicSeq
+		message.PublicSeq = &publicSeq
+		chat.chat.UpdatedAt = message.CreatedAt
+	}
+	r.messages[message.ID] = message
+	r.messageUpdated[message.ID] = message.CreatedAt
+
+	// Senders have read their own messages
+	if sender, ok := r.users[m.SenderID]; ok {
+		read := message.Seq
+		if sender.Role == "customer" {
+			read = 0
+			if message.PublicSeq != nil {
+				read = *message.PublicSeq
+			}
+		}
+		key := readKey{m.ChatID, m.SenderID}
+		if read > r.reads[key] {
+			r.reads[key] = read
+		}
+	}
+
+	inserted := *message
+	inserted.ReplyToID = nil
+	return &inserted, nil
+}
+
+func (r *memoryMessages) Page(chatID string, includeStaff bool, before, after string, limit int) ([]models.Message, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var chatMessages []*models.Message
+	for _, message := range r.messages {
+		if message.ChatID == chatID && (includeStaff || message.Visibility == models.VisibilityPublic) {
+			chatMessages = append(chatMessages, message)
+		}
+	}
+	sort.Slice(chatMessages, func(i, j int) bool { return chatMessages[i].Seq < chatMessages[j].Seq })
+
+	var page []*models.Message
+	switch {
+	case after != "":
+		cursor := r.messages[after]
+		for _, message := range chatMessages {
+			if cursor != nil && message.Seq > cursor.Seq && len(page) < limit {
+				page = append(page, message)
+			}
+		}
+	default:
+		for _, message := range chatMessages {
+			if before == "" || (r.messages[before] != nil && message.Seq < r.messages[before].Seq) {
+				page = append(page, message)
+			}
+		}
+		if len(page) > limit {
+			page = page[len(page)-limit:]
+		}
+	}
+
+	messages := make([]models.Message, len(page))
+	for i, message := range page {
+		messages[i] = *r.view(message)
+	}
+	return messages, nil
+}
+
+func (r *memoryMessages) Latest(chatIDs []string, includeStaff bool) (map[string]*models.Message, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	wanted := make(map[string]bool, len(chatIDs))
+	for _, id := range chatIDs {
+		wanted[id] = true
+	}
+
+	newest := make(map[string]*models.Message)
+	for _, message := range r.messages {
+		if !wanted[message.ChatID] || !(includeStaff || message.Visibility == models.VisibilityPublic) {
+			continue
+		}
+		if current, ok := newest[message.ChatID]; !ok || message.Seq > current.Seq {
+			newest[message.ChatID] = message
+		}
+	}
+
+	latest := make(map[string]*models.Message, len(newest))
+	for chatID, message := range newest {
+		latest[chatID] = r.view(message)
+	}
+	return latest, nil
+}
+
+func (r *memoryMessages) Touch(id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.messages[id]; ok {
+		r.messageUpdated[id] = now()
+	}
+	return nil
+}
+
+func (r *memoryMessages) AddReaction(messageID, userID, emoji string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	reaction := memoryReaction{messageID, userID, emoji}
+	for _, existing := range r.reactions {
+		if existing == reaction {
+			return nil
+		}
+	}
+	r.reactions = append(r.reactions, reaction)
+	return nil
+}
+
+func (r *memoryMessages) RemoveReaction(messageID, userID, emoji string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	reaction := memoryReaction{messageID, userID, emoji}
+	for i, existing := range r.reactions {
+		if existing == reaction {
+			r.reactions = append(r.reactions[:i], r.reactions[i+1:]...)
+			return nil
+		}
+	}
+	return nil
+}
+
+func (r *memoryMessages) Reactions(messageIDs []string) (map[string][]models.Reaction, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	wanted := make(map[string]bool, len(messageIDs))
+	for _, id := range messageIDs {
+		wanted[id] = true
+	}
+
+	// Reactions are kept in the order they were made
+	reactions := make(map[string][]models.Reaction)
+	for _, reaction := range r.reactions {
+		if !wanted[reaction.messageID] {
+			continue
+		}
+		list := reactions[reaction.messageID]
+		i := 0
+		for i < len(list) && list[i].Emoji != reaction.emoji {
+			i++
+		}
+		if i == len(list) {
+			list = append(list, models.Reaction{Emoji: reaction.emoji})
+		}
+		list[i].Count++
+		list[i].UserIDs = append(list[i].UserIDs, reaction.userID)
+		reactions[reaction.messageID] = list
+	}
+	return reactions, nil
+}
+
+func (r *memoryMessages) Attachments(messageIDs []string) ([]*models.Attachment, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	wanted := make(map[string]bool, len(messageIDs))
+	for _, id := range messageIDs {
+		wanted[id] = true
+	}
+
+	var attachments []*models.Attachment
+	for _, id := range r.attachmentOrder {
+		if attachment, ok := r.attachments[id]; ok && attachment.MessageID != nil && wanted[*attachment.MessageID] {
+			attachments = append(attachments, r.viewAttachment(attachment))
+		}
+	}
+	return attachments, nil
+}
+
+// viewAttachment returns an attachment without thumbnails, as
+// AttachmentColumns would.
+func (r *memoryMessages) viewAttachment(stored *models.Attachment) *models.Attachment {
+	attachment := *stored
+	attachment.Thumbnails = nil
+	return &attachment
+}
+
+func (r *memoryMessages) Thumbnails(attachmentIDs []string) (map[string][]models.Thumbnail, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	thumbnails := make(map[string][]models.Thumbnail)
+	for _, id := range attachmentIDs {
+		attachment, ok := r.attachments[id]
+		if !ok || len(attachment.Thumbnails) == 0 {
+			continue
+		}
+		list := append([]models.Thumbnail(nil), attachment.Thumbnails...)
+		sort.SliceStable(list, func(i, j int) bool { return list[i].Width*list[i].Height < list[j].Width*list[j].Height })
+		thumbnails[id] = list
+	}
+	return thumbnails, nil
+}
+
+func (r *memoryMessages) GetAttachment(id string) (*models.Attachment, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	attachment, ok := r.attachments[id]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	if attachment.MessageID != nil {
+		if message, ok := r.messages[*attachment.MessageID]; ok && message.DeletedAt != nil {
+			return nil, sql.ErrNoRows
+		}
+	}
+	return r.viewAttachment(attachment), nil
+}
+
+func (r *memoryMessages) CreateAttachment(attachment *models.Attachment) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.attachments[attachment.ID]; ok {
+		return ErrDuplicate
+	}
+	if _, ok := r.chats[attachment.ChatID]; !ok {
+		return sql.ErrNoRows
+	}
+
+	attachment.CreatedAt = now()
+	stored := *attachment
+	stored.MessageID = nil
+	stored.Thumbnails = append([]models.Thumbnail(nil), attachment.Thumbnails...)
+	r.attachments[attachment.ID] = &stored
+	r.attachmentOrder = append(r.attachmentOrder, attachment.ID)
+	return nil
+}
+
+func (r *memoryMessages) DeleteAttachment(id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.attachments, id)
+	return nil
+}
+
+func (r *memoryMessages) LinkAttachments(messageID string, attachmentIDs []string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, id := range attachmentIDs {
+		if attachment, ok := r.attachments[id]; ok {
+			linked := messageID
+			attachment.MessageID = &linked
+		}
+	}
+	return nil
+}
+
+// editable returns a message that is not deleted and was sent within
+// window, or sql.ErrNoRows. The lock must be held.
+func (r *memoryMessages) editable(id string, window time.Duration) (*models.Message, error) {
+	message, ok := r.messages[id]
+	if !ok || message.DeletedAt != nil || (window > 0 && message.CreatedAt.Before(now().Add(-window))) {
+		return nil, sql.ErrNoRows
+	}
+	return message, nil
+}
+
+func (r *memoryMessages) Edit(id, editorID, content string, window time.Duration) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	message, err := r.editable(id, window)
+	if err != nil {
+		return err
+	}
+
+	at := now()
+	editedBy := editorID
+	r.edits = append(r.edits, models.MessageEdit{
+		ID:        uuid.New().String(),
+		MessageID: id,
+		Content:   message.Content,
+		EditedBy:  &editedBy,
+		EditedAt:  at,
+	})
+	message.Content = content
+	message.EditedAt = &at
+	r.messageUpdated[id] = at
+	return nil
+}
+
+func (r *memoryMessages) Delete(id, userID string, window time.Duration) (time.Time, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	message, err := r.editable(id, window)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	at := now()
+	message.DeletedAt = &at
+	r.deletedBy[id] = userID
+	r.messageUpdated[id] = at
+	return at, nil
+}
+
+func (r *memoryMessages) History(id string) (*models.MessageHistory, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored, ok := r.messages[id]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+
+	history := models.MessageHistory{Message: *r.view(stored), Edits: []models.MessageEdit{}}
+	history.Message.Content = stored.Content
+	if deletedBy, ok := r.deletedBy[id]; ok {
+		history.DeletedBy = &deletedBy
+	}
+	for _, edit := range r.edits {
+		if edit.MessageID == id {
+			history.Edits = append(history.Edits, edit)
+		}
+	}
+	return &history, nil
+}
+
+func (r *memoryMessages) InsertNote(m NewMessage, usernames []string) (*models.Message, []string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	message, err := r.insert(m)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var userIDs []string
+	for _, user := range r.users {
+		if contains(usernames, user.Username) && isStaffRole(user.Role) && user.ID != m.SenderID {
+			userIDs = append(userIDs, user.ID)
+		}
+	}
+	sort.Strings(userIDs)
+
+	for _, userID := range userIDs {
+		r.mentions = append(r.mentions, memoryMention{messageID: message.ID, userID: userID, createdAt: message.CreatedAt})
+	}
+	return message, userIDs, nil
+}
+
+func (r *memoryMessages) Mentions(userID string, limit int) ([]models.Mention, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	mentions := []models.Mention{}
+	for i := len(r.mentions) - 1; i >= 0 && len(mentions) < limit; i-- {
+		mention := r.mentions[i]
+		if mention.userID != userID {
+			continue
+		}
+		message := r.view(r.messages[mention.messageID])
+		mentions = append(mentions, models.Mention{
+			ChatID:    message.ChatID,
+			Message:   *message,
+			CreatedAt: message.CreatedAt,
+		})
+	}
+	return mentions, nil
+}
+
+// searchTerms splits a search query into the words a message must contain
+// and those it must not, which have a leading "-". Quotes are ignored.
+func searchTerms(query string) (include, exclude []string) {
+	for _, field := range strings.Fields(strings.ToLower(query)) {
+		excluded := strings.HasPrefix(field, "-")
+		for _, word := range searchWords(field) {
+			if excluded {
+				exclude = append(exclude, word)
+			} else {
+				include = append(include, word)
+			}
+		}
+	}
+	return include, exclude
+}
+
+// searchWords returns the words of text, in lower case.
+func searchWords(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
+
+// headlineEscaper escapes content like the search query does before
+// ts_headline marks the matches.
+var headlineEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+// searchHeadline returns the escaped content with the words in include
+// wrapped in <mark>, and how many words matched.
+func searchHeadline(content string, include []string) (string, int) {
+	var headline strings.Builder
+	matches := 0
+	word := func(r rune) bool { return unicode.IsLetter(r) || unicode.IsNumber(r) }
+
+	runes := []rune(content)
+	for i := 0; i < len(runes); {
+		j := i + 1
+		for j < len(runes) && word(runes[j]) == word(runes[i]) {
+			j++
+		}
+		part := string(runes[i:j])
+		if word(runes[i]) && contains(include, strings.ToLower(part)) {
+			matches++
+			headline.WriteString("<mark>" + headlineEscaper.Replace(part) + "</mark>")
+		} else {
+			headline.WriteString(headlineEscaper.Replace(part))
+		}
+		i = j
+	}
+	return headline.String(), matches
+}
+
+func (r *memoryMessages) Search(scope ChatScope, f models.MessageSearchFilter, includeStaff bool) ([]models.MessageSearchResult, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	include, exclude := searchTerms(f.Query)
+	results := []models.MessageSearchResult{}
+	for _, message := range r.messages {
+		stored, ok := r.chats[message.ChatID]
+		if !ok {
+			continue
+		}
+		c := &stored.chat
+		if !inScope(scope, c) || message.DeletedAt != nil ||
+			(!includeStaff && message.Visibility != models.VisibilityPublic) ||
+			(f.From != nil && message.CreatedAt.Before(*f.From)) || (f.To != nil && !message.CreatedAt.Before(*f.To)) ||
+			(f.AgentID != "" && (c.AgentID == nil || *c.AgentID != f.AgentID)) ||
+			(f.CustomerID != "" && c.CustomerID != f.CustomerID) || (f.Status != "" && c.Status != f.Status) {
+			continue
+		}
+
+		words := searchWords(message.Content)
+		found := len(include) > 0
+		for _, term := range include {
+			found = found && contains(words, term)
+		}
+		for _, term := range exclude {
+			found = found && !contains(words, term)
+		}
+		if !found {
+			continue
+		}
+
+		headline, matches := searchHeadline(message.Content, include)
+		result := models.MessageSearchResult{
+			MessageID:   message.ID,
+			ChatID:      message.ChatID,
+			SenderID:    message.SenderID,
+			MessageType: message.MessageType,
+			Visibility:  message.Visibility,
+			Headline:    headline,
+			Rank:        float64(matches) / float64(len(words)),
+			CreatedAt:   message.CreatedAt,
+			Chat: models.SearchChatContext{
+				ID:         c.ID,
+				Status:     c.Status,
+				Topic:      c.Topic,
+				CustomerID: c.CustomerID,
+				AgentID:    c.AgentID,
+				CreatedAt:  c.CreatedAt,
+			},
+		}
+		if sender, ok := r.users[message.SenderID]; ok {
+			result.SenderName, result.SenderRole = sender.Name, sender.Role
+		}
+		if customer, ok := r.users[c.CustomerID]; ok {
+			result.Chat.CustomerName = customer.Name
+		}
+		if c.AgentID != nil {
+			if agent, ok := r.users[*c.AgentID]; ok {
+				name := agent.Name
+				result.Chat.AgentName = &name
+			}
+		}
+		results = append(results, result)
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Rank != results[j].Rank {
+			return results[i].Rank > results[j].Rank
+		}
+		return results[i].CreatedAt.After(results[j].CreatedAt)
+	})
+	return page(results, f.Offset, f.Limit), nil
+}
+
+func (r *memoryMessages) Changed(scope ChatScope, since time.Time, includeStaff bool, limit int) ([]models.Message, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var changed []*models.Message
+	for id, message := range r.messages {
+		stored, ok := r.chats[message.ChatID]
+		if ok && inScope(scope, &stored.chat) && r.messageUpdated[id].After(since) &&
+			(includeStaff || message.Visibility == models.VisibilityPublic) {
+			changed = append(changed, message)
+		}
+	}
+	sort.Slice(changed, func(i, j int) bool {
+		ti, tj := r.messageUpdated[changed[i].ID], r.messageUpdated[changed[j].ID]
+		if !ti.Equal(tj) {
+			return ti.Before(tj)
+		}
+		return changed[i].ID < changed[j].ID
+	})
+
+	changed = page(changed, 0, limit)
+	messages := make([]models.Message, len(changed))
+	for i, message := range changed {
+		messages[i] = *r.view(message)
+	}
+	return messages, nil
+}
+
+func (r *memoryMessages) ClaimScans(retryAfter time.Duration, limit int) ([]ScanClaim, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	at := now()
+	var claims []ScanClaim
+	for _, id := range r.attachmentOrder {
+		attachment, ok := r.attachments[id]
+		if !ok || attachment.ScanStatus != models.ScanPending || attachment.MessageID == nil || len(claims) == limit {
+			continue
+		}
+		scan := r.scans[id]
+		if scan == nil {
+			scan = &memoryScan{}
+			r.scans[id] = scan
+		}
+		if scan.attemptedAt != nil && scan.attemptedAt.After(at.Add(-retryAfter)) {
+			continue
+		}
+
+		attemptedAt := at
+		scan.attempts++
+		scan.attemptedAt = &attemptedAt
+		claims = append(claims, ScanClaim{AttachmentID: id, StorageKey: attachment.StorageKey, Attempts: scan.attempts})
+	}
+	return claims, nil
+}
+
+func (r *memoryMessages) RecordScan(attachmentID, status string, signature *string) (*models.Attachment, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	attachment, ok := r.attachments[attachmentID]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+
+	at := now()
+	attachment.ScanStatus = status
+	attachment.ScannedAt = &at
+	scan := r.scans[attachmentID]
+	if scan == nil {
+		scan = &memoryScan{}
+		r.scans[attachmentID] = scan
+	}
+	scan.signature = signature
+	return r.viewAttachment(attachment), nil
+}
+
+type memoryEscalations struct {
+	*memory
+}
+
+func (r *memoryEscalations) Get(id string) (*models.Escalation, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	escalation, err := r.find(id)
+	if err != nil {
+		return nil, err
+	}
+	copied := *escalation
+	return &copied, nil
+}
+
+// find returns the stored escalation with the given ID. The lock must be
+// held.
+func (r *memoryEscalations) find(id string) (*models.Escalation, error) {
+	for _, escalation := range r.escalations {
+		if escalation.ID == id {
+			return escalation, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (r *memoryEscalations) Create(chatID, requestedBy, reason string) (*models.Escalation, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	chat, ok := r.chats[chatID]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	for _, existing := range r.escalations {
+		if existing.ChatID == chatID && (existing.Status == models.EscalationOpen || existing.Status == models.EscalationClaimed) {
+			return nil, ErrDuplicate
+		}
+	}
+
+	escalation := &models.Escalation{
+		ID:          uuid.New().String(),
+		ChatID:      chatID,
+		RequestedBy: requestedBy,
+		Reason:      reason,
+		Status:      models.EscalationOpen,
+		CreatedAt:   now(),
+	}
+	r.escalations = append(r.escalations, escalation)
+	chat.chat.IsEscalated = true
+	chat.chat.UpdatedAt = escalation.CreatedAt
+
+	copied := *escalation
+	return &copied, nil
+}
+
+func (r *memoryEscalations) Claim(id, userID string) (*models.Escalation, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	escalation, err := r.find(id)
+	if err != nil {
+		return nil, err
+	}
+	if escalation.Status != models.EscalationOpen {
+		return nil, sql.ErrNoRows
+	}
+
+	at := now()
+	claimedBy := userID
+	escalation.Status = models.EscalationClaimed
+	escalation.ClaimedBy = &claimedBy
+	escalation.ClaimedAt = &at
+
+	if chat, ok := r.chats[escalation.ChatID]; ok {
+		if !contains(chat.assistants, userID) {
+			chat.assistants = append(chat.assistants, userID)
+		}
+		chat.chat.UpdatedAt = at
+	}
+
+	copied := *escalation
+	return &copied, nil
+}
+
+func (r *memoryEscalations) Resolve(id, from, to, outcome string) (*models.Escalation, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	escalation, err := r.find(id)
+	if err != nil {
+		return nil, err
+	}
+	if escalation.Status != from {
+		return nil, sql.ErrNoRows
+	}
+
+	at := now()
+	escalation.Status = to
+	escalation.Outcome = &outcome
+	escalation.ResolvedAt = &at
+
+	if chat, ok := r.chats[escalation.ChatID]; ok {
+		chat.chat.IsEscalated = false
+		chat.chat.UpdatedAt = at
+		if escalation.ClaimedBy != nil {
+			chat.assistants = filter(chat.assistants, func(id string) bool { return id != *escalation.ClaimedBy })
+		}
+	}
+
+	copied := *escalation
+	return &copied, nil
+}
+
+func (r *memoryEscalations) List(f EscalationFilter) ([]models.Escalation, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	escalations := []models.Escalation{}
+	for i := len(r.escalations) - 1; i >= 0; i-- {
+		e := r.escalations[i]
+		if (f.Status == "" || e.Status == f.Status) && (f.ChatID == "" || e.ChatID == f.ChatID) &&
+			(f.RequestedBy == "" || e.RequestedBy == f.RequestedBy) {
+			escalations = append(escalations, *e)
+		}
+	}
+	return escalations, nil
+}
+
+func contains(ids []string, id string) bool {
+	for _, existing := range ids {
+		if existing == id {
+			return true
+		}
+	}
+	return false
+}
+
+type memoryTags struct {
+	*memory
+}
+
+func (r *memoryTags) List() ([]models.Tag, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	tags := []models.Tag{}
+	for _, tag := range r.tags {
+		tags = append(tags, *tag)
+	}
+	sort.Slice(tags, func(i, j int) bool { return tags[i].Name < tags[j].Name })
+	return tags, nil
+}
+
+func (r *memoryTags) Create(tag *models.Tag) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.nameTaken(tag.Name, "") {
+		return ErrDuplicate
+	}
+	tag.ID = uuid.New().String()
+	tag.CreatedAt = now()
+	tag.UpdatedAt = tag.CreatedAt
+	stored := *tag
+	r.tags[tag.ID] = &stored
+	return nil
+}
+
+func (r *memoryTags) Update(tag *models.Tag) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored, ok := r.tags[tag.ID]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	if r.nameTaken(tag.Name, tag.ID) {
+		return ErrDuplicate
+	}
+	stored.Name, stored.Description, stored.Color = tag.Name, tag.Description, tag.Color
+	stored.UpdatedAt = now()
+	*tag = *stored
+	return nil
+}
+
+// nameTaken reports whether a tag other than exceptID has the name. The lock
+// must be held.
+func (r *memoryTags) nameTaken(name, exceptID string) bool {
+	for id, tag := range r.tags {
+		if tag.Name == name && id != exceptID {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *memoryTags) Delete(id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.tags[id]; !ok {
+		return sql.ErrNoRows
+	}
+	delete(r.tags, id)
+	r.chatTags = filter(r.chatTags, func(chatTag memoryChatTag) bool { return chatTag.tagID != id })
+	return nil
+}
+
+func (r *memoryTags) AddToChat(chatID, tagID, userID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.tags[tagID]; !ok {
+		return sql.ErrNoRows
+	}
+	chat, ok := r.chats[chatID]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	for _, chatTag := range r.chatTags {
+		if chatTag.chatID == chatID && chatTag.tagID == tagID {
+			return nil
+		}
+	}
+
+	at := now()
+	r.chatTags = append(r.chatTags, memoryChatTag{chatID: chatID, tagID: tagID, addedAt: at})
+	chat.chat.UpdatedAt = at
+	return nil
+}
+
+func (r *memoryTags) RemoveFromChat(chatID, tagID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	before := len(r.chatTags)
+	r.chatTags = filter(r.chatTags, func(chatTag memoryChatTag) bool {
+		return chatTag.chatID != chatID || chatTag.tagID != tagID
+	})
+	if chat, ok := r.chats[chatID]; ok && len(r.chatTags) < before {
+		chat.chat.UpdatedAt = now()
+	}
+	return nil
+}
+
+func (r *memoryTags) Stats(f models.TagStatsFilter) ([]models.TagCount, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	type key struct {
+		period time.Time
+		tagID  string
+	}
+	counts := make(map[key]int)
+	for _, chatTag := range r.chatTags {
+		tag := r.tags[chatTag.tagID]
+		if (f.Tag != "" && tag.Name != f.Tag) || (f.From != nil && chatTag.addedAt.Before(*f.From)) ||
+			(f.To != nil && !chatTag.addedAt.Before(*f.To)) {
+			continue
+		}
+		counts[key{truncate(chatTag.addedAt, f.Interval), tag.ID}]++
+	}
+
+	stats := []models.TagCount{}
+	for k, count := range counts {
+		stats = append(stats, models.TagCount{Period: k.period, TagID: k.tagID, Tag: r.tags[k.tagID].Name, Count: count})
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		if !stats[i].Period.Equal(stats[j].Period) {
+			return stats[i].Period.Before(stats[j].Period)
+		}
+		return stats[i].Tag < stats[j].Tag
+	})
+	return stats, nil
+}
+
+// truncate rounds t down to the start of its day, ISO week or month in UTC,
+// like date_trunc.
+func truncate(t time.Time, interval string) time.Time {
+	t = t.UTC()
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	switch interval {
+	case "week":
+		return day.AddDate(0, 0, -(int(day.Weekday())+6)%7)
+	case "month":
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+	}
+	return day
+}
+
+type memoryCSAT struct {
+	*memory
+}
+
+func (r *memoryCSAT) Rated(chatID string) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	_, ok := r.ratings[chatID]
+	return ok, nil
+}
+
+func (r *memoryCSAT) Submit(chatID string, score int, comment *string) (*models.CSATRating, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored, ok := r.chats[chatID]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+
+	at := now()
+	rating, ok := r.ratings[chatID]
+	if !ok {
+		rating = &models.CSATRating{
+			ID:         uuid.New().String(),
+			ChatID:     chatID,
+			CustomerID: stored.chat.CustomerID,
+			AgentID:    stored.chat.AgentID,
+			CreatedAt:  at,
+		}
+		if stored.chat.AgentID != nil {
+			if agent, ok := r.users[*stored.chat.AgentID]; ok {
+				rating.Team = agent.Team
+			}
+		}
+		r.ratings[chatID] = rating
+	}
+	rating.Score = score
+	rating.Comment = comment
+	rating.UpdatedAt = at
+
+	copied := *rating
+	return &copied, nil
+}
+
+func (r *memoryCSAT) Summary(f models.CSATFilter) ([]models.CSATSummary, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	groups := make(map[string]*models.CSATSummary)
+	var keys []string
+	satisfied := make(map[string]int)
+	for _, rating := range r.ratings {
+		if (f.AgentID != "" && (rating.AgentID == nil || *rating.AgentID != f.AgentID)) ||
+			(f.Team != "" && (rating.Team == nil || *rating.Team != f.Team)) ||
+			(f.From != nil && rating.CreatedAt.Before(*f.From)) || (f.To != nil && !rating.CreatedAt.Before(*f.To)) {
+			continue
+		}
+
+		var key, label string
+		switch f.GroupBy {
+		case "agent":
+			if rating.AgentID != nil {
+				key = *rating.AgentID
+				if agent, ok := r.users[key]; ok {
+					label = agent.Name
+				}
+			}
+		case "team":
+			if rating.Team != nil {
+				key, label = *rating.Team, *rating.Team
+			}
+		}
+
+		summary, ok := groups[key]
+		if !ok {
+			summary = &models.CSATSummary{Key: key, Label: label}
+			groups[key] = summary
+			keys = append(keys, key)
+		}
+		summary.Responses++
+		summary.Average += float64(rating.Score)
+		summary.Distribution[rating.Score-1]++
+		if rating.Score >= 4 {
+			satisfied[key]++
+		}
+	}
+
+	summaries := []models.CSATSummary{}
+	for _, key := range keys {
+		summary := groups[key]
+		summary.Average /= float64(summary.Responses)
+		summary.Satisfaction = 100 * float64(satisfied[key]) / float64(summary.Responses)
+		summaries = append(summaries, *summary)
+	}
+	sort.SliceStable(summaries, func(i, j int) bool { return summaries[i].Average > summaries[j].Average })
+	return summaries, nil
+}
+
+type memoryCanned struct {
+	*memory
+}
+
+func (r *memoryCanned) List(ownerID, team string, allTeams bool, query string) ([]models.CannedResponse, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	query = strings.ToLower(query)
+	responses := []models.CannedResponse{}
+	for _, response := range r.canned {
+		shared := response.Scope == models.CannedScopeTeam && (allTeams || (response.Team != nil && *response.Team == team))
+		own := response.Scope == models.CannedScopePersonal && response.OwnerID == ownerID
+		if (own || shared) && (strings.Contains(strings.ToLower(response.Shortcut), query) ||
+			strings.Contains(strings.ToLower(response.Title), query)) {
+			responses = append(responses, *response)
+		}
+	}
+	sort.Slice(responses, func(i, j int) bool {
+		if responses[i].Shortcut != responses[j].Shortcut {
+			return responses[i].Shortcut < responses[j].Shortcut
+		}
+		return responses[i].Scope < responses[j].Scope
+	})
+	return responses, nil
+}
+
+func (r *memoryCanned) Get(id string) (*models.CannedResponse, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	response, ok := r.canned[id]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	copied := *response
+	return &copied, nil
+}
+
+// shortcutTaken reports whether a response other than response itself uses
+// its shortcut in the same personal or team library. The lock must be held.
+func (r *memoryCanned) shortcutTaken(response *models.CannedResponse) bool {
+	for id, existing := range r.canned {
+		if id == response.ID || existing.Shortcut != response.Shortcut || existing.Scope != response.Scope {
+			continue
+		}
+		if response.Scope == models.CannedScopePersonal && existing.OwnerID == response.OwnerID {
+			return true
+		}
+		if response.Scope == models.CannedScopeTeam && existing.Team != nil && response.Team != nil && *existing.Team == *response.Team {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *memoryCanned) Create(response *models.CannedResponse) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	response.ID = uuid.New().String()
+	if r.shortcutTaken(response) {
+		return ErrDuplicate
+	}
+	response.CreatedAt = now()
+	response.UpdatedAt = response.CreatedAt
+	stored := *response
+	r.canned[response.ID] = &stored
+	return nil
+}
+
+func (r *memoryCanned) Update(response *models.CannedResponse) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored, ok := r.canned[response.ID]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	updated := *stored
+	updated.Scope, updated.Team = response.Scope, response.Team
+	updated.Shortcut, updated.Title, updated.Content = response.Shortcut, response.Title, response.Content
+	if r.shortcutTaken(&updated) {
+		return ErrDuplicate
+	}
+	updated.UpdatedAt = now()
+	*stored = updated
+	*response = updated
+	return nil
+}
+
+func (r *memoryCanned) Delete(id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.canned, id)
+	r.cannedUses = filter(r.cannedUses, func(use memoryCannedUse) bool { return use.responseID != id })
+	return nil
+}
+
+func (r *memoryCanned) RecordUse(responseID, chatID, agentID, messageID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.canned[responseID]; !ok {
+		return sql.ErrNoRows
+	}
+	r.cannedUses = append(r.cannedUses, memoryCannedUse{
+		responseID: responseID,
+		chatID:     chatID,
+		agentID:    agentID,
+		messageID:  messageID,
+		usedAt:     now(),
+	})
+	return nil
+}
+
+func (r *memoryCanned) Stats(f models.CannedStatsFilter) ([]models.CannedResponseStats, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stats := []models.CannedResponseStats{}
+	for _, response := range r.canned {
+		owner, ok := r.users[response.OwnerID]
+		if !ok || (f.Team != "" && (owner.Team == nil || *owner.Team != f.Team)) {
+			continue
+		}
+
+		st := models.CannedResponseStats{
+			ID: response.ID, Shortcut: response.Shortcut, Title: response.Title,
+			Scope: response.Scope, Team: response.Team,
+		}
+		chats := make(map[string]bool)
+		agents := make(map[string]bool)
+		for _, use := range r.cannedUses {
+			if use.responseID != response.ID || (f.From != nil && use.usedAt.Before(*f.From)) ||
+				(f.To != nil && !use.usedAt.Before(*f.To)) {
+				continue
+			}
+			st.Uses++
+			chats[use.chatID] = true
+			agents[use.agentID] = true
+		}
+		st.Chats, st.Agents = len(chats), len(agents)
+
+		// Outcomes are counted once per chat
+		total := 0
+		for chatID := range chats {
+			if stored, ok := r.chats[chatID]; ok && stored.chat.ResolutionCode != nil && *stored.chat.ResolutionCode == "resolved" {
+				st.ResolvedChats++
+			}
+			if rating, ok := r.ratings[chatID]; ok {
+				st.CSATResponses++
+				total += rating.Score
+			}
+		}
+		if st.CSATResponses > 0 {
+			average := float64(total) / float64(st.CSATResponses)
+			st.AverageCSAT = &average
+		}
+		stats = append(stats, st)
+	}
+
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].Uses != stats[j].Uses {
+			return stats[i].Uses > stats[j].Uses
+		}
+		return stats[i].Shortcut < stats[j].Shortcut
+	})
+	return stats, nil
+}
+
+type memorySLA struct {
+	*memory
+}
+
+func (r *memorySLA) Policies() ([]models.SLAPolicy, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var policies []models.SLAPolicy
+	for _, policy := range r.slaPolicies {
+		policies = append(policies, *policy)
+	}
+	sort.Slice(policies, func(i, j int) bool { return policies[i].Name < policies[j].Name })
+	return policies, nil
+}
+
+func (r *memorySLA) GetPolicy(id string) (*models.SLAPolicy, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	policy, ok := r.slaPolicies[id]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	copied := *policy
+	return &copied, nil
+}
+
+func (r *memorySLA) CreatePolicy(policy *models.SLAPolicy) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	policy.ID = uuid.New().String()
+	policy.CreatedAt = now()
+	policy.UpdatedAt = policy.CreatedAt
+	stored := *policy
+	r.slaPolicies[policy.ID] = &stored
+	return nil
+}
+
+func (r *memorySLA) UpdatePolicy(policy *models.SLAPolicy) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored, ok := r.slaPolicies[policy.ID]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	policy.CreatedAt = stored.CreatedAt
+	policy.UpdatedAt = now()
+	*stored = *policy
+	return nil
+}
+
+func (r *memorySLA) DeletePolicy(id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.slaPolicies, id)
+	for _, event := range r.slaEvents {
+		if event.PolicyID != nil && *event.PolicyID == id {
+			event.PolicyID = nil
+		}
+	}
+	return nil
+}
+
+// countsForSLA reports whether a message counts towards response times.
+func countsForSLA(message *models.Message) bool {
+	return message.Visibility == models.VisibilityPublic &&
+		message.MessageType != "system" && message.MessageType != "csat_prompt"
+}
+
+func (r *memorySLA) OpenChats() ([]SLAChat, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var chats []SLAChat
+	for _, stored := range r.chats {
+		c := &stored.chat
+		if !models.IsOpenStatus(c.Status) {
+			continue
+		}
+
+		chat := SLAChat{ID: c.ID, AgentID: c.AgentID, Topic: c.Topic, Priority: c.Priority, CreatedAt: c.CreatedAt}
+		for _, message := range r.messages {
+			if message.ChatID == c.ID && message.SenderID != c.CustomerID && countsForSLA(message) {
+				chat.Responded = true
+			}
+		}
+		chats = append(chats, chat)
+	}
+	return chats, nil
+}
+
+func (r *memorySLA) Messages(chatID string) ([]SLAMessage, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored, ok := r.chats[chatID]
+	if !ok {
+		return nil, nil
+	}
+
+	var counted []*models.Message
+	for _, message := range r.messages {
+		if message.ChatID == chatID && countsForSLA(message) {
+			counted = append(counted, message)
+		}
+	}
+	sort.Slice(counted, func(i, j int) bool { return counted[i].Seq < counted[j].Seq })
+
+	var messages []SLAMessage
+	for _, message := range counted {
+		messages = append(messages, SLAMessage{
+			FromCustomer: message.SenderID == stored.chat.CustomerID,
+			At:           message.CreatedAt,
+		})
+	}
+	return messages, nil
+}
+
+func (r *memorySLA) RecordEvent(event *models.SLAEvent) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.chats[event.ChatID]; !ok {
+		return sql.ErrNoRows
+	}
+	for _, existing := range r.slaEvents {
+		if existing.ChatID == event.ChatID && existing.Metric == event.Metric && existing.Level == event.Level {
+			return ErrDuplicate
+		}
+	}
+
+	event.ID = uuid.New().String()
+	event.CreatedAt = now()
+	stored := *event
+	r.slaEvents = append(r.slaEvents, &stored)
+	return nil
+}
+
+func (r *memorySLA) Events(f models.SLAEventFilter) ([]models.SLAEvent, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	events := []models.SLAEvent{}
+	for i := len(r.slaEvents) - 1; i >= 0; i-- {
+		e := r.slaEvents[i]
+		if (f.ChatID != "" && e.ChatID != f.ChatID) || (f.AgentID != "" && (e.AgentID == nil || *e.AgentID != f.AgentID)) ||
+			(f.Level != "" && e.Level != f.Level) || (f.From != nil && e.CreatedAt.Before(*f.From)) ||
+			(f.To != nil && !e.CreatedAt.Before(*f.To)) {
+			continue
+		}
+		events = append(events, *e)
+	}
+	return events, nil
+}
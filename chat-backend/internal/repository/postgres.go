@@ -0,0 +1,1700 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"cs-socket/internal/models"
+
+	"github.com/lib/pq"
+)
+
+// NewPostgres returns repositories backed by a PostgreSQL database.
+func NewPostgres(db *sql.DB) Repositories {
+	return Repositories{
+		Users:       &postgresUsers{db: db},
+		Chats:       &postgresChats{db: db},
+		Messages:    &postgresMessages{db: db},
+		Escalations: &postgresEscalations{db: db},
+		Tags:        &postgresTags{db: db},
+		CSAT:        &postgresCSAT{db: db},
+		Canned:      &postgresCanned{db: db},
+		SLA:         &postgresSLA{db: db},
+	}
+}
+
+// readUpsert finishes an INSERT into chat_reads so that read positions only
+// move forward.
+const readUpsert = `ON CONFLICT (chat_id, user_id) DO UPDATE
+		SET last_read_seq = GREATEST(chat_reads.last_read_seq, EXCLUDED.last_read_seq), updated_at = CURRENT_TIMESTAMP`
+
+// IsUniqueViolation reports whether err is a unique constraint violation.
+func IsUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505"
+}
+
+type postgresUsers struct {
+	db *sql.DB
+}
+
+const userColumns = `id, username, email, name, role, tier, team, avatar, is_online, created_at, updated_at`
+
+func scanUser(row RowScanner, extra ...interface{}) (*models.User, error) {
+	var user models.User
+	dest := []interface{}{
+		&user.ID, &user.Username, &user.Email, &user.Name,
+		&user.Role, &user.Tier, &user.Team, &user.Avatar, &user.IsOnline, &user.CreatedAt, &user.UpdatedAt,
+	}
+	if err := row.Scan(append(dest, extra...)...); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (r *postgresUsers) queryUsers(query string, args ...interface{}) ([]models.User, error) {
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []models.User
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, *user)
+	}
+	return users, rows.Err()
+}
+
+func (r *postgresUsers) GetByID(id string) (*models.User, error) {
+	return scanUser(r.db.QueryRow(`SELECT `+userColumns+` FROM users WHERE id = $1`, id))
+}
+
+func (r *postgresUsers) GetByUsername(username string) (*models.User, error) {
+	var hash string
+	user, err := scanUser(r.db.QueryRow(`SELECT `+userColumns+`, password_hash FROM users WHERE username = $1`, username), &hash)
+	if err != nil {
+		return nil, err
+	}
+	user.Password = hash
+	return user, nil
+}
+
+func (r *postgresUsers) Create(user models.User) (*models.User, error) {
+	created, err := scanUser(r.db.QueryRow(`INSERT INTO users (id, username, email, password_hash, name, role, is_online, created_at, updated_at)
+			  VALUES ($1, $2, $3, $4, $5, $6, $7, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+			  RETURNING `+userColumns,
+		user.ID, user.Username, user.Email, user.Password, user.Name, user.Role, user.IsOnline))
+	if IsUniqueViolation(err) {
+		return nil, ErrDuplicate
+	}
+	return created, err
+}
+
+func (r *postgresUsers) List(staffOnly bool) ([]models.User, error) {
+	return r.queryUsers(`SELECT `+userColumns+` FROM users
+			  WHERE role != 'system' AND (NOT $1 OR role IN ('agent', 'super-agent'))
+			  ORDER BY name`, staffOnly)
+}
+
+func (r *postgresUsers) SetOnline(id string, online bool) error {
+	_, err := r.db.Exec(`UPDATE users SET is_online = $1, updated_at = CURRENT_TIMESTAMP WHERE id = $2`, online, id)
+	return err
+}
+
+func (r *postgresUsers) SetTier(id, tier string) error {
+	result, err := r.db.Exec(`UPDATE users SET tier = $1, updated_at = CURRENT_TIMESTAMP WHERE id = $2 AND role = 'customer'`, tier, id)
+	return checkUpdated(result, err)
+}
+
+func (r *postgresUsers) SetTeam(id string, team *string) error {
+	result, err := r.db.Exec(`UPDATE users SET team = $1, updated_at = CURRENT_TIMESTAMP
+			  WHERE id = $2 AND role IN ('agent', 'super-agent')`, team, id)
+	return checkUpdated(result, err)
+}
+
+func (r *postgresUsers) SetRole(id, role string) error {
+	result, err := r.db.Exec(`UPDATE users SET role = $1, updated_at = CURRENT_TIMESTAMP WHERE id = $2`, role, id)
+	return checkUpdated(result, err)
+}
+
+func (r *postgresUsers) SetPassword(id, hash string) error {
+	result, err := r.db.Exec(`UPDATE users SET password_hash = $1, updated_at = CURRENT_TIMESTAMP WHERE id = $2`, hash, id)
+	return checkUpdated(result, err)
+}
+
+func (r *postgresUsers) AvailableAgents(customerID string) ([]models.User, error) {
+	// Super-agents are not offered to customers
+	return r.queryUsers(`SELECT `+userColumns+` FROM users
+			  WHERE role = 'agent' AND is_online = true
+			  AND id NOT IN (
+				  SELECT c.agent_id FROM chats c
+				  WHERE c.status IN ('active', 'pending_customer')
+				  AND c.agent_id IS NOT NULL
+				  AND c.customer_id != $1
+			  )
+			  ORDER BY name`, customerID)
+}
+
+func (r *postgresUsers) AvailableCustomers(agentID string) ([]models.User, error) {
+	return r.queryUsers(`SELECT `+userColumns+` FROM users
+			  WHERE role = 'customer' AND is_online = true
+			  AND id NOT IN (
+				  SELECT c.customer_id FROM chats c
+				  WHERE c.status IN ('active', 'pending_customer')
+				  AND c.agent_id IS NOT NULL
+				  AND c.agent_id != $1
+			  )
+			  ORDER BY name`, agentID)
+}
+
+// conditions collects the conditions of a WHERE clause and the arguments of
+// their placeholders.
+type conditions struct {
+	list []string
+	args []interface{}
+}
+
+// and adds a condition.
+func (c *conditions) and(condition string) {
+	c.list = append(c.list, condition)
+}
+
+// param adds an argument and returns its placeholder.
+func (c *conditions) param(value interface{}) string {
+	c.args = append(c.args, value)
+	return fmt.Sprintf("$%d", len(c.args))
+}
+
+// scope adds the conditions of a chat scope on chats c.
+func (c *conditions) scope(scope ChatScope) {
+	if scope.CustomerID != "" {
+		c.and(`c.customer_id = ` + c.param(scope.CustomerID))
+	}
+	if scope.AgentID != "" && scope.WithUnassigned {
+		c.and(`(c.agent_id = ` + c.param(scope.AgentID) + ` OR c.agent_id IS NULL)`)
+	} else if scope.AgentID != "" {
+		c.and(`c.agent_id = ` + c.param(scope.AgentID))
+	}
+}
+
+// where returns the WHERE clause of the conditions, or nothing if there are
+// none.
+func (c *conditions) where() string {
+	if len(c.list) == 0 {
+		return ""
+	}
+	return "WHERE " + strings.Join(c.list, " AND ")
+}
+
+// checkUpdated turns an update that matched no rows into sql.ErrNoRows.
+func checkUpdated(result sql.Result, err error) error {
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+type postgresChats struct {
+	db *sql.DB
+}
+
+func (r *postgresChats) Get(id string) (*models.Chat, error) {
+	return ScanChat(r.db.QueryRow(ChatSelect+` WHERE c.id = $1`, id))
+}
+
+func (r *postgresChats) Create(chat *models.Chat) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRow(`INSERT INTO chats (id, customer_id, agent_id, topic, priority, status, created_at, updated_at)
+			  VALUES ($1, $2, $3, $4, $5, $6, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+			  RETURNING created_at, updated_at`,
+		chat.ID, chat.CustomerID, chat.AgentID, chat.Topic, chat.Priority, chat.Status).Scan(&chat.CreatedAt, &chat.UpdatedAt)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`INSERT INTO chat_status_history (chat_id, to_status, created_at)
+			  VALUES ($1, $2, CURRENT_TIMESTAMP)`, chat.ID, chat.Status)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (r *postgresChats) Delete(id string) error {
+	// Messages are deleted by the foreign key's ON DELETE CASCADE
+	_, err := r.db.Exec(`DELETE FROM chats WHERE id = $1`, id)
+	return err
+}
+
+func (r *postgresChats) Assistants(chatID string) ([]string, error) {
+	rows, err := r.db.Query(`SELECT user_id FROM chat_participants
+			  WHERE chat_id = $1 AND role = 'assistant'`, chatID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, rows.Err()
+}
+
+func (r *postgresChats) MarkRead(chatID, userID string, seq int64) error {
+	_, err := r.db.Exec(`INSERT INTO chat_reads (chat_id, user_id, last_read_seq)
+			  VALUES ($1, $2, $3)
+			  `+readUpsert, chatID, userID, seq)
+	return err
+}
+
+func (r *postgresChats) ChangeStatus(change StatusChange) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if change.AssignTo != nil {
+		result, err := tx.Exec(`UPDATE chats SET agent_id = $1 WHERE id = $2 AND agent_id IS NULL`, *change.AssignTo, change.ChatID)
+		if err := checkUpdated(result, err); err == sql.ErrNoRows {
+			return ErrAssigned
+		} else if err != nil {
+			return err
+		}
+	}
+
+	// The status guard makes concurrent transitions of the same chat fail
+	// instead of silently overwriting each other
+	query := `UPDATE chats SET
+			  status = $1,
+			  agent_id = CASE WHEN $1 = 'queued' THEN NULL ELSE agent_id END,
+			  resolution_code = CASE WHEN $1 IN ('queued', 'active', 'pending_customer') THEN NULL
+			                    ELSE COALESCE($3, resolution_code) END,
+			  wrap_up_notes = CASE WHEN $1 IN ('queued', 'active', 'pending_customer') THEN NULL
+			                  ELSE COALESCE($4, wrap_up_notes) END,
+			  resolved_at = CASE WHEN $1 = 'resolved' THEN CURRENT_TIMESTAMP
+			                WHEN $1 IN ('queued', 'active', 'pending_customer') THEN NULL
+			                ELSE resolved_at END,
+			  closed_at = CASE WHEN $1 = 'closed' THEN CURRENT_TIMESTAMP
+			              WHEN $1 IN ('queued', 'active', 'pending_customer') THEN NULL
+			              ELSE closed_at END,
+			  updated_at = CURRENT_TIMESTAMP
+			  WHERE id = $2 AND status = $5`
+
+	result, err := tx.Exec(query, change.To, change.ChatID, change.ResolutionCode, change.Note, change.From)
+	if err := checkUpdated(result, err); err == sql.ErrNoRows {
+		return ErrStale
+	} else if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`INSERT INTO chat_status_history (chat_id, from_status, to_status, changed_by, resolution_code, note, created_at)
+			  VALUES ($1, $2, $3, $4, $5, $6, CURRENT_TIMESTAMP)`,
+		change.ChatID, change.From, change.To, change.ChangedBy, change.ResolutionCode, change.Note)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (r *postgresChats) StatusHistory(chatID string) ([]models.ChatStatusChange, error) {
+	rows, err := r.db.Query(`SELECT id, chat_id, from_status, to_status, changed_by, resolution_code, note, created_at
+			  FROM chat_status_history
+			  WHERE chat_id = $1
+			  ORDER BY created_at`, chatID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	history := []models.ChatStatusChange{}
+	for rows.Next() {
+		var change models.ChatStatusChange
+		err := rows.Scan(
+			&change.ID, &change.ChatID, &change.FromStatus, &change.ToStatus,
+			&change.ChangedBy, &change.ResolutionCode, &change.Note, &change.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		history = append(history, change)
+	}
+
+	return history, rows.Err()
+}
+
+// priorityRank ranks c.priority so that higher priorities sort first with
+// ORDER BY ... DESC.
+const priorityRank = `CASE c.priority WHEN 'urgent' THEN 3 WHEN 'high' THEN 2 WHEN 'normal' THEN 1 ELSE 0 END`
+
+// chatOrder returns the ORDER BY clause of a chat listing. By priority in
+// descending order, open chats come first, highest priority first and most
+// recently updated first within the same priority; ascending order reverses
+// all of that. The chat ID breaks ties, so that pages neither overlap nor
+// skip chats.
+func chatOrder(filter models.ChatFilter) string {
+	direction := "DESC"
+	if filter.Order == "asc" {
+		direction = "ASC"
+	}
+
+	switch filter.Sort {
+	case "updated":
+		return `ORDER BY c.updated_at ` + direction + `, c.id`
+	case "created":
+		return `ORDER BY c.created_at ` + direction + `, c.id`
+	default:
+		return `ORDER BY CASE WHEN c.status IN ('queued', 'active', 'pending_customer')
+			THEN ` + priorityRank + ` ELSE -1 END ` + direction + `, c.updated_at ` + direction + `, c.id`
+	}
+}
+
+func (r *postgresChats) queryChats(query string, args ...interface{}) ([]models.Chat, error) {
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	chats := []models.Chat{}
+	for rows.Next() {
+		chat, err := ScanChat(rows)
+		if err != nil {
+			return nil, err
+		}
+		chats = append(chats, *chat)
+	}
+	return chats, rows.Err()
+}
+
+func (r *postgresChats) List(listing ChatListing) ([]models.Chat, error) {
+	// Read positions count in the numbering the reader sees
+	unread := `c.last_public_seq - COALESCE(r.last_read_seq, 0)`
+	if listing.Staff {
+		unread = `c.last_seq - COALESCE(r.last_read_seq, 0)`
+	}
+
+	var where conditions
+	reader := where.param(listing.ReaderID)
+	where.scope(listing.Scope)
+	if listing.Archived {
+		where.and(`c.status = 'archived'`)
+	} else {
+		where.and(`c.status != 'archived'`)
+	}
+
+	filter := listing.Filter
+	if filter.Tag != "" {
+		where.and(`EXISTS (SELECT 1 FROM chat_tags ct JOIN tags t ON t.id = ct.tag_id
+				 WHERE ct.chat_id = c.id AND t.name = ` + where.param(filter.Tag) + `)`)
+	}
+	if filter.Status != "" {
+		where.and(`c.status = ` + where.param(filter.Status))
+	}
+	if filter.AgentID != "" {
+		where.and(`c.agent_id = ` + where.param(filter.AgentID))
+	}
+	if filter.Unassigned {
+		where.and(`c.agent_id IS NULL`)
+	}
+	if filter.HasUnread {
+		where.and(unread + ` > 0`)
+	}
+	limit, offset := where.param(filter.Limit), where.param(filter.Offset)
+
+	rows, err := r.db.Query(`SELECT `+ChatColumns+`, `+unread+`
+			 `+ChatFrom+`
+			 LEFT JOIN chat_reads r ON r.chat_id = c.id AND r.user_id = `+reader+`
+			 `+where.where()+`
+			 `+chatOrder(filter)+`
+			 LIMIT `+limit+` OFFSET `+offset, where.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	chats := []models.Chat{}
+	for rows.Next() {
+		var unreadCount int64
+		chat, err := ScanChat(rows, &unreadCount)
+		if err != nil {
+			return nil, err
+		}
+		chat.UnreadCount = &unreadCount
+		chats = append(chats, *chat)
+	}
+	return chats, rows.Err()
+}
+
+func (r *postgresChats) Queue(limit int) ([]models.Chat, error) {
+	return r.queryChats(ChatSelect+`
+			 WHERE c.status = 'queued' AND c.agent_id IS NULL
+			 ORDER BY `+priorityRank+` DESC, c.created_at
+			 LIMIT $1`, sql.NullInt64{Int64: int64(limit), Valid: limit > 0})
+}
+
+func (r *postgresChats) SetPriority(id, priority string) error {
+	result, err := r.db.Exec(`UPDATE chats SET priority = $1, updated_at = CURRENT_TIMESTAMP WHERE id = $2`, priority, id)
+	return checkUpdated(result, err)
+}
+
+func (r *postgresChats) Now() (time.Time, error) {
+	var now time.Time
+	err := r.db.QueryRow(`SELECT CURRENT_TIMESTAMP`).Scan(&now)
+	return now, err
+}
+
+func (r *postgresChats) Changed(scope ChatScope, since time.Time, limit int) ([]models.Chat, error) {
+	var where conditions
+	where.scope(scope)
+	where.and(`c.updated_at > ` + where.param(since))
+	limitParam := where.param(limit)
+
+	return r.queryChats(ChatSelect+`
+			 `+where.where()+`
+			 ORDER BY c.updated_at
+			 LIMIT `+limitParam, where.args...)
+}
+
+func (r *postgresChats) IdleChats(systemUserID string) ([]IdleChat, error) {
+	rows, err := r.db.Query(`SELECT c.id, c.agent_id, c.topic, c.idle_nudged_at,
+			  (SELECT MAX(m.created_at) FROM messages m
+			   WHERE m.chat_id = c.id AND m.sender_id = c.customer_id),
+			  (SELECT MAX(m.created_at) FROM messages m
+			   WHERE m.chat_id = c.id AND m.sender_id NOT IN (c.customer_id, $1) AND m.visibility = 'public')
+			  FROM chats c
+			  WHERE c.status IN ('active', 'pending_customer') AND c.agent_id IS NOT NULL`, systemUserID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var chats []IdleChat
+	for rows.Next() {
+		var chat IdleChat
+		err := rows.Scan(
+			&chat.ID, &chat.AgentID, &chat.Topic, &chat.NudgedAt,
+			&chat.LastCustomerAt, &chat.LastStaffAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		chats = append(chats, chat)
+	}
+
+	return chats, rows.Err()
+}
+
+func (r *postgresChats) SetIdleNudged(chatID string, at *time.Time) error {
+	_, err := r.db.Exec(`UPDATE chats SET idle_nudged_at = $1 WHERE id = $2`, at, chatID)
+	return err
+}
+
+type postgresMessages struct {
+	db *sql.DB
+}
+
+func (r *postgresMessages) queryMessages(query string, args ...interface{}) ([]models.Message, error) {
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	messages := []models.Message{}
+	for rows.Next() {
+		message, err := ScanMessage(rows)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, *message)
+	}
+	return messages, rows.Err()
+}
+
+func (r *postgresMessages) Get(id string) (*models.Message, error) {
+	return ScanMessage(r.db.QueryRow(MessageSelect+` WHERE m.id = $1`, id))
+}
+
+func (r *postgresMessages) Insert(m NewMessage) (*models.Message, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	message, err := insertMessage(tx, m)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return message, nil
+}
+
+// insertMessage stores a message as part of tx.
+func insertMessage(tx *sql.Tx, m NewMessage) (*models.Message, error) {
+	// lib/pq sends []byte as bytea, so JSON goes over the wire as text
+	metadata := sql.NullString{String: string(m.Metadata), Valid: m.Metadata != nil}
+
+	// Taking the next number locks the chat row until the message is
+	// committed, so concurrent messages are numbered in commit order and a
+	// failed insert leaves no gap. Staff-only messages leave updated_at alone
+	// so they don't reorder the customer's chat list.
+	public := m.Visibility == models.VisibilityPublic
+	var seq int64
+	var publicSeq sql.NullInt64
+	err := tx.QueryRow(`UPDATE chats SET last_seq = last_seq + 1,
+			  last_public_seq = last_public_seq + CASE WHEN $2 THEN 1 ELSE 0 END,
+			  updated_at = CASE WHEN $2 THEN CURRENT_TIMESTAMP ELSE updated_at END
+			  WHERE id = $1
+			  RETURNING last_seq, CASE WHEN $2 THEN last_public_seq END`, m.ChatID, public).Scan(&seq, &publicSeq)
+	if err != nil {
+		return nil, err
+	}
+
+	var message models.Message
+	var storedMetadata []byte
+	err = tx.QueryRow(`INSERT INTO messages (chat_id, seq, public_seq, sender_id, content, message_type, visibility, metadata, reply_to_id, created_at)
+			  VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, CURRENT_TIMESTAMP)
+			  RETURNING id, chat_id, seq, public_seq, sender_id, content, message_type, visibility, metadata, created_at`,
+		m.ChatID, seq, publicSeq, m.SenderID, m.Content, m.MessageType, m.Visibility, metadata, m.ReplyToID).Scan(
+		&message.ID, &message.ChatID, &message.Seq, &message.PublicSeq, &message.SenderID, &message.Content, &message.MessageType, &message.Visibility,
+		&storedMetadata, &message.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	// Senders have read their own messages. Read positions count in the
+	// numbering the reader sees: public_seq for customers.
+	_, err = tx.Exec(`INSERT INTO chat_reads (chat_id, user_id, last_read_seq)
+			  SELECT $1, u.id, CASE WHEN u.role = 'customer' THEN COALESCE($4::bigint, 0) ELSE $3 END
+			  FROM users u WHERE u.id = $2
+			  `+readUpsert, m.ChatID, m.SenderID, seq, publicSeq)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(storedMetadata) > 0 {
+		message.Metadata = storedMetadata
+	}
+	return &message, nil
+}
+
+func (r *postgresMessages) Page(chatID string, includeStaff bool, before, after string, limit int) ([]models.Message, error) {
+	// Without a cursor, the newest messages are returned
+	cursor, order, compare := before, "DESC", "<"
+	if after != "" {
+		cursor, order, compare = after, "ASC", ">"
+	}
+
+	where := `m.chat_id = $1 AND ($2 OR m.visibility = 'public')`
+	args := []interface{}{chatID, includeStaff, limit}
+	if cursor != "" {
+		args = append(args, cursor)
+		where += ` AND m.seq ` + compare + ` (SELECT seq FROM messages WHERE id = $4)`
+	}
+
+	messages, err := r.queryMessages(MessageSelect+`
+			  WHERE `+where+`
+			  ORDER BY m.seq `+order+`
+			  LIMIT $3`, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	if order == "DESC" {
+		for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+			messages[i], messages[j] = messages[j], messages[i]
+		}
+	}
+	return messages, nil
+}
+
+func (r *postgresMessages) Latest(chatIDs []string, includeStaff bool) (map[string]*models.Message, error) {
+	latest := make(map[string]*models.Message, len(chatIDs))
+	if len(chatIDs) == 0 {
+		return latest, nil
+	}
+
+	// Each lookup walks idx_messages_chat_seq backwards from the chat's
+	// newest message
+	messages, err := r.queryMessages(`SELECT lm.* FROM unnest($1::uuid[]) AS ids(chat_id)
+			  CROSS JOIN LATERAL (`+MessageSelect+`
+			      WHERE m.chat_id = ids.chat_id AND ($2 OR m.visibility = 'public')
+			      ORDER BY m.seq DESC
+			      LIMIT 1) lm`, pq.Array(chatIDs), includeStaff)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range messages {
+		latest[messages[i].ChatID] = &messages[i]
+	}
+	return latest, nil
+}
+
+func (r *postgresMessages) Touch(id string) error {
+	_, err := r.db.Exec(`UPDATE messages SET updated_at = CURRENT_TIMESTAMP WHERE id = $1`, id)
+	return err
+}
+
+func (r *postgresMessages) AddReaction(messageID, userID, emoji string) error {
+	_, err := r.db.Exec(`INSERT INTO message_reactions (message_id, user_id, emoji, created_at)
+			  VALUES ($1, $2, $3, CURRENT_TIMESTAMP)
+			  ON CONFLICT (message_id, user_id, emoji) DO NOTHING`, messageID, userID, emoji)
+	return err
+}
+
+func (r *postgresMessages) RemoveReaction(messageID, userID, emoji string) error {
+	_, err := r.db.Exec(`DELETE FROM message_reactions WHERE message_id = $1 AND user_id = $2 AND emoji = $3`,
+		messageID, userID, emoji)
+	return err
+}
+
+func (r *postgresMessages) Reactions(messageIDs []string) (map[string][]models.Reaction, error) {
+	rows, err := r.db.Query(`SELECT message_id, emoji, COUNT(*), array_agg(user_id::text ORDER BY created_at)
+			  FROM message_reactions
+			  WHERE message_id = ANY($1::uuid[])
+			  GROUP BY message_id, emoji
+			  ORDER BY message_id, MIN(created_at)`, pq.Array(messageIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	reactions := make(map[string][]models.Reaction)
+	for rows.Next() {
+		var messageID string
+		var reaction models.Reaction
+		if err := rows.Scan(&messageID, &reaction.Emoji, &reaction.Count, pq.Array(&reaction.UserIDs)); err != nil {
+			return nil, err
+		}
+		reactions[messageID] = append(reactions[messageID], reaction)
+	}
+
+	return reactions, rows.Err()
+}
+
+func (r *postgresMessages) Attachments(messageIDs []string) ([]*models.Attachment, error) {
+	rows, err := r.db.Query(`SELECT `+AttachmentColumns+` FROM attachments
+			  WHERE message_id = ANY($1::uuid[])
+			  ORDER BY created_at`, pq.Array(messageIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var attachments []*models.Attachment
+	for rows.Next() {
+		attachment, err := ScanAttachment(rows)
+		if err != nil {
+			return nil, err
+		}
+		attachments = append(attachments, attachment)
+	}
+	return attachments, rows.Err()
+}
+
+func (r *postgresMessages) Thumbnails(attachmentIDs []string) (map[string][]models.Thumbnail, error) {
+	rows, err := r.db.Query(`SELECT attachment_id, name, content_type, size_bytes, width, height, storage_key
+			  FROM attachment_thumbnails
+			  WHERE attachment_id = ANY($1::uuid[])
+			  ORDER BY width * height`, pq.Array(attachmentIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	thumbnails := make(map[string][]models.Thumbnail)
+	for rows.Next() {
+		var attachmentID string
+		var t models.Thumbnail
+		if err := rows.Scan(&attachmentID, &t.Name, &t.ContentType, &t.Size, &t.Width, &t.Height, &t.StorageKey); err != nil {
+			return nil, err
+		}
+		thumbnails[attachmentID] = append(thumbnails[attachmentID], t)
+	}
+
+	return thumbnails, rows.Err()
+}
+
+func (r *postgresMessages) GetAttachment(id string) (*models.Attachment, error) {
+	return ScanAttachment(r.db.QueryRow(`SELECT `+AttachmentColumns+` FROM attachments a
+			  WHERE a.id = $1 AND NOT EXISTS (
+			      SELECT 1 FROM messages m WHERE m.id = a.message_id AND m.deleted_at IS NOT NULL)`, id))
+}
+
+func (r *postgresMessages) CreateAttachment(a *models.Attachment) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRow(`INSERT INTO attachments
+			  (id, chat_id, uploader_id, file_name, content_type, size_bytes, width, height, scan_status, storage_key, created_at)
+			  VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, CURRENT_TIMESTAMP)
+			  RETURNING created_at`,
+		a.ID, a.ChatID, a.UploaderID, a.FileName, a.ContentType, a.Size, a.Width, a.Height, a.ScanStatus, a.StorageKey).Scan(&a.CreatedAt)
+	if err != nil {
+		return err
+	}
+
+	for _, t := range a.Thumbnails {
+		_, err := tx.Exec(`INSERT INTO attachment_thumbnails
+				  (attachment_id, name, content_type, size_bytes, width, height, storage_key)
+				  VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+			a.ID, t.Name, t.ContentType, t.Size, t.Width, t.Height, t.StorageKey)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (r *postgresMessages) DeleteAttachment(id string) error {
+	// Thumbnails are deleted by the foreign key's ON DELETE CASCADE
+	_, err := r.db.Exec(`DELETE FROM attachments WHERE id = $1`, id)
+	return err
+}
+
+func (r *postgresMessages) LinkAttachments(messageID string, attachmentIDs []string) error {
+	_, err := r.db.Exec(`UPDATE attachments SET message_id = $1 WHERE id = ANY($2::uuid[])`, messageID, pq.Array(attachmentIDs))
+	return err
+}
+
+// editWindow limits a query on messages to those sent within the edit
+// window, given in seconds as the second parameter. Zero means no limit.
+const editWindow = ` AND ($2::float8 = 0 OR created_at >= CURRENT_TIMESTAMP - make_interval(secs => $2::float8))`
+
+func (r *postgresMessages) Edit(id, editorID, content string, window time.Duration) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var previous string
+	err = tx.QueryRow(`SELECT content FROM messages
+			  WHERE id = $1 AND deleted_at IS NULL`+editWindow+`
+			  FOR UPDATE`, id, window.Seconds()).Scan(&previous)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`INSERT INTO message_edits (message_id, content, edited_by, edited_at)
+			  VALUES ($1, $2, $3, CURRENT_TIMESTAMP)`, id, previous, editorID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`UPDATE messages SET content = $1, edited_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+			  WHERE id = $2`, content, id)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (r *postgresMessages) Delete(id, userID string, window time.Duration) (time.Time, error) {
+	var deletedAt time.Time
+	err := r.db.QueryRow(`UPDATE messages SET deleted_at = CURRENT_TIMESTAMP, deleted_by = $3, updated_at = CURRENT_TIMESTAMP
+			  WHERE id = $1 AND deleted_at IS NULL`+editWindow+`
+			  RETURNING deleted_at`, id, window.Seconds(), userID).Scan(&deletedAt)
+	return deletedAt, err
+}
+
+func (r *postgresMessages) History(id string) (*models.MessageHistory, error) {
+	message, err := r.Get(id)
+	if err != nil {
+		return nil, err
+	}
+
+	history := models.MessageHistory{Edits: []models.MessageEdit{}}
+	err = r.db.QueryRow(`SELECT content, deleted_by FROM messages WHERE id = $1`, id).
+		Scan(&message.Content, &history.DeletedBy)
+	if err != nil {
+		return nil, err
+	}
+	history.Message = *message
+
+	rows, err := r.db.Query(`SELECT id, message_id, content, edited_by, edited_at FROM message_edits
+			  WHERE message_id = $1
+			  ORDER BY edited_at`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var edit models.MessageEdit
+		if err := rows.Scan(&edit.ID, &edit.MessageID, &edit.Content, &edit.EditedBy, &edit.EditedAt); err != nil {
+			return nil, err
+		}
+		history.Edits = append(history.Edits, edit)
+	}
+
+	return &history, rows.Err()
+}
+
+func (r *postgresMessages) InsertNote(m NewMessage, usernames []string) (*models.Message, []string, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback()
+
+	message, err := insertMessage(tx, m)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var userIDs []string
+	if len(usernames) > 0 {
+		rows, err := tx.Query(`INSERT INTO message_mentions (message_id, user_id, created_at)
+				  SELECT $1, id, CURRENT_TIMESTAMP FROM users
+				  WHERE username = ANY($2) AND role IN ('agent', 'super-agent') AND id != $3
+				  RETURNING user_id`,
+			message.ID, pq.Array(usernames), m.SenderID)
+		if err != nil {
+			return nil, nil, err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var id string
+			if err := rows.Scan(&id); err != nil {
+				return nil, nil, err
+			}
+			userIDs = append(userIDs, id)
+		}
+		if err := rows.Err(); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, nil, err
+	}
+	return message, userIDs, nil
+}
+
+func (r *postgresMessages) Mentions(userID string, limit int) ([]models.Mention, error) {
+	messages, err := r.queryMessages(MessageSelect+`
+			  JOIN message_mentions mm ON mm.message_id = m.id
+			  WHERE mm.user_id = $1
+			  ORDER BY mm.created_at DESC
+			  LIMIT $2`, userID, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	mentions := make([]models.Mention, len(messages))
+	for i, message := range messages {
+		mentions[i] = models.Mention{
+			ChatID:    message.ChatID,
+			Message:   message,
+			CreatedAt: message.CreatedAt,
+		}
+	}
+	return mentions, nil
+}
+
+// searchConfig is the text search configuration of messages.search_vector.
+// Both must change together.
+const searchConfig = "english"
+
+// searchHeadlineOptions keep up to two passages around the matches. The
+// content is HTML-escaped before ts_headline wraps matches in <mark>, so the
+// headline is safe to render as HTML.
+const searchHeadlineOptions = `StartSel=<mark>, StopSel=</mark>, MaxWords=30, MinWords=10, MaxFragments=2, FragmentDelimiter=" … "`
+
+// Search accepts web search syntax: quoted phrases, "or" and a leading "-"
+// to exclude a word.
+func (r *postgresMessages) Search(scope ChatScope, filter models.MessageSearchFilter, includeStaff bool) ([]models.MessageSearchResult, error) {
+	var where conditions
+	where.scope(scope)
+	query := where.param(filter.Query)
+	where.and(`m.search_vector @@ query`)
+	where.and(`m.deleted_at IS NULL`)
+	if !includeStaff {
+		where.and(`m.visibility = 'public'`)
+	}
+	if filter.From != nil {
+		where.and(`m.created_at >= ` + where.param(*filter.From))
+	}
+	if filter.To != nil {
+		where.and(`m.created_at < ` + where.param(*filter.To))
+	}
+	if filter.AgentID != "" {
+		where.and(`c.agent_id = ` + where.param(filter.AgentID))
+	}
+	if filter.CustomerID != "" {
+		where.and(`c.customer_id = ` + where.param(filter.CustomerID))
+	}
+	if filter.Status != "" {
+		where.and(`c.status = ` + where.param(filter.Status))
+	}
+	limit, offset := where.param(filter.Limit), where.param(filter.Offset)
+
+	rows, err := r.db.Query(fmt.Sprintf(`SELECT m.id, m.chat_id, m.sender_id, u.name, u.role, m.message_type, m.visibility,
+			  ts_headline('%[1]s', replace(replace(replace(m.content, '&', '&amp;'), '<', '&lt;'), '>', '&gt;'),
+			              query, '%[2]s'),
+			  ts_rank(m.search_vector, query) AS rank, m.created_at,
+			  c.id, c.status, c.topic, c.customer_id, cu.name, c.agent_id, a.name, c.created_at
+			  FROM messages m
+			  CROSS JOIN websearch_to_tsquery('%[1]s', %[3]s) AS query
+			  JOIN chats c ON c.id = m.chat_id
+			  JOIN users u ON u.id = m.sender_id
+			  JOIN users cu ON cu.id = c.customer_id
+			  LEFT JOIN users a ON a.id = c.agent_id
+			  %[4]s
+			  ORDER BY rank DESC, m.created_at DESC
+			  LIMIT %[5]s OFFSET %[6]s`,
+		searchConfig, searchHeadlineOptions, query, where.where(), limit, offset), where.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := []models.MessageSearchResult{}
+	for rows.Next() {
+		var result models.MessageSearchResult
+		c := &result.Chat
+		err := rows.Scan(&result.MessageID, &result.ChatID, &result.SenderID, &result.SenderName, &result.SenderRole,
+			&result.MessageType, &result.Visibility, &result.Headline, &result.Rank, &result.CreatedAt,
+			&c.ID, &c.Status, &c.Topic, &c.CustomerID, &c.CustomerName, &c.AgentID, &c.AgentName, &c.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, result)
+	}
+
+	return results, rows.Err()
+}
+
+func (r *postgresMessages) Changed(scope ChatScope, since time.Time, includeStaff bool, limit int) ([]models.Message, error) {
+	var where conditions
+	where.scope(scope)
+	where.and(`m.updated_at > ` + where.param(since))
+	if !includeStaff {
+		where.and(`m.visibility = 'public'`)
+	}
+	limitParam := where.param(limit)
+
+	return r.queryMessages(MessageSelect+`
+			  JOIN chats c ON c.id = m.chat_id
+			  `+where.where()+`
+			  ORDER BY m.updated_at, m.id
+			  LIMIT `+limitParam, where.args...)
+}
+
+func (r *postgresMessages) ClaimScans(retryAfter time.Duration, limit int) ([]ScanClaim, error) {
+	rows, err := r.db.Query(`UPDATE attachments SET scan_attempts = scan_attempts + 1, scan_attempted_at = CURRENT_TIMESTAMP
+			  WHERE id IN (
+			      SELECT id FROM attachments
+			      WHERE scan_status = 'pending' AND message_id IS NOT NULL
+			        AND (scan_attempted_at IS NULL OR scan_attempted_at <= CURRENT_TIMESTAMP - make_interval(secs => $1::float8))
+			      ORDER BY created_at
+			      LIMIT $2
+			      FOR UPDATE SKIP LOCKED)
+			  RETURNING id, storage_key, scan_attempts`, retryAfter.Seconds(), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var claims []ScanClaim
+	for rows.Next() {
+		var c ScanClaim
+		if err := rows.Scan(&c.AttachmentID, &c.StorageKey, &c.Attempts); err != nil {
+			return nil, err
+		}
+		claims = append(claims, c)
+	}
+	return claims, rows.Err()
+}
+
+func (r *postgresMessages) RecordScan(attachmentID, status string, signature *string) (*models.Attachment, error) {
+	return ScanAttachment(r.db.QueryRow(`UPDATE attachments
+			  SET scan_status = $2, scan_signature = $3, scanned_at = CURRENT_TIMESTAMP
+			  WHERE id = $1
+			  RETURNING `+AttachmentColumns, attachmentID, status, signature))
+}
+
+type postgresEscalations struct {
+	db *sql.DB
+}
+
+const escalationColumns = `id, chat_id, requested_by, reason, status, claimed_by, claimed_at, outcome, resolved_at, created_at`
+
+func scanEscalation(row RowScanner) (*models.Escalation, error) {
+	var e models.Escalation
+	err := row.Scan(
+		&e.ID, &e.ChatID, &e.RequestedBy, &e.Reason, &e.Status,
+		&e.ClaimedBy, &e.ClaimedAt, &e.Outcome, &e.ResolvedAt, &e.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &e, nil
+}
+
+func (r *postgresEscalations) Get(id string) (*models.Escalation, error) {
+	return scanEscalation(r.db.QueryRow(`SELECT `+escalationColumns+` FROM chat_escalations WHERE id = $1`, id))
+}
+
+func (r *postgresEscalations) Create(chatID, requestedBy, reason string) (*models.Escalation, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// A partial unique index allows one open or claimed escalation per chat
+	escalation, err := scanEscalation(tx.QueryRow(`INSERT INTO chat_escalations (chat_id, requested_by, reason, status, created_at)
+			  VALUES ($1, $2, $3, 'open', CURRENT_TIMESTAMP)
+			  RETURNING `+escalationColumns, chatID, requestedBy, reason))
+	if IsUniqueViolation(err) {
+		return nil, ErrDuplicate
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := tx.Exec(`UPDATE chats SET is_escalated = true, updated_at = CURRENT_TIMESTAMP WHERE id = $1`, chatID); err != nil {
+		return nil, err
+	}
+
+	return escalation, tx.Commit()
+}
+
+func (r *postgresEscalations) Claim(id, userID string) (*models.Escalation, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	escalation, err := scanEscalation(tx.QueryRow(`UPDATE chat_escalations
+			  SET status = 'claimed', claimed_by = $1, claimed_at = CURRENT_TIMESTAMP
+			  WHERE id = $2 AND status = 'open'
+			  RETURNING `+escalationColumns, userID, id))
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.Exec(`INSERT INTO chat_participants (chat_id, user_id, role, added_at)
+			  VALUES ($1, $2, 'assistant', CURRENT_TIMESTAMP)
+			  ON CONFLICT (chat_id, user_id) DO NOTHING`, escalation.ChatID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Bumped so that clients pick up the escalation's progress on sync
+	if _, err := tx.Exec(`UPDATE chats SET updated_at = CURRENT_TIMESTAMP WHERE id = $1`, escalation.ChatID); err != nil {
+		return nil, err
+	}
+
+	return escalation, tx.Commit()
+}
+
+func (r *postgresEscalations) Resolve(id, from, to, outcome string) (*models.Escalation, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	escalation, err := scanEscalation(tx.QueryRow(`UPDATE chat_escalations
+			  SET status = $1, outcome = $2, resolved_at = CURRENT_TIMESTAMP
+			  WHERE id = $3 AND status = $4
+			  RETURNING `+escalationColumns, to, outcome, id, from))
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := tx.Exec(`UPDATE chats SET is_escalated = false, updated_at = CURRENT_TIMESTAMP WHERE id = $1`, escalation.ChatID); err != nil {
+		return nil, err
+	}
+
+	if escalation.ClaimedBy != nil {
+		_, err := tx.Exec(`DELETE FROM chat_participants
+				  WHERE chat_id = $1 AND user_id = $2 AND role = 'assistant'`, escalation.ChatID, *escalation.ClaimedBy)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return escalation, tx.Commit()
+}
+
+func (r *postgresEscalations) List(filter EscalationFilter) ([]models.Escalation, error) {
+	rows, err := r.db.Query(`SELECT `+escalationColumns+` FROM chat_escalations
+			  WHERE ($1 = '' OR status = $1)
+			  AND ($2 = '' OR chat_id::text = $2)
+			  AND ($3 = '' OR requested_by::text = $3)
+			  ORDER BY created_at DESC`, filter.Status, filter.ChatID, filter.RequestedBy)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	escalations := []models.Escalation{}
+	for rows.Next() {
+		escalation, err := scanEscalation(rows)
+		if err != nil {
+			return nil, err
+		}
+		escalations = append(escalations, *escalation)
+	}
+
+	return escalations, rows.Err()
+}
+
+type postgresTags struct {
+	db *sql.DB
+}
+
+const tagColumns = `id, name, description, color, created_at, updated_at`
+
+func scanTag(row RowScanner, tag *models.Tag) error {
+	return row.Scan(&tag.ID, &tag.Name, &tag.Description, &tag.Color, &tag.CreatedAt, &tag.UpdatedAt)
+}
+
+func (r *postgresTags) List() ([]models.Tag, error) {
+	rows, err := r.db.Query(`SELECT ` + tagColumns + ` FROM tags ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tags := []models.Tag{}
+	for rows.Next() {
+		var tag models.Tag
+		if err := scanTag(rows, &tag); err != nil {
+			return nil, err
+		}
+		tags = append(tags, tag)
+	}
+
+	return tags, rows.Err()
+}
+
+func (r *postgresTags) Create(tag *models.Tag) error {
+	err := scanTag(r.db.QueryRow(`INSERT INTO tags (name, description, color, created_at, updated_at)
+			  VALUES ($1, $2, $3, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+			  RETURNING `+tagColumns, tag.Name, tag.Description, tag.Color), tag)
+	if IsUniqueViolation(err) {
+		return ErrDuplicate
+	}
+	return err
+}
+
+func (r *postgresTags) Update(tag *models.Tag) error {
+	err := scanTag(r.db.QueryRow(`UPDATE tags SET name = $1, description = $2, color = $3, updated_at = CURRENT_TIMESTAMP
+			  WHERE id = $4
+			  RETURNING `+tagColumns, tag.Name, tag.Description, tag.Color, tag.ID), tag)
+	if IsUniqueViolation(err) {
+		return ErrDuplicate
+	}
+	return err
+}
+
+func (r *postgresTags) Delete(id string) error {
+	// Chats lose the tag through the foreign key's ON DELETE CASCADE
+	result, err := r.db.Exec(`DELETE FROM tags WHERE id = $1`, id)
+	return checkUpdated(result, err)
+}
+
+func (r *postgresTags) AddToChat(chatID, tagID, userID string) error {
+	var exists bool
+	if err := r.db.QueryRow(`SELECT EXISTS (SELECT 1 FROM tags WHERE id = $1)`, tagID).Scan(&exists); err != nil {
+		return err
+	}
+	if !exists {
+		return sql.ErrNoRows
+	}
+
+	_, err := r.db.Exec(`WITH added AS (
+			      INSERT INTO chat_tags (chat_id, tag_id, added_by, added_at)
+			      VALUES ($1, $2, $3, CURRENT_TIMESTAMP)
+			      ON CONFLICT (chat_id, tag_id) DO NOTHING
+			      RETURNING chat_id)
+			  UPDATE chats SET updated_at = CURRENT_TIMESTAMP WHERE id IN (SELECT chat_id FROM added)`, chatID, tagID, userID)
+	return err
+}
+
+func (r *postgresTags) RemoveFromChat(chatID, tagID string) error {
+	_, err := r.db.Exec(`WITH removed AS (
+			      DELETE FROM chat_tags WHERE chat_id = $1 AND tag_id = $2
+			      RETURNING chat_id)
+			  UPDATE chats SET updated_at = CURRENT_TIMESTAMP WHERE id IN (SELECT chat_id FROM removed)`, chatID, tagID)
+	return err
+}
+
+func (r *postgresTags) Stats(filter models.TagStatsFilter) ([]models.TagCount, error) {
+	var conditions []string
+	args := []interface{}{filter.Interval}
+	add := func(condition string, value interface{}) {
+		args = append(args, value)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+	if filter.Tag != "" {
+		add("t.name = $%d", filter.Tag)
+	}
+	if filter.From != nil {
+		add("ct.added_at >= $%d", *filter.From)
+	}
+	if filter.To != nil {
+		add("ct.added_at < $%d", *filter.To)
+	}
+
+	where := ""
+	if len(conditions) > 0 {
+		where = "WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	rows, err := r.db.Query(`SELECT date_trunc($1, ct.added_at) AS period, t.id, t.name, COUNT(*)
+			  FROM chat_tags ct
+			  JOIN tags t ON t.id = ct.tag_id
+			  `+where+`
+			  GROUP BY period, t.id, t.name
+			  ORDER BY period, t.name`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := []models.TagCount{}
+	for rows.Next() {
+		var count models.TagCount
+		if err := rows.Scan(&count.Period, &count.TagID, &count.Tag, &count.Count); err != nil {
+			return nil, err
+		}
+		counts = append(counts, count)
+	}
+
+	return counts, rows.Err()
+}
+
+type postgresCSAT struct {
+	db *sql.DB
+}
+
+const csatColumns = `id, chat_id, customer_id, agent_id, team, score, comment, created_at, updated_at`
+
+func scanCSATRating(row RowScanner) (*models.CSATRating, error) {
+	var rating models.CSATRating
+	err := row.Scan(&rating.ID, &rating.ChatID, &rating.CustomerID, &rating.AgentID, &rating.Team,
+		&rating.Score, &rating.Comment, &rating.CreatedAt, &rating.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &rating, nil
+}
+
+func (r *postgresCSAT) Rated(chatID string) (bool, error) {
+	var rated bool
+	err := r.db.QueryRow(`SELECT EXISTS (SELECT 1 FROM csat_ratings WHERE chat_id = $1)`, chatID).Scan(&rated)
+	return rated, err
+}
+
+func (r *postgresCSAT) Submit(chatID string, score int, comment *string) (*models.CSATRating, error) {
+	return scanCSATRating(r.db.QueryRow(`INSERT INTO csat_ratings (chat_id, customer_id, agent_id, team, score, comment, created_at, updated_at)
+			  SELECT c.id, c.customer_id, c.agent_id, a.team, $2, $3, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP
+			  FROM chats c LEFT JOIN users a ON a.id = c.agent_id
+			  WHERE c.id = $1
+			  ON CONFLICT (chat_id) DO UPDATE SET
+			  score = EXCLUDED.score, comment = EXCLUDED.comment, updated_at = CURRENT_TIMESTAMP
+			  RETURNING `+csatColumns, chatID, score, comment))
+}
+
+func (r *postgresCSAT) Summary(filter models.CSATFilter) ([]models.CSATSummary, error) {
+	key, label := "''", "''"
+	switch filter.GroupBy {
+	case "agent":
+		key, label = "COALESCE(r.agent_id::text, '')", "COALESCE(MAX(a.name), '')"
+	case "team":
+		key, label = "COALESCE(r.team, '')", "COALESCE(r.team, '')"
+	}
+
+	var where conditions
+	if filter.AgentID != "" {
+		where.and(`r.agent_id = ` + where.param(filter.AgentID))
+	}
+	if filter.Team != "" {
+		where.and(`r.team = ` + where.param(filter.Team))
+	}
+	if filter.From != nil {
+		where.and(`r.created_at >= ` + where.param(*filter.From))
+	}
+	if filter.To != nil {
+		where.and(`r.created_at < ` + where.param(*filter.To))
+	}
+
+	rows, err := r.db.Query(fmt.Sprintf(`SELECT %s, %s, COUNT(*), AVG(r.score)::float8,
+			  100.0 * COUNT(*) FILTER (WHERE r.score >= 4) / COUNT(*),
+			  COUNT(*) FILTER (WHERE r.score = 1), COUNT(*) FILTER (WHERE r.score = 2),
+			  COUNT(*) FILTER (WHERE r.score = 3), COUNT(*) FILTER (WHERE r.score = 4),
+			  COUNT(*) FILTER (WHERE r.score = 5)
+			  FROM csat_ratings r LEFT JOIN users a ON a.id = r.agent_id
+			  %s
+			  GROUP BY 1
+			  ORDER BY 4 DESC`, key, label, where.where()), where.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	summaries := []models.CSATSummary{}
+	for rows.Next() {
+		var summary models.CSATSummary
+		d := &summary.Distribution
+		err := rows.Scan(&summary.Key, &summary.Label, &summary.Responses, &summary.Average, &summary.Satisfaction,
+			&d[0], &d[1], &d[2], &d[3], &d[4])
+		if err != nil {
+			return nil, err
+		}
+		summaries = append(summaries, summary)
+	}
+
+	return summaries, rows.Err()
+}
+
+type postgresCanned struct {
+	db *sql.DB
+}
+
+const cannedColumns = `id, owner_id, scope, team, shortcut, title, content, created_at, updated_at`
+
+func scanCannedResponse(row RowScanner, response *models.CannedResponse) error {
+	return row.Scan(&response.ID, &response.OwnerID, &response.Scope, &response.Team,
+		&response.Shortcut, &response.Title, &response.Content, &response.CreatedAt, &response.UpdatedAt)
+}
+
+func (r *postgresCanned) List(ownerID, team string, allTeams bool, query string) ([]models.CannedResponse, error) {
+	rows, err := r.db.Query(`SELECT `+cannedColumns+` FROM canned_responses
+			  WHERE ((scope = 'personal' AND owner_id = $1)
+			         OR (scope = 'team' AND ($2 OR team = $3)))
+			  AND ($4 = '' OR shortcut ILIKE '%' || $4 || '%' OR title ILIKE '%' || $4 || '%')
+			  ORDER BY shortcut, scope`, ownerID, allTeams, team, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	responses := []models.CannedResponse{}
+	for rows.Next() {
+		var response models.CannedResponse
+		if err := scanCannedResponse(rows, &response); err != nil {
+			return nil, err
+		}
+		responses = append(responses, response)
+	}
+
+	return responses, rows.Err()
+}
+
+func (r *postgresCanned) Get(id string) (*models.CannedResponse, error) {
+	var response models.CannedResponse
+	if err := scanCannedResponse(r.db.QueryRow(`SELECT `+cannedColumns+` FROM canned_responses WHERE id = $1`, id), &response); err != nil {
+		return nil, err
+	}
+	return &response, nil
+}
+
+func (r *postgresCanned) Create(response *models.CannedResponse) error {
+	err := scanCannedResponse(r.db.QueryRow(`INSERT INTO canned_responses
+			  (owner_id, scope, team, shortcut, title, content, created_at, updated_at)
+			  VALUES ($1, $2, $3, $4, $5, $6, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+			  RETURNING `+cannedColumns,
+		response.OwnerID, response.Scope, response.Team, response.Shortcut, response.Title, response.Content), response)
+	if IsUniqueViolation(err) {
+		return ErrDuplicate
+	}
+	return err
+}
+
+func (r *postgresCanned) Update(response *models.CannedResponse) error {
+	err := scanCannedResponse(r.db.QueryRow(`UPDATE canned_responses
+			  SET scope = $1, team = $2, shortcut = $3, title = $4, content = $5, updated_at = CURRENT_TIMESTAMP
+			  WHERE id = $6
+			  RETURNING `+cannedColumns,
+		response.Scope, response.Team, response.Shortcut, response.Title, response.Content, response.ID), response)
+	if IsUniqueViolation(err) {
+		return ErrDuplicate
+	}
+	return err
+}
+
+func (r *postgresCanned) Delete(id string) error {
+	_, err := r.db.Exec(`DELETE FROM canned_responses WHERE id = $1`, id)
+	return err
+}
+
+func (r *postgresCanned) RecordUse(responseID, chatID, agentID, messageID string) error {
+	_, err := r.db.Exec(`INSERT INTO canned_response_usage (canned_response_id, chat_id, agent_id, message_id, used_at)
+			  VALUES ($1, $2, $3, $4, CURRENT_TIMESTAMP)`, responseID, chatID, agentID, messageID)
+	return err
+}
+
+func (r *postgresCanned) Stats(filter models.CannedStatsFilter) ([]models.CannedResponseStats, error) {
+	args := []interface{}{filter.Team}
+	usage := ""
+	if filter.From != nil {
+		args = append(args, *filter.From)
+		usage += fmt.Sprintf(" AND used_at >= $%d", len(args))
+	}
+	if filter.To != nil {
+		args = append(args, *filter.To)
+		usage += fmt.Sprintf(" AND used_at < $%d", len(args))
+	}
+
+	// Outcomes are counted once per chat, however often a response was used
+	// in it.
+	query := `WITH used AS (
+			      SELECT canned_response_id, COUNT(*) AS uses,
+			      COUNT(DISTINCT chat_id) AS chats, COUNT(DISTINCT agent_id) AS agents
+			      FROM canned_response_usage WHERE TRUE` + usage + `
+			      GROUP BY canned_response_id
+			  ), outcomes AS (
+			      SELECT cu.canned_response_id,
+			      COUNT(*) FILTER (WHERE c.resolution_code = 'resolved') AS resolved,
+			      COUNT(r.id) AS ratings, AVG(r.score)::float8 AS average
+			      FROM (SELECT DISTINCT canned_response_id, chat_id FROM canned_response_usage
+			            WHERE TRUE` + usage + `) cu
+			      JOIN chats c ON c.id = cu.chat_id
+			      LEFT JOIN csat_ratings r ON r.chat_id = cu.chat_id
+			      GROUP BY cu.canned_response_id
+			  )
+			  SELECT cr.id, cr.shortcut, cr.title, cr.scope, cr.team,
+			  COALESCE(used.uses, 0), COALESCE(used.chats, 0), COALESCE(used.agents, 0),
+			  COALESCE(o.resolved, 0), COALESCE(o.ratings, 0), o.average
+			  FROM canned_responses cr
+			  JOIN users owner ON owner.id = cr.owner_id
+			  LEFT JOIN used ON used.canned_response_id = cr.id
+			  LEFT JOIN outcomes o ON o.canned_response_id = cr.id
+			  WHERE ($1 = '' OR owner.team = $1)
+			  ORDER BY COALESCE(used.uses, 0) DESC, cr.shortcut`
+
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	stats := []models.CannedResponseStats{}
+	for rows.Next() {
+		var st models.CannedResponseStats
+		err := rows.Scan(&st.ID, &st.Shortcut, &st.Title, &st.Scope, &st.Team,
+			&st.Uses, &st.Chats, &st.Agents, &st.ResolvedChats, &st.CSATResponses, &st.AverageCSAT)
+		if err != nil {
+			return nil, err
+		}
+		stats = append(stats, st)
+	}
+
+	return stats, rows.Err()
+}
+
+type postgresSLA struct {
+	db *sql.DB
+}
+
+const slaPolicyColumns = `id, name, priority, topic, first_response_warning_secs, first_response_breach_secs,
+		resolution_warning_secs, resolution_breach_secs, created_at, updated_at`
+
+func scanSLAPolicy(row RowScanner, policy *models.SLAPolicy) error {
+	return row.Scan(
+		&policy.ID, &policy.Name, &policy.Priority, &policy.Topic,
+		&policy.FirstResponseWarning, &policy.FirstResponseBreach,
+		&policy.ResolutionWarning, &policy.ResolutionBreach,
+		&policy.CreatedAt, &policy.UpdatedAt,
+	)
+}
+
+func (r *postgresSLA) Policies() ([]models.SLAPolicy, error) {
+	rows, err := r.db.Query(`SELECT ` + slaPolicyColumns + ` FROM sla_policies ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var policies []models.SLAPolicy
+	for rows.Next() {
+		var policy models.SLAPolicy
+		if err := scanSLAPolicy(rows, &policy); err != nil {
+			return nil, err
+		}
+		policies = append(policies, policy)
+	}
+
+	return policies, rows.Err()
+}
+
+func (r *postgresSLA) GetPolicy(id string) (*models.SLAPolicy, error) {
+	var policy models.SLAPolicy
+	if err := scanSLAPolicy(r.db.QueryRow(`SELECT `+slaPolicyColumns+` FROM sla_policies WHERE id = $1`, id), &policy); err != nil {
+		return nil, err
+	}
+	return &policy, nil
+}
+
+func (r *postgresSLA) CreatePolicy(p *models.SLAPolicy) error {
+	return scanSLAPolicy(r.db.QueryRow(`INSERT INTO sla_policies (name, priority, topic, first_response_warning_secs, first_response_breach_secs,
+			  resolution_warning_secs, resolution_breach_secs, created_at, updated_at)
+			  VALUES ($1, $2, $3, $4, $5, $6, $7, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+			  RETURNING `+slaPolicyColumns, p.Name, p.Priority, p.Topic,
+		p.FirstResponseWarning, p.FirstResponseBreach, p.ResolutionWarning, p.ResolutionBreach), p)
+}
+
+func (r *postgresSLA) UpdatePolicy(p *models.SLAPolicy) error {
+	return scanSLAPolicy(r.db.QueryRow(`UPDATE sla_policies SET name = $1, priority = $2, topic = $3,
+			  first_response_warning_secs = $4, first_response_breach_secs = $5,
+			  resolution_warning_secs = $6, resolution_breach_secs = $7, updated_at = CURRENT_TIMESTAMP
+			  WHERE id = $8
+			  RETURNING `+slaPolicyColumns, p.Name, p.Priority, p.Topic,
+		p.FirstResponseWarning, p.FirstResponseBreach, p.ResolutionWarning, p.ResolutionBreach, p.ID), p)
+}
+
+func (r *postgresSLA) DeletePolicy(id string) error {
+	_, err := r.db.Exec(`DELETE FROM sla_policies WHERE id = $1`, id)
+	return err
+}
+
+func (r *postgresSLA) OpenChats() ([]SLAChat, error) {
+	rows, err := r.db.Query(`SELECT c.id, c.agent_id, c.topic, c.priority, c.created_at,
+			  EXISTS (SELECT 1 FROM messages m
+			          WHERE m.chat_id = c.id AND m.sender_id != c.customer_id
+			          AND m.visibility = 'public' AND m.message_type NOT IN ('system', 'csat_prompt'))
+			  FROM chats c
+			  WHERE c.status IN ('queued', 'active', 'pending_customer')`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var chats []SLAChat
+	for rows.Next() {
+		var c SLAChat
+		if err := rows.Scan(&c.ID, &c.AgentID, &c.Topic, &c.Priority, &c.CreatedAt, &c.Responded); err != nil {
+			return nil, err
+		}
+		chats = append(chats, c)
+	}
+
+	return chats, rows.Err()
+}
+
+func (r *postgresSLA) Messages(chatID string) ([]SLAMessage, error) {
+	rows, err := r.db.Query(`SELECT m.sender_id = c.customer_id, m.created_at
+			  FROM messages m JOIN chats c ON c.id = m.chat_id
+			  WHERE m.chat_id = $1 AND m.visibility = 'public' AND m.message_type NOT IN ('system', 'csat_prompt')
+			  ORDER BY m.created_at`, chatID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var messages []SLAMessage
+	for rows.Next() {
+		var m SLAMessage
+		if err := rows.Scan(&m.FromCustomer, &m.At); err != nil {
+			return nil, err
+		}
+		messages = append(messages, m)
+	}
+
+	return messages, rows.Err()
+}
+
+const slaEventColumns = `id, chat_id, policy_id, agent_id, metric, level, threshold_secs, elapsed_secs, created_at`
+
+func scanSLAEvent(row RowScanner, event *models.SLAEvent) error {
+	return row.Scan(
+		&event.ID, &event.ChatID, &event.PolicyID, &event.AgentID, &event.Metric,
+		&event.Level, &event.Threshold, &event.Elapsed, &event.CreatedAt,
+	)
+}
+
+func (r *postgresSLA) RecordEvent(e *models.SLAEvent) error {
+	err := scanSLAEvent(r.db.QueryRow(`INSERT INTO sla_events (chat_id, policy_id, agent_id, metric, level, threshold_secs, elapsed_secs, created_at)
+			  VALUES ($1, $2, $3, $4, $5, $6, $7, CURRENT_TIMESTAMP)
+			  ON CONFLICT (chat_id, metric, level) DO NOTHING
+			  RETURNING `+slaEventColumns,
+		e.ChatID, e.PolicyID, e.AgentID, e.Metric, e.Level, e.Threshold, e.Elapsed), e)
+	if err == sql.ErrNoRows {
+		return ErrDuplicate
+	}
+	return err
+}
+
+func (r *postgresSLA) Events(filter models.SLAEventFilter) ([]models.SLAEvent, error) {
+	var where conditions
+	if filter.ChatID != "" {
+		where.and(`chat_id = ` + where.param(filter.ChatID))
+	}
+	if filter.AgentID != "" {
+		where.and(`agent_id = ` + where.param(filter.AgentID))
+	}
+	if filter.Level != "" {
+		where.and(`level = ` + where.param(filter.Level))
+	}
+	if filter.From != nil {
+		where.and(`created_at >= ` + where.param(*filter.From))
+	}
+	if filter.To != nil {
+		where.and(`created_at < ` + where.param(*filter.To))
+	}
+
+	rows, err := r.db.Query(`SELECT `+slaEventColumns+` FROM sla_events
+			  `+where.where()+`
+			  ORDER BY created_at DESC`, where.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []models.SLAEvent{}
+	for rows.Next() {
+		var event models.SLAEvent
+		if err := scanSLAEvent(rows, &event); err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+
+	return events, rows.Err()
+}
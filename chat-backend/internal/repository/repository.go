@@ -0,0 +1,379 @@
+// Package repository stores users, chats, messages, escalations, tags, CSAT
+// ratings, canned responses and SLA records. Services depend on the
+// interfaces here, so that they can run against PostgreSQL in production and
+// against memory in tests.
+package repository
+
+import (
+	"errors"
+	"time"
+
+	"cs-socket/internal/models"
+)
+
+// ErrDuplicate is returned when a record would take a unique value, such as a
+// username, that another record already has. Missing records are reported
+// with sql.ErrNoRows.
+var ErrDuplicate = errors.New("already exists")
+
+// Errors of a status change whose chat changed after it was loaded.
+var (
+	// ErrAssigned is returned when a chat to be assigned already has an
+	// agent.
+	ErrAssigned = errors.New("already assigned")
+	// ErrStale is returned when a chat no longer has the status it was
+	// loaded with.
+	ErrStale = errors.New("changed concurrently")
+)
+
+// Repositories groups the repositories of one store.
+type Repositories struct {
+	Users       UserRepository
+	Chats       ChatRepository
+	Messages    MessageRepository
+	Escalations EscalationRepository
+	Tags        TagRepository
+	CSAT        CSATRepository
+	Canned      CannedRepository
+	SLA         SLARepository
+}
+
+type UserRepository interface {
+	GetByID(id string) (*models.User, error)
+	// GetByUsername returns the user with their password hash.
+	GetByUsername(username string) (*models.User, error)
+	// Create stores a new user, whose Password is the password hash, and
+	// fills in the stored defaults. The returned user has no password.
+	Create(user models.User) (*models.User, error)
+	// List returns users by name, only agents and super-agents if staffOnly.
+	// The system user is never listed.
+	List(staffOnly bool) ([]models.User, error)
+	SetOnline(id string, online bool) error
+	// SetTier changes the tier of a customer.
+	SetTier(id, tier string) error
+	// SetTeam changes the team of an agent or super-agent.
+	SetTeam(id string, team *string) error
+	SetRole(id, role string) error
+	// SetPassword replaces the user's password hash.
+	SetPassword(id, hash string) error
+	// AvailableAgents returns the online agents who are not busy with another
+	// customer's open chat.
+	AvailableAgents(customerID string) ([]models.User, error)
+	// AvailableCustomers returns the online customers who have no open chat
+	// with another agent.
+	AvailableCustomers(agentID string) ([]models.User, error)
+}
+
+type ChatRepository interface {
+	// Get returns a chat with its customer, agent and tags.
+	Get(id string) (*models.Chat, error)
+	// Create stores a new chat with its first status history entry and
+	// fills in its timestamps.
+	Create(chat *models.Chat) error
+	// Delete removes a chat with its messages.
+	Delete(id string) error
+	// Assistants returns the super-agents assisting with a chat.
+	Assistants(chatID string) ([]string, error)
+	// MarkRead moves the user's read position in a chat forward to seq, in
+	// the numbering the user sees.
+	MarkRead(chatID, userID string, seq int64) error
+
+	// ChangeStatus moves a chat from one status to another and records the
+	// change in its status history. Reopening a chat clears its wrap-up
+	// details and releasing it to the queue unassigns its agent. It returns
+	// ErrAssigned if AssignTo is set and the chat already has an agent, and
+	// ErrStale if the chat's status is no longer From.
+	ChangeStatus(change StatusChange) error
+	// StatusHistory returns the status changes of a chat, oldest first.
+	StatusHistory(chatID string) ([]models.ChatStatusChange, error)
+
+	// List returns a page of the chats of a listing, each with the reader's
+	// unread count.
+	List(listing ChatListing) ([]models.Chat, error)
+	// Queue returns up to limit unassigned queued chats, or all of them if
+	// limit is zero, in the order they should be picked up: highest priority
+	// first, then oldest first.
+	Queue(limit int) ([]models.Chat, error)
+	// SetPriority changes a chat's priority and bumps it for sync.
+	SetPriority(id, priority string) error
+
+	// Now returns the current time of the store, which change times are
+	// taken from.
+	Now() (time.Time, error)
+	// Changed returns up to limit chats in scope that changed after since,
+	// least recently changed first.
+	Changed(scope ChatScope, since time.Time, limit int) ([]models.Chat, error)
+
+	// IdleChats returns the assigned active and pending_customer chats with
+	// the times their customer and staff last spoke. Messages of the user
+	// systemUserID count for neither side.
+	IdleChats(systemUserID string) ([]IdleChat, error)
+	// SetIdleNudged records when a chat's customer was nudged, or clears it
+	// if at is nil.
+	SetIdleNudged(chatID string, at *time.Time) error
+}
+
+// ChatScope limits chats to those a user may see. Empty fields match every
+// chat.
+type ChatScope struct {
+	CustomerID string
+	// AgentID limits chats to those assigned to the agent, and to unassigned
+	// ones as well if WithUnassigned is set.
+	AgentID        string
+	WithUnassigned bool
+}
+
+// ChatListing selects a page of chats. The filter's sort and order must be
+// valid; its limit and offset page the listing as they are given. Unread
+// counts are those of ReaderID, who counts staff-only messages if Staff is
+// set.
+type ChatListing struct {
+	Scope ChatScope
+	// Archived lists the archived chats instead of all the others.
+	Archived bool
+	Filter   models.ChatFilter
+	ReaderID string
+	Staff    bool
+}
+
+// IdleChat is the state of an open chat that the idle monitor looks at.
+type IdleChat struct {
+	ID             string
+	AgentID        string
+	Topic          string
+	NudgedAt       *time.Time
+	LastCustomerAt *time.Time
+	LastStaffAt    *time.Time
+}
+
+// StatusChange is a move of a chat between statuses. ChangedBy is nil for
+// changes made by the system.
+type StatusChange struct {
+	ChatID string
+	From   string
+	To     string
+	// AssignTo, if set, assigns the unassigned chat to this agent in the
+	// same transaction.
+	AssignTo       *string
+	ChangedBy      *string
+	ResolutionCode *string
+	Note           *string
+}
+
+// NewMessage is a message to be stored. Metadata is JSON.
+type NewMessage struct {
+	ChatID      string
+	SenderID    string
+	Content     string
+	MessageType string
+	Visibility  string
+	Metadata    []byte
+	ReplyToID   *string
+}
+
+type MessageRepository interface {
+	// Get returns a message with its sender and the message it replies to.
+	// Deleted messages are tombstones without content.
+	Get(id string) (*models.Message, error)
+	// Insert numbers and stores a message, bumps its chat for public ones
+	// and marks the chat read for the sender up to the message.
+	Insert(m NewMessage) (*models.Message, error)
+	// Page returns up to limit messages of a chat in chronological order:
+	// the ones right before or after the given message, or the newest ones.
+	// Staff-only messages are left out unless includeStaff is set.
+	Page(chatID string, includeStaff bool, before, after string, limit int) ([]models.Message, error)
+	// Latest returns the newest message of each chat that has one.
+	Latest(chatIDs []string, includeStaff bool) (map[string]*models.Message, error)
+	// Touch marks a message as changed for sync.
+	Touch(id string) error
+
+	AddReaction(messageID, userID, emoji string) error
+	RemoveReaction(messageID, userID, emoji string) error
+	// Reactions returns the reactions of each message, in the order each
+	// emoji was first used.
+	Reactions(messageIDs []string) (map[string][]models.Reaction, error)
+
+	// Attachments returns the attachments of the given messages, oldest
+	// first, without thumbnails.
+	Attachments(messageIDs []string) ([]*models.Attachment, error)
+	// Thumbnails returns the thumbnails of each attachment, smallest first.
+	Thumbnails(attachmentIDs []string) (map[string][]models.Thumbnail, error)
+	// GetAttachment returns an attachment without thumbnails, unless the
+	// message it was sent with has been deleted.
+	GetAttachment(id string) (*models.Attachment, error)
+	// CreateAttachment stores an attachment that belongs to no message yet,
+	// with its thumbnails, and fills in its creation time.
+	CreateAttachment(attachment *models.Attachment) error
+	// DeleteAttachment removes an attachment with its thumbnails.
+	DeleteAttachment(id string) error
+	// LinkAttachments makes the given attachments belong to a message.
+	LinkAttachments(messageID string, attachmentIDs []string) error
+
+	// Edit replaces the content of a message and keeps the previous content
+	// in its edit history. Only messages that are not deleted and were sent
+	// within window, or at any time if window is zero, can be edited; it
+	// returns sql.ErrNoRows for others.
+	Edit(id, editorID, content string, window time.Duration) error
+	// Delete turns a message into a tombstone and returns when it was
+	// deleted. Like Edit, it returns sql.ErrNoRows for messages that are
+	// already deleted or were sent before window.
+	Delete(id, userID string, window time.Duration) (time.Time, error)
+	// History returns a message with its content, even if it was deleted,
+	// who deleted it and its earlier versions, oldest first.
+	History(id string) (*models.MessageHistory, error)
+
+	// InsertNote stores a message like Insert and, with it, records the
+	// agents and super-agents among usernames, other than the sender, as
+	// mentioned in it. It returns the message and the mentioned users' IDs.
+	InsertNote(m NewMessage, usernames []string) (*models.Message, []string, error)
+	// Mentions returns up to limit messages the user was mentioned in, most
+	// recently mentioned first.
+	Mentions(userID string, limit int) ([]models.Mention, error)
+
+	// Search finds messages that are not deleted in the chats in scope, best
+	// matches first. Staff-only messages are left out unless includeStaff is
+	// set. The filter's limit and offset must be valid.
+	Search(scope ChatScope, filter models.MessageSearchFilter, includeStaff bool) ([]models.MessageSearchResult, error)
+	// Changed returns up to limit messages of the chats in scope that changed
+	// after since, including deleted ones, least recently changed first.
+	// Staff-only messages are left out unless includeStaff is set.
+	Changed(scope ChatScope, since time.Time, includeStaff bool, limit int) ([]models.Message, error)
+
+	// ClaimScans picks up to limit pending attachments, already sent with a
+	// message, that were not tried within retryAfter, and counts the attempt
+	// so that other instances skip them.
+	ClaimScans(retryAfter time.Duration, limit int) ([]ScanClaim, error)
+	// RecordScan saves the outcome of an attachment's scan and returns the
+	// attachment without thumbnails.
+	RecordScan(attachmentID, status string, signature *string) (*models.Attachment, error)
+}
+
+// ScanClaim is an attachment claimed for scanning. Attempts includes the
+// current one.
+type ScanClaim struct {
+	AttachmentID string
+	StorageKey   string
+	Attempts     int
+}
+
+// EscalationFilter narrows escalation listings. Empty fields match every
+// escalation.
+type EscalationFilter struct {
+	Status      string
+	ChatID      string
+	RequestedBy string
+}
+
+type EscalationRepository interface {
+	Get(id string) (*models.Escalation, error)
+	// Create opens an escalation of a chat and flags the chat as escalated.
+	// It returns ErrDuplicate if the chat already has an open or claimed
+	// escalation.
+	Create(chatID, requestedBy, reason string) (*models.Escalation, error)
+	// Claim gives an open escalation to a super-agent, who joins its chat as
+	// an assistant. It returns sql.ErrNoRows if the escalation is not open.
+	Claim(id, userID string) (*models.Escalation, error)
+	// Resolve moves an escalation from status from to status to with an
+	// outcome, clears its chat's escalation flag and ends the assistance of
+	// the super-agent who claimed it. It returns sql.ErrNoRows if the
+	// escalation's status is no longer from.
+	Resolve(id, from, to, outcome string) (*models.Escalation, error)
+	// List returns escalations, newest first.
+	List(filter EscalationFilter) ([]models.Escalation, error)
+}
+
+type TagRepository interface {
+	// List returns the tag catalogue ordered by name.
+	List() ([]models.Tag, error)
+	// Create stores a tag and fills in its ID and timestamps. It returns
+	// ErrDuplicate if another tag has the name.
+	Create(tag *models.Tag) error
+	// Update changes a tag's name, description and color. It returns
+	// ErrDuplicate if another tag has the name.
+	Update(tag *models.Tag) error
+	// Delete removes a tag from the catalogue and from every chat.
+	Delete(id string) error
+	// AddToChat tags a chat and bumps it for sync, unless it already has
+	// the tag. It returns sql.ErrNoRows if there is no such tag.
+	AddToChat(chatID, tagID, userID string) error
+	// RemoveFromChat untags a chat and bumps it for sync, if it had the tag.
+	RemoveFromChat(chatID, tagID string) error
+	// Stats counts the chats tagged with each tag per filter.Interval, a
+	// day, week or month, by when the tag was added, ordered by period and
+	// tag name.
+	Stats(filter models.TagStatsFilter) ([]models.TagCount, error)
+}
+
+type CSATRepository interface {
+	// Rated reports whether a chat has been rated.
+	Rated(chatID string) (bool, error)
+	// Submit stores a chat's rating together with the chat's agent and that
+	// agent's team, replacing an earlier rating of the chat.
+	Submit(chatID string, score int, comment *string) (*models.CSATRating, error)
+	// Summary aggregates ratings per filter.GroupBy, which must be valid,
+	// highest average first.
+	Summary(filter models.CSATFilter) ([]models.CSATSummary, error)
+}
+
+type CannedRepository interface {
+	// List returns by shortcut the personal responses of ownerID and the team
+	// responses of team, or of every team if allTeams is set, whose shortcut
+	// or title contains query, ignoring case.
+	List(ownerID, team string, allTeams bool, query string) ([]models.CannedResponse, error)
+	Get(id string) (*models.CannedResponse, error)
+	// Create stores a response and fills in its ID and timestamps. It returns
+	// ErrDuplicate if the owner's personal responses, or the team's
+	// responses, already use the shortcut.
+	Create(response *models.CannedResponse) error
+	// Update changes a response's scope, team, shortcut, title and content.
+	// It returns ErrDuplicate like Create.
+	Update(response *models.CannedResponse) error
+	Delete(id string) error
+	// RecordUse records that an agent sent a response as a message.
+	RecordUse(responseID, chatID, agentID, messageID string) error
+	// Stats reports the use of every response whose owner is in filter.Team,
+	// or of every response without a team, most used first.
+	Stats(filter models.CannedStatsFilter) ([]models.CannedResponseStats, error)
+}
+
+type SLARepository interface {
+	// Policies returns the policies ordered by name.
+	Policies() ([]models.SLAPolicy, error)
+	GetPolicy(id string) (*models.SLAPolicy, error)
+	// CreatePolicy stores a policy and fills in its ID and timestamps.
+	CreatePolicy(policy *models.SLAPolicy) error
+	// UpdatePolicy changes a policy's name, match and thresholds.
+	UpdatePolicy(policy *models.SLAPolicy) error
+	DeletePolicy(id string) error
+
+	// OpenChats returns the queued, active and pending_customer chats with
+	// whether staff have answered them.
+	OpenChats() ([]SLAChat, error)
+	// Messages returns the public messages of a chat that count towards its
+	// response times, oldest first: all but system messages and CSAT prompts.
+	Messages(chatID string) ([]SLAMessage, error)
+
+	// RecordEvent stores a warning or breach and fills in its ID and creation
+	// time. It returns ErrDuplicate if the chat already has an event of the
+	// same metric and level.
+	RecordEvent(event *models.SLAEvent) error
+	// Events returns the recorded warnings and breaches, most recent first.
+	Events(filter models.SLAEventFilter) ([]models.SLAEvent, error)
+}
+
+// SLAChat is an open chat checked against SLA policies. Responded says
+// whether staff have answered it.
+type SLAChat struct {
+	ID        string
+	AgentID   *string
+	Topic     string
+	Priority  string
+	CreatedAt time.Time
+	Responded bool
+}
+
+// SLAMessage is a message that counts towards a chat's response times.
+type SLAMessage struct {
+	FromCustomer bool
+	At           time.Time
+}
@@ -0,0 +1,159 @@
+package repository
+
+import (
+	"database/sql"
+	"strings"
+
+	"cs-socket/internal/models"
+
+	"github.com/lib/pq"
+)
+
+// The SQL shapes below are shared by the PostgreSQL repositories.
+
+// ChatSelect selects a chat together with its customer and agent. It is
+// scanned by ScanChat.
+const ChatSelect = `SELECT ` + ChatColumns + `
+		` + ChatFrom
+
+const ChatColumns = `c.id, c.customer_id, c.agent_id, c.topic, c.priority, c.is_escalated, c.status, c.resolution_code, c.wrap_up_notes,
+		c.resolved_at, c.closed_at, c.created_at, c.updated_at,
+		ARRAY(SELECT t.name FROM chat_tags ct JOIN tags t ON t.id = ct.tag_id WHERE ct.chat_id = c.id ORDER BY t.name),
+		u1.id, u1.username, u1.name, u1.role, u1.tier, u1.avatar, u1.is_online,
+		u2.id, u2.username, u2.name, u2.role, u2.avatar, u2.is_online`
+
+const ChatFrom = `FROM chats c
+		LEFT JOIN users u1 ON c.customer_id = u1.id
+		LEFT JOIN users u2 ON c.agent_id = u2.id`
+
+// RowScanner is a *sql.Row or *sql.Rows.
+type RowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// ScanChat scans a row of ChatSelect, followed by any extra columns.
+func ScanChat(row RowScanner, extra ...interface{}) (*models.Chat, error) {
+	var chat models.Chat
+	var customer models.User
+	var agent models.User
+	var agentID sql.NullString
+	var agentIDField, agentUsername, agentName, agentRole, agentAvatar sql.NullString
+	var agentIsOnline sql.NullBool
+
+	dest := []interface{}{
+		&chat.ID, &chat.CustomerID, &agentID, &chat.Topic, &chat.Priority, &chat.IsEscalated, &chat.Status, &chat.ResolutionCode, &chat.WrapUpNotes,
+		&chat.ResolvedAt, &chat.ClosedAt, &chat.CreatedAt, &chat.UpdatedAt, pq.Array(&chat.Tags),
+		&customer.ID, &customer.Username, &customer.Name, &customer.Role, &customer.Tier, &customer.Avatar, &customer.IsOnline,
+		&agentIDField, &agentUsername, &agentName, &agentRole, &agentAvatar, &agentIsOnline,
+	}
+	err := row.Scan(append(dest, extra...)...)
+	if err != nil {
+		return nil, err
+	}
+
+	chat.Customer = &customer
+	if agentID.Valid && agentIDField.Valid {
+		chat.AgentID = &agentID.String
+		agent.ID = agentIDField.String
+		agent.Username = agentUsername.String
+		agent.Name = agentName.String
+		agent.Role = agentRole.String
+		if agentAvatar.Valid {
+			agent.Avatar = &agentAvatar.String
+		}
+		agent.IsOnline = agentIsOnline.Bool
+		chat.Agent = &agent
+	}
+	chat.IsActive = models.IsOpenStatus(chat.Status)
+
+	return &chat, nil
+}
+
+// MessageSelect selects a message together with its sender and the message
+// it replies to. Deleted messages are returned as tombstones without content.
+// It is scanned by ScanMessage.
+const MessageSelect = `SELECT m.id, m.chat_id, m.seq, m.public_seq, m.sender_id,
+		CASE WHEN m.deleted_at IS NULL THEN m.content ELSE '' END, m.message_type, m.visibility,
+		CASE WHEN m.deleted_at IS NULL THEN m.metadata END, m.created_at, m.edited_at, m.deleted_at,
+		u.id, u.username, u.name, u.role, u.avatar, u.is_online,
+		q.id, q.sender_id, qu.name, q.message_type, CASE WHEN q.deleted_at IS NULL THEN q.content ELSE '' END, q.deleted_at IS NOT NULL
+		FROM messages m
+		LEFT JOIN users u ON m.sender_id = u.id
+		LEFT JOIN messages q ON m.reply_to_id = q.id
+		LEFT JOIN users qu ON q.sender_id = qu.id`
+
+func ScanMessage(row RowScanner) (*models.Message, error) {
+	var message models.Message
+	var sender models.User
+	var metadata []byte
+	var quoteID, quoteSenderID, quoteSenderName, quoteType, quoteContent sql.NullString
+	var quoteDeleted bool
+
+	err := row.Scan(
+		&message.ID, &message.ChatID, &message.Seq, &message.PublicSeq, &message.SenderID, &message.Content, &message.MessageType,
+		&message.Visibility, &metadata, &message.CreatedAt, &message.EditedAt, &message.DeletedAt,
+		&sender.ID, &sender.Username, &sender.Name, &sender.Role, &sender.Avatar, &sender.IsOnline,
+		&quoteID, &quoteSenderID, &quoteSenderName, &quoteType, &quoteContent, &quoteDeleted,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(metadata) > 0 {
+		message.Metadata = metadata
+	}
+	message.Sender = &sender
+	if quoteID.Valid {
+		message.ReplyToID = &quoteID.String
+		message.ReplyTo = &models.QuotedMessage{
+			ID:         quoteID.String,
+			SenderID:   quoteSenderID.String,
+			SenderName: quoteSenderName.String,
+			Type:       quoteType.String,
+			Snippet:    Snippet(quoteContent.String),
+			IsDeleted:  quoteDeleted,
+		}
+	}
+	return &message, nil
+}
+
+// AttachmentColumns are the columns of attachments scanned by ScanAttachment.
+const AttachmentColumns = `id, chat_id, message_id, uploader_id, file_name, content_type, size_bytes, width, height, scan_status, scanned_at, storage_key, created_at`
+
+func ScanAttachment(row RowScanner) (*models.Attachment, error) {
+	var attachment models.Attachment
+	err := row.Scan(&attachment.ID, &attachment.ChatID, &attachment.MessageID, &attachment.UploaderID,
+		&attachment.FileName, &attachment.ContentType, &attachment.Size, &attachment.Width, &attachment.Height,
+		&attachment.ScanStatus, &attachment.ScannedAt, &attachment.StorageKey, &attachment.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &attachment, nil
+}
+
+// Quote returns the quote of a message shown in replies to it.
+func Quote(message *models.Message) *models.QuotedMessage {
+	quote := &models.QuotedMessage{
+		ID:        message.ID,
+		SenderID:  message.SenderID,
+		Type:      message.MessageType,
+		Snippet:   Snippet(message.Content),
+		IsDeleted: message.DeletedAt != nil,
+	}
+	if message.Sender != nil {
+		quote.SenderName = message.Sender.Name
+	}
+	return quote
+}
+
+// QuoteLength is the maximum length in characters of a quoted snippet.
+const QuoteLength = 120
+
+// Snippet shortens content to QuoteLength characters.
+func Snippet(content string) string {
+	runes := []rune(strings.TrimSpace(content))
+	if len(runes) <= QuoteLength {
+		return string(runes)
+	}
+	return strings.TrimSpace(string(runes[:QuoteLength])) + "…"
+}
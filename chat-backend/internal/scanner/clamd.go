@@ -0,0 +1,141 @@
+package scanner
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+// chunkSize is the size of the chunks a file is streamed to clamd in. It must
+// stay below clamd's StreamMaxLength.
+const chunkSize = 64 << 10
+
+// Clamd scans files with a ClamAV daemon using its INSTREAM command.
+type Clamd struct {
+	network string
+	address string
+	timeout time.Duration
+}
+
+// NewClamd returns a scanner for the clamd listening at address, given as
+// "tcp://host:port" or "unix:///path/to/clamd.sock". A scan that takes longer
+// than timeout fails.
+func NewClamd(address string, timeout time.Duration) (*Clamd, error) {
+	network, addr, ok := strings.Cut(address, "://")
+	if !ok || addr == "" || (network != "tcp" && network != "unix") {
+		return nil, fmt.Errorf("scanner: invalid clamd address %q", address)
+	}
+	return &Clamd{
+		network: network,
+		address: addr,
+		timeout: timeout,
+	}, nil
+}
+
+// Ping checks that clamd is reachable.
+func (c *Clamd) Ping(ctx context.Context) error {
+	reply, err := c.command(ctx, "zPING\x00", nil)
+	if err != nil {
+		return err
+	}
+	if reply != "PONG" {
+		return fmt.Errorf("scanner: unexpected reply to PING: %q", reply)
+	}
+	return nil
+}
+
+func (c *Clamd) Scan(ctx context.Context, r io.Reader) (Result, error) {
+	reply, err := c.command(ctx, "zINSTREAM\x00", r)
+	if err != nil {
+		return Result{}, err
+	}
+
+	// Replies look like "stream: OK" or "stream: Eicar-Signature FOUND"
+	reply = strings.TrimPrefix(reply, "stream: ")
+	switch {
+	case reply == "OK":
+		return Result{}, nil
+	case strings.HasSuffix(reply, " FOUND"):
+		return Result{Infected: true, Signature: strings.TrimSuffix(reply, " FOUND")}, nil
+	default:
+		return Result{}, fmt.Errorf("scanner: clamd: %s", reply)
+	}
+}
+
+// command sends a command, followed by the contents of r as INSTREAM chunks
+// if r is not nil, and returns clamd's reply.
+func (c *Clamd) command(ctx context.Context, command string, r io.Reader) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, c.network, c.address)
+	if err != nil {
+		return "", fmt.Errorf("scanner: %w", err)
+	}
+	defer conn.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	writeErr := c.send(conn, command, r)
+	var readErr *sourceError
+	if errors.As(writeErr, &readErr) {
+		return "", fmt.Errorf("scanner: reading file: %w", readErr.err)
+	}
+	// clamd answers and hangs up early when it refuses a stream, e.g. because
+	// it is too long, so its reply is more useful than the write error.
+	reply, err := bufio.NewReader(conn).ReadString('\x00')
+	if err != nil && !(errors.Is(err, io.EOF) && reply != "") {
+		if writeErr != nil {
+			return "", fmt.Errorf("scanner: %w", writeErr)
+		}
+		return "", fmt.Errorf("scanner: %w", err)
+	}
+	return strings.TrimSpace(strings.TrimSuffix(reply, "\x00")), nil
+}
+
+func (c *Clamd) send(conn net.Conn, command string, r io.Reader) error {
+	if _, err := io.WriteString(conn, command); err != nil {
+		return err
+	}
+	if r == nil {
+		return nil
+	}
+
+	buf := make([]byte, 4+chunkSize)
+	for {
+		n, err := r.Read(buf[4:])
+		if n > 0 {
+			binary.BigEndian.PutUint32(buf, uint32(n))
+			if _, err := conn.Write(buf[:4+n]); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return &sourceError{err}
+		}
+	}
+
+	// A zero-length chunk ends the stream
+	_, err := conn.Write([]byte{0, 0, 0, 0})
+	return err
+}
+
+// sourceError is a failure to read the file being scanned, as opposed to a
+// failure to talk to clamd.
+type sourceError struct {
+	err error
+}
+
+func (e *sourceError) Error() string { return e.err.Error() }
@@ -0,0 +1,225 @@
+package scanner
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeClamd listens on a local port and handles each connection with
+// handle, which gets the command and a reader positioned after it.
+func fakeClamd(t *testing.T, handle func(command string, r *bufio.Reader, conn net.Conn)) *Clamd {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listening: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				command, err := r.ReadString('\x00')
+				if err != nil {
+					return
+				}
+				handle(strings.TrimSuffix(command, "\x00"), r, conn)
+			}()
+		}
+	}()
+
+	clamd, err := NewClamd("tcp://"+ln.Addr().String(), 2*time.Second)
+	if err != nil {
+		t.Fatalf("NewClamd: %v", err)
+	}
+	return clamd
+}
+
+// readStream reads INSTREAM chunks up to the terminating empty chunk, or
+// until limit bytes were read if limit is positive. It reports whether the
+// stream went over the limit.
+func readStream(r io.Reader, limit int) ([]byte, bool, error) {
+	var data []byte
+	for {
+		var size uint32
+		if err := binary.Read(r, binary.BigEndian, &size); err != nil {
+			return nil, false, err
+		}
+		if size == 0 {
+			return data, false, nil
+		}
+		chunk := make([]byte, size)
+		if _, err := io.ReadFull(r, chunk); err != nil {
+			return nil, false, err
+		}
+		data = append(data, chunk...)
+		if limit > 0 && len(data) > limit {
+			return data, true, nil
+		}
+	}
+}
+
+func TestClamdScan(t *testing.T) {
+	const eicar = `X5O!P%@AP[4\PZX54(P^)7CC)7}$EICAR-STANDARD-ANTIVIRUS-TEST-FILE!$H+H*`
+
+	received := make(chan []byte, 10)
+	clamd := fakeClamd(t, func(command string, r *bufio.Reader, conn net.Conn) {
+		if command != "zINSTREAM" {
+			io.WriteString(conn, "UNKNOWN COMMAND\x00")
+			return
+		}
+		data, _, err := readStream(r, 0)
+		if err != nil {
+			return
+		}
+		received <- data
+		if bytes.Contains(data, []byte("EICAR")) {
+			io.WriteString(conn, "stream: Eicar-Test-Signature FOUND\x00")
+			return
+		}
+		io.WriteString(conn, "stream: OK\x00")
+	})
+
+	// Larger than a chunk, to check chunks are reassembled
+	clean := bytes.Repeat([]byte("clean file "), chunkSize/5)
+	tests := []struct {
+		name      string
+		data      []byte
+		infected  bool
+		signature string
+	}{
+		{"clean", clean, false, ""},
+		{"empty", nil, false, ""},
+		{"infected", []byte(eicar), true, "Eicar-Test-Signature"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := clamd.Scan(context.Background(), bytes.NewReader(tt.data))
+			if err != nil {
+				t.Fatalf("Scan: %v", err)
+			}
+			if result.Infected != tt.infected || result.Signature != tt.signature {
+				t.Errorf("result = %+v, want infected %v with %q", result, tt.infected, tt.signature)
+			}
+			if got := <-received; !bytes.Equal(got, tt.data) {
+				t.Errorf("clamd received %d bytes, want %d", len(got), len(tt.data))
+			}
+		})
+	}
+}
+
+func TestClamdPing(t *testing.T) {
+	clamd := fakeClamd(t, func(command string, r *bufio.Reader, conn net.Conn) {
+		if command == "zPING" {
+			io.WriteString(conn, "PONG\x00")
+		}
+	})
+	if err := clamd.Ping(context.Background()); err != nil {
+		t.Errorf("Ping: %v", err)
+	}
+}
+
+func TestClamdSizeLimit(t *testing.T) {
+	const limit = 100 << 10
+	clamd := fakeClamd(t, func(command string, r *bufio.Reader, conn net.Conn) {
+		_, over, err := readStream(r, limit)
+		if err != nil {
+			return
+		}
+		if over {
+			// Like clamd, answer and hang up without reading the rest
+			io.WriteString(conn, "INSTREAM size limit exceeded. ERROR\x00")
+			return
+		}
+		io.WriteString(conn, "stream: OK\x00")
+	})
+
+	_, err := clamd.Scan(context.Background(), bytes.NewReader(make([]byte, 4<<20)))
+	if err == nil || !strings.Contains(err.Error(), "size limit exceeded") {
+		t.Errorf("err = %v, want the size limit error", err)
+	}
+}
+
+func TestClamdHangup(t *testing.T) {
+	clamd := fakeClamd(t, func(command string, r *bufio.Reader, conn net.Conn) {
+		// Read part of the stream, then hang up without a reply
+		io.ReadFull(r, make([]byte, 1000))
+	})
+
+	_, err := clamd.Scan(context.Background(), bytes.NewReader(make([]byte, 1<<20)))
+	if err == nil {
+		t.Error("Scan succeeded although clamd hung up mid-stream")
+	}
+}
+
+func TestClamdNoReply(t *testing.T) {
+	clamd := fakeClamd(t, func(command string, r *bufio.Reader, conn net.Conn) {
+		readStream(r, 0)
+		time.Sleep(time.Second)
+	})
+	clamd.timeout = 100 * time.Millisecond
+
+	start := time.Now()
+	if _, err := clamd.Scan(context.Background(), strings.NewReader("data")); err == nil {
+		t.Error("Scan succeeded without a reply")
+	}
+	if elapsed := time.Since(start); elapsed > 900*time.Millisecond {
+		t.Errorf("Scan took %s, want it to give up after the timeout", elapsed)
+	}
+}
+
+func TestClamdUnexpectedReply(t *testing.T) {
+	clamd := fakeClamd(t, func(command string, r *bufio.Reader, conn net.Conn) {
+		readStream(r, 0)
+		io.WriteString(conn, "stream: lstat() failed. ERROR\x00")
+	})
+
+	if _, err := clamd.Scan(context.Background(), strings.NewReader("data")); err == nil {
+		t.Error("Scan succeeded on an error reply")
+	}
+}
+
+type failingReader struct{}
+
+func (failingReader) Read([]byte) (int, error) { return 0, errors.New("disk on fire") }
+
+func TestClamdSourceError(t *testing.T) {
+	clamd := fakeClamd(t, func(command string, r *bufio.Reader, conn net.Conn) {
+		readStream(r, 0)
+	})
+
+	_, err := clamd.Scan(context.Background(), failingReader{})
+	if err == nil || !strings.Contains(err.Error(), "reading file: disk on fire") {
+		t.Errorf("err = %v, want the read error", err)
+	}
+}
+
+func TestNewClamd(t *testing.T) {
+	valid := []string{"tcp://localhost:3310", "unix:///var/run/clamd.sock"}
+	invalid := []string{"", "localhost:3310", "udp://localhost:3310", "tcp://", "http://clamd"}
+
+	for _, address := range valid {
+		if _, err := NewClamd(address, time.Second); err != nil {
+			t.Errorf("NewClamd(%q): %v", address, err)
+		}
+	}
+	for _, address := range invalid {
+		if _, err := NewClamd(address, time.Second); err == nil {
+			t.Errorf("NewClamd(%q) accepted an invalid address", address)
+		}
+	}
+}
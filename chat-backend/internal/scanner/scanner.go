@@ -0,0 +1,20 @@
+// Package scanner checks uploaded files for malware.
+package scanner
+
+import (
+	"context"
+	"io"
+)
+
+// Result is the verdict on a scanned file. Signature names the malware found
+// in an infected file.
+type Result struct {
+	Infected  bool
+	Signature string
+}
+
+// Scanner scans file contents. An error means the file could not be scanned,
+// not that it is infected.
+type Scanner interface {
+	Scan(ctx context.Context, r io.Reader) (Result, error)
+}
@@ -0,0 +1,490 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode"
+
+	"cs-socket/internal/config"
+	"cs-socket/internal/imaging"
+	"cs-socket/internal/models"
+	"cs-socket/internal/storage"
+
+	"github.com/google/uuid"
+)
+
+// sniffLength is the number of bytes http.DetectContentType looks at.
+const sniffLength = 512
+
+// Upload is a file sent to a chat, optionally with a caption.
+type Upload struct {
+	ChatID    string
+	UserID    string
+	Role      string
+	FileName  string
+	Size      int64
+	Content   io.Reader
+	Caption   string
+	ReplyToID *string
+}
+
+// Download is an attachment, or one of its thumbnails, opened for reading.
+// The caller must close Content.
+type Download struct {
+	FileName    string
+	ContentType string
+	Size        int64
+	Content     io.ReadCloser
+}
+
+// AttachmentService stores uploaded files and serves them through signed,
+// expiring download URLs. Images are stored without their metadata and with
+// thumbnails. If scans is set, files are quarantined until it has scanned
+// them.
+type AttachmentService struct {
+	chatService *ChatService
+	store       storage.Storage
+	scans       *ScanMonitor
+	images      *imaging.Processor
+	config      config.AttachmentsConfig
+}
+
+func NewAttachmentService(chatService *ChatService, store storage.Storage, scans *ScanMonitor, cfg config.AttachmentsConfig) *AttachmentService {
+	// The chat service signs download URLs when it loads messages, so it
+	// must follow the same policy
+	chatService.serveUnscanned = cfg.ServeUnscanned
+	return &AttachmentService{
+		chatService: chatService,
+		store:       store,
+		scans:       scans,
+		images:      imaging.NewProcessor(cfg.Thumbnails, cfg.ImageMaxPixels),
+		config:      cfg,
+	}
+}
+
+// MaxSize returns the upload size limit in bytes for a role.
+func (s *AttachmentService) MaxSize(role string) int64 {
+	return s.config.MaxSize(role)
+}
+
+// Upload stores a file and sends it to the chat as an image or file message.
+// The file type is sniffed from its content; the name the client gives it is
+// only kept for display. Images are stored upright, without EXIF or other
+// metadata, and with a thumbnail for each configured size they exceed.
+func (s *AttachmentService) Upload(ctx context.Context, u Upload) (*models.Message, error) {
+	chat, err := s.chatService.GetChat(u.ChatID)
+	if err != nil {
+		return nil, err
+	}
+	if !canManageChat(chat, u.UserID, u.Role) {
+		return nil, ErrForbidden
+	}
+	if !models.IsOpenStatus(chat.Status) {
+		return nil, fmt.Errorf("%w: chat is not open", ErrInvalidTransition)
+	}
+	if limit := s.config.MaxSize(u.Role); u.Size > limit {
+		return nil, fmt.Errorf("%w: files may be at most %d bytes", ErrTooLarge, limit)
+	}
+
+	head := make([]byte, sniffLength)
+	n, err := io.ReadFull(u.Content, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return nil, err
+	}
+	if n == 0 {
+		return nil, fmt.Errorf("%w: file is empty", ErrInvalidInput)
+	}
+	head = head[:n]
+
+	contentType := sniffContentType(head)
+	if !s.allowedType(contentType) {
+		return nil, fmt.Errorf("%w: files of type %s are not allowed", ErrInvalidInput, contentType)
+	}
+
+	body := io.MultiReader(bytes.NewReader(head), u.Content)
+	size := u.Size
+	var img *imaging.Image
+	if imaging.Supported(contentType) {
+		limit := s.config.MaxSize(u.Role)
+		data, err := io.ReadAll(io.LimitReader(body, limit+1))
+		if err != nil {
+			return nil, err
+		}
+		if int64(len(data)) > limit {
+			return nil, fmt.Errorf("%w: files may be at most %d bytes", ErrTooLarge, limit)
+		}
+
+		img, err = s.images.Process(data, contentType)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidInput, err)
+		}
+		body, size = bytes.NewReader(img.Data), int64(len(img.Data))
+	}
+
+	attachment, err := s.save(ctx, chat.ID, u, contentType, body, size, img)
+	if err != nil {
+		return nil, err
+	}
+	s.chatService.signAttachment(attachment)
+
+	messageType := "file"
+	if strings.HasPrefix(contentType, "image/") {
+		messageType = "image"
+	}
+	content := strings.TrimSpace(u.Caption)
+	if content == "" {
+		content = attachment.FileName
+	}
+
+	message, err := s.chatService.sendMessage(newMessage{
+		ChatID:      chat.ID,
+		SenderID:    u.UserID,
+		Content:     content,
+		MessageType: messageType,
+		Visibility:  models.VisibilityPublic,
+		ReplyToID:   u.ReplyToID,
+		Attachments: []models.Attachment{*attachment},
+	})
+	if err != nil {
+		s.discard(ctx, attachment)
+		return nil, err
+	}
+
+	if s.scans != nil {
+		s.scans.Notify()
+	}
+	return MessageForRole(message, u.Role), nil
+}
+
+// save stores a file and the thumbnails of an image and records them. If
+// anything fails, the files already stored are removed again.
+func (s *AttachmentService) save(ctx context.Context, chatID string, u Upload, contentType string, body io.Reader, size int64, img *imaging.Image) (*models.Attachment, error) {
+	id := uuid.New().String()
+	key := "chats/" + chatID + "/" + id
+	if err := s.store.Put(ctx, key, body, size, contentType); err != nil {
+		return nil, err
+	}
+	keys := []string{key}
+	fail := func(err error) (*models.Attachment, error) {
+		s.removeFiles(ctx, keys)
+		return nil, err
+	}
+
+	var width, height *int
+	var thumbnails []models.Thumbnail
+	if img != nil {
+		width, height = &img.Width, &img.Height
+		// The processor renders the largest thumbnail first
+		for i := len(img.Thumbnails) - 1; i >= 0; i-- {
+			t := img.Thumbnails[i]
+			thumbnailKey := key + "-" + t.Name
+			if err := s.store.Put(ctx, thumbnailKey, bytes.NewReader(t.Data), int64(len(t.Data)), t.ContentType); err != nil {
+				return fail(err)
+			}
+			keys = append(keys, thumbnailKey)
+			thumbnails = append(thumbnails, models.Thumbnail{
+				Name:        t.Name,
+				ContentType: t.ContentType,
+				Size:        int64(len(t.Data)),
+				Width:       t.Width,
+				Height:      t.Height,
+				StorageKey:  thumbnailKey,
+			})
+		}
+	}
+
+	scanStatus := models.ScanUnscanned
+	if s.scans != nil {
+		scanStatus = models.ScanPending
+	}
+
+	uploaderID := u.UserID
+	attachment := &models.Attachment{
+		ID:          id,
+		ChatID:      chatID,
+		UploaderID:  &uploaderID,
+		FileName:    cleanFileName(u.FileName),
+		ContentType: contentType,
+		Size:        size,
+		Width:       width,
+		Height:      height,
+		ScanStatus:  scanStatus,
+		StorageKey:  key,
+		Thumbnails:  thumbnails,
+	}
+	if err := s.chatService.repos.Messages.CreateAttachment(attachment); err != nil {
+		return fail(err)
+	}
+	return attachment, nil
+}
+
+// GetAttachment returns an attachment of a chat the user can see, with a
+// fresh download URL.
+func (s *AttachmentService) GetAttachment(chatID, attachmentID, userID, role string) (*models.Attachment, error) {
+	chat, err := s.chatService.GetChat(chatID)
+	if err != nil {
+		return nil, err
+	}
+	if !canAccessChat(chat, userID, role) {
+		return nil, ErrForbidden
+	}
+
+	attachment, err := s.visibleAttachment(attachmentID)
+	if err != nil {
+		return nil, err
+	}
+	if attachment.ChatID != chatID {
+		return nil, sql.ErrNoRows
+	}
+	if err := s.chatService.attachThumbnails([]*models.Attachment{attachment}); err != nil {
+		return nil, err
+	}
+
+	s.chatService.signAttachment(attachment)
+	return attachment, nil
+}
+
+// Open checks a download URL's signature and opens the attachment, or the
+// thumbnail variant, it points to. An image smaller than a thumbnail size has
+// no thumbnail of that size and serves as its own.
+func (s *AttachmentService) Open(ctx context.Context, attachmentID, variant, expires, signature string) (*Download, error) {
+	if !s.chatService.signer.Verify(attachmentResource(attachmentID, variant), expires, signature, time.Now()) {
+		return nil, fmt.Errorf("%w: download link is invalid or has expired", ErrForbidden)
+	}
+
+	attachment, err := s.visibleAttachment(attachmentID)
+	if err != nil {
+		return nil, err
+	}
+	if err := downloadable(attachment, s.config.ServeUnscanned); err != nil {
+		return nil, err
+	}
+
+	download := &Download{
+		FileName:    attachment.FileName,
+		ContentType: attachment.ContentType,
+		Size:        attachment.Size,
+	}
+	key := attachment.StorageKey
+
+	if variant != "" {
+		if attachment.Width == nil || !s.thumbnailSize(variant) {
+			return nil, sql.ErrNoRows
+		}
+		if err := s.chatService.attachThumbnails([]*models.Attachment{attachment}); err != nil {
+			return nil, err
+		}
+		for _, t := range attachment.Thumbnails {
+			if t.Name == variant {
+				download.ContentType, download.Size, key = t.ContentType, t.Size, t.StorageKey
+			}
+		}
+	}
+
+	download.Content, err = s.store.Get(ctx, key)
+	if err == storage.ErrNotFound {
+		return nil, sql.ErrNoRows
+	}
+	if err != nil {
+		return nil, err
+	}
+	return download, nil
+}
+
+// visibleAttachment loads an attachment unless the message it was sent with
+// has been deleted.
+func (s *AttachmentService) visibleAttachment(attachmentID string) (*models.Attachment, error) {
+	return s.chatService.repos.Messages.GetAttachment(attachmentID)
+}
+
+func (s *AttachmentService) discard(ctx context.Context, attachment *models.Attachment) {
+	if err := s.chatService.repos.Messages.DeleteAttachment(attachment.ID); err != nil {
+		log.Printf("Failed to remove attachment %s: %v", attachment.ID, err)
+	}
+
+	keys := []string{attachment.StorageKey}
+	for _, t := range attachment.Thumbnails {
+		keys = append(keys, t.StorageKey)
+	}
+	s.removeFiles(ctx, keys)
+}
+
+func (s *AttachmentService) removeFiles(ctx context.Context, keys []string) {
+	for _, key := range keys {
+		if err := s.store.Delete(ctx, key); err != nil {
+			log.Printf("Failed to remove stored file %s: %v", key, err)
+		}
+	}
+}
+
+func (s *AttachmentService) thumbnailSize(name string) bool {
+	for _, size := range s.config.Thumbnails {
+		if size.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *AttachmentService) allowedType(contentType string) bool {
+	for _, allowed := range s.config.AllowedTypes {
+		if allowed == contentType {
+			return true
+		}
+	}
+	return false
+}
+
+// downloadable reports why a file may not be downloaded, if it may not.
+// Files uploaded while scanning was off are served only if serveUnscanned.
+func downloadable(attachment *models.Attachment, serveUnscanned bool) error {
+	switch attachment.ScanStatus {
+	case models.ScanClean:
+		return nil
+	case models.ScanUnscanned:
+		if serveUnscanned {
+			return nil
+		}
+		return fmt.Errorf("%w: file was not scanned for malware", ErrForbidden)
+	case models.ScanPending:
+		return fmt.Errorf("%w: file is still being scanned for malware", ErrConflict)
+	case models.ScanInfected:
+		return fmt.Errorf("%w: file contains malware and has been quarantined", ErrForbidden)
+	default:
+		return fmt.Errorf("%w: file could not be scanned for malware", ErrForbidden)
+	}
+}
+
+// signAttachment sets download URLs for an attachment and its thumbnails that
+// are valid for the configured time. Files that may not be downloaded get no
+// URLs.
+func (s *ChatService) signAttachment(attachment *models.Attachment) {
+	if downloadable(attachment, s.serveUnscanned) != nil {
+		return
+	}
+
+	now := time.Now()
+	expires, signature := s.signer.Sign(attachment.ID, now)
+	attachment.URL = fmt.Sprintf("/api/files/%s?expires=%d&signature=%s", attachment.ID, expires.Unix(), signature)
+	attachment.URLExpiresAt = &expires
+
+	for i := range attachment.Thumbnails {
+		t := &attachment.Thumbnails[i]
+		_, signature := s.signer.Sign(attachmentResource(attachment.ID, t.Name), now)
+		t.URL = fmt.Sprintf("/api/files/%s?variant=%s&expires=%d&signature=%s", attachment.ID, url.QueryEscape(t.Name), expires.Unix(), signature)
+	}
+}
+
+// attachmentResource is what a download URL's signature covers, so that a
+// thumbnail's URL cannot be turned into one for the full image.
+func attachmentResource(attachmentID, variant string) string {
+	if variant == "" {
+		return attachmentID
+	}
+	return attachmentID + "/" + variant
+}
+
+func (s *ChatService) linkAttachments(messageID string, attachments []models.Attachment) error {
+	ids := make([]string, len(attachments))
+	for i := range attachments {
+		ids[i] = attachments[i].ID
+		attachments[i].MessageID = &messageID
+	}
+
+	return s.repos.Messages.LinkAttachments(messageID, ids)
+}
+
+// attachAttachments loads the attachments of the given messages in one query
+// and signs their download URLs. Deleted messages keep no attachments.
+func (s *ChatService) attachAttachments(messages []models.Message) error {
+	ids := make([]string, 0, len(messages))
+	index := make(map[string]int, len(messages))
+	for i, message := range messages {
+		if message.DeletedAt == nil {
+			ids = append(ids, message.ID)
+			index[message.ID] = i
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	attachments, err := s.repos.Messages.Attachments(ids)
+	if err != nil {
+		return err
+	}
+
+	if err := s.attachThumbnails(attachments); err != nil {
+		return err
+	}
+	for _, attachment := range attachments {
+		s.signAttachment(attachment)
+		i := index[*attachment.MessageID]
+		messages[i].Attachments = append(messages[i].Attachments, *attachment)
+	}
+	return nil
+}
+
+// attachThumbnails loads the thumbnails of the given attachments in one query.
+func (s *ChatService) attachThumbnails(attachments []*models.Attachment) error {
+	ids := make([]string, 0, len(attachments))
+	index := make(map[string]*models.Attachment, len(attachments))
+	for _, attachment := range attachments {
+		if attachment.Width != nil {
+			ids = append(ids, attachment.ID)
+			index[attachment.ID] = attachment
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	thumbnails, err := s.repos.Messages.Thumbnails(ids)
+	if err != nil {
+		return err
+	}
+	for id, list := range thumbnails {
+		index[id].Thumbnails = list
+	}
+	return nil
+}
+
+// sniffContentType detects the MIME type of a file from its first bytes and
+// drops parameters such as the charset.
+func sniffContentType(head []byte) string {
+	detected := http.DetectContentType(head)
+	if mediaType, _, err := mime.ParseMediaType(detected); err == nil {
+		return mediaType
+	}
+	return detected
+}
+
+// cleanFileName keeps the base name of an uploaded file without control
+// characters, shortened to fit the database column.
+func cleanFileName(name string) string {
+	name = filepath.Base(strings.ReplaceAll(name, "\\", "/"))
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, name)
+
+	if runes := []rune(name); len(runes) > 255 {
+		name = string(runes[:255])
+	}
+	if name == "" || name == "." || name == "/" {
+		return "file"
+	}
+	return name
+}
@@ -0,0 +1,30 @@
+package services
+
+import (
+	"errors"
+	"testing"
+
+	"cs-socket/internal/models"
+)
+
+func TestDownloadable(t *testing.T) {
+	tests := []struct {
+		status         string
+		serveUnscanned bool
+		want           error
+	}{
+		{models.ScanClean, false, nil},
+		{models.ScanUnscanned, true, nil},
+		{models.ScanUnscanned, false, ErrForbidden},
+		{models.ScanPending, true, ErrConflict},
+		{models.ScanInfected, true, ErrForbidden},
+		{models.ScanFailed, true, ErrForbidden},
+	}
+
+	for _, tt := range tests {
+		err := downloadable(&models.Attachment{ScanStatus: tt.status}, tt.serveUnscanned)
+		if !errors.Is(err, tt.want) || (tt.want == nil && err != nil) {
+			t.Errorf("%s with serveUnscanned %v: err = %v, want %v", tt.status, tt.serveUnscanned, err, tt.want)
+		}
+	}
+}
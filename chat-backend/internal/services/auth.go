@@ -2,64 +2,53 @@ package services
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
 	"time"
 
 	"cs-socket/internal/models"
+	"cs-socket/internal/repository"
 
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
 	"golang.org/x/crypto/bcrypt"
 )
 
+// ErrInvalidCredentials is returned by Login for an unknown username or a
+// wrong password, without telling which.
+var ErrInvalidCredentials = errors.New("invalid credentials")
+
 type AuthService struct {
-	db        *sql.DB
+	users     repository.UserRepository
 	jwtSecret string
 }
 
-func NewAuthService(db *sql.DB, jwtSecret string) *AuthService {
+func NewAuthService(users repository.UserRepository, jwtSecret string) *AuthService {
 	return &AuthService{
-		db:        db,
+		users:     users,
 		jwtSecret: jwtSecret,
 	}
 }
 
 func (s *AuthService) Login(username, password string) (*models.User, string, error) {
-	var user models.User
-	query := `SELECT id, username, email, password_hash, name, role, avatar, is_online, created_at, updated_at 
-			  FROM users WHERE username = $1`
-
-	err := s.db.QueryRow(query, username).Scan(
-		&user.ID, &user.Username, &user.Email, &user.Password,
-		&user.Name, &user.Role, &user.Avatar, &user.IsOnline,
-		&user.CreatedAt, &user.UpdatedAt,
-	)
-
+	user, err := s.users.GetByUsername(username)
 	if err != nil {
 		if err == sql.ErrNoRows {
-			return nil, "", fmt.Errorf("invalid credentials")
+			return nil, "", ErrInvalidCredentials
 		}
 		return nil, "", err
 	}
 
 	// Check password
 	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)); err != nil {
-		return nil, "", fmt.Errorf("invalid credentials")
+		return nil, "", ErrInvalidCredentials
 	}
 
 	// Update online status
 	s.UpdateUserStatus(user.ID, true)
 	user.IsOnline = true
 
-	// Generate JWT token
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"userID":   user.ID,
-		"username": user.Username,
-		"role":     user.Role,
-		"exp":      time.Now().Add(time.Hour * 24).Unix(),
-	})
-
-	tokenString, err := token.SignedString([]byte(s.jwtSecret))
+	tokenString, err := s.issueToken(user)
 	if err != nil {
 		return nil, "", err
 	}
@@ -67,7 +56,7 @@ func (s *AuthService) Login(username, password string) (*models.User, string, er
 	// Clear password from response
 	user.Password = ""
 
-	return &user, tokenString, nil
+	return user, tokenString, nil
 }
 
 func (s *AuthService) Register(req models.RegisterRequest) (*models.User, string, error) {
@@ -82,25 +71,32 @@ func (s *AuthService) Register(req models.RegisterRequest) (*models.User, string
 		req.Role = "customer"
 	}
 
-	// Generate UUID
-	userID := uuid.New().String()
-
-	// Insert user
-	query := `INSERT INTO users (id, username, email, password_hash, name, role, is_online, created_at, updated_at)
-			  VALUES ($1, $2, $3, $4, $5, $6, true, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
-			  RETURNING id, username, email, name, role, avatar, is_online, created_at, updated_at`
-
-	var user models.User
-	err = s.db.QueryRow(query, userID, req.Username, req.Email, string(hashedPassword), req.Name, req.Role).Scan(
-		&user.ID, &user.Username, &user.Email, &user.Name,
-		&user.Role, &user.Avatar, &user.IsOnline, &user.CreatedAt, &user.UpdatedAt,
-	)
+	user, err := s.users.Create(models.User{
+		ID:       uuid.New().String(),
+		Username: req.Username,
+		Email:    req.Email,
+		Password: string(hashedPassword),
+		Name:     req.Name,
+		Role:     req.Role,
+		IsOnline: true,
+	})
+	if errors.Is(err, repository.ErrDuplicate) {
+		return nil, "", fmt.Errorf("%w: username or email is already taken", ErrConflict)
+	}
+	if err != nil {
+		return nil, "", err
+	}
 
+	tokenString, err := s.issueToken(user)
 	if err != nil {
 		return nil, "", err
 	}
 
-	// Generate JWT token
+	return user, tokenString, nil
+}
+
+// issueToken returns a JWT for the user that is valid for a day.
+func (s *AuthService) issueToken(user *models.User) (string, error) {
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
 		"userID":   user.ID,
 		"username": user.Username,
@@ -108,33 +104,13 @@ func (s *AuthService) Register(req models.RegisterRequest) (*models.User, string
 		"exp":      time.Now().Add(time.Hour * 24).Unix(),
 	})
 
-	tokenString, err := token.SignedString([]byte(s.jwtSecret))
-	if err != nil {
-		return nil, "", err
-	}
-
-	return &user, tokenString, nil
+	return token.SignedString([]byte(s.jwtSecret))
 }
 
 func (s *AuthService) UpdateUserStatus(userID string, isOnline bool) error {
-	query := `UPDATE users SET is_online = $1, updated_at = CURRENT_TIMESTAMP WHERE id = $2`
-	_, err := s.db.Exec(query, isOnline, userID)
-	return err
+	return s.users.SetOnline(userID, isOnline)
 }
 
 func (s *AuthService) GetUserByID(userID string) (*models.User, error) {
-	var user models.User
-	query := `SELECT id, username, email, name, role, avatar, is_online, created_at, updated_at 
-			  FROM users WHERE id = $1`
-
-	err := s.db.QueryRow(query, userID).Scan(
-		&user.ID, &user.Username, &user.Email, &user.Name,
-		&user.Role, &user.Avatar, &user.IsOnline, &user.CreatedAt, &user.UpdatedAt,
-	)
-
-	if err != nil {
-		return nil, err
-	}
-
-	return &user, nil
+	return s.users.GetByID(userID)
 }
@@ -0,0 +1,89 @@
+package services
+
+import (
+	"errors"
+	"testing"
+
+	"cs-socket/internal/models"
+	"cs-socket/internal/repository"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const testSecret = "test-secret"
+
+func registerRequest(username string) models.RegisterRequest {
+	return models.RegisterRequest{
+		Username: username,
+		Email:    username + "@example.com",
+		Password: "secret123",
+		Name:     "Test " + username,
+	}
+}
+
+func TestRegisterAndLogin(t *testing.T) {
+	s := NewAuthService(repository.NewMemory().Users, testSecret)
+
+	registered, token, err := s.Register(registerRequest("alice"))
+	if err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+	if registered.Role != "customer" {
+		t.Errorf("role = %q, want customer by default", registered.Role)
+	}
+	if registered.Password != "" {
+		t.Error("Register returned the password hash")
+	}
+	if token == "" {
+		t.Error("Register returned no token")
+	}
+
+	user, token, err := s.Login("alice", "secret123")
+	if err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+	if user.ID != registered.ID || user.Password != "" || !user.IsOnline {
+		t.Errorf("Login returned %+v", user)
+	}
+
+	claims := jwt.MapClaims{}
+	if _, err := jwt.ParseWithClaims(token, claims, func(*jwt.Token) (interface{}, error) {
+		return []byte(testSecret), nil
+	}); err != nil {
+		t.Fatalf("parsing token: %v", err)
+	}
+	if claims["userID"] != registered.ID || claims["role"] != "customer" {
+		t.Errorf("claims = %v", claims)
+	}
+}
+
+func TestLoginInvalidCredentials(t *testing.T) {
+	s := NewAuthService(repository.NewMemory().Users, testSecret)
+	if _, _, err := s.Register(registerRequest("alice")); err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+
+	for _, tc := range []struct{ name, username, password string }{
+		{"wrong password", "alice", "wrong-password"},
+		{"unknown user", "bob", "secret123"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, _, err := s.Login(tc.username, tc.password); err != ErrInvalidCredentials {
+				t.Errorf("err = %v, want ErrInvalidCredentials", err)
+			}
+		})
+	}
+}
+
+func TestRegisterDuplicate(t *testing.T) {
+	s := NewAuthService(repository.NewMemory().Users, testSecret)
+	if _, _, err := s.Register(registerRequest("alice")); err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+
+	req := registerRequest("alice")
+	req.Email = "other@example.com"
+	if _, _, err := s.Register(req); !errors.Is(err, ErrConflict) {
+		t.Errorf("err = %v, want ErrConflict", err)
+	}
+}
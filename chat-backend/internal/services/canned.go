@@ -0,0 +1,318 @@
+package services
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"cs-socket/internal/models"
+	"cs-socket/internal/repository"
+)
+
+// placeholderPattern matches {{name}} placeholders, allowing inner spaces.
+var placeholderPattern = regexp.MustCompile(`\{\{\s*([a-zA-Z]+\.[a-zA-Z]+)\s*\}\}`)
+
+// CannedService manages the canned response library and sends rendered
+// responses into chats.
+type CannedService struct {
+	repos       repository.Repositories
+	chatService *ChatService
+}
+
+func NewCannedService(chatService *ChatService) *CannedService {
+	return &CannedService{
+		repos:       chatService.repos,
+		chatService: chatService,
+	}
+}
+
+// GetResponses lists the responses available to a staff member: their own
+// personal responses and those shared with their team. Super-agents see every
+// team's responses. query filters on shortcut and title.
+func (s *CannedService) GetResponses(userID, role, query string) ([]models.CannedResponse, error) {
+	if !isStaff(role) {
+		return nil, ErrForbidden
+	}
+
+	team, err := s.userTeam(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.repos.Canned.List(userID, team, role == "super-agent", strings.TrimSpace(query))
+}
+
+func (s *CannedService) GetResponse(id string) (*models.CannedResponse, error) {
+	return s.repos.Canned.Get(id)
+}
+
+// CreateResponse adds a response to the library. Team responses default to
+// the creator's team; only super-agents may share with another team.
+func (s *CannedService) CreateResponse(userID, role string, req models.CannedResponseRequest) (*models.CannedResponse, error) {
+	if !isStaff(role) {
+		return nil, ErrForbidden
+	}
+
+	scope, team, err := s.resolveScope(userID, role, req)
+	if err != nil {
+		return nil, err
+	}
+
+	response := &models.CannedResponse{
+		OwnerID:  userID,
+		Scope:    scope,
+		Team:     team,
+		Shortcut: normalizeShortcut(req.Shortcut),
+		Title:    req.Title,
+		Content:  req.Content,
+	}
+	if err := s.repos.Canned.Create(response); err != nil {
+		return nil, shortcutError(err, response.Shortcut)
+	}
+	return response, nil
+}
+
+func (s *CannedService) UpdateResponse(id, userID, role string, req models.CannedResponseRequest) (*models.CannedResponse, error) {
+	existing, err := s.accessibleResponse(id, userID, role)
+	if err != nil {
+		return nil, err
+	}
+
+	// A request without a scope leaves the response shared as it was
+	scope, team := existing.Scope, existing.Team
+	if req.Scope != "" {
+		if scope, team, err = s.resolveScope(userID, role, req); err != nil {
+			return nil, err
+		}
+	}
+	// Team members may edit a team response but not take it from the team
+	if changesScope(existing, scope, team) && existing.OwnerID != userID && role != "super-agent" {
+		return nil, fmt.Errorf("%w: only the owner or a super-agent can change a response's scope", ErrForbidden)
+	}
+
+	response := *existing
+	response.Scope, response.Team = scope, team
+	response.Shortcut = normalizeShortcut(req.Shortcut)
+	response.Title, response.Content = req.Title, req.Content
+	if err := s.repos.Canned.Update(&response); err != nil {
+		return nil, shortcutError(err, response.Shortcut)
+	}
+	return &response, nil
+}
+
+// shortcutError reports a shortcut that is already in use as a conflict.
+func shortcutError(err error, shortcut string) error {
+	if err == repository.ErrDuplicate {
+		return fmt.Errorf("%w: shortcut %q is already in use", ErrConflict, shortcut)
+	}
+	return err
+}
+
+func (s *CannedService) DeleteResponse(id, userID, role string) error {
+	if _, err := s.accessibleResponse(id, userID, role); err != nil {
+		return err
+	}
+
+	return s.repos.Canned.Delete(id)
+}
+
+// Render substitutes the placeholders of a response for a chat, with the
+// caller as the agent.
+func (s *CannedService) Render(chatID, responseID, userID, role string) (string, error) {
+	response, chat, err := s.usableResponse(chatID, responseID, userID, role)
+	if err != nil {
+		return "", err
+	}
+
+	agent, err := s.userName(userID)
+	if err != nil {
+		return "", err
+	}
+
+	return renderPlaceholders(response.Content, chat, agent), nil
+}
+
+// Send renders a response for a chat, sends it as a message from the caller
+// and records the use for statistics.
+func (s *CannedService) Send(chatID, responseID, userID, role string) (*models.Message, error) {
+	response, chat, err := s.usableResponse(chatID, responseID, userID, role)
+	if err != nil {
+		return nil, err
+	}
+
+	agent, err := s.userName(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	message, err := s.chatService.sendMessage(newMessage{
+		ChatID:      chatID,
+		SenderID:    userID,
+		Content:     renderPlaceholders(response.Content, chat, agent),
+		MessageType: "text",
+		Visibility:  models.VisibilityPublic,
+		Metadata: map[string]interface{}{
+			"cannedResponseId": response.ID,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.repos.Canned.RecordUse(response.ID, chatID, userID, message.ID); err != nil {
+		return nil, err
+	}
+
+	return message, nil
+}
+
+// GetStats reports per response how often it was used and how the chats it
+// was used in were resolved and rated. Responses that were never used in the
+// range are included with zero uses.
+func (s *CannedService) GetStats(role string, filter models.CannedStatsFilter) ([]models.CannedResponseStats, error) {
+	if role != "super-agent" {
+		return nil, ErrForbidden
+	}
+
+	return s.repos.Canned.Stats(filter)
+}
+
+// resolveScope validates the scope of a request and returns the team to
+// store with it.
+func (s *CannedService) resolveScope(userID, role string, req models.CannedResponseRequest) (string, *string, error) {
+	switch req.Scope {
+	case "", models.CannedScopePersonal:
+		return models.CannedScopePersonal, nil, nil
+	case models.CannedScopeTeam:
+	default:
+		return "", nil, fmt.Errorf("%w: scope must be personal or team", ErrInvalidInput)
+	}
+
+	team, err := s.userTeam(userID)
+	if err != nil {
+		return "", nil, err
+	}
+
+	if req.Team != nil && strings.TrimSpace(*req.Team) != "" && strings.TrimSpace(*req.Team) != team {
+		if role != "super-agent" {
+			return "", nil, ErrForbidden
+		}
+		team = strings.TrimSpace(*req.Team)
+	}
+	if team == "" {
+		return "", nil, fmt.Errorf("%w: team responses need a team", ErrInvalidInput)
+	}
+
+	return models.CannedScopeTeam, &team, nil
+}
+
+// changesScope reports whether storing a response with scope and team would
+// change who it is shared with.
+func changesScope(response *models.CannedResponse, scope string, team *string) bool {
+	if scope != response.Scope {
+		return true
+	}
+	if scope != models.CannedScopeTeam {
+		return false
+	}
+	return response.Team == nil || team == nil || *response.Team != *team
+}
+
+// accessibleResponse loads a response the caller may use and change: their
+// own personal responses, their team's responses, or any team response for
+// super-agents.
+func (s *CannedService) accessibleResponse(id, userID, role string) (*models.CannedResponse, error) {
+	if !isStaff(role) {
+		return nil, ErrForbidden
+	}
+
+	response, err := s.GetResponse(id)
+	if err != nil {
+		return nil, err
+	}
+
+	ok, err := s.canUse(response, userID, role)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrForbidden
+	}
+	return response, nil
+}
+
+// usableResponse loads a response and a chat for a staff member who may use
+// the response and manage the chat.
+func (s *CannedService) usableResponse(chatID, responseID, userID, role string) (*models.CannedResponse, *models.Chat, error) {
+	response, err := s.accessibleResponse(responseID, userID, role)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	chat, err := s.chatService.GetChat(chatID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !canManageChat(chat, userID, role) {
+		return nil, nil, ErrForbidden
+	}
+
+	return response, chat, nil
+}
+
+func (s *CannedService) canUse(response *models.CannedResponse, userID, role string) (bool, error) {
+	if response.Scope == models.CannedScopePersonal {
+		return response.OwnerID == userID, nil
+	}
+	if role == "super-agent" {
+		return true, nil
+	}
+
+	team, err := s.userTeam(userID)
+	if err != nil {
+		return false, err
+	}
+	return response.Team != nil && team != "" && *response.Team == team, nil
+}
+
+func (s *CannedService) userTeam(userID string) (string, error) {
+	user, err := s.repos.Users.GetByID(userID)
+	if err != nil || user.Team == nil {
+		return "", err
+	}
+	return *user.Team, nil
+}
+
+func (s *CannedService) userName(userID string) (string, error) {
+	user, err := s.repos.Users.GetByID(userID)
+	if err != nil {
+		return "", err
+	}
+	return user.Name, nil
+}
+
+// renderPlaceholders substitutes the known placeholders of content. Unknown
+// placeholders are left as they are so that typos stay visible.
+func renderPlaceholders(content string, chat *models.Chat, agentName string) string {
+	values := map[string]string{
+		"chat.id":    chat.ID,
+		"agent.name": agentName,
+	}
+	if chat.Customer != nil {
+		values["customer.name"] = chat.Customer.Name
+	}
+
+	return placeholderPattern.ReplaceAllStringFunc(content, func(match string) string {
+		key := placeholderPattern.FindStringSubmatch(match)[1]
+		if value, ok := values[key]; ok {
+			return value
+		}
+		return match
+	})
+}
+
+// normalizeShortcut strips a leading slash and lowercases a shortcut, so "/Hi"
+// and "hi" are the same shortcut.
+func normalizeShortcut(shortcut string) string {
+	return strings.ToLower(strings.TrimPrefix(strings.TrimSpace(shortcut), "/"))
+}
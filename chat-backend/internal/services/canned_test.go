@@ -0,0 +1,137 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+
+	"cs-socket/internal/models"
+)
+
+func TestChangesScope(t *testing.T) {
+	payments, support := "payments", "support"
+	personal := &models.CannedResponse{Scope: models.CannedScopePersonal}
+	team := &models.CannedResponse{Scope: models.CannedScopeTeam, Team: &payments}
+
+	tests := []struct {
+		name     string
+		response *models.CannedResponse
+		scope    string
+		team     *string
+		want     bool
+	}{
+		{"personal kept", personal, models.CannedScopePersonal, nil, false},
+		{"personal shared", personal, models.CannedScopeTeam, &payments, true},
+		{"team kept", team, models.CannedScopeTeam, &payments, false},
+		{"team made personal", team, models.CannedScopePersonal, nil, true},
+		{"team moved", team, models.CannedScopeTeam, &support, true},
+	}
+
+	for _, tt := range tests {
+		if got := changesScope(tt.response, tt.scope, tt.team); got != tt.want {
+			t.Errorf("%s: changesScope = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestRenderPlaceholders(t *testing.T) {
+	chat := &models.Chat{ID: "chat-1", Customer: &models.User{Name: "Carol"}}
+	anonymous := &models.Chat{ID: "chat-2"}
+
+	tests := []struct {
+		name    string
+		content string
+		chat    *models.Chat
+		want    string
+	}{
+		{"known", "Hi {{customer.name}}, I'm {{agent.name}} (ref {{chat.id}})", chat, "Hi Carol, I'm Bob (ref chat-1)"},
+		{"inner spaces", "Hi {{ customer.name }}", chat, "Hi Carol"},
+		{"repeated", "{{agent.name}} and {{agent.name}}", chat, "Bob and Bob"},
+		{"unknown", "Your {{customer.email}} is {{Customer.Name}}", chat, "Your {{customer.email}} is {{Customer.Name}}"},
+		{"not a placeholder", "{{name}} {customer.name} {{customer.name", chat, "{{name}} {customer.name} {{customer.name"},
+		{"missing customer", "Hi {{customer.name}}, ref {{chat.id}}", anonymous, "Hi {{customer.name}}, ref chat-2"},
+		{"no placeholders", "Thanks for waiting", chat, "Thanks for waiting"},
+	}
+
+	for _, tt := range tests {
+		if got := renderPlaceholders(tt.content, tt.chat, "Bob"); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestCannedResponses(t *testing.T) {
+	s, repos := newTestChatService(t)
+	canned := NewCannedService(s)
+	chat, customer, agent := createChat(t, s, repos)
+	other := createUser(t, repos, "other", "agent")
+	payments := "payments"
+	for _, id := range []string{agent.ID, other.ID} {
+		if err := repos.Users.SetTeam(id, &payments); err != nil {
+			t.Fatalf("SetTeam: %v", err)
+		}
+	}
+
+	req := models.CannedResponseRequest{
+		Scope:    models.CannedScopeTeam,
+		Shortcut: "/Thanks",
+		Title:    "Thanks",
+		Content:  "Thanks for waiting, this is {{agent.name}}.",
+	}
+	if _, err := canned.CreateResponse(customer.ID, "customer", req); !errors.Is(err, ErrForbidden) {
+		t.Errorf("CreateResponse as a customer: err = %v, want ErrForbidden", err)
+	}
+	response, err := canned.CreateResponse(agent.ID, "agent", req)
+	if err != nil {
+		t.Fatalf("CreateResponse: %v", err)
+	}
+	if response.Team == nil || *response.Team != payments {
+		t.Errorf("team response shared with %v, want %s", response.Team, payments)
+	}
+	if _, err := canned.CreateResponse(other.ID, "agent", req); !errors.Is(err, ErrConflict) {
+		t.Errorf("reusing a team shortcut: err = %v, want ErrConflict", err)
+	}
+
+	// Team members find and may edit the response
+	responses, err := canned.GetResponses(other.ID, "agent", "thank")
+	if err != nil {
+		t.Fatalf("GetResponses: %v", err)
+	}
+	if len(responses) != 1 || responses[0].ID != response.ID {
+		t.Errorf("responses = %+v, want the team response", responses)
+	}
+	req.Title = "Thank you"
+	updated, err := canned.UpdateResponse(response.ID, other.ID, "agent", req)
+	if err != nil {
+		t.Fatalf("UpdateResponse: %v", err)
+	}
+	if updated.Title != "Thank you" || updated.OwnerID != agent.ID {
+		t.Errorf("updated response: title %q, owner %s", updated.Title, updated.OwnerID)
+	}
+
+	message, err := canned.Send(chat.ID, response.ID, agent.ID, "agent")
+	if err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	if message.Content != "Thanks for waiting, this is "+agent.Name+"." {
+		t.Errorf("sent content = %q", message.Content)
+	}
+
+	if _, err := canned.GetStats("agent", models.CannedStatsFilter{}); !errors.Is(err, ErrForbidden) {
+		t.Errorf("GetStats as an agent: err = %v, want ErrForbidden", err)
+	}
+	stats, err := canned.GetStats("super-agent", models.CannedStatsFilter{Team: payments})
+	if err != nil {
+		t.Fatalf("GetStats: %v", err)
+	}
+	if len(stats) != 1 || stats[0].Uses != 1 || stats[0].Chats != 1 || stats[0].Agents != 1 {
+		t.Errorf("stats = %+v", stats)
+	}
+
+	if err := canned.DeleteResponse(response.ID, other.ID, "agent"); err != nil {
+		t.Fatalf("DeleteResponse: %v", err)
+	}
+	if _, err := canned.GetResponse(response.ID); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("GetResponse after deleting: err = %v, want sql.ErrNoRows", err)
+	}
+}
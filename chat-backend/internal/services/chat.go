@@ -2,182 +2,232 @@ package services
 
 import (
 	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
 
+	"cs-socket/internal/config"
 	"cs-socket/internal/models"
+	"cs-socket/internal/repository"
+	"cs-socket/internal/storage"
 	"cs-socket/internal/websocket"
 
 	"github.com/google/uuid"
 )
 
+// defaultTopic is used for chats created without a topic.
+const defaultTopic = "general"
+
 type ChatService struct {
-	db  *sql.DB
-	hub *websocket.Hub
+	repos    repository.Repositories
+	hub      *websocket.Hub
+	priority config.PriorityConfig
+	messages config.MessagesConfig
+	signer   *storage.URLSigner
+	// serveUnscanned is set from the attachments config by
+	// NewAttachmentService
+	serveUnscanned bool
 }
 
-func NewChatService(db *sql.DB, hub *websocket.Hub) *ChatService {
+// NewChatService returns a chat service that keeps chats, messages and
+// everything about them in repos.
+func NewChatService(repos repository.Repositories, hub *websocket.Hub, priority config.PriorityConfig, messages config.MessagesConfig, signer *storage.URLSigner) *ChatService {
 	return &ChatService{
-		db:  db,
-		hub: hub,
+		repos:    repos,
+		hub:      hub,
+		priority: priority,
+		messages: messages,
+		signer:   signer,
 	}
 }
 
-func (s *ChatService) GetChats(userID, role string) ([]models.Chat, error) {
-	var query string
-	var args []interface{}
+func (s *ChatService) GetChats(userID, role string, filter models.ChatFilter) ([]models.Chat, *models.ChatPaging, error) {
+	return s.listChats(userID, role, chatScope(userID, role), false, filter)
+}
 
-	if role == "customer" {
-		query = `SELECT c.id, c.customer_id, c.agent_id, c.status, c.created_at, c.updated_at,
-				 u1.id, u1.username, u1.name, u1.role, u1.avatar, u1.is_online,
-				 u2.id, u2.username, u2.name, u2.role, u2.avatar, u2.is_online
-				 FROM chats c
-				 LEFT JOIN users u1 ON c.customer_id = u1.id
-				 LEFT JOIN users u2 ON c.agent_id = u2.id
-				 WHERE c.customer_id = $1 AND c.status != 'archived'
-				 ORDER BY c.updated_at DESC`
-		args = []interface{}{userID}
-	} else if role == "super-agent" {
+// chatScope returns the scope of the chats a user may see.
+func chatScope(userID, role string) repository.ChatScope {
+	switch role {
+	case "customer":
+		return repository.ChatScope{CustomerID: userID}
+	case "super-agent":
 		// Super-agents can see all chats
-		query = `SELECT c.id, c.customer_id, c.agent_id, c.status, c.created_at, c.updated_at,
-				 u1.id, u1.username, u1.name, u1.role, u1.avatar, u1.is_online,
-				 u2.id, u2.username, u2.name, u2.role, u2.avatar, u2.is_online
-				 FROM chats c
-				 LEFT JOIN users u1 ON c.customer_id = u1.id
-				 LEFT JOIN users u2 ON c.agent_id = u2.id
-				 WHERE c.status != 'archived'
-				 ORDER BY c.updated_at DESC`
-		args = []interface{}{}
-	} else {
+		return repository.ChatScope{}
+	default:
 		// Regular agents can see their assigned chats and unassigned chats
-		query = `SELECT c.id, c.customer_id, c.agent_id, c.status, c.created_at, c.updated_at,
-				 u1.id, u1.username, u1.name, u1.role, u1.avatar, u1.is_online,
-				 u2.id, u2.username, u2.name, u2.role, u2.avatar, u2.is_online
-				 FROM chats c
-				 LEFT JOIN users u1 ON c.customer_id = u1.id
-				 LEFT JOIN users u2 ON c.agent_id = u2.id
-				 WHERE (c.agent_id = $1 OR c.agent_id IS NULL) AND c.status != 'archived'
-				 ORDER BY c.updated_at DESC`
-		args = []interface{}{userID}
-	}
-
-	rows, err := s.db.Query(query, args...)
+		return repository.ChatScope{AgentID: userID, WithUnassigned: true}
+	}
+}
+
+// Number of chats in a page of a listing when none or too many are asked for.
+const (
+	defaultChatPage = 50
+	maxChatPage     = 200
+)
+
+// listChats returns a page of the chats in scope, archived or not, with the
+// user's unread count and the last message of each. It takes two queries
+// however many chats there are.
+func (s *ChatService) listChats(userID, role string, scope repository.ChatScope, archived bool, filter models.ChatFilter) ([]models.Chat, *models.ChatPaging, error) {
+	if err := checkChatOrder(filter); err != nil {
+		return nil, nil, err
+	}
+	if filter.Limit <= 0 || filter.Limit > maxChatPage {
+		filter.Limit = defaultChatPage
+	}
+	if filter.Offset < 0 {
+		filter.Offset = 0
+	}
+
+	// Tags are staff-only, so customers cannot list chats by them
+	if !isStaff(role) {
+		filter.Tag = ""
+	}
+
+	paging := &models.ChatPaging{
+		Limit:  filter.Limit,
+		Offset: filter.Offset,
+	}
+	// One more than the page tells whether there are more
+	filter.Limit++
+	chats, err := s.repos.Chats.List(repository.ChatListing{
+		Scope:    scope,
+		Archived: archived,
+		Filter:   filter,
+		ReaderID: userID,
+		Staff:    isStaff(role),
+	})
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
-	defer rows.Close()
-
-	var chats []models.Chat
-	for rows.Next() {
-		var chat models.Chat
-		var customer models.User
-		var agent models.User
-		var agentID sql.NullString
-		var agentIDField, agentUsername, agentName, agentRole, agentAvatar sql.NullString
-		var agentIsOnline sql.NullBool
-
-		err := rows.Scan(
-			&chat.ID, &chat.CustomerID, &agentID, &chat.Status, &chat.CreatedAt, &chat.UpdatedAt,
-			&customer.ID, &customer.Username, &customer.Name, &customer.Role, &customer.Avatar, &customer.IsOnline,
-			&agentIDField, &agentUsername, &agentName, &agentRole, &agentAvatar, &agentIsOnline,
-		)
-		if err != nil {
-			return nil, err
-		}
 
-		chat.Customer = &customer
-		if agentID.Valid && agentIDField.Valid {
-			chat.AgentID = &agentID.String
-			agent.ID = agentIDField.String
-			agent.Username = agentUsername.String
-			agent.Name = agentName.String
-			agent.Role = agentRole.String
-			if agentAvatar.Valid {
-				agent.Avatar = &agentAvatar.String
-			}
-			agent.IsOnline = agentIsOnline.Bool
-			chat.Agent = &agent
-		}
-		chat.IsActive = chat.Status == "active"
+	paging.HasMore = len(chats) > paging.Limit
+	if paging.HasMore {
+		chats = chats[:paging.Limit]
+	}
+	for i := range chats {
+		hideStaffFields(&chats[i], role)
+	}
 
-		// Get last message
-		lastMessage, _ := s.getLastMessage(chat.ID)
-		if lastMessage != nil {
-			chat.LastMessage = lastMessage
-		}
+	if err := s.attachLastMessages(chats, role); err != nil {
+		return nil, nil, err
+	}
+	return chats, paging, nil
+}
+
+// checkChatOrder validates the sort and order of a chat listing.
+func checkChatOrder(filter models.ChatFilter) error {
+	switch filter.Order {
+	case "", "desc", "asc":
+	default:
+		return fmt.Errorf("%w: order must be asc or desc", ErrInvalidInput)
+	}
 
-		chats = append(chats, chat)
+	switch filter.Sort {
+	case "", "priority", "updated", "created":
+		return nil
+	default:
+		return fmt.Errorf("%w: sort must be priority, updated or created", ErrInvalidInput)
 	}
+}
 
-	return chats, nil
+// attachLastMessages sets the last message the role may see on each chat,
+// using one query for all of them.
+func (s *ChatService) attachLastMessages(chats []models.Chat, role string) error {
+	if len(chats) == 0 {
+		return nil
+	}
+
+	ids := make([]string, len(chats))
+	for i, chat := range chats {
+		ids[i] = chat.ID
+	}
+
+	latest, err := s.repos.Messages.Latest(ids, isStaff(role))
+	if err != nil {
+		return err
+	}
+	for i := range chats {
+		if message := latest[chats[i].ID]; message != nil {
+			chats[i].LastMessage = MessageForRole(message, role)
+		}
+	}
+	return nil
 }
 
 func (s *ChatService) GetChat(chatID string) (*models.Chat, error) {
-	query := `SELECT c.id, c.customer_id, c.agent_id, c.status, c.created_at, c.updated_at,
-			  u1.id, u1.username, u1.name, u1.role, u1.avatar, u1.is_online,
-			  u2.id, u2.username, u2.name, u2.role, u2.avatar, u2.is_online
-			  FROM chats c
-			  LEFT JOIN users u1 ON c.customer_id = u1.id
-			  LEFT JOIN users u2 ON c.agent_id = u2.id
-			  WHERE c.id = $1`
-
-	var chat models.Chat
-	var customer models.User
-	var agent models.User
-	var agentID sql.NullString
-	var agentIDField, agentUsername, agentName, agentRole, agentAvatar sql.NullString
-	var agentIsOnline sql.NullBool
-
-	err := s.db.QueryRow(query, chatID).Scan(
-		&chat.ID, &chat.CustomerID, &agentID, &chat.Status, &chat.CreatedAt, &chat.UpdatedAt,
-		&customer.ID, &customer.Username, &customer.Name, &customer.Role, &customer.Avatar, &customer.IsOnline,
-		&agentIDField, &agentUsername, &agentName, &agentRole, &agentAvatar, &agentIsOnline,
-	)
+	return s.repos.Chats.Get(chatID)
+}
+
+// GetChatForUser returns a chat the user may see, without the fields their
+// role may not see.
+func (s *ChatService) GetChatForUser(chatID, userID, role string) (*models.Chat, error) {
+	chat, err := s.GetChat(chatID)
 	if err != nil {
 		return nil, err
 	}
+	if !canAccessChat(chat, userID, role) {
+		return nil, ErrForbidden
+	}
+	hideStaffFields(chat, role)
+	return chat, nil
+}
 
-	chat.Customer = &customer
-	if agentID.Valid && agentIDField.Valid {
-		chat.AgentID = &agentID.String
-		agent.ID = agentIDField.String
-		agent.Username = agentUsername.String
-		agent.Name = agentName.String
-		agent.Role = agentRole.String
-		if agentAvatar.Valid {
-			agent.Avatar = &agentAvatar.String
-		}
-		agent.IsOnline = agentIsOnline.Bool
-		chat.Agent = &agent
+// hideStaffFields clears the fields of a chat that only staff may see: its
+// tags, priority, escalation flag and wrap-up notes.
+func hideStaffFields(chat *models.Chat, role string) {
+	if isStaff(role) {
+		return
 	}
-	chat.IsActive = chat.Status == "active"
+	chat.Tags = []string{}
+	chat.Priority = ""
+	chat.IsEscalated = false
+	chat.WrapUpNotes = nil
+}
 
-	return &chat, nil
+// ChatForRole returns a chat as the role may see it, see hideStaffFields.
+func ChatForRole(chat *models.Chat, role string) *models.Chat {
+	if isStaff(role) {
+		return chat
+	}
+	copied := *chat
+	hideStaffFields(&copied, role)
+	return &copied
 }
 
-func (s *ChatService) CreateChat(customerID string, agentID *string) (*models.Chat, error) {
+// CreateChat opens a chat for a customer, assigned to agentID or queued
+// without one. It returns the chat with every field; callers show it with
+// ChatForRole.
+func (s *ChatService) CreateChat(customerID string, agentID *string, topic string) (*models.Chat, error) {
 	chatID := uuid.New().String()
 
-	var query string
-	var args []interface{}
-
-	if agentID != nil {
-		query = `INSERT INTO chats (id, customer_id, agent_id, status, created_at, updated_at)
-				 VALUES ($1, $2, $3, 'active', CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
-				 RETURNING id, customer_id, agent_id, status, created_at, updated_at`
-		args = []interface{}{chatID, customerID, *agentID}
-	} else {
-		query = `INSERT INTO chats (id, customer_id, status, created_at, updated_at)
-				 VALUES ($1, $2, 'active', CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
-				 RETURNING id, customer_id, agent_id, status, created_at, updated_at`
-		args = []interface{}{chatID, customerID}
-	}
-
-	var chat models.Chat
-	err := s.db.QueryRow(query, args...).Scan(
-		&chat.ID, &chat.CustomerID, &chat.AgentID, &chat.Status, &chat.CreatedAt, &chat.UpdatedAt,
-	)
+	topic = strings.ToLower(strings.TrimSpace(topic))
+	if topic == "" {
+		topic = defaultTopic
+	}
+
+	tier, err := s.customerTier(customerID)
 	if err != nil {
 		return nil, err
 	}
+	priority := s.derivePriority(tier, topic)
+
+	chat := models.Chat{
+		ID:         chatID,
+		CustomerID: customerID,
+		AgentID:    agentID,
+		Topic:      topic,
+		Priority:   priority,
+		Status:     models.ChatStatusActive,
+	}
+	if agentID == nil {
+		// Unassigned chats wait in the queue until an agent picks them up
+		chat.Status = models.ChatStatusQueued
+	}
+	if err := s.repos.Chats.Create(&chat); err != nil {
+		return nil, err
+	}
 
 	// Get the complete chat with customer information
 	completeChat, err := s.GetChat(chatID)
@@ -192,347 +242,422 @@ func (s *ChatService) CreateChat(customerID string, agentID *string) (*models.Ch
 		Data:   completeChat,
 	}
 
-	// Notify the customer who created the chat
-	s.hub.BroadcastToUser(completeChat.CustomerID, wsMessage)
-
-	// If there's an agent assigned, notify them too
+	// If there's an agent assigned, notify them
 	if completeChat.AgentID != nil {
 		s.hub.BroadcastToUser(*completeChat.AgentID, wsMessage)
 	}
 
+	// Notify the customer who created the chat
+	wsMessage.Data = ChatForRole(completeChat, "customer")
+	s.hub.BroadcastToUser(completeChat.CustomerID, wsMessage)
+
 	return completeChat, nil
 }
 
-func (s *ChatService) SendMessage(chatID, senderID, content, messageType string) (*models.Message, error) {
-	messageID := uuid.New().String()
+// clientMessageTypes are the message types users may send. Other types, such
+// as system nudges, CSAT prompts and attachments, are only sent by the
+// services that own them.
+var clientMessageTypes = map[string]bool{
+	"text": true,
+}
 
-	query := `INSERT INTO messages (id, chat_id, sender_id, content, message_type, created_at)
-			  VALUES ($1, $2, $3, $4, $5, CURRENT_TIMESTAMP)
-			  RETURNING id, chat_id, sender_id, content, message_type, created_at`
+// SendMessage sends a public message from a user who may access the chat.
+// replyToID optionally quotes an earlier message of the same chat.
+func (s *ChatService) SendMessage(chatID, senderID, role, content, messageType string, replyToID *string) (*models.Message, error) {
+	if !clientMessageTypes[messageType] {
+		return nil, fmt.Errorf("%w: unknown message type %q", ErrInvalidInput, messageType)
+	}
 
-	var message models.Message
-	err := s.db.QueryRow(query, messageID, chatID, senderID, content, messageType).Scan(
-		&message.ID, &message.ChatID, &message.SenderID, &message.Content, &message.MessageType, &message.CreatedAt,
-	)
+	chat, err := s.GetChat(chatID)
 	if err != nil {
 		return nil, err
 	}
+	if !canAccessChat(chat, senderID, role) {
+		return nil, ErrForbidden
+	}
 
-	// Update chat's updated_at timestamp
-	s.db.Exec(`UPDATE chats SET updated_at = CURRENT_TIMESTAMP WHERE id = $1`, chatID)
+	if replyToID != nil && *replyToID == "" {
+		replyToID = nil
+	}
+	return s.sendMessage(newMessage{
+		ChatID:      chatID,
+		SenderID:    senderID,
+		Content:     content,
+		MessageType: messageType,
+		Visibility:  models.VisibilityPublic,
+		ReplyToID:   replyToID,
+	})
+}
 
-	// Broadcast message via WebSocket
-	wsMessage := websocket.Message{
-		Type:   "new_message",
-		ChatID: chatID,
-		Data:   message,
+// sendMessage stores a public message and broadcasts it to the chat.
+func (s *ChatService) sendMessage(m newMessage) (*models.Message, error) {
+	message, err := s.insertMessage(m)
+	if err != nil {
+		return nil, err
 	}
-	s.hub.BroadcastToChat(chatID, wsMessage)
 
-	return &message, nil
+	// A customer reply moves a chat that was waiting on them back to active
+	if chat, err := s.GetChat(m.ChatID); err == nil &&
+		chat.Status == models.ChatStatusPendingCustomer && chat.CustomerID == m.SenderID {
+		s.transition(chat, models.ChatStatusActive, &m.SenderID, nil, nil)
+	}
+
+	// Broadcast message via WebSocket
+	s.broadcastMessageEvent(message, func(role string) websocket.Message {
+		return websocket.Message{
+			Type:   "new_message",
+			ChatID: m.ChatID,
+			Data:   MessageForRole(message, role),
+		}
+	})
+
+	return message, nil
 }
 
-func (s *ChatService) GetMessages(chatID string, limit, offset int) ([]models.Message, error) {
-	query := `SELECT m.id, m.chat_id, m.sender_id, m.content, m.message_type, m.created_at,
-			  u.id, u.username, u.name, u.role, u.avatar, u.is_online
-			  FROM messages m
-			  LEFT JOIN users u ON m.sender_id = u.id
-			  WHERE m.chat_id = $1
-			  ORDER BY m.created_at DESC
-			  LIMIT $2 OFFSET $3`
+// SendWhisper stores a staff-only coaching message and delivers it to the
+// staff participants of the chat. The customer never receives it. Only the
+// assigned agent and super-agents may whisper.
+func (s *ChatService) SendWhisper(chatID, senderID, role, content string) (*models.Message, error) {
+	return s.sendStaffMessage(chatID, senderID, role, content, "whisper", canManageChat)
+}
 
-	rows, err := s.db.Query(query, chatID, limit, offset)
+// sendStaffMessage stores a message with staff visibility and delivers it to
+// the staff participants of the chat and the sender's other connections.
+// allowed decides whether the sender may post it on the chat.
+func (s *ChatService) sendStaffMessage(chatID, senderID, role, content, messageType string, allowed func(chat *models.Chat, userID, role string) bool) (*models.Message, error) {
+	chat, err := s.staffChat(chatID, senderID, role, allowed)
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
 
-	var messages []models.Message
-	for rows.Next() {
-		var message models.Message
-		var sender models.User
+	message, err := s.insertMessage(newMessage{
+		ChatID:      chatID,
+		SenderID:    senderID,
+		Content:     content,
+		MessageType: messageType,
+		Visibility:  models.VisibilityStaff,
+	})
+	if err != nil {
+		return nil, err
+	}
 
-		err := rows.Scan(
-			&message.ID, &message.ChatID, &message.SenderID, &message.Content, &message.MessageType, &message.CreatedAt,
-			&sender.ID, &sender.Username, &sender.Name, &sender.Role, &sender.Avatar, &sender.IsOnline,
-		)
-		if err != nil {
-			return nil, err
-		}
+	s.broadcastStaffMessage(chat, message)
+	return message, nil
+}
 
-		message.Sender = &sender
-		messages = append(messages, message)
+// staffChat loads a chat that a staff member may post staff-only messages on.
+func (s *ChatService) staffChat(chatID, senderID, role string, allowed func(chat *models.Chat, userID, role string) bool) (*models.Chat, error) {
+	if !isStaff(role) {
+		return nil, ErrForbidden
 	}
 
-	// Reverse the slice to get chronological order
-	for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
-		messages[i], messages[j] = messages[j], messages[i]
+	chat, err := s.GetChat(chatID)
+	if err != nil {
+		return nil, err
 	}
+	if !allowed(chat, senderID, role) {
+		return nil, ErrForbidden
+	}
+	return chat, nil
+}
 
-	return messages, nil
+// broadcastStaffMessage delivers a staff-only message to the staff
+// participants of its chat and the sender's other connections.
+func (s *ChatService) broadcastStaffMessage(chat *models.Chat, message *models.Message) {
+	wsMessage := websocket.Message{
+		Type:   "new_message",
+		ChatID: chat.ID,
+		Data:   message,
+	}
+	s.hub.BroadcastToUsers(append(s.staffParticipants(chat), message.SenderID), wsMessage)
 }
 
-func (s *ChatService) UpdateChatStatus(chatID, status string) error {
-	query := `UPDATE chats SET status = $1, updated_at = CURRENT_TIMESTAMP WHERE id = $2`
-	_, err := s.db.Exec(query, status, chatID)
-	return err
+// ObserveChat lets a super-agent silently receive every event of a chat.
+// Nothing is broadcast, so the customer is not aware of the observer.
+func (s *ChatService) ObserveChat(chatID, userID, role string) error {
+	if role != "super-agent" {
+		return ErrForbidden
+	}
+
+	if _, err := s.GetChat(chatID); err != nil {
+		return err
+	}
+
+	s.hub.Observe(chatID, userID)
+	return nil
 }
 
-func (s *ChatService) DeleteChat(chatID string) error {
-	// Messages will be deleted automatically due to CASCADE
-	query := `DELETE FROM chats WHERE id = $1`
-	_, err := s.db.Exec(query, chatID)
-	return err
+func (s *ChatService) UnobserveChat(chatID, userID string) {
+	s.hub.Unobserve(chatID, userID)
 }
 
-func (s *ChatService) GetAvailableAgents(customerID string) ([]models.User, error) {
-	// Get agents who are online and available for new chats (exclude super-agents)
-	query := `SELECT DISTINCT u.id, u.username, u.email, u.name, u.role, u.avatar, u.is_online, u.created_at, u.updated_at
-			  FROM users u
-			  WHERE u.role = 'agent' 
-			  AND u.is_online = true
-			  AND u.id NOT IN (
-				  SELECT DISTINCT c.agent_id 
-				  FROM chats c 
-				  WHERE c.status = 'active' 
-				  AND c.agent_id IS NOT NULL
-				  AND c.customer_id != $1
-			  )
-			  ORDER BY u.name`
-
-	rows, err := s.db.Query(query, customerID)
+// newMessage describes a message to be stored by insertMessage.
+type newMessage struct {
+	ChatID      string
+	SenderID    string
+	Content     string
+	MessageType string
+	Visibility  string
+	// Metadata carries structured data for non-text messages and is stored
+	// as JSON
+	Metadata interface{}
+	// ReplyToID is the message being replied to, if any
+	ReplyToID *string
+	// Attachments are uploaded files to link to the message
+	Attachments []models.Attachment
+}
+
+func (s *ChatService) insertMessage(m newMessage) (*models.Message, error) {
+	var quoted *models.Message
+	if m.ReplyToID != nil {
+		var err error
+		if quoted, err = s.validateReply(m); err != nil {
+			return nil, err
+		}
+	}
+
+	var metadata []byte
+	if m.Metadata != nil {
+		var err error
+		if metadata, err = json.Marshal(m.Metadata); err != nil {
+			return nil, err
+		}
+	}
+
+	message, err := s.repos.Messages.Insert(repository.NewMessage{
+		ChatID:      m.ChatID,
+		SenderID:    m.SenderID,
+		Content:     m.Content,
+		MessageType: m.MessageType,
+		Visibility:  m.Visibility,
+		Metadata:    metadata,
+		ReplyToID:   m.ReplyToID,
+	})
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
 
-	var agents []models.User
-	for rows.Next() {
-		var agent models.User
-		err := rows.Scan(
-			&agent.ID, &agent.Username, &agent.Email, &agent.Name,
-			&agent.Role, &agent.Avatar, &agent.IsOnline, &agent.CreatedAt, &agent.UpdatedAt,
-		)
-		if err != nil {
+	if quoted != nil {
+		message.ReplyToID = &quoted.ID
+		message.ReplyTo = repository.Quote(quoted)
+	}
+	if len(m.Attachments) > 0 {
+		if err := s.linkAttachments(message.ID, m.Attachments); err != nil {
 			return nil, err
 		}
-		agents = append(agents, agent)
+		message.Attachments = m.Attachments
 	}
 
-	return agents, nil
+	return message, nil
 }
 
-func (s *ChatService) GetAvailableCustomers(agentID string) ([]models.User, error) {
-	// Get customers who are online and either have no active chats or need assistance
-	query := `SELECT DISTINCT u.id, u.username, u.email, u.name, u.role, u.avatar, u.is_online, u.created_at, u.updated_at
-			  FROM users u
-			  WHERE u.role = 'customer' 
-			  AND u.is_online = true
-			  AND u.id NOT IN (
-				  SELECT DISTINCT c.customer_id 
-				  FROM chats c 
-				  WHERE c.status = 'active' 
-				  AND c.agent_id IS NOT NULL
-				  AND c.agent_id != $1
-			  )
-			  ORDER BY u.name`
-
-	rows, err := s.db.Query(query, agentID)
+// validateReply checks that a reply quotes a message of the same chat that
+// is still there and that everyone who sees the reply may see. It returns the
+// quoted message.
+func (s *ChatService) validateReply(m newMessage) (*models.Message, error) {
+	quoted, err := s.getMessage(*m.ReplyToID)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("%w: replied-to message does not exist", ErrInvalidInput)
+	}
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
 
-	var customers []models.User
-	for rows.Next() {
-		var customer models.User
-		err := rows.Scan(
-			&customer.ID, &customer.Username, &customer.Email, &customer.Name,
-			&customer.Role, &customer.Avatar, &customer.IsOnline, &customer.CreatedAt, &customer.UpdatedAt,
-		)
-		if err != nil {
-			return nil, err
-		}
-		customers = append(customers, customer)
+	switch {
+	case quoted.ChatID != m.ChatID:
+		return nil, fmt.Errorf("%w: replied-to message belongs to another chat", ErrInvalidInput)
+	case quoted.DeletedAt != nil:
+		return nil, fmt.Errorf("%w: replied-to message has been deleted", ErrInvalidInput)
+	case m.Visibility == models.VisibilityPublic && quoted.Visibility != models.VisibilityPublic:
+		return nil, fmt.Errorf("%w: public messages cannot quote staff-only messages", ErrInvalidInput)
+	}
+	return quoted, nil
+}
+
+// staffParticipants returns the assigned agent, the assisting super-agents
+// and the observers of a chat.
+func (s *ChatService) staffParticipants(chat *models.Chat) []string {
+	ids := s.hub.Observers(chat.ID)
+	if chat.AgentID != nil {
+		ids = append(ids, *chat.AgentID)
 	}
 
-	return customers, nil
+	assistants, err := s.assistants(chat.ID)
+	if err != nil {
+		log.Printf("Error loading assistants of chat %s: %v", chat.ID, err)
+	}
+	return append(ids, assistants...)
 }
 
-func (s *ChatService) GetArchivedChats(userID, role string) ([]models.Chat, error) {
-	var query string
-	var args []interface{}
+// Number of messages in a page when none or too many are asked for.
+const (
+	defaultMessagePage = 50
+	maxMessagePage     = 200
+)
 
-	if role == "customer" {
-		query = `SELECT c.id, c.customer_id, c.agent_id, c.status, c.created_at, c.updated_at,
-				 u1.id, u1.username, u1.name, u1.role, u1.avatar, u1.is_online,
-				 u2.id, u2.username, u2.name, u2.role, u2.avatar, u2.is_online
-				 FROM chats c
-				 LEFT JOIN users u1 ON c.customer_id = u1.id
-				 LEFT JOIN users u2 ON c.agent_id = u2.id
-				 WHERE c.customer_id = $1 AND c.status = 'archived'
-				 ORDER BY c.updated_at DESC`
-		args = []interface{}{userID}
-	} else if role == "super-agent" {
-		// Super-agents can see all archived chats
-		query = `SELECT c.id, c.customer_id, c.agent_id, c.status, c.created_at, c.updated_at,
-				 u1.id, u1.username, u1.name, u1.role, u1.avatar, u1.is_online,
-				 u2.id, u2.username, u2.name, u2.role, u2.avatar, u2.is_online
-				 FROM chats c
-				 LEFT JOIN users u1 ON c.customer_id = u1.id
-				 LEFT JOIN users u2 ON c.agent_id = u2.id
-				 WHERE c.status = 'archived'
-				 ORDER BY c.updated_at DESC`
-		args = []interface{}{}
-	} else {
-		// Regular agents can see their archived chats
-		query = `SELECT c.id, c.customer_id, c.agent_id, c.status, c.created_at, c.updated_at,
-				 u1.id, u1.username, u1.name, u1.role, u1.avatar, u1.is_online,
-				 u2.id, u2.username, u2.name, u2.role, u2.avatar, u2.is_online
-				 FROM chats c
-				 LEFT JOIN users u1 ON c.customer_id = u1.id
-				 LEFT JOIN users u2 ON c.agent_id = u2.id
-				 WHERE c.agent_id = $1 AND c.status = 'archived'
-				 ORDER BY c.updated_at DESC`
-		args = []interface{}{userID}
-	}
-
-	rows, err := s.db.Query(query, args...)
+// GetMessages returns a page of a chat's messages in chronological order.
+// Pages are anchored on a message rather than an offset, so messages arriving
+// while a client pages back neither shift nor repeat the pages.
+func (s *ChatService) GetMessages(chatID, userID, role string, page models.MessagePage) ([]models.Message, *models.MessagePaging, error) {
+	if page.Before != "" && page.After != "" {
+		return nil, nil, fmt.Errorf("%w: only one of before and after may be given", ErrInvalidInput)
+	}
+	if page.Limit <= 0 || page.Limit > maxMessagePage {
+		page.Limit = defaultMessagePage
+	}
+
+	chat, err := s.GetChat(chatID)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+	if !canAccessChat(chat, userID, role) {
+		return nil, nil, ErrForbidden
 	}
-	defer rows.Close()
-
-	var chats []models.Chat
-	for rows.Next() {
-		var chat models.Chat
-		var customer models.User
-		var agent models.User
-		var agentID sql.NullString
-		var agentIDField, agentUsername, agentName, agentRole, agentAvatar sql.NullString
-		var agentIsOnline sql.NullBool
-
-		err := rows.Scan(
-			&chat.ID, &chat.CustomerID, &agentID, &chat.Status, &chat.CreatedAt, &chat.UpdatedAt,
-			&customer.ID, &customer.Username, &customer.Name, &customer.Role, &customer.Avatar, &customer.IsOnline,
-			&agentIDField, &agentUsername, &agentName, &agentRole, &agentAvatar, &agentIsOnline,
-		)
-		if err != nil {
-			return nil, err
-		}
 
-		chat.Customer = &customer
-		if agentID.Valid && agentIDField.Valid {
-			chat.AgentID = &agentID.String
-			agent.ID = agentIDField.String
-			agent.Username = agentUsername.String
-			agent.Name = agentName.String
-			agent.Role = agentRole.String
-			if agentAvatar.Valid {
-				agent.Avatar = &agentAvatar.String
-			}
-			agent.IsOnline = agentIsOnline.Bool
-			chat.Agent = &agent
+	for _, cursor := range []string{page.Before, page.After} {
+		if cursor == "" {
+			continue
 		}
-		chat.IsActive = false // Archived chats are not active
-
-		// Get last message
-		lastMessage, _ := s.getLastMessage(chat.ID)
-		if lastMessage != nil {
-			chat.LastMessage = lastMessage
+		if err := s.checkMessageCursor(chatID, cursor); err != nil {
+			return nil, nil, err
 		}
+	}
+
+	messages, err := s.repos.Messages.Page(chatID, isStaff(role), page.Before, page.After, page.Limit+1)
+	if err != nil {
+		return nil, nil, err
+	}
 
-		chats = append(chats, chat)
+	paging := &models.MessagePaging{
+		Before:  page.Before,
+		After:   page.After,
+		HasMore: len(messages) > page.Limit,
+	}
+	// The extra message is the one furthest from the cursor
+	if paging.HasMore && page.After != "" {
+		messages = messages[:page.Limit]
+	} else if paging.HasMore {
+		messages = messages[1:]
+	}
+	if len(messages) > 0 {
+		paging.Before = messages[0].ID
+		paging.After = messages[len(messages)-1].ID
+	}
+
+	if err := s.attachReactions(messages); err != nil {
+		return nil, nil, err
+	}
+	if err := s.attachAttachments(messages); err != nil {
+		return nil, nil, err
 	}
 
-	return chats, nil
+	hideStaffSeqs(messages, role)
+	return messages, paging, nil
 }
 
-func (s *ChatService) ArchiveChat(chatID, userID, role string) error {
-	// Check if user has permission to archive this chat
-	var checkQuery string
-	var args []interface{}
+// checkMessageCursor makes sure a pagination cursor is a message of the chat.
+func (s *ChatService) checkMessageCursor(chatID, cursor string) error {
+	if _, err := uuid.Parse(cursor); err != nil {
+		return fmt.Errorf("%w: invalid cursor %q", ErrInvalidInput, cursor)
+	}
+
+	message, err := s.repos.Messages.Get(cursor)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	if message == nil || message.ChatID != chatID {
+		return fmt.Errorf("%w: cursor %s is not a message of this chat", ErrInvalidInput, cursor)
+	}
+	return nil
+}
+
+func (s *ChatService) DeleteChat(chatID string) error {
+	return s.repos.Chats.Delete(chatID)
+}
 
+// GetAvailableAgents returns the online agents a customer can start a chat
+// with: those not busy with another customer. Super-agents are not offered.
+func (s *ChatService) GetAvailableAgents(customerID string) ([]models.User, error) {
+	return s.repos.Users.AvailableAgents(customerID)
+}
+
+// GetAvailableCustomers returns the online customers an agent can start a
+// chat with: those not already talking to another agent.
+func (s *ChatService) GetAvailableCustomers(agentID string) ([]models.User, error) {
+	return s.repos.Users.AvailableCustomers(agentID)
+}
+
+func (s *ChatService) GetArchivedChats(userID, role string, filter models.ChatFilter) ([]models.Chat, *models.ChatPaging, error) {
+	var scope repository.ChatScope
 	if role == "customer" {
-		checkQuery = `SELECT id FROM chats WHERE id = $1 AND customer_id = $2`
-		args = []interface{}{chatID, userID}
-	} else if role == "super-agent" {
-		checkQuery = `SELECT id FROM chats WHERE id = $1`
-		args = []interface{}{chatID}
-	} else { // agent
-		checkQuery = `SELECT id FROM chats WHERE id = $1 AND agent_id = $2`
-		args = []interface{}{chatID, userID}
-	}
-
-	var existingID string
-	err := s.db.QueryRow(checkQuery, args...).Scan(&existingID)
+		scope.CustomerID = userID
+	} else if role != "super-agent" {
+		// Regular agents can see their archived chats; super-agents see all
+		scope.AgentID = userID
+	}
+
+	return s.listChats(userID, role, scope, true, filter)
+}
+
+func (s *ChatService) ArchiveChat(chatID, userID, role string) error {
+	chat, err := s.GetChat(chatID)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			return err // Chat not found or no permission
-		}
 		return err
 	}
 
-	// Archive the chat
-	query := `UPDATE chats SET status = 'archived', updated_at = CURRENT_TIMESTAMP WHERE id = $1`
-	_, err = s.db.Exec(query, chatID)
-	return err
+	// Check if user has permission to archive this chat
+	if !canManageChat(chat, userID, role) {
+		return sql.ErrNoRows // Chat not found or no permission
+	}
+
+	if chat.Status == models.ChatStatusArchived {
+		return nil
+	}
+
+	return s.transition(chat, models.ChatStatusArchived, &userID, nil, nil)
 }
 
 func (s *ChatService) UnarchiveChat(chatID, userID, role string) error {
+	chat, err := s.GetChat(chatID)
+	if err != nil {
+		return err
+	}
+
 	// Check if user has permission to unarchive this chat
-	var checkQuery string
-	var args []interface{}
+	if chat.Status != models.ChatStatusArchived || !canManageChat(chat, userID, role) {
+		return sql.ErrNoRows // Chat not found or no permission
+	}
 
-	if role == "customer" {
-		checkQuery = `SELECT id FROM chats WHERE id = $1 AND customer_id = $2 AND status = 'archived'`
-		args = []interface{}{chatID, userID}
-	} else if role == "super-agent" {
-		checkQuery = `SELECT id FROM chats WHERE id = $1 AND status = 'archived'`
-		args = []interface{}{chatID}
-	} else { // agent
-		checkQuery = `SELECT id FROM chats WHERE id = $1 AND agent_id = $2 AND status = 'archived'`
-		args = []interface{}{chatID, userID}
-	}
-
-	var existingID string
-	err := s.db.QueryRow(checkQuery, args...).Scan(&existingID)
+	// Restore the status the chat had before it was archived
+	previous, err := s.statusBeforeArchive(chatID)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			return err // Chat not found or no permission
-		}
 		return err
 	}
 
-	// Unarchive the chat
-	query := `UPDATE chats SET status = 'active', updated_at = CURRENT_TIMESTAMP WHERE id = $1`
-	_, err = s.db.Exec(query, chatID)
-	return err
+	return s.transition(chat, previous, &userID, nil, nil)
 }
 
-func (s *ChatService) getLastMessage(chatID string) (*models.Message, error) {
-	query := `SELECT m.id, m.chat_id, m.sender_id, m.content, m.message_type, m.created_at,
-			  u.id, u.username, u.name, u.role, u.avatar, u.is_online
-			  FROM messages m
-			  LEFT JOIN users u ON m.sender_id = u.id
-			  WHERE m.chat_id = $1
-			  ORDER BY m.created_at DESC
-			  LIMIT 1`
-
-	var message models.Message
-	var sender models.User
+// MessageForRole returns a message as the role may see it: without Seq for
+// customers, who number messages by PublicSeq.
+func MessageForRole(message *models.Message, role string) *models.Message {
+	if isStaff(role) {
+		return message
+	}
+	copied := *message
+	copied.Seq = 0
+	return &copied
+}
 
-	err := s.db.QueryRow(query, chatID).Scan(
-		&message.ID, &message.ChatID, &message.SenderID, &message.Content, &message.MessageType, &message.CreatedAt,
-		&sender.ID, &sender.Username, &sender.Name, &sender.Role, &sender.Avatar, &sender.IsOnline,
-	)
-	if err != nil {
-		if err == sql.ErrNoRows {
-			return nil, nil
-		}
-		return nil, err
+// hideStaffSeqs is MessageForRole for a list of messages, in place.
+func hideStaffSeqs(messages []models.Message, role string) {
+	if isStaff(role) {
+		return
 	}
+	for i := range messages {
+		messages[i].Seq = 0
+	}
+}
 
-	message.Sender = &sender
-	return &message, nil
+func isStaff(role string) bool {
+	return role == "agent" || role == "super-agent"
 }
@@ -0,0 +1,144 @@
+package services
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"cs-socket/internal/config"
+	"cs-socket/internal/database"
+	"cs-socket/internal/models"
+	"cs-socket/internal/repository"
+)
+
+// Size of the dataset the chat listing benchmarks run against.
+const (
+	benchAgents          = 20
+	benchCustomers       = 1000
+	benchChats           = 5000
+	benchMessagesPerChat = 10
+)
+
+// BenchmarkGetChats lists chats from a seeded dataset. It needs a scratch
+// PostgreSQL database, named by BENCH_DB_NAME and reached with the usual DB_*
+// settings; its rows are removed afterwards.
+//
+//	BENCH_DB_NAME=chat_bench go test -run '^$' -bench GetChats ./internal/services
+func BenchmarkGetChats(b *testing.B) {
+	name := os.Getenv("BENCH_DB_NAME")
+	if name == "" {
+		b.Skip("BENCH_DB_NAME is not set")
+	}
+
+	loaded, err := config.Load("")
+	if err != nil {
+		b.Fatal(err)
+	}
+	cfg := loaded.Database
+	cfg.Name = name
+	if err := database.RunMigrations(cfg); err != nil {
+		b.Fatal(err)
+	}
+	db, err := database.Connect(cfg)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer db.Close()
+
+	prefix := fmt.Sprintf("bench%x", time.Now().UnixNano())
+	agentID, customerID, err := seedBenchChats(db, prefix)
+	defer cleanBenchChats(b, db, prefix)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	s := NewChatService(repository.NewPostgres(db), nil, config.PriorityConfig{}, config.MessagesConfig{}, nil)
+	cases := []struct {
+		name   string
+		userID string
+		role   string
+		filter models.ChatFilter
+	}{
+		{"super-agent", agentID, "super-agent", models.ChatFilter{}},
+		{"super-agent/max-page", agentID, "super-agent", models.ChatFilter{Limit: maxChatPage}},
+		{"super-agent/deep-page", agentID, "super-agent", models.ChatFilter{Offset: benchChats - defaultChatPage}},
+		{"super-agent/updated", agentID, "super-agent", models.ChatFilter{Sort: "updated"}},
+		{"super-agent/unassigned", agentID, "super-agent", models.ChatFilter{Unassigned: true}},
+		{"super-agent/has-unread", agentID, "super-agent", models.ChatFilter{HasUnread: true}},
+		{"agent", agentID, "agent", models.ChatFilter{}},
+		{"agent/status", agentID, "agent", models.ChatFilter{Status: models.ChatStatusActive}},
+		{"customer", customerID, "customer", models.ChatFilter{}},
+	}
+
+	for _, c := range cases {
+		b.Run(c.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, _, err := s.GetChats(c.userID, c.role, c.filter); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// seedBenchChats creates agents, customers and chats with messages, all with
+// usernames starting with prefix, and returns an agent and a customer.
+func seedBenchChats(db *sql.DB, prefix string) (agentID, customerID string, err error) {
+	queries := []struct {
+		query string
+		args  []interface{}
+	}{
+		{`INSERT INTO users (username, email, password_hash, name, role)
+			SELECT $1 || '-agent-' || i, $1 || '-agent-' || i || '@bench.test', '-', 'Agent ' || i, 'agent'
+			FROM generate_series(1, $2) i`, []interface{}{prefix, benchAgents}},
+		{`INSERT INTO users (username, email, password_hash, name, role)
+			SELECT $1 || '-customer-' || i, $1 || '-customer-' || i || '@bench.test', '-', 'Customer ' || i, 'customer'
+			FROM generate_series(1, $2) i`, []interface{}{prefix, benchCustomers}},
+		// Every fifth chat is queued without an agent
+		{`INSERT INTO chats (customer_id, agent_id, status, last_seq, last_public_seq, created_at, updated_at)
+			SELECT cu.ids[1 + i % $2], CASE WHEN i % 5 = 0 THEN NULL ELSE ag.ids[1 + i % $3] END,
+				(ARRAY['queued', 'active', 'active', 'pending_customer', 'resolved'])[1 + i % 5], $5, $5,
+				CURRENT_TIMESTAMP - i * interval '1 minute', CURRENT_TIMESTAMP - i * interval '1 minute'
+			FROM generate_series(1, $4) i,
+				(SELECT array_agg(id) AS ids FROM users WHERE username LIKE $1 || '-customer-%') cu,
+				(SELECT array_agg(id) AS ids FROM users WHERE username LIKE $1 || '-agent-%') ag`,
+			[]interface{}{prefix, benchCustomers, benchAgents, benchChats, benchMessagesPerChat}},
+		{`INSERT INTO messages (chat_id, seq, public_seq, sender_id, content, created_at)
+			SELECT c.id, n, n, CASE WHEN n % 2 = 1 OR c.agent_id IS NULL THEN c.customer_id ELSE c.agent_id END,
+				'Benchmark message ' || n, c.created_at + n * interval '1 second'
+			FROM chats c CROSS JOIN generate_series(1, $2) n
+			WHERE c.customer_id IN (SELECT id FROM users WHERE username LIKE $1 || '-customer-%')`,
+			[]interface{}{prefix, benchMessagesPerChat}},
+		// Half of the chats have been read by their agent
+		{`INSERT INTO chat_reads (chat_id, user_id, last_read_seq)
+			SELECT c.id, c.agent_id, c.last_seq FROM chats c
+			WHERE c.agent_id IN (SELECT id FROM users WHERE username LIKE $1 || '-agent-%')
+				AND c.created_at < CURRENT_TIMESTAMP - $2 * interval '1 minute'`, []interface{}{prefix, benchChats / 2}},
+	}
+	for _, q := range queries {
+		if _, err := db.Exec(q.query, q.args...); err != nil {
+			return "", "", err
+		}
+	}
+
+	err = db.QueryRow(`SELECT id FROM users WHERE username = $1`, prefix+"-agent-1").Scan(&agentID)
+	if err != nil {
+		return "", "", err
+	}
+	err = db.QueryRow(`SELECT id FROM users WHERE username = $1`, prefix+"-customer-1").Scan(&customerID)
+	return agentID, customerID, err
+}
+
+func cleanBenchChats(b *testing.B, db *sql.DB, prefix string) {
+	queries := []string{
+		`DELETE FROM chats WHERE customer_id IN (SELECT id FROM users WHERE username LIKE $1 || '-%')`,
+		`DELETE FROM users WHERE username LIKE $1 || '-%'`,
+	}
+	for _, query := range queries {
+		if _, err := db.Exec(query, prefix); err != nil {
+			b.Errorf("cleaning up benchmark data: %v", err)
+		}
+	}
+}
@@ -0,0 +1,748 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"cs-socket/internal/models"
+
+	"github.com/google/uuid"
+)
+
+func TestCreateChat(t *testing.T) {
+	s, repos := newTestChatService(t)
+	customer := createUser(t, repos, "carol", "customer")
+	agent := createUser(t, repos, "bob", "agent")
+
+	chat, err := s.CreateChat(customer.ID, &agent.ID, "")
+	if err != nil {
+		t.Fatalf("CreateChat: %v", err)
+	}
+	if chat.Status != models.ChatStatusActive || chat.Topic != defaultTopic || chat.Priority != models.PriorityNormal {
+		t.Errorf("assigned chat: status %q, topic %q, priority %q", chat.Status, chat.Topic, chat.Priority)
+	}
+	if chat.Customer == nil || chat.Customer.ID != customer.ID || chat.Agent == nil || chat.Agent.ID != agent.ID {
+		t.Errorf("chat participants not loaded: %+v", chat)
+	}
+
+	queued, err := s.CreateChat(customer.ID, nil, "  Withdrawal ")
+	if err != nil {
+		t.Fatalf("CreateChat: %v", err)
+	}
+	if queued.Status != models.ChatStatusQueued {
+		t.Errorf("unassigned chat: status %q, want queued", queued.Status)
+	}
+	if queued.Topic != "withdrawal" || queued.Priority != models.PriorityHigh {
+		t.Errorf("elevated topic: topic %q, priority %q", queued.Topic, queued.Priority)
+	}
+}
+
+func TestCreateChatTierPriority(t *testing.T) {
+	s, repos := newTestChatService(t)
+	customer := createUser(t, repos, "carol", "customer")
+	if err := repos.Users.SetTier(customer.ID, models.TierVIP); err != nil {
+		t.Fatalf("SetTier: %v", err)
+	}
+
+	chat, err := s.CreateChat(customer.ID, nil, "")
+	if err != nil {
+		t.Fatalf("CreateChat: %v", err)
+	}
+	if chat.Priority != models.PriorityHigh {
+		t.Errorf("VIP chat priority = %q, want high", chat.Priority)
+	}
+
+	chat, err = s.CreateChat(customer.ID, nil, "withdrawal")
+	if err != nil {
+		t.Fatalf("CreateChat: %v", err)
+	}
+	if chat.Priority != models.PriorityUrgent {
+		t.Errorf("VIP elevated chat priority = %q, want urgent", chat.Priority)
+	}
+}
+
+func TestAvailableUsers(t *testing.T) {
+	s, repos := newTestChatService(t)
+	chat, customer, agent := createChat(t, s, repos)
+	free := createUser(t, repos, "free-agent", "agent")
+	other := createUser(t, repos, "other-customer", "customer")
+
+	agents, err := s.GetAvailableAgents(other.ID)
+	if err != nil {
+		t.Fatalf("GetAvailableAgents: %v", err)
+	}
+	if len(agents) != 1 || agents[0].ID != free.ID {
+		t.Errorf("agents for another customer = %v, want only %s", agents, free.Name)
+	}
+
+	// The agent is not busy for the customer they are already serving
+	if agents, _ = s.GetAvailableAgents(customer.ID); len(agents) != 2 {
+		t.Errorf("agents for the chat's customer = %d, want 2", len(agents))
+	}
+
+	customers, err := s.GetAvailableCustomers(free.ID)
+	if err != nil {
+		t.Fatalf("GetAvailableCustomers: %v", err)
+	}
+	if len(customers) != 1 || customers[0].ID != other.ID {
+		t.Errorf("customers for another agent = %v, want only %s", customers, other.Name)
+	}
+	if customers, _ = s.GetAvailableCustomers(*chat.AgentID); len(customers) != 2 {
+		t.Errorf("customers for %s = %d, want 2", agent.Name, len(customers))
+	}
+}
+
+func TestGetChatForUserHidesStaffFields(t *testing.T) {
+	s, repos := newTestChatService(t)
+	customer := createUser(t, repos, "carol", "customer")
+	agent := createUser(t, repos, "bob", "agent")
+	other := createUser(t, repos, "dave", "customer")
+
+	created, err := s.CreateChat(customer.ID, &agent.ID, "withdrawal")
+	if err != nil {
+		t.Fatalf("CreateChat: %v", err)
+	}
+
+	chat, err := s.GetChatForUser(created.ID, agent.ID, "agent")
+	if err != nil {
+		t.Fatalf("GetChatForUser as agent: %v", err)
+	}
+	if chat.Priority != models.PriorityHigh {
+		t.Errorf("agent sees priority %q, want high", chat.Priority)
+	}
+
+	chat, err = s.GetChatForUser(created.ID, customer.ID, "customer")
+	if err != nil {
+		t.Fatalf("GetChatForUser as customer: %v", err)
+	}
+	if chat.Priority != "" || len(chat.Tags) != 0 {
+		t.Errorf("customer sees priority %q and tags %v", chat.Priority, chat.Tags)
+	}
+
+	if _, err := s.GetChatForUser(created.ID, other.ID, "customer"); !errors.Is(err, ErrForbidden) {
+		t.Errorf("another customer's chat: err = %v, want ErrForbidden", err)
+	}
+}
+
+func TestHideStaffFields(t *testing.T) {
+	notes := "asked for a refund twice"
+	tagged := models.Chat{
+		Tags:        []string{"refund", "angry"},
+		Priority:    models.PriorityUrgent,
+		IsEscalated: true,
+		WrapUpNotes: &notes,
+	}
+
+	staff := tagged
+	hideStaffFields(&staff, "super-agent")
+	if len(staff.Tags) != 2 || staff.Priority != models.PriorityUrgent || !staff.IsEscalated || staff.WrapUpNotes == nil {
+		t.Errorf("staff fields hidden from a super-agent: %+v", staff)
+	}
+
+	customer := tagged
+	hideStaffFields(&customer, "customer")
+	if customer.Tags == nil || len(customer.Tags) != 0 {
+		t.Errorf("customer sees tags %v, want none", customer.Tags)
+	}
+	if customer.Priority != "" || customer.IsEscalated || customer.WrapUpNotes != nil {
+		t.Errorf("customer sees staff fields: %+v", customer)
+	}
+}
+
+func TestMessageNumbering(t *testing.T) {
+	s, repos := newTestChatService(t)
+	chat, customer, agent := createChat(t, s, repos)
+
+	first := sendMessage(t, s, chat.ID, customer.ID, "hello")
+	whisper, err := s.SendWhisper(chat.ID, agent.ID, "agent", "VIP, be nice")
+	if err != nil {
+		t.Fatalf("SendWhisper: %v", err)
+	}
+	second := sendMessage(t, s, chat.ID, agent.ID, "hi there")
+
+	for _, tc := range []struct {
+		name      string
+		message   *models.Message
+		seq       int64
+		publicSeq int64
+	}{
+		{"first", first, 1, 1},
+		{"whisper", whisper, 2, 0},
+		{"second", second, 3, 2},
+	} {
+		if tc.message.Seq != tc.seq {
+			t.Errorf("%s: seq = %d, want %d", tc.name, tc.message.Seq, tc.seq)
+		}
+		switch {
+		case tc.publicSeq == 0 && tc.message.PublicSeq != nil:
+			t.Errorf("%s: publicSeq = %d, want none", tc.name, *tc.message.PublicSeq)
+		case tc.publicSeq != 0 && (tc.message.PublicSeq == nil || *tc.message.PublicSeq != tc.publicSeq):
+			t.Errorf("%s: publicSeq = %v, want %d", tc.name, tc.message.PublicSeq, tc.publicSeq)
+		}
+	}
+
+	if whisper.Visibility != models.VisibilityStaff {
+		t.Errorf("whisper visibility = %q", whisper.Visibility)
+	}
+	if _, err := s.SendWhisper(chat.ID, customer.ID, "customer", "psst"); err != ErrForbidden {
+		t.Errorf("customer whisper: err = %v, want ErrForbidden", err)
+	}
+}
+
+func TestStaffMessagePermissions(t *testing.T) {
+	s, repos := newTestChatService(t)
+	chat, _, agent := createChat(t, s, repos)
+	other := createUser(t, repos, "other", "agent")
+	super := createUser(t, repos, "super", "super-agent")
+	unassigned, err := s.CreateChat(createUser(t, repos, "waiting", "customer").ID, nil, "")
+	if err != nil {
+		t.Fatalf("CreateChat: %v", err)
+	}
+
+	for _, tc := range []struct {
+		name   string
+		send   func(chatID, senderID, role, content string) (*models.Message, error)
+		chatID string
+		user   *models.User
+		want   error
+	}{
+		{"whisper by assigned agent", s.SendWhisper, chat.ID, agent, nil},
+		{"whisper by super-agent", s.SendWhisper, chat.ID, super, nil},
+		{"whisper by other agent", s.SendWhisper, chat.ID, other, ErrForbidden},
+		{"whisper on unassigned chat", s.SendWhisper, unassigned.ID, other, ErrForbidden},
+		{"note by assigned agent", s.AddNote, chat.ID, agent, nil},
+		{"note by other agent", s.AddNote, chat.ID, other, ErrForbidden},
+		{"note on unassigned chat", s.AddNote, unassigned.ID, other, nil},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := tc.send(tc.chatID, tc.user.ID, tc.user.Role, "psst"); err != tc.want {
+				t.Errorf("err = %v, want %v", err, tc.want)
+			}
+		})
+	}
+}
+
+func TestSendMessageChecks(t *testing.T) {
+	s, repos := newTestChatService(t)
+	chat, customer, _ := createChat(t, s, repos)
+	_, otherCustomer, otherAgent := createChat(t, s, repos)
+
+	for _, tc := range []struct {
+		name        string
+		senderID    string
+		role        string
+		messageType string
+		want        error
+	}{
+		{"system type", customer.ID, "customer", "system", ErrInvalidInput},
+		{"CSAT prompt type", customer.ID, "customer", "csat_prompt", ErrInvalidInput},
+		{"whisper type", customer.ID, "customer", "whisper", ErrInvalidInput},
+		{"other customer", otherCustomer.ID, "customer", "text", ErrForbidden},
+		{"other agent", otherAgent.ID, "agent", "text", ErrForbidden},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := s.SendMessage(chat.ID, tc.senderID, tc.role, "hello", tc.messageType, nil)
+			if !errors.Is(err, tc.want) {
+				t.Errorf("err = %v, want %v", err, tc.want)
+			}
+		})
+	}
+}
+
+func TestReplies(t *testing.T) {
+	s, repos := newTestChatService(t)
+	chat, customer, agent := createChat(t, s, repos)
+	otherChat, otherCustomer, _ := createChat(t, s, repos)
+
+	quoted := sendMessage(t, s, chat.ID, customer.ID, "where is my withdrawal?")
+	reply, err := s.SendMessage(chat.ID, agent.ID, "agent", "on its way", "text", &quoted.ID)
+	if err != nil {
+		t.Fatalf("SendMessage: %v", err)
+	}
+	if reply.ReplyTo == nil || reply.ReplyTo.ID != quoted.ID || reply.ReplyTo.Snippet != quoted.Content {
+		t.Errorf("reply quote = %+v", reply.ReplyTo)
+	}
+
+	whisper, err := s.SendWhisper(chat.ID, agent.ID, "agent", "checking")
+	if err != nil {
+		t.Fatalf("SendWhisper: %v", err)
+	}
+	elsewhere := sendMessage(t, s, otherChat.ID, otherCustomer.ID, "hi")
+	missing := uuid.New().String()
+
+	for _, tc := range []struct {
+		name      string
+		replyToID string
+	}{
+		{"other chat", elsewhere.ID},
+		{"staff-only", whisper.ID},
+		{"missing", missing},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := s.SendMessage(chat.ID, agent.ID, "agent", "reply", "text", &tc.replyToID)
+			if !errors.Is(err, ErrInvalidInput) {
+				t.Errorf("err = %v, want ErrInvalidInput", err)
+			}
+		})
+	}
+}
+
+func TestGetMessagesPaging(t *testing.T) {
+	s, repos := newTestChatService(t)
+	chat, customer, agent := createChat(t, s, repos)
+
+	var sent []*models.Message
+	for _, content := range []string{"one", "two", "three", "four", "five"} {
+		sent = append(sent, sendMessage(t, s, chat.ID, customer.ID, content))
+	}
+	if _, err := s.SendWhisper(chat.ID, agent.ID, "agent", "staff only"); err != nil {
+		t.Fatalf("SendWhisper: %v", err)
+	}
+
+	contents := func(messages []models.Message) []string {
+		var out []string
+		for _, message := range messages {
+			out = append(out, message.Content)
+		}
+		return out
+	}
+	check := func(t *testing.T, role string, page models.MessagePage, want []string, hasMore bool) {
+		t.Helper()
+		userID := customer.ID
+		if role != "customer" {
+			userID = agent.ID
+		}
+		messages, paging, err := s.GetMessages(chat.ID, userID, role, page)
+		if err != nil {
+			t.Fatalf("GetMessages: %v", err)
+		}
+		got := contents(messages)
+		if len(got) != len(want) {
+			t.Fatalf("messages = %v, want %v", got, want)
+		}
+		for i := range got {
+			if got[i] != want[i] {
+				t.Fatalf("messages = %v, want %v", got, want)
+			}
+		}
+		if paging.HasMore != hasMore {
+			t.Errorf("hasMore = %v, want %v", paging.HasMore, hasMore)
+		}
+	}
+
+	t.Run("newest", func(t *testing.T) {
+		check(t, "customer", models.MessagePage{Limit: 2}, []string{"four", "five"}, true)
+	})
+	t.Run("staff", func(t *testing.T) {
+		check(t, "agent", models.MessagePage{Limit: 2}, []string{"five", "staff only"}, true)
+	})
+	t.Run("before", func(t *testing.T) {
+		check(t, "customer", models.MessagePage{Before: sent[3].ID, Limit: 2}, []string{"two", "three"}, true)
+		check(t, "customer", models.MessagePage{Before: sent[1].ID, Limit: 2}, []string{"one"}, false)
+	})
+	t.Run("after", func(t *testing.T) {
+		check(t, "customer", models.MessagePage{After: sent[0].ID, Limit: 2}, []string{"two", "three"}, true)
+		check(t, "customer", models.MessagePage{After: sent[2].ID, Limit: 2}, []string{"four", "five"}, false)
+	})
+}
+
+func TestGetMessagesErrors(t *testing.T) {
+	s, repos := newTestChatService(t)
+	chat, customer, _ := createChat(t, s, repos)
+	_, stranger, _ := createChat(t, s, repos)
+	message := sendMessage(t, s, chat.ID, customer.ID, "hello")
+
+	if _, _, err := s.GetMessages(chat.ID, stranger.ID, "customer", models.MessagePage{}); err != ErrForbidden {
+		t.Errorf("other customer: err = %v, want ErrForbidden", err)
+	}
+
+	for _, tc := range []struct {
+		name string
+		page models.MessagePage
+	}{
+		{"both cursors", models.MessagePage{Before: message.ID, After: message.ID}},
+		{"malformed cursor", models.MessagePage{Before: "not-a-uuid"}},
+		{"unknown cursor", models.MessagePage{After: uuid.New().String()}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, _, err := s.GetMessages(chat.ID, customer.ID, "customer", tc.page)
+			if !errors.Is(err, ErrInvalidInput) {
+				t.Errorf("err = %v, want ErrInvalidInput", err)
+			}
+		})
+	}
+}
+
+func TestMarkRead(t *testing.T) {
+	s, repos := newTestChatService(t)
+	chat, customer, agent := createChat(t, s, repos)
+	otherChat, otherCustomer, _ := createChat(t, s, repos)
+
+	message := sendMessage(t, s, chat.ID, agent.ID, "hello")
+	whisper, err := s.SendWhisper(chat.ID, agent.ID, "agent", "staff only")
+	if err != nil {
+		t.Fatalf("SendWhisper: %v", err)
+	}
+	elsewhere := sendMessage(t, s, otherChat.ID, otherCustomer.ID, "hi")
+
+	if err := s.MarkRead(chat.ID, customer.ID, "customer", message.ID); err != nil {
+		t.Errorf("MarkRead: %v", err)
+	}
+	if err := s.MarkRead(chat.ID, otherCustomer.ID, "customer", message.ID); err != ErrForbidden {
+		t.Errorf("other customer: err = %v, want ErrForbidden", err)
+	}
+
+	for _, tc := range []struct{ name, messageID string }{
+		{"malformed", "not-a-uuid"},
+		{"other chat", elsewhere.ID},
+		{"staff-only", whisper.ID},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			err := s.MarkRead(chat.ID, customer.ID, "customer", tc.messageID)
+			if !errors.Is(err, ErrInvalidInput) {
+				t.Errorf("err = %v, want ErrInvalidInput", err)
+			}
+		})
+	}
+}
+
+func TestReactions(t *testing.T) {
+	s, repos := newTestChatService(t)
+	chat, customer, agent := createChat(t, s, repos)
+	message := sendMessage(t, s, chat.ID, customer.ID, "thanks!")
+
+	if _, err := s.AddReaction(chat.ID, message.ID, agent.ID, "agent", "🎉"); !errors.Is(err, ErrInvalidInput) {
+		t.Errorf("disallowed emoji: err = %v, want ErrInvalidInput", err)
+	}
+
+	for _, userID := range []string{agent.ID, customer.ID, agent.ID} {
+		role := "agent"
+		if userID == customer.ID {
+			role = "customer"
+		}
+		if _, err := s.AddReaction(chat.ID, message.ID, userID, role, testReactions[0]); err != nil {
+			t.Fatalf("AddReaction: %v", err)
+		}
+	}
+	reactions, err := s.AddReaction(chat.ID, message.ID, customer.ID, "customer", testReactions[1])
+	if err != nil {
+		t.Fatalf("AddReaction: %v", err)
+	}
+	if len(reactions) != 2 || reactions[0].Emoji != testReactions[0] || reactions[0].Count != 2 || reactions[1].Count != 1 {
+		t.Errorf("reactions = %+v", reactions)
+	}
+
+	if reactions, err = s.RemoveReaction(chat.ID, message.ID, agent.ID, "agent", testReactions[0]); err != nil {
+		t.Fatalf("RemoveReaction: %v", err)
+	}
+	if len(reactions) != 2 || reactions[0].Count != 1 {
+		t.Errorf("reactions after removal = %+v", reactions)
+	}
+
+	messages, _, err := s.GetMessages(chat.ID, customer.ID, "customer", models.MessagePage{})
+	if err != nil {
+		t.Fatalf("GetMessages: %v", err)
+	}
+	if len(messages) != 1 || len(messages[0].Reactions) != 2 {
+		t.Errorf("messages = %+v", messages)
+	}
+}
+
+func TestEditAfterReassignment(t *testing.T) {
+	s, repos := newTestChatService(t)
+	chat, _, agent := createChat(t, s, repos)
+	other := createUser(t, repos, "other", "agent")
+	message := sendMessage(t, s, chat.ID, agent.ID, "let me check")
+
+	// The agent hands the chat back to the queue and another one picks it up
+	requeue := models.UpdateChatStatusRequest{Status: models.ChatStatusQueued}
+	if _, err := s.UpdateChatStatus(chat.ID, agent.ID, "agent", requeue); err != nil {
+		t.Fatalf("requeueing: %v", err)
+	}
+	pickUp := models.UpdateChatStatusRequest{Status: models.ChatStatusActive}
+	if _, err := s.UpdateChatStatus(chat.ID, other.ID, "agent", pickUp); err != nil {
+		t.Fatalf("picking up: %v", err)
+	}
+
+	if _, err := s.EditMessage(chat.ID, message.ID, agent.ID, "agent", "never mind"); !errors.Is(err, ErrForbidden) {
+		t.Errorf("EditMessage: err = %v, want ErrForbidden", err)
+	}
+	if err := s.DeleteMessage(chat.ID, message.ID, agent.ID, "agent"); !errors.Is(err, ErrForbidden) {
+		t.Errorf("DeleteMessage: err = %v, want ErrForbidden", err)
+	}
+}
+
+func TestGetChats(t *testing.T) {
+	s, repos := newTestChatService(t)
+	own, customer, agent := createChat(t, s, repos)
+	other, _, _ := createChat(t, s, repos)
+	queued, err := s.CreateChat(createUser(t, repos, "dave", "customer").ID, nil, "")
+	if err != nil {
+		t.Fatalf("CreateChat: %v", err)
+	}
+	super := createUser(t, repos, "sue", "super-agent")
+
+	tests := []struct {
+		name   string
+		userID string
+		role   string
+		want   []string
+	}{
+		{"customer", customer.ID, "customer", []string{own.ID}},
+		{"agent", agent.ID, "agent", []string{own.ID, queued.ID}},
+		{"super-agent", super.ID, "super-agent", []string{own.ID, other.ID, queued.ID}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chats, _, err := s.GetChats(tt.userID, tt.role, models.ChatFilter{})
+			if err != nil {
+				t.Fatalf("GetChats: %v", err)
+			}
+			if !sameChats(chats, tt.want) {
+				t.Errorf("chats = %v, want %v", chatIDs(chats), tt.want)
+			}
+		})
+	}
+
+	chats, _, err := s.GetChats(customer.ID, "customer", models.ChatFilter{})
+	if err != nil {
+		t.Fatalf("GetChats: %v", err)
+	}
+	if len(chats) == 1 && chats[0].Priority != "" {
+		t.Errorf("customer sees priority %q", chats[0].Priority)
+	}
+
+	first, paging, err := s.GetChats(super.ID, "super-agent", models.ChatFilter{Limit: 2})
+	if err != nil {
+		t.Fatalf("GetChats: %v", err)
+	}
+	if len(first) != 2 || !paging.HasMore {
+		t.Errorf("first page: %d chats, hasMore %v", len(first), paging.HasMore)
+	}
+	rest, paging, err := s.GetChats(super.ID, "super-agent", models.ChatFilter{Limit: 2, Offset: 2})
+	if err != nil {
+		t.Fatalf("GetChats: %v", err)
+	}
+	if len(rest) != 1 || paging.HasMore {
+		t.Errorf("second page: %d chats, hasMore %v", len(rest), paging.HasMore)
+	}
+	if !sameChats(append(first, rest...), tests[2].want) {
+		t.Errorf("pages = %v + %v, want %v", chatIDs(first), chatIDs(rest), tests[2].want)
+	}
+
+	if _, _, err := s.GetChats(super.ID, "super-agent", models.ChatFilter{Sort: "name"}); !errors.Is(err, ErrInvalidInput) {
+		t.Errorf("unknown sort: err = %v, want ErrInvalidInput", err)
+	}
+}
+
+func TestGetChatsByPriority(t *testing.T) {
+	s, repos := newTestChatService(t)
+	normal, _, _ := createChat(t, s, repos)
+	closed, _, closer := createChat(t, s, repos)
+	agent := createUser(t, repos, "bob", "agent")
+	high, err := s.CreateChat(createUser(t, repos, "carol", "customer").ID, &agent.ID, "withdrawal")
+	if err != nil {
+		t.Fatalf("CreateChat: %v", err)
+	}
+	urgent, err := s.CreateChat(createUser(t, repos, "dave", "customer").ID, &agent.ID, "withdrawal")
+	if err != nil {
+		t.Fatalf("CreateChat: %v", err)
+	}
+	if _, err := s.EscalatePriority(urgent.ID, agent.ID, "agent"); err != nil {
+		t.Fatalf("EscalatePriority: %v", err)
+	}
+	closing := models.UpdateChatStatusRequest{Status: models.ChatStatusClosed, ResolutionCode: "resolved"}
+	if _, err := s.UpdateChatStatus(closed.ID, closer.ID, "agent", closing); err != nil {
+		t.Fatalf("closing: %v", err)
+	}
+	super := createUser(t, repos, "sue", "super-agent")
+
+	// Closed chats rank below every open one
+	descending := []string{urgent.ID, high.ID, normal.ID, closed.ID}
+	tests := []struct {
+		order string
+		want  []string
+	}{
+		{"", descending},
+		{"desc", descending},
+		{"asc", []string{closed.ID, normal.ID, high.ID, urgent.ID}},
+	}
+	for _, tt := range tests {
+		t.Run("order "+tt.order, func(t *testing.T) {
+			chats, _, err := s.GetChats(super.ID, "super-agent", models.ChatFilter{Sort: "priority", Order: tt.order})
+			if err != nil {
+				t.Fatalf("GetChats: %v", err)
+			}
+			if got := chatIDs(chats); fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("chats = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetArchivedChats(t *testing.T) {
+	s, repos := newTestChatService(t)
+	chat, customer, agent := createChat(t, s, repos)
+	other, _, _ := createChat(t, s, repos)
+	super := createUser(t, repos, "sue", "super-agent")
+
+	for _, c := range []*models.Chat{chat, other} {
+		if err := s.ArchiveChat(c.ID, super.ID, "super-agent"); err != nil {
+			t.Fatalf("ArchiveChat: %v", err)
+		}
+	}
+
+	tests := []struct {
+		name   string
+		userID string
+		role   string
+		want   []string
+	}{
+		{"customer", customer.ID, "customer", []string{chat.ID}},
+		{"agent", agent.ID, "agent", []string{chat.ID}},
+		{"super-agent", super.ID, "super-agent", []string{chat.ID, other.ID}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chats, _, err := s.GetArchivedChats(tt.userID, tt.role, models.ChatFilter{})
+			if err != nil {
+				t.Fatalf("GetArchivedChats: %v", err)
+			}
+			if !sameChats(chats, tt.want) {
+				t.Errorf("chats = %v, want %v", chatIDs(chats), tt.want)
+			}
+		})
+	}
+
+	active, _, err := s.GetChats(super.ID, "super-agent", models.ChatFilter{})
+	if err != nil {
+		t.Fatalf("GetChats: %v", err)
+	}
+	if len(active) != 0 {
+		t.Errorf("archived chats listed as open: %v", chatIDs(active))
+	}
+}
+
+func TestSearchMessages(t *testing.T) {
+	s, repos := newTestChatService(t)
+	chat, customer, agent := createChat(t, s, repos)
+	other, otherCustomer, _ := createChat(t, s, repos)
+	sendMessage(t, s, chat.ID, customer.ID, "I would like a refund")
+	if _, err := s.AddNote(chat.ID, agent.ID, "agent", "refund looks legitimate"); err != nil {
+		t.Fatalf("AddNote: %v", err)
+	}
+	sendMessage(t, s, other.ID, otherCustomer.ID, "refund please")
+
+	results, err := s.SearchMessages(customer.ID, "customer", models.MessageSearchFilter{Query: "refund"})
+	if err != nil {
+		t.Fatalf("SearchMessages: %v", err)
+	}
+	if len(results) != 1 || results[0].ChatID != chat.ID || results[0].SenderID != customer.ID {
+		t.Errorf("customer results = %+v", results)
+	} else if results[0].Headline != "I would like a <mark>refund</mark>" {
+		t.Errorf("headline = %q", results[0].Headline)
+	}
+
+	results, err = s.SearchMessages(agent.ID, "agent", models.MessageSearchFilter{Query: "refund"})
+	if err != nil {
+		t.Fatalf("SearchMessages: %v", err)
+	}
+	if len(results) != 2 {
+		t.Errorf("agent results = %+v, want the message and the note of their chat", results)
+	}
+	for _, result := range results {
+		if result.ChatID != chat.ID {
+			t.Errorf("agent found a message of chat %s they are not assigned to", result.ChatID)
+		}
+	}
+}
+
+func TestSync(t *testing.T) {
+	s, repos := newTestChatService(t)
+	chat, customer, agent := createChat(t, s, repos)
+	createChat(t, s, repos)
+	since := encodeSyncCursor(time.Now().Add(-time.Minute))
+	sendMessage(t, s, chat.ID, customer.ID, "hello")
+	if _, err := s.AddNote(chat.ID, agent.ID, "agent", "regular customer"); err != nil {
+		t.Fatalf("AddNote: %v", err)
+	}
+
+	first, err := s.Sync(customer.ID, "customer", "")
+	if err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+	if !first.Reset || first.Cursor == "" {
+		t.Errorf("sync without a cursor: reset %v, cursor %q", first.Reset, first.Cursor)
+	}
+
+	result, err := s.Sync(customer.ID, "customer", since)
+	if err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+	if result.Reset || !sameChats(result.Chats, []string{chat.ID}) {
+		t.Errorf("customer sync: reset %v, chats %v", result.Reset, chatIDs(result.Chats))
+	}
+	if len(result.Messages) != 1 || result.Messages[0].Content != "hello" {
+		t.Errorf("customer sync messages = %+v, want the public message only", result.Messages)
+	}
+	if len(result.Chats) == 1 && result.Chats[0].Priority != "" {
+		t.Errorf("customer sync shows priority %q", result.Chats[0].Priority)
+	}
+
+	result, err = s.Sync(agent.ID, "agent", since)
+	if err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+	if len(result.Messages) != 2 {
+		t.Errorf("agent sync messages = %+v, want the message and the note", result.Messages)
+	}
+
+	if _, err := s.Sync(customer.ID, "customer", "not a cursor"); !errors.Is(err, ErrInvalidInput) {
+		t.Errorf("invalid cursor: err = %v, want ErrInvalidInput", err)
+	}
+}
+
+func TestChangePriorityRequiresAssignment(t *testing.T) {
+	s, repos := newTestChatService(t)
+	agent := createUser(t, repos, "bob", "agent")
+	queued, err := s.CreateChat(createUser(t, repos, "carol", "customer").ID, nil, "")
+	if err != nil {
+		t.Fatalf("CreateChat: %v", err)
+	}
+	chat, _, _ := createChat(t, s, repos)
+
+	for _, chatID := range []string{queued.ID, chat.ID} {
+		if _, err := s.EscalatePriority(chatID, agent.ID, "agent"); !errors.Is(err, ErrForbidden) {
+			t.Errorf("EscalatePriority on a chat not assigned to the agent: err = %v, want ErrForbidden", err)
+		}
+	}
+}
+
+// chatIDs returns the IDs of chats.
+func chatIDs(chats []models.Chat) []string {
+	ids := make([]string, len(chats))
+	for i := range chats {
+		ids[i] = chats[i].ID
+	}
+	return ids
+}
+
+// sameChats reports whether chats are exactly the chats with the given IDs,
+// in any order.
+func sameChats(chats []models.Chat, ids []string) bool {
+	if len(chats) != len(ids) {
+		return false
+	}
+	want := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		want[id] = true
+	}
+	for _, chat := range chats {
+		if !want[chat.ID] {
+			return false
+		}
+	}
+	return true
+}
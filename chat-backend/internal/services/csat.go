@@ -0,0 +1,132 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"cs-socket/internal/models"
+	"cs-socket/internal/repository"
+	"cs-socket/internal/websocket"
+)
+
+const csatPromptContent = "How would you rate this conversation?"
+
+// sendCSATPrompt asks the customer of a resolved chat to rate it, unless the
+// chat was already rated. The prompt is a structured message from the system
+// user, since no agent wrote it.
+func (s *ChatService) sendCSATPrompt(chat *models.Chat) error {
+	rated, err := s.repos.CSAT.Rated(chat.ID)
+	if err != nil || rated {
+		return err
+	}
+
+	_, err = s.sendMessage(newMessage{
+		ChatID:      chat.ID,
+		SenderID:    SystemUserID,
+		Content:     csatPromptContent,
+		MessageType: "csat_prompt",
+		Visibility:  models.VisibilityPublic,
+		Metadata: map[string]interface{}{
+			"minScore": 1,
+			"maxScore": 5,
+		},
+	})
+	return err
+}
+
+type CSATService struct {
+	repos repository.Repositories
+	hub   *websocket.Hub
+}
+
+func NewCSATService(repos repository.Repositories, hub *websocket.Hub) *CSATService {
+	return &CSATService{
+		repos: repos,
+		hub:   hub,
+	}
+}
+
+// SubmitRating stores the customer's rating of a resolved chat together with
+// the agent who handled it and that agent's team. Rating again replaces the
+// previous score.
+func (s *CSATService) SubmitRating(chatID, userID, role string, req models.CSATRequest) (*models.CSATRating, error) {
+	if req.Score < 1 || req.Score > 5 {
+		return nil, fmt.Errorf("%w: score must be between 1 and 5", ErrInvalidInput)
+	}
+
+	chat, err := s.repos.Chats.Get(chatID)
+	if err != nil {
+		return nil, err
+	}
+	if role != "customer" || chat.CustomerID != userID {
+		return nil, ErrForbidden
+	}
+	if chat.Status != models.ChatStatusResolved && chat.Status != models.ChatStatusClosed && chat.Status != models.ChatStatusArchived {
+		return nil, fmt.Errorf("%w: chat must be resolved before it can be rated", ErrInvalidTransition)
+	}
+
+	var comment *string
+	if trimmed := strings.TrimSpace(req.Comment); trimmed != "" {
+		comment = &trimmed
+	}
+
+	rating, err := s.repos.CSAT.Submit(chatID, req.Score, comment)
+	if err != nil {
+		return nil, err
+	}
+
+	if rating.AgentID != nil {
+		s.hub.BroadcastToUsersAndRole([]string{*rating.AgentID}, "super-agent", websocket.Message{
+			Type:   "csat_submitted",
+			ChatID: chatID,
+			Data:   rating,
+		})
+	}
+
+	return rating, nil
+}
+
+// HandleSocketSubmit accepts a csat_submit socket message whose data holds a
+// score and optional comment, and answers the sender with csat_recorded or
+// an error event.
+func (s *CSATService) HandleSocketSubmit(userID, role string, msg websocket.Message) {
+	var req models.CSATRequest
+	data, err := json.Marshal(msg.Data)
+	if err == nil {
+		err = json.Unmarshal(data, &req)
+	}
+
+	var rating *models.CSATRating
+	if err == nil {
+		rating, err = s.SubmitRating(msg.ChatID, userID, role, req)
+	}
+
+	if err != nil {
+		s.hub.BroadcastToUser(userID, websocket.Message{
+			Type:   "error",
+			ChatID: msg.ChatID,
+			Data: map[string]interface{}{
+				"request": msg.Type,
+				"error":   err.Error(),
+			},
+		})
+		return
+	}
+
+	s.hub.BroadcastToUser(userID, websocket.Message{
+		Type:   "csat_recorded",
+		ChatID: msg.ChatID,
+		Data:   rating,
+	})
+}
+
+// GetSummary aggregates ratings per agent, per team or overall.
+func (s *CSATService) GetSummary(filter models.CSATFilter) ([]models.CSATSummary, error) {
+	switch filter.GroupBy {
+	case "", "agent", "team":
+	default:
+		return nil, fmt.Errorf("%w: groupBy must be agent or team", ErrInvalidInput)
+	}
+	return s.repos.CSAT.Summary(filter)
+}
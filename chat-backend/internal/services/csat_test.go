@@ -0,0 +1,71 @@
+package services
+
+import (
+	"errors"
+	"testing"
+
+	"cs-socket/internal/models"
+)
+
+func TestSubmitRating(t *testing.T) {
+	s, repos := newTestChatService(t)
+	csat := NewCSATService(repos, s.hub)
+	chat, customer, agent := createChat(t, s, repos)
+
+	if _, err := csat.SubmitRating(chat.ID, customer.ID, "customer", models.CSATRequest{Score: 5}); !errors.Is(err, ErrInvalidTransition) {
+		t.Errorf("rating an active chat: err = %v, want ErrInvalidTransition", err)
+	}
+
+	resolve := models.UpdateChatStatusRequest{Status: models.ChatStatusResolved, ResolutionCode: "resolved"}
+	if _, err := s.UpdateChatStatus(chat.ID, agent.ID, "agent", resolve); err != nil {
+		t.Fatalf("resolving: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		userID  string
+		role    string
+		score   int
+		wantErr error
+	}{
+		{"score too low", customer.ID, "customer", 0, ErrInvalidInput},
+		{"score too high", customer.ID, "customer", 6, ErrInvalidInput},
+		{"agent", agent.ID, "agent", 5, ErrForbidden},
+		{"another customer", createUser(t, repos, "dave", "customer").ID, "customer", 5, ErrForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := csat.SubmitRating(chat.ID, tt.userID, tt.role, models.CSATRequest{Score: tt.score})
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("err = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	if _, err := csat.SubmitRating(chat.ID, customer.ID, "customer", models.CSATRequest{Score: 2}); err != nil {
+		t.Fatalf("SubmitRating: %v", err)
+	}
+	// Rating again replaces the score
+	rating, err := csat.SubmitRating(chat.ID, customer.ID, "customer", models.CSATRequest{Score: 4, Comment: "  quick  "})
+	if err != nil {
+		t.Fatalf("SubmitRating: %v", err)
+	}
+	if rating.Score != 4 || rating.Comment == nil || *rating.Comment != "quick" {
+		t.Errorf("rating: score %d, comment %v", rating.Score, rating.Comment)
+	}
+	if rating.AgentID == nil || *rating.AgentID != agent.ID {
+		t.Errorf("rating agent = %v, want %s", rating.AgentID, agent.ID)
+	}
+
+	summaries, err := csat.GetSummary(models.CSATFilter{GroupBy: "agent"})
+	if err != nil {
+		t.Fatalf("GetSummary: %v", err)
+	}
+	if len(summaries) != 1 || summaries[0].Key != agent.ID || summaries[0].Responses != 1 ||
+		summaries[0].Average != 4 || summaries[0].Satisfaction != 100 {
+		t.Errorf("summaries = %+v", summaries)
+	}
+	if _, err := csat.GetSummary(models.CSATFilter{GroupBy: "customer"}); !errors.Is(err, ErrInvalidInput) {
+		t.Errorf("unknown grouping: err = %v, want ErrInvalidInput", err)
+	}
+}
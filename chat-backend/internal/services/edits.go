@@ -0,0 +1,168 @@
+package services
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"cs-socket/internal/models"
+	"cs-socket/internal/websocket"
+)
+
+// editableTypes are the message types whose sender may edit or delete them.
+// System messages, surveys and the like are never changed.
+var editableTypes = map[string]bool{
+	"text":    true,
+	"whisper": true,
+	"note":    true,
+}
+
+// EditMessage replaces the content of the caller's own message. The previous
+// version is kept in the message's edit history.
+func (s *ChatService) EditMessage(chatID, messageID, userID, role, content string) (*models.Message, error) {
+	content = strings.TrimSpace(content)
+	if content == "" {
+		return nil, fmt.Errorf("%w: content is required", ErrInvalidInput)
+	}
+
+	if _, err := s.ownMessage(chatID, messageID, userID, role); err != nil {
+		return nil, err
+	}
+
+	err := s.repos.Messages.Edit(messageID, userID, content, s.messages.EditWindow)
+	if err == sql.ErrNoRows {
+		return nil, s.uneditableError()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	message, err := s.getMessage(messageID)
+	if err != nil {
+		return nil, err
+	}
+
+	s.broadcastMessageEvent(message, func(role string) websocket.Message {
+		return websocket.Message{
+			Type:   "message_updated",
+			ChatID: chatID,
+			Data:   MessageForRole(message, role),
+		}
+	})
+
+	return message, nil
+}
+
+// DeleteMessage retracts the caller's own message. The message stays in the
+// database as a tombstone: clients see it without content, super-agents can
+// still read it in the message history.
+func (s *ChatService) DeleteMessage(chatID, messageID, userID, role string) error {
+	message, err := s.ownMessage(chatID, messageID, userID, role)
+	if err != nil {
+		return err
+	}
+
+	deletedAt, err := s.repos.Messages.Delete(messageID, userID, s.messages.EditWindow)
+	if err == sql.ErrNoRows {
+		return s.uneditableError()
+	}
+	if err != nil {
+		return err
+	}
+
+	s.broadcastMessageEvent(message, func(role string) websocket.Message {
+		data := map[string]interface{}{
+			"chatId":    chatID,
+			"messageId": messageID,
+			"deletedAt": deletedAt,
+		}
+		if isStaff(role) {
+			data["seq"] = message.Seq
+		}
+		if message.PublicSeq != nil {
+			data["publicSeq"] = *message.PublicSeq
+		}
+		return websocket.Message{
+			Type:   "message_deleted",
+			ChatID: chatID,
+			Data:   data,
+		}
+	})
+
+	return nil
+}
+
+// GetMessageHistory returns a message with its full edit history, including
+// the content of deleted messages. Only super-agents may see it.
+func (s *ChatService) GetMessageHistory(chatID, messageID, role string) (*models.MessageHistory, error) {
+	if role != "super-agent" {
+		return nil, ErrForbidden
+	}
+
+	history, err := s.repos.Messages.History(messageID)
+	if err != nil {
+		return nil, err
+	}
+	if history.Message.ChatID != chatID {
+		return nil, sql.ErrNoRows
+	}
+	return history, nil
+}
+
+// ownMessage loads a message of a chat that the user sent and may change.
+// The user must still have access to the chat.
+func (s *ChatService) ownMessage(chatID, messageID, userID, role string) (*models.Message, error) {
+	chat, err := s.GetChat(chatID)
+	if err != nil {
+		return nil, err
+	}
+	if !canAccessChat(chat, userID, role) {
+		return nil, ErrForbidden
+	}
+
+	message, err := s.getMessage(messageID)
+	if err != nil {
+		return nil, err
+	}
+	if message.ChatID != chatID {
+		return nil, sql.ErrNoRows
+	}
+	if message.SenderID != userID || !editableTypes[message.MessageType] {
+		return nil, ErrForbidden
+	}
+	if message.DeletedAt != nil {
+		return nil, fmt.Errorf("%w: message has been deleted", ErrInvalidTransition)
+	}
+	return message, nil
+}
+
+func (s *ChatService) uneditableError() error {
+	return fmt.Errorf("%w: messages can only be changed within %s of sending", ErrForbidden, s.messages.EditWindow)
+}
+
+// touchMessage marks a message as changed for clients that sync, e.g. after
+// its reactions or attachments changed.
+func (s *ChatService) touchMessage(messageID string) error {
+	return s.repos.Messages.Touch(messageID)
+}
+
+func (s *ChatService) getMessage(messageID string) (*models.Message, error) {
+	return s.repos.Messages.Get(messageID)
+}
+
+// broadcastMessageEvent sends an event about a message to everyone who can
+// see the message: the chat's participants and observers for public
+// messages, the staff participants and the sender for staff-only ones. event
+// builds the event for a role, so that customers can be sent less than staff.
+func (s *ChatService) broadcastMessageEvent(message *models.Message, event func(role string) websocket.Message) {
+	chat, err := s.GetChat(message.ChatID)
+	if err != nil {
+		return
+	}
+
+	if message.Visibility == models.VisibilityPublic {
+		s.hub.BroadcastToChatSplit(chat.ID, s.participants(chat), event("agent"), event("customer"))
+		return
+	}
+	s.hub.BroadcastToUsers(append(s.staffParticipants(chat), message.SenderID), event("agent"))
+}
@@ -0,0 +1,69 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestEditMessage(t *testing.T) {
+	s, repos := newTestChatService(t)
+	chat, customer, agent := createChat(t, s, repos)
+	other, _, _ := createChat(t, s, repos)
+	message := sendMessage(t, s, chat.ID, customer.ID, "my order is late")
+
+	if _, err := s.EditMessage(chat.ID, message.ID, agent.ID, "agent", "hijacked"); !errors.Is(err, ErrForbidden) {
+		t.Errorf("editing someone else's message: err = %v, want ErrForbidden", err)
+	}
+	edited, err := s.EditMessage(chat.ID, message.ID, customer.ID, "customer", "my order is very late")
+	if err != nil {
+		t.Fatalf("EditMessage: %v", err)
+	}
+	if edited.Content != "my order is very late" || edited.EditedAt == nil {
+		t.Errorf("edited message: content %q, editedAt %v", edited.Content, edited.EditedAt)
+	}
+
+	if err := s.DeleteMessage(chat.ID, message.ID, customer.ID, "customer"); err != nil {
+		t.Fatalf("DeleteMessage: %v", err)
+	}
+	if _, err := s.EditMessage(chat.ID, message.ID, customer.ID, "customer", "again"); !errors.Is(err, ErrInvalidTransition) {
+		t.Errorf("editing a deleted message: err = %v, want ErrInvalidTransition", err)
+	}
+
+	if _, err := s.GetMessageHistory(chat.ID, message.ID, "agent"); !errors.Is(err, ErrForbidden) {
+		t.Errorf("history as an agent: err = %v, want ErrForbidden", err)
+	}
+	if _, err := s.GetMessageHistory(other.ID, message.ID, "super-agent"); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("history through another chat: err = %v, want sql.ErrNoRows", err)
+	}
+	history, err := s.GetMessageHistory(chat.ID, message.ID, "super-agent")
+	if err != nil {
+		t.Fatalf("GetMessageHistory: %v", err)
+	}
+	if history.Message.Content != "my order is very late" || history.Message.DeletedAt == nil {
+		t.Errorf("history message: content %q, deletedAt %v", history.Message.Content, history.Message.DeletedAt)
+	}
+	if history.DeletedBy == nil || *history.DeletedBy != customer.ID {
+		t.Errorf("deletedBy = %v, want %s", history.DeletedBy, customer.ID)
+	}
+	if len(history.Edits) != 1 || history.Edits[0].Content != "my order is late" {
+		t.Errorf("edits = %+v, want the original content", history.Edits)
+	}
+}
+
+func TestEditWindow(t *testing.T) {
+	s, repos := newTestChatService(t)
+	chat, customer, _ := createChat(t, s, repos)
+	message := sendMessage(t, s, chat.ID, customer.ID, "hello")
+
+	s.messages.EditWindow = time.Nanosecond
+	time.Sleep(time.Millisecond)
+
+	if _, err := s.EditMessage(chat.ID, message.ID, customer.ID, "customer", "hi"); !errors.Is(err, ErrForbidden) {
+		t.Errorf("EditMessage after the window: err = %v, want ErrForbidden", err)
+	}
+	if err := s.DeleteMessage(chat.ID, message.ID, customer.ID, "customer"); !errors.Is(err, ErrForbidden) {
+		t.Errorf("DeleteMessage after the window: err = %v, want ErrForbidden", err)
+	}
+}
@@ -0,0 +1,25 @@
+package services
+
+import "errors"
+
+var (
+	// ErrForbidden is returned when the caller's role or relationship to a
+	// resource does not allow the requested operation.
+	ErrForbidden = errors.New("forbidden")
+
+	// ErrInvalidTransition is returned when a chat cannot move to the
+	// requested status from its current one.
+	ErrInvalidTransition = errors.New("invalid status transition")
+
+	// ErrInvalidInput is returned when a request is well-formed but its
+	// values are not acceptable.
+	ErrInvalidInput = errors.New("invalid input")
+
+	// ErrTooLarge is returned when an upload exceeds the size limit of the
+	// uploader's role.
+	ErrTooLarge = errors.New("too large")
+
+	// ErrConflict is returned when a create or update would duplicate an
+	// existing resource.
+	ErrConflict = errors.New("conflict")
+)
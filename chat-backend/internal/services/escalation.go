@@ -0,0 +1,143 @@
+package services
+
+import (
+	"database/sql"
+	"fmt"
+
+	"cs-socket/internal/models"
+	"cs-socket/internal/repository"
+	"cs-socket/internal/websocket"
+)
+
+// errAlreadyEscalated is returned when a chat already has an open or claimed
+// escalation.
+var errAlreadyEscalated = fmt.Errorf("%w: chat is already escalated", ErrConflict)
+
+// EscalateChat flags a chat as needing a super-agent and notifies every
+// online super-agent. A chat can only have one open escalation at a time.
+func (s *ChatService) EscalateChat(chatID, userID, role, reason string) (*models.Escalation, error) {
+	if !isStaff(role) {
+		return nil, ErrForbidden
+	}
+
+	chat, err := s.GetChat(chatID)
+	if err != nil {
+		return nil, err
+	}
+	if !canManageChat(chat, userID, role) {
+		return nil, ErrForbidden
+	}
+
+	escalation, err := s.repos.Escalations.Create(chatID, userID, reason)
+	if err == repository.ErrDuplicate {
+		return nil, errAlreadyEscalated
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	chat.IsEscalated = true
+	s.hub.BroadcastToRole("super-agent", websocket.Message{
+		Type:   "escalation_requested",
+		ChatID: chatID,
+		Data: map[string]interface{}{
+			"escalation": escalation,
+			"chat":       chat,
+		},
+	})
+
+	return escalation, nil
+}
+
+// ClaimEscalation lets a super-agent take an open escalation. Only the first
+// claim succeeds; the super-agent joins the chat as an assisting participant.
+func (s *ChatService) ClaimEscalation(escalationID, userID, role string) (*models.Escalation, error) {
+	if role != "super-agent" {
+		return nil, ErrForbidden
+	}
+
+	escalation, err := s.repos.Escalations.Claim(escalationID, userID)
+	if err == sql.ErrNoRows {
+		if _, err := s.GetEscalation(escalationID); err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("%w: escalation is no longer open", ErrInvalidTransition)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	s.notifyEscalation("escalation_claimed", escalation)
+	return escalation, nil
+}
+
+// ResolveEscalation records the outcome of an escalation. A claimed
+// escalation becomes resolved and its super-agent stops assisting; an
+// unclaimed one is cancelled. Only super-agents and the requesting agent may
+// do this.
+func (s *ChatService) ResolveEscalation(escalationID, userID, role, outcome string) (*models.Escalation, error) {
+	escalation, err := s.GetEscalation(escalationID)
+	if err != nil {
+		return nil, err
+	}
+	if role != "super-agent" && escalation.RequestedBy != userID {
+		return nil, ErrForbidden
+	}
+
+	status := models.EscalationCancelled
+	if escalation.Status == models.EscalationClaimed {
+		status = models.EscalationResolved
+	}
+
+	resolved, err := s.repos.Escalations.Resolve(escalationID, escalation.Status, status, outcome)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("%w: escalation is already closed", ErrInvalidTransition)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// The super-agent who assisted is told along with every other super-agent
+	s.notifyEscalation("escalation_resolved", resolved)
+
+	return resolved, nil
+}
+
+func (s *ChatService) GetEscalation(escalationID string) (*models.Escalation, error) {
+	return s.repos.Escalations.Get(escalationID)
+}
+
+// GetEscalations lists escalations for review, most recent first.
+// Super-agents see all of them, agents only those they requested.
+func (s *ChatService) GetEscalations(userID, role, status, chatID string) ([]models.Escalation, error) {
+	if !isStaff(role) {
+		return nil, ErrForbidden
+	}
+
+	filter := repository.EscalationFilter{Status: status, ChatID: chatID}
+	if role != "super-agent" {
+		filter.RequestedBy = userID
+	}
+	return s.repos.Escalations.List(filter)
+}
+
+// notifyEscalation tells the super-agents and the staff on the chat about a
+// change to an escalation.
+func (s *ChatService) notifyEscalation(eventType string, escalation *models.Escalation) {
+	wsMessage := websocket.Message{
+		Type:   eventType,
+		ChatID: escalation.ChatID,
+		Data:   escalation,
+	}
+
+	recipients := []string{escalation.RequestedBy}
+	if chat, err := s.GetChat(escalation.ChatID); err == nil {
+		recipients = append(recipients, s.staffParticipants(chat)...)
+	}
+	s.hub.BroadcastToUsersAndRole(recipients, "super-agent", wsMessage)
+}
+
+// assistants returns the super-agents assisting on a chat.
+func (s *ChatService) assistants(chatID string) ([]string, error) {
+	return s.repos.Chats.Assistants(chatID)
+}
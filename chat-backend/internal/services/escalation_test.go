@@ -0,0 +1,126 @@
+package services
+
+import (
+	"errors"
+	"testing"
+
+	"cs-socket/internal/models"
+)
+
+func TestEscalation(t *testing.T) {
+	s, repos := newTestChatService(t)
+	chat, _, agent := createChat(t, s, repos)
+	other := createUser(t, repos, "other", "agent")
+	super := createUser(t, repos, "super", "super-agent")
+
+	if _, err := s.EscalateChat(chat.ID, other.ID, "agent", "help"); !errors.Is(err, ErrForbidden) {
+		t.Errorf("escalation by another agent: err = %v, want ErrForbidden", err)
+	}
+
+	escalation, err := s.EscalateChat(chat.ID, agent.ID, "agent", "refund over limit")
+	if err != nil {
+		t.Fatalf("EscalateChat: %v", err)
+	}
+	if escalation.Status != models.EscalationOpen {
+		t.Errorf("new escalation status = %q, want open", escalation.Status)
+	}
+	if _, err := s.EscalateChat(chat.ID, agent.ID, "agent", "again"); !errors.Is(err, ErrConflict) {
+		t.Errorf("second escalation: err = %v, want ErrConflict", err)
+	}
+	if loaded, _ := s.GetChat(chat.ID); !loaded.IsEscalated {
+		t.Error("escalated chat is not flagged")
+	}
+
+	if _, err := s.ClaimEscalation(escalation.ID, agent.ID, "agent"); !errors.Is(err, ErrForbidden) {
+		t.Errorf("claim by an agent: err = %v, want ErrForbidden", err)
+	}
+	claimed, err := s.ClaimEscalation(escalation.ID, super.ID, "super-agent")
+	if err != nil {
+		t.Fatalf("ClaimEscalation: %v", err)
+	}
+	if claimed.Status != models.EscalationClaimed || claimed.ClaimedBy == nil || *claimed.ClaimedBy != super.ID {
+		t.Errorf("claimed escalation: status %q, claimed by %v", claimed.Status, claimed.ClaimedBy)
+	}
+	if _, err := s.ClaimEscalation(escalation.ID, super.ID, "super-agent"); !errors.Is(err, ErrInvalidTransition) {
+		t.Errorf("second claim: err = %v, want ErrInvalidTransition", err)
+	}
+	if assistants, _ := s.assistants(chat.ID); len(assistants) != 1 || assistants[0] != super.ID {
+		t.Errorf("assistants after claim = %v, want [%s]", assistants, super.ID)
+	}
+
+	if _, err := s.ResolveEscalation(escalation.ID, other.ID, "agent", "done"); !errors.Is(err, ErrForbidden) {
+		t.Errorf("resolve by another agent: err = %v, want ErrForbidden", err)
+	}
+	resolved, err := s.ResolveEscalation(escalation.ID, agent.ID, "agent", "refund approved")
+	if err != nil {
+		t.Fatalf("ResolveEscalation: %v", err)
+	}
+	if resolved.Status != models.EscalationResolved || resolved.Outcome == nil || *resolved.Outcome != "refund approved" {
+		t.Errorf("resolved escalation: status %q, outcome %v", resolved.Status, resolved.Outcome)
+	}
+	if assistants, _ := s.assistants(chat.ID); len(assistants) != 0 {
+		t.Errorf("assistants after resolving = %v, want none", assistants)
+	}
+	if loaded, _ := s.GetChat(chat.ID); loaded.IsEscalated {
+		t.Error("chat is still flagged after resolving")
+	}
+
+	// An unclaimed escalation is cancelled
+	again, err := s.EscalateChat(chat.ID, agent.ID, "agent", "again")
+	if err != nil {
+		t.Fatalf("EscalateChat after resolving: %v", err)
+	}
+	cancelled, err := s.ResolveEscalation(again.ID, agent.ID, "agent", "sorted it out")
+	if err != nil {
+		t.Fatalf("ResolveEscalation: %v", err)
+	}
+	if cancelled.Status != models.EscalationCancelled {
+		t.Errorf("unclaimed escalation status = %q, want cancelled", cancelled.Status)
+	}
+}
+
+func TestGetEscalations(t *testing.T) {
+	s, repos := newTestChatService(t)
+	chat, _, agent := createChat(t, s, repos)
+	otherChat, _, otherAgent := createChat(t, s, repos)
+
+	mine, err := s.EscalateChat(chat.ID, agent.ID, "agent", "help")
+	if err != nil {
+		t.Fatalf("EscalateChat: %v", err)
+	}
+	if _, err := s.EscalateChat(otherChat.ID, otherAgent.ID, "agent", "help"); err != nil {
+		t.Fatalf("EscalateChat: %v", err)
+	}
+
+	for _, tc := range []struct {
+		name   string
+		userID string
+		role   string
+		status string
+		chatID string
+		want   int
+	}{
+		{"agent sees their own", agent.ID, "agent", "", "", 1},
+		{"super-agent sees all", "", "super-agent", "", "", 2},
+		{"by status", "", "super-agent", models.EscalationClaimed, "", 0},
+		{"by chat", "", "super-agent", "", otherChat.ID, 1},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			escalations, err := s.GetEscalations(tc.userID, tc.role, tc.status, tc.chatID)
+			if err != nil {
+				t.Fatalf("GetEscalations: %v", err)
+			}
+			if len(escalations) != tc.want {
+				t.Errorf("got %d escalations, want %d", len(escalations), tc.want)
+			}
+		})
+	}
+
+	escalations, _ := s.GetEscalations(agent.ID, "agent", "", "")
+	if len(escalations) == 1 && escalations[0].ID != mine.ID {
+		t.Errorf("agent sees escalation %s, want %s", escalations[0].ID, mine.ID)
+	}
+	if _, err := s.GetEscalations(agent.ID, "customer", "", ""); !errors.Is(err, ErrForbidden) {
+		t.Errorf("customer listing: err = %v, want ErrForbidden", err)
+	}
+}
@@ -0,0 +1,75 @@
+package services
+
+import (
+	"testing"
+
+	"cs-socket/internal/config"
+	"cs-socket/internal/models"
+	"cs-socket/internal/repository"
+	"cs-socket/internal/websocket"
+
+	"github.com/google/uuid"
+)
+
+// testReactions are the reactions allowed by newTestChatService.
+var testReactions = []string{"👍", "❤️"}
+
+// newTestChatService returns a chat service on empty memory repositories,
+// with a hub without clients.
+func newTestChatService(t *testing.T) (*ChatService, repository.Repositories) {
+	t.Helper()
+
+	repos := repository.NewMemory()
+	s := NewChatService(repos, websocket.NewHub(config.WebSocketConfig{}),
+		config.PriorityConfig{ElevatedTopics: []string{"withdrawal"}},
+		config.MessagesConfig{Reactions: testReactions},
+		nil)
+	return s, repos
+}
+
+// createUser stores an online user with the given role and returns it.
+func createUser(t *testing.T, repos repository.Repositories, name, role string) *models.User {
+	t.Helper()
+
+	user, err := repos.Users.Create(models.User{
+		ID:       uuid.New().String(),
+		Username: name,
+		Email:    name + "@example.com",
+		Password: "-",
+		Name:     name,
+		Role:     role,
+		IsOnline: true,
+	})
+	if err != nil {
+		t.Fatalf("creating user %s: %v", name, err)
+	}
+	return user
+}
+
+// createChat opens a chat between a new customer and a new agent.
+func createChat(t *testing.T, s *ChatService, repos repository.Repositories) (chat *models.Chat, customer, agent *models.User) {
+	t.Helper()
+
+	customer = createUser(t, repos, "customer-"+uuid.New().String()[:8], "customer")
+	agent = createUser(t, repos, "agent-"+uuid.New().String()[:8], "agent")
+	chat, err := s.CreateChat(customer.ID, &agent.ID, "")
+	if err != nil {
+		t.Fatalf("creating chat: %v", err)
+	}
+	return chat, customer, agent
+}
+
+// sendMessage sends a text message from senderID in their role.
+func sendMessage(t *testing.T, s *ChatService, chatID, senderID, content string) *models.Message {
+	t.Helper()
+
+	sender, err := s.repos.Users.GetByID(senderID)
+	if err != nil {
+		t.Fatalf("loading sender: %v", err)
+	}
+	message, err := s.SendMessage(chatID, senderID, sender.Role, content, "text", nil)
+	if err != nil {
+		t.Fatalf("sending %q: %v", content, err)
+	}
+	return message
+}
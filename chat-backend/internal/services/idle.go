@@ -0,0 +1,171 @@
+package services
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"cs-socket/internal/clock"
+	"cs-socket/internal/config"
+	"cs-socket/internal/models"
+	"cs-socket/internal/repository"
+)
+
+type idleAction int
+
+const (
+	idleNone idleAction = iota
+	idleNudge
+	idleResolve
+)
+
+// idleStore holds the idle state of chats and resolves them. It is an
+// interface so that tests can drive Check on prepared chat states.
+type idleStore interface {
+	openChats() ([]repository.IdleChat, error)
+	// setNudged records when a chat was nudged, or clears it if at is nil.
+	setNudged(chatID string, at *time.Time) error
+	resolve(chat *models.Chat, code, note string) error
+}
+
+// IdleMonitor nudges customers who stopped replying and resolves their chats
+// when they stay silent, which frees the agent for new chats. Nudges are
+// sent by the system user, see EnsureSystemUser.
+type IdleMonitor struct {
+	store       idleStore
+	chatService *ChatService
+	config      config.IdleConfig
+	clock       clock.Clock
+}
+
+func NewIdleMonitor(chatService *ChatService, cfg config.IdleConfig, clk clock.Clock) *IdleMonitor {
+	return &IdleMonitor{
+		store:       &idleRepository{chatService: chatService},
+		chatService: chatService,
+		config:      cfg,
+		clock:       clk,
+	}
+}
+
+// Run checks for idle chats every configured interval until ctx is done.
+func (m *IdleMonitor) Run(ctx context.Context) {
+	ticker := time.NewTicker(m.config.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := m.Check(); err != nil {
+				log.Printf("Idle monitor: %v", err)
+			}
+		}
+	}
+}
+
+// Check runs a single pass over the open chats, nudging or resolving the
+// ones whose customer has been silent for too long.
+func (m *IdleMonitor) Check() error {
+	chats, err := m.store.openChats()
+	if err != nil {
+		return err
+	}
+
+	now := m.clock.Now()
+	for _, chat := range chats {
+		// A customer reply after the nudge starts the idle timer over
+		if chat.NudgedAt != nil && chat.LastCustomerAt != nil && chat.LastCustomerAt.After(*chat.NudgedAt) {
+			if err := m.store.setNudged(chat.ID, nil); err != nil {
+				return err
+			}
+			chat.NudgedAt = nil
+		}
+
+		switch nextIdleAction(now, chat, m.config.ForTopic(chat.Topic)) {
+		case idleNudge:
+			if err := m.nudge(chat, now); err != nil {
+				log.Printf("Idle monitor: failed to nudge chat %s: %v", chat.ID, err)
+			}
+		case idleResolve:
+			if err := m.resolve(chat); err != nil {
+				log.Printf("Idle monitor: failed to resolve chat %s: %v", chat.ID, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// nextIdleAction decides what to do with a chat at time now. The customer
+// only counts as silent once staff have spoken last.
+func nextIdleAction(now time.Time, chat repository.IdleChat, timings config.IdleTimings) idleAction {
+	if chat.NudgedAt != nil {
+		if timings.ResolveAfter > 0 && now.Sub(*chat.NudgedAt) >= timings.ResolveAfter {
+			return idleResolve
+		}
+		return idleNone
+	}
+
+	if chat.LastStaffAt == nil {
+		return idleNone
+	}
+	if chat.LastCustomerAt != nil && !chat.LastStaffAt.After(*chat.LastCustomerAt) {
+		return idleNone
+	}
+
+	if timings.NudgeAfter > 0 && now.Sub(*chat.LastStaffAt) >= timings.NudgeAfter {
+		return idleNudge
+	}
+	return idleNone
+}
+
+func (m *IdleMonitor) nudge(chat repository.IdleChat, now time.Time) error {
+	_, err := m.chatService.sendMessage(newMessage{
+		ChatID:      chat.ID,
+		SenderID:    SystemUserID,
+		Content:     m.config.NudgeMessage,
+		MessageType: "system",
+		Visibility:  models.VisibilityPublic,
+	})
+	if err != nil {
+		return err
+	}
+
+	return m.store.setNudged(chat.ID, &now)
+}
+
+func (m *IdleMonitor) resolve(chat repository.IdleChat) error {
+	current, err := m.chatService.GetChat(chat.ID)
+	if err != nil {
+		return err
+	}
+	if !models.IsOpenStatus(current.Status) {
+		return nil
+	}
+
+	if err := m.store.resolve(current, "no_response", "Resolved automatically after customer inactivity"); err != nil {
+		return err
+	}
+
+	return m.store.setNudged(chat.ID, nil)
+}
+
+// idleRepository is the idle state kept with the chats.
+type idleRepository struct {
+	chatService *ChatService
+}
+
+// openChats returns the assigned open chats with the times the customer and
+// staff last spoke. Messages of the system user count for neither side.
+func (d *idleRepository) openChats() ([]repository.IdleChat, error) {
+	return d.chatService.repos.Chats.IdleChats(SystemUserID)
+}
+
+func (d *idleRepository) setNudged(chatID string, at *time.Time) error {
+	return d.chatService.repos.Chats.SetIdleNudged(chatID, at)
+}
+
+func (d *idleRepository) resolve(chat *models.Chat, code, note string) error {
+	return d.chatService.transition(chat, models.ChatStatusResolved, nil, &code, &note)
+}
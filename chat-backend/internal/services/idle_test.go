@@ -0,0 +1,196 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"cs-socket/internal/clock"
+	"cs-socket/internal/config"
+	"cs-socket/internal/models"
+	"cs-socket/internal/repository"
+)
+
+// fakeIdleStore keeps the idle state of chats in memory and records the
+// chats it resolved.
+type fakeIdleStore struct {
+	chats    map[string]*repository.IdleChat
+	resolved []string
+}
+
+func (f *fakeIdleStore) openChats() ([]repository.IdleChat, error) {
+	var chats []repository.IdleChat
+	for _, chat := range f.chats {
+		if !f.isResolved(chat.ID) {
+			chats = append(chats, *chat)
+		}
+	}
+	return chats, nil
+}
+
+func (f *fakeIdleStore) isResolved(chatID string) bool {
+	for _, id := range f.resolved {
+		if id == chatID {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *fakeIdleStore) setNudged(chatID string, at *time.Time) error {
+	f.chats[chatID].NudgedAt = at
+	return nil
+}
+
+func (f *fakeIdleStore) resolve(chat *models.Chat, code, note string) error {
+	if code != "no_response" {
+		return ErrInvalidInput
+	}
+	f.resolved = append(f.resolved, chat.ID)
+	return nil
+}
+
+var testIdleConfig = config.IdleConfig{
+	NudgeMessage: "Are you still there?",
+	Default:      config.IdleTimings{NudgeAfter: 5 * time.Minute, ResolveAfter: 10 * time.Minute},
+	Topics: map[string]config.IdleTimings{
+		"withdrawal": {NudgeAfter: time.Minute, ResolveAfter: 2 * time.Minute},
+	},
+}
+
+// newTestIdleMonitor returns an idle monitor on a fake store and clock,
+// watching one chat whose agent spoke last at the clock's start.
+func newTestIdleMonitor(t *testing.T, topic string) (*IdleMonitor, *fakeIdleStore, *clock.Fake, *models.Chat, repository.Repositories) {
+	t.Helper()
+
+	s, repos := newTestChatService(t)
+	if err := EnsureSystemUser(repos.Users); err != nil {
+		t.Fatalf("EnsureSystemUser: %v", err)
+	}
+	chat, _, agent := createChat(t, s, repos)
+
+	start := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	customerAt := start.Add(-time.Minute)
+	store := &fakeIdleStore{chats: map[string]*repository.IdleChat{
+		chat.ID: {ID: chat.ID, AgentID: agent.ID, Topic: topic, LastCustomerAt: &customerAt, LastStaffAt: &start},
+	}}
+	clk := clock.NewFake(start)
+	m := &IdleMonitor{store: store, chatService: s, config: testIdleConfig, clock: clk}
+	return m, store, clk, chat, repos
+}
+
+// nudges returns the idle nudges posted to a chat.
+func nudges(t *testing.T, repos repository.Repositories, chatID string) []models.Message {
+	t.Helper()
+
+	messages, err := repos.Messages.Page(chatID, true, "", "", 100)
+	if err != nil {
+		t.Fatalf("Page: %v", err)
+	}
+	var found []models.Message
+	for _, message := range messages {
+		if message.Content == testIdleConfig.NudgeMessage {
+			found = append(found, message)
+		}
+	}
+	return found
+}
+
+func TestIdleMonitorNudgesThenResolves(t *testing.T) {
+	m, store, clk, chat, repos := newTestIdleMonitor(t, "general")
+
+	clk.Advance(4 * time.Minute)
+	if err := m.Check(); err != nil {
+		t.Fatalf("Check: %v", err)
+	}
+	if got := nudges(t, repos, chat.ID); len(got) != 0 {
+		t.Fatalf("nudged after 4m of silence: %+v", got)
+	}
+
+	clk.Advance(time.Minute)
+	if err := m.Check(); err != nil {
+		t.Fatalf("Check: %v", err)
+	}
+	got := nudges(t, repos, chat.ID)
+	if len(got) != 1 {
+		t.Fatalf("got %d nudges after 5m of silence, want 1", len(got))
+	}
+	if got[0].SenderID != SystemUserID || got[0].MessageType != "system" {
+		t.Errorf("nudge sent by %s as %q, want the system user", got[0].SenderID, got[0].MessageType)
+	}
+	if nudged := store.chats[chat.ID].NudgedAt; nudged == nil || !nudged.Equal(clk.Now()) {
+		t.Errorf("nudged at %v, want %v", nudged, clk.Now())
+	}
+
+	// The nudge is sent once, and the chat stays open until ResolveAfter
+	clk.Advance(9 * time.Minute)
+	if err := m.Check(); err != nil {
+		t.Fatalf("Check: %v", err)
+	}
+	if got := nudges(t, repos, chat.ID); len(got) != 1 || len(store.resolved) != 0 {
+		t.Fatalf("9m after the nudge: %d nudges, resolved %v", len(got), store.resolved)
+	}
+
+	clk.Advance(time.Minute)
+	if err := m.Check(); err != nil {
+		t.Fatalf("Check: %v", err)
+	}
+	if len(store.resolved) != 1 || store.resolved[0] != chat.ID {
+		t.Errorf("resolved %v, want the chat resolved 10m after the nudge", store.resolved)
+	}
+}
+
+func TestIdleMonitorTopicTimings(t *testing.T) {
+	m, _, clk, chat, repos := newTestIdleMonitor(t, "withdrawal")
+
+	clk.Advance(time.Minute)
+	if err := m.Check(); err != nil {
+		t.Fatalf("Check: %v", err)
+	}
+	if got := nudges(t, repos, chat.ID); len(got) != 1 {
+		t.Errorf("got %d nudges after the topic's 1m, want 1", len(got))
+	}
+}
+
+func TestIdleMonitorCustomerReplyResetsNudge(t *testing.T) {
+	m, store, clk, chat, repos := newTestIdleMonitor(t, "general")
+
+	clk.Advance(5 * time.Minute)
+	if err := m.Check(); err != nil {
+		t.Fatalf("Check: %v", err)
+	}
+
+	// The customer answers the nudge
+	clk.Advance(time.Minute)
+	replied := clk.Now()
+	store.chats[chat.ID].LastCustomerAt = &replied
+
+	clk.Advance(20 * time.Minute)
+	if err := m.Check(); err != nil {
+		t.Fatalf("Check: %v", err)
+	}
+	if store.chats[chat.ID].NudgedAt != nil {
+		t.Error("the nudge was not cleared after the customer replied")
+	}
+	if len(store.resolved) != 0 {
+		t.Errorf("resolved %v although the customer replied", store.resolved)
+	}
+	if got := nudges(t, repos, chat.ID); len(got) != 1 {
+		t.Errorf("got %d nudges while the customer spoke last, want 1", len(got))
+	}
+}
+
+func TestIdleMonitorWaitsForStaff(t *testing.T) {
+	m, store, clk, chat, repos := newTestIdleMonitor(t, "general")
+
+	// Nobody is waiting on a customer who spoke last
+	customerAt := clk.Now().Add(time.Second)
+	store.chats[chat.ID].LastCustomerAt = &customerAt
+
+	clk.Advance(time.Hour)
+	if err := m.Check(); err != nil {
+		t.Fatalf("Check: %v", err)
+	}
+	if got := nudges(t, repos, chat.ID); len(got) != 0 {
+		t.Errorf("nudged a chat waiting on staff: %+v", got)
+	}
+}
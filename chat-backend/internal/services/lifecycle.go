@@ -0,0 +1,249 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"cs-socket/internal/models"
+	"cs-socket/internal/repository"
+	"cs-socket/internal/websocket"
+)
+
+var (
+	staffRoles = []string{"agent", "super-agent"}
+	allRoles   = []string{"customer", "agent", "super-agent"}
+)
+
+// chatTransitions lists, for every chat status, the statuses it may move to
+// and the roles allowed to make that move. Archived chats leave the archive
+// through UnarchiveChat, which restores the status they had before.
+var chatTransitions = map[string]map[string][]string{
+	models.ChatStatusQueued: {
+		models.ChatStatusActive:   staffRoles,
+		models.ChatStatusClosed:   staffRoles,
+		models.ChatStatusArchived: allRoles,
+	},
+	models.ChatStatusActive: {
+		models.ChatStatusQueued:          staffRoles,
+		models.ChatStatusPendingCustomer: staffRoles,
+		models.ChatStatusResolved:        staffRoles,
+		models.ChatStatusClosed:          staffRoles,
+		models.ChatStatusArchived:        allRoles,
+	},
+	models.ChatStatusPendingCustomer: {
+		models.ChatStatusActive:   allRoles,
+		models.ChatStatusResolved: staffRoles,
+		models.ChatStatusClosed:   staffRoles,
+		models.ChatStatusArchived: allRoles,
+	},
+	models.ChatStatusResolved: {
+		models.ChatStatusActive:   allRoles,
+		models.ChatStatusClosed:   staffRoles,
+		models.ChatStatusArchived: allRoles,
+	},
+	models.ChatStatusClosed: {
+		models.ChatStatusArchived: allRoles,
+	},
+}
+
+// resolutionCodes are the wrap-up codes accepted when resolving or closing a
+// chat.
+var resolutionCodes = map[string]bool{
+	"resolved":     true,
+	"no_response":  true,
+	"duplicate":    true,
+	"spam":         true,
+	"transferred":  true,
+	"out_of_scope": true,
+}
+
+// UpdateChatStatus moves a chat to a new status if the lifecycle allows the
+// caller's role to make that transition. Only the chat's customer, its agent
+// and super-agents may change it, except that any agent may pick up an
+// unassigned chat by making it active. Closing a chat requires a resolution
+// code unless one was already recorded when it was resolved.
+func (s *ChatService) UpdateChatStatus(chatID, userID, role string, req models.UpdateChatStatusRequest) (*models.Chat, error) {
+	chat, err := s.GetChat(chatID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Picking up an unassigned chat assigns it to the agent
+	pickUp := req.Status == models.ChatStatusActive && chat.AgentID == nil && isStaff(role)
+	if !canManageChat(chat, userID, role) && !(pickUp && canAccessChat(chat, userID, role)) {
+		return nil, ErrForbidden
+	}
+
+	if !canTransition(chat.Status, req.Status, role) {
+		return nil, fmt.Errorf("%w: %s cannot move a chat from %s to %s",
+			ErrInvalidTransition, role, chat.Status, req.Status)
+	}
+
+	var code, note *string
+	if req.ResolutionCode != "" {
+		if !resolutionCodes[req.ResolutionCode] {
+			return nil, fmt.Errorf("%w: unknown resolution code %q", ErrInvalidInput, req.ResolutionCode)
+		}
+		code = &req.ResolutionCode
+	}
+	if req.Note != "" {
+		note = &req.Note
+	}
+
+	if req.Status == models.ChatStatusClosed && code == nil && chat.ResolutionCode == nil {
+		return nil, fmt.Errorf("%w: a resolution code is required to close a chat", ErrInvalidInput)
+	}
+
+	var assignTo *string
+	if pickUp {
+		assignTo = &userID
+	}
+	if err := s.changeStatus(chat, req.Status, assignTo, &userID, code, note); err != nil {
+		return nil, err
+	}
+
+	return s.GetChatForUser(chatID, userID, role)
+}
+
+// GetStatusHistory returns every status change of a chat in order. Only
+// staff who may access the chat can see it.
+func (s *ChatService) GetStatusHistory(chatID, userID, role string) ([]models.ChatStatusChange, error) {
+	if !isStaff(role) {
+		return nil, ErrForbidden
+	}
+	chat, err := s.GetChat(chatID)
+	if err != nil {
+		return nil, err
+	}
+	if !canAccessChat(chat, userID, role) {
+		return nil, ErrForbidden
+	}
+
+	return s.repos.Chats.StatusHistory(chatID)
+}
+
+// Errors of a status change that lost a race with another one. The chat
+// was changed by someone else between loading it and moving it.
+var (
+	errChatTaken     = fmt.Errorf("%w: chat was already picked up by another agent", ErrInvalidTransition)
+	errStatusChanged = fmt.Errorf("%w: chat status changed concurrently", ErrInvalidTransition)
+)
+
+// transition moves a chat to a new status without checking the lifecycle
+// rules, records the change and notifies the chat participants. changedBy is
+// nil for system-initiated transitions.
+func (s *ChatService) transition(chat *models.Chat, to string, changedBy, code, note *string) error {
+	return s.changeStatus(chat, to, nil, changedBy, code, note)
+}
+
+// changeStatus is transition that also assigns an unassigned chat to the
+// agent assignTo, if given, in the same transaction.
+func (s *ChatService) changeStatus(chat *models.Chat, to string, assignTo, changedBy, code, note *string) error {
+	err := s.repos.Chats.ChangeStatus(repository.StatusChange{
+		ChatID:         chat.ID,
+		From:           chat.Status,
+		To:             to,
+		AssignTo:       assignTo,
+		ChangedBy:      changedBy,
+		ResolutionCode: code,
+		Note:           note,
+	})
+	switch {
+	case errors.Is(err, repository.ErrAssigned):
+		return errChatTaken
+	case errors.Is(err, repository.ErrStale):
+		return errStatusChanged
+	case err != nil:
+		return err
+	}
+
+	from := chat.Status
+	chat.Status = to
+	if assignTo != nil {
+		chat.AgentID = assignTo
+	}
+	chat.IsActive = models.IsOpenStatus(to)
+	if to == models.ChatStatusQueued {
+		chat.AgentID = nil
+	}
+
+	s.hub.BroadcastToUsers(s.participants(chat), websocket.Message{
+		Type:   "chat_status_changed",
+		ChatID: chat.ID,
+		Data: map[string]interface{}{
+			"chatId":         chat.ID,
+			"fromStatus":     from,
+			"status":         to,
+			"changedBy":      changedBy,
+			"resolutionCode": code,
+			"changedAt":      time.Now(),
+		},
+	})
+
+	if to == models.ChatStatusResolved {
+		if err := s.sendCSATPrompt(chat); err != nil {
+			log.Printf("Failed to send CSAT prompt for chat %s: %v", chat.ID, err)
+		}
+	}
+
+	return nil
+}
+
+// statusBeforeArchive returns the status a chat had when it was archived.
+// Chats archived before status history was recorded go back to active.
+func (s *ChatService) statusBeforeArchive(chatID string) (string, error) {
+	history, err := s.repos.Chats.StatusHistory(chatID)
+	if err != nil {
+		return "", err
+	}
+
+	for i := len(history) - 1; i >= 0; i-- {
+		change := history[i]
+		if change.ToStatus != models.ChatStatusArchived {
+			continue
+		}
+		if change.FromStatus == nil || *change.FromStatus == models.ChatStatusArchived {
+			break
+		}
+		return *change.FromStatus, nil
+	}
+	return models.ChatStatusActive, nil
+}
+
+// participants returns the customer and staff participants of a chat.
+func (s *ChatService) participants(chat *models.Chat) []string {
+	return append(s.staffParticipants(chat), chat.CustomerID)
+}
+
+func canTransition(from, to, role string) bool {
+	for _, allowed := range chatTransitions[from][to] {
+		if allowed == role {
+			return true
+		}
+	}
+	return false
+}
+
+// canAccessChat reports whether a user may see a chat: customers their own,
+// agents their assigned and unassigned chats, and super-agents every chat.
+func canAccessChat(chat *models.Chat, userID, role string) bool {
+	switch role {
+	case "customer":
+		return chat.CustomerID == userID
+	case "super-agent":
+		return true
+	case "agent":
+		return chat.AgentID == nil || *chat.AgentID == userID
+	}
+	return false
+}
+
+// canManageChat is like canAccessChat but requires agents to be assigned.
+func canManageChat(chat *models.Chat, userID, role string) bool {
+	if role == "agent" {
+		return chat.AgentID != nil && *chat.AgentID == userID
+	}
+	return canAccessChat(chat, userID, role)
+}
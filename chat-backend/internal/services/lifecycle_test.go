@@ -0,0 +1,163 @@
+package services
+
+import (
+	"errors"
+	"testing"
+
+	"cs-socket/internal/models"
+)
+
+func TestCanTransition(t *testing.T) {
+	const (
+		queued   = models.ChatStatusQueued
+		active   = models.ChatStatusActive
+		pending  = models.ChatStatusPendingCustomer
+		resolved = models.ChatStatusResolved
+		closed   = models.ChatStatusClosed
+		archived = models.ChatStatusArchived
+	)
+	statuses := []string{queued, active, pending, resolved, closed, archived}
+
+	// allowed lists the moves each role may make; every other move is refused
+	allowed := map[string][][2]string{
+		"customer": {
+			{queued, archived},
+			{active, archived},
+			{pending, active}, {pending, archived},
+			{resolved, active}, {resolved, archived},
+			{closed, archived},
+		},
+		"agent": {
+			{queued, active}, {queued, closed}, {queued, archived},
+			{active, queued}, {active, pending}, {active, resolved}, {active, closed}, {active, archived},
+			{pending, active}, {pending, resolved}, {pending, closed}, {pending, archived},
+			{resolved, active}, {resolved, closed}, {resolved, archived},
+			{closed, archived},
+		},
+	}
+	allowed["super-agent"] = allowed["agent"]
+
+	for _, role := range []string{"customer", "agent", "super-agent", "system"} {
+		want := make(map[[2]string]bool)
+		for _, move := range allowed[role] {
+			want[move] = true
+		}
+
+		for _, from := range statuses {
+			for _, to := range statuses {
+				if got := canTransition(from, to, role); got != want[[2]string{from, to}] {
+					t.Errorf("%s: canTransition(%s, %s) = %v, want %v", role, from, to, got, !got)
+				}
+			}
+		}
+	}
+}
+
+func TestUpdateChatStatus(t *testing.T) {
+	s, repos := newTestChatService(t)
+	chat, customer, agent := createChat(t, s, repos)
+
+	_, err := s.UpdateChatStatus(chat.ID, agent.ID, "agent", models.UpdateChatStatusRequest{Status: models.ChatStatusClosed})
+	if !errors.Is(err, ErrInvalidInput) {
+		t.Errorf("closing without a resolution code: err = %v, want ErrInvalidInput", err)
+	}
+	_, err = s.UpdateChatStatus(chat.ID, customer.ID, "customer", models.UpdateChatStatusRequest{Status: models.ChatStatusQueued})
+	if !errors.Is(err, ErrInvalidTransition) {
+		t.Errorf("customer requeueing: err = %v, want ErrInvalidTransition", err)
+	}
+
+	updated, err := s.UpdateChatStatus(chat.ID, agent.ID, "agent", models.UpdateChatStatusRequest{
+		Status:         models.ChatStatusResolved,
+		ResolutionCode: "resolved",
+	})
+	if err != nil {
+		t.Fatalf("resolving: %v", err)
+	}
+	if updated.Status != models.ChatStatusResolved || updated.IsActive {
+		t.Errorf("resolved chat: status %q, active %v", updated.Status, updated.IsActive)
+	}
+
+	// The code recorded when resolving is enough to close
+	if _, err := s.UpdateChatStatus(chat.ID, agent.ID, "agent", models.UpdateChatStatusRequest{Status: models.ChatStatusClosed}); err != nil {
+		t.Fatalf("closing a resolved chat: %v", err)
+	}
+
+	history, err := s.GetStatusHistory(chat.ID, agent.ID, "agent")
+	if err != nil {
+		t.Fatalf("GetStatusHistory: %v", err)
+	}
+	var statuses []string
+	for _, change := range history {
+		statuses = append(statuses, change.ToStatus)
+	}
+	want := []string{models.ChatStatusActive, models.ChatStatusResolved, models.ChatStatusClosed}
+	if len(statuses) != len(want) {
+		t.Fatalf("history = %v, want %v", statuses, want)
+	}
+	for i := range want {
+		if statuses[i] != want[i] {
+			t.Errorf("history = %v, want %v", statuses, want)
+			break
+		}
+	}
+}
+
+func TestPickUpChat(t *testing.T) {
+	s, repos := newTestChatService(t)
+	customer := createUser(t, repos, "carol", "customer")
+	first := createUser(t, repos, "bob", "agent")
+	second := createUser(t, repos, "dave", "agent")
+
+	chat, err := s.CreateChat(customer.ID, nil, "")
+	if err != nil {
+		t.Fatalf("CreateChat: %v", err)
+	}
+	// Both agents load the queued chat before either picks it up
+	stale := *chat
+
+	picked, err := s.UpdateChatStatus(chat.ID, first.ID, "agent", models.UpdateChatStatusRequest{Status: models.ChatStatusActive})
+	if err != nil {
+		t.Fatalf("picking up: %v", err)
+	}
+	if picked.AgentID == nil || *picked.AgentID != first.ID {
+		t.Errorf("picked up chat agent = %v, want %s", picked.AgentID, first.ID)
+	}
+
+	if err := s.changeStatus(&stale, models.ChatStatusActive, &second.ID, &second.ID, nil, nil); err != errChatTaken {
+		t.Errorf("second pick-up: err = %v, want errChatTaken", err)
+	}
+	_, err = s.UpdateChatStatus(chat.ID, second.ID, "agent", models.UpdateChatStatusRequest{Status: models.ChatStatusActive})
+	if !errors.Is(err, ErrForbidden) {
+		t.Errorf("picking up an assigned chat: err = %v, want ErrForbidden", err)
+	}
+	if _, err := s.GetStatusHistory(chat.ID, second.ID, "agent"); !errors.Is(err, ErrForbidden) {
+		t.Errorf("history for another agent: err = %v, want ErrForbidden", err)
+	}
+	if _, err := s.GetStatusHistory(chat.ID, customer.ID, "customer"); !errors.Is(err, ErrForbidden) {
+		t.Errorf("history for the customer: err = %v, want ErrForbidden", err)
+	}
+}
+
+func TestUnarchiveRestoresStatus(t *testing.T) {
+	s, repos := newTestChatService(t)
+	chat, _, agent := createChat(t, s, repos)
+
+	_, err := s.UpdateChatStatus(chat.ID, agent.ID, "agent", models.UpdateChatStatusRequest{Status: models.ChatStatusPendingCustomer})
+	if err != nil {
+		t.Fatalf("UpdateChatStatus: %v", err)
+	}
+	if err := s.ArchiveChat(chat.ID, agent.ID, "agent"); err != nil {
+		t.Fatalf("ArchiveChat: %v", err)
+	}
+	if err := s.UnarchiveChat(chat.ID, agent.ID, "agent"); err != nil {
+		t.Fatalf("UnarchiveChat: %v", err)
+	}
+
+	restored, err := s.GetChat(chat.ID)
+	if err != nil {
+		t.Fatalf("GetChat: %v", err)
+	}
+	if restored.Status != models.ChatStatusPendingCustomer {
+		t.Errorf("unarchived status = %q, want %q", restored.Status, models.ChatStatusPendingCustomer)
+	}
+}
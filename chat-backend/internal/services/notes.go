@@ -0,0 +1,82 @@
+package services
+
+import (
+	"regexp"
+	"strings"
+
+	"cs-socket/internal/models"
+	"cs-socket/internal/repository"
+	"cs-socket/internal/websocket"
+)
+
+var mentionPattern = regexp.MustCompile(`(?:^|\s)@([A-Za-z0-9_.\-]+)`)
+
+// AddNote stores an internal note on a chat. Notes are only visible to staff.
+// Agents and super-agents mentioned with @username are recorded and notified.
+func (s *ChatService) AddNote(chatID, senderID, role, content string) (*models.Message, error) {
+	chat, err := s.staffChat(chatID, senderID, role, canAccessChat)
+	if err != nil {
+		return nil, err
+	}
+
+	// The note and its mentions are stored together, so that nobody is
+	// notified about a note that was not saved
+	message, mentioned, err := s.repos.Messages.InsertNote(repository.NewMessage{
+		ChatID:      chatID,
+		SenderID:    senderID,
+		Content:     content,
+		MessageType: "note",
+		Visibility:  models.VisibilityStaff,
+	}, parseMentions(content))
+	if err != nil {
+		return nil, err
+	}
+
+	s.broadcastStaffMessage(chat, message)
+	if len(mentioned) > 0 {
+		s.hub.BroadcastToUsers(mentioned, websocket.Message{
+			Type:   "mention",
+			ChatID: chatID,
+			Data: map[string]interface{}{
+				"chatId":      chatID,
+				"message":     message,
+				"mentionedBy": senderID,
+			},
+		})
+	}
+
+	return message, nil
+}
+
+// Number of mentions returned when none or too many are asked for.
+const (
+	defaultMentionPage = 50
+	maxMentionPage     = 100
+)
+
+// GetMentions returns the notes in which the user has been mentioned, most
+// recent first.
+func (s *ChatService) GetMentions(userID string, limit int) ([]models.Mention, error) {
+	if limit <= 0 {
+		limit = defaultMentionPage
+	}
+	if limit > maxMentionPage {
+		limit = maxMentionPage
+	}
+
+	return s.repos.Messages.Mentions(userID, limit)
+}
+
+func parseMentions(content string) []string {
+	seen := make(map[string]bool)
+	var usernames []string
+	for _, match := range mentionPattern.FindAllStringSubmatch(content, -1) {
+		// Allow mentions at the end of a sentence ("thanks @agent1.")
+		name := strings.TrimRight(match[1], ".-")
+		if name != "" && !seen[name] {
+			seen[name] = true
+			usernames = append(usernames, name)
+		}
+	}
+	return usernames
+}
@@ -0,0 +1,103 @@
+package services
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"cs-socket/internal/models"
+	"cs-socket/internal/repository"
+)
+
+func TestParseMentions(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    []string
+	}{
+		{"start", "@alice please check", []string{"alice"}},
+		{"end", "please check @alice", []string{"alice"}},
+		{"after newline", "please check\n@alice", []string{"alice"}},
+		{"end of sentence", "Thanks @alice. Also @bob-", []string{"alice", "bob"}},
+		{"punctuation", "@alice, @bob! @carol? @dave:", []string{"alice", "bob", "carol", "dave"}},
+		{"dots and dashes inside", "ask @agent.one or @super-agent_2", []string{"agent.one", "super-agent_2"}},
+		{"repeated", "@alice and @alice again", []string{"alice"}},
+		{"email", "mail carol@example.com or me@example.com", nil},
+		{"inside a word", "x@alice", nil},
+		{"bare at sign", "meet @ 5 or @. later", nil},
+		// Unknown users are parsed; they are dropped when mentions are recorded
+		{"unknown user", "@nobody-here", []string{"nobody-here"}},
+	}
+
+	for _, tt := range tests {
+		if got := parseMentions(tt.content); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: parseMentions(%q) = %q, want %q", tt.name, tt.content, got, tt.want)
+		}
+	}
+}
+
+func TestMentions(t *testing.T) {
+	s, repos := newTestChatService(t)
+	chat, customer, agent := createChat(t, s, repos)
+	alice := createUser(t, repos, "alice", "super-agent")
+	createUser(t, repos, "dave", "customer")
+
+	note, err := s.AddNote(chat.ID, agent.ID, "agent", "@alice @dave @"+agent.Username+" @nobody please check")
+	if err != nil {
+		t.Fatalf("AddNote: %v", err)
+	}
+	if note.MessageType != "note" || note.Visibility == models.VisibilityPublic {
+		t.Errorf("note: type %q, visibility %q", note.MessageType, note.Visibility)
+	}
+	if _, err := s.AddNote(chat.ID, customer.ID, "customer", "@alice"); !errors.Is(err, ErrForbidden) {
+		t.Errorf("AddNote as the customer: err = %v, want ErrForbidden", err)
+	}
+
+	mentions, err := s.GetMentions(alice.ID, 0)
+	if err != nil {
+		t.Fatalf("GetMentions: %v", err)
+	}
+	if len(mentions) != 1 || mentions[0].ChatID != chat.ID || mentions[0].Message.ID != note.ID {
+		t.Errorf("mentions of alice = %+v, want the note", mentions)
+	}
+
+	// Customers and the sender are not mentioned
+	for _, userID := range []string{agent.ID, customer.ID} {
+		mentions, err := s.GetMentions(userID, 0)
+		if err != nil {
+			t.Fatalf("GetMentions: %v", err)
+		}
+		if len(mentions) != 0 {
+			t.Errorf("mentions of %s = %+v, want none", userID, mentions)
+		}
+	}
+}
+
+// failingNotes is a message store whose notes never commit.
+type failingNotes struct {
+	repository.MessageRepository
+}
+
+func (failingNotes) InsertNote(repository.NewMessage, []string) (*models.Message, []string, error) {
+	return nil, nil, errors.New("commit failed")
+}
+
+func TestAddNoteNotStored(t *testing.T) {
+	s, repos := newTestChatService(t)
+	chat, _, agent := createChat(t, s, repos)
+	alice := createUser(t, repos, "alice", "super-agent")
+	s.repos.Messages = failingNotes{repos.Messages}
+
+	if _, err := s.AddNote(chat.ID, agent.ID, "agent", "@alice please check"); err == nil {
+		t.Fatal("AddNote succeeded without storing the note")
+	}
+
+	// Mentions are stored with the note, so a failed note leaves none
+	mentions, err := repos.Messages.Mentions(alice.ID, 10)
+	if err != nil {
+		t.Fatalf("Mentions: %v", err)
+	}
+	if len(mentions) != 0 {
+		t.Errorf("mentions of alice = %+v, want none", mentions)
+	}
+}
@@ -0,0 +1,166 @@
+package services
+
+import (
+	"database/sql"
+	"fmt"
+
+	"cs-socket/internal/models"
+	"cs-socket/internal/websocket"
+)
+
+// priorityLevels lists the chat priorities from lowest to highest.
+var priorityLevels = []string{
+	models.PriorityLow,
+	models.PriorityNormal,
+	models.PriorityHigh,
+	models.PriorityUrgent,
+}
+
+// tierPriority is the base priority of a chat opened by a customer tier.
+var tierPriority = map[string]string{
+	models.TierRegular:    models.PriorityNormal,
+	models.TierVIP:        models.PriorityHigh,
+	models.TierHighRoller: models.PriorityUrgent,
+}
+
+// derivePriority returns the priority of a new chat from the customer's tier,
+// raised one level for topics configured as elevated.
+func (s *ChatService) derivePriority(tier, topic string) string {
+	priority, ok := tierPriority[tier]
+	if !ok {
+		priority = models.PriorityNormal
+	}
+
+	for _, elevated := range s.priority.ElevatedTopics {
+		if elevated == topic {
+			return shiftPriority(priority, 1)
+		}
+	}
+	return priority
+}
+
+// EscalatePriority raises a chat's priority by one level.
+func (s *ChatService) EscalatePriority(chatID, userID, role string) (*models.Chat, error) {
+	return s.changePriority(chatID, userID, role, 1)
+}
+
+// DeescalatePriority lowers a chat's priority by one level.
+func (s *ChatService) DeescalatePriority(chatID, userID, role string) (*models.Chat, error) {
+	return s.changePriority(chatID, userID, role, -1)
+}
+
+func (s *ChatService) changePriority(chatID, userID, role string, delta int) (*models.Chat, error) {
+	if !isStaff(role) {
+		return nil, ErrForbidden
+	}
+
+	chat, err := s.GetChat(chatID)
+	if err != nil {
+		return nil, err
+	}
+	if !canManageChat(chat, userID, role) {
+		return nil, ErrForbidden
+	}
+
+	priority := shiftPriority(chat.Priority, delta)
+	if priority == chat.Priority {
+		return nil, fmt.Errorf("%w: chat is already at %s priority", ErrInvalidInput, priority)
+	}
+
+	if err := s.repos.Chats.SetPriority(chatID, priority); err != nil {
+		return nil, err
+	}
+
+	wsMessage := websocket.Message{
+		Type:   "chat_priority_changed",
+		ChatID: chatID,
+		Data: map[string]interface{}{
+			"chatId":       chatID,
+			"fromPriority": chat.Priority,
+			"priority":     priority,
+			"changedBy":    userID,
+		},
+	}
+	s.hub.BroadcastToUsersAndRole(s.staffParticipants(chat), "super-agent", wsMessage)
+
+	chat.Priority = priority
+	return chat, nil
+}
+
+// GetQueue returns the unassigned queued chats in the order they should be
+// picked up: highest priority first, then oldest first.
+func (s *ChatService) GetQueue(role string) ([]models.Chat, error) {
+	if !isStaff(role) {
+		return nil, ErrForbidden
+	}
+
+	chats, err := s.repos.Chats.Queue(0)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.attachLastMessages(chats, role); err != nil {
+		return nil, err
+	}
+	return chats, nil
+}
+
+// TakeNextChat assigns the highest-priority queued chat to the agent and
+// makes it active. It returns sql.ErrNoRows when the queue is empty.
+func (s *ChatService) TakeNextChat(userID, role string) (*models.Chat, error) {
+	if !isStaff(role) {
+		return nil, ErrForbidden
+	}
+
+	// Another agent may take or close the same chat between the select and
+	// the assignment; retry a few times with the next candidate.
+	for attempt := 0; attempt < 5; attempt++ {
+		queue, err := s.repos.Chats.Queue(1)
+		if err != nil {
+			return nil, err
+		}
+		if len(queue) == 0 {
+			return nil, sql.ErrNoRows
+		}
+		chat := &queue[0]
+
+		err = s.changeStatus(chat, models.ChatStatusActive, &userID, &userID, nil, nil)
+		if err == errChatTaken || err == errStatusChanged {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return s.GetChat(chat.ID)
+	}
+
+	return nil, fmt.Errorf("%w: queue is busy, try again", ErrInvalidTransition)
+}
+
+func (s *ChatService) customerTier(customerID string) (string, error) {
+	customer, err := s.repos.Users.GetByID(customerID)
+	if err == sql.ErrNoRows {
+		return models.TierRegular, nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return customer.Tier, nil
+}
+
+func shiftPriority(priority string, delta int) string {
+	index := 1 // normal
+	for i, level := range priorityLevels {
+		if level == priority {
+			index = i
+		}
+	}
+
+	index += delta
+	if index < 0 {
+		index = 0
+	}
+	if index >= len(priorityLevels) {
+		index = len(priorityLevels) - 1
+	}
+	return priorityLevels[index]
+}
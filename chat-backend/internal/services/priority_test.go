@@ -0,0 +1,81 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+
+	"cs-socket/internal/models"
+)
+
+func TestQueue(t *testing.T) {
+	s, repos := newTestChatService(t)
+	agent := createUser(t, repos, "bob", "agent")
+
+	normal, err := s.CreateChat(createUser(t, repos, "carol", "customer").ID, nil, "")
+	if err != nil {
+		t.Fatalf("CreateChat: %v", err)
+	}
+	high, err := s.CreateChat(createUser(t, repos, "dave", "customer").ID, nil, "withdrawal")
+	if err != nil {
+		t.Fatalf("CreateChat: %v", err)
+	}
+	createChat(t, s, repos)
+
+	if _, err := s.GetQueue("customer"); !errors.Is(err, ErrForbidden) {
+		t.Errorf("GetQueue as a customer: err = %v, want ErrForbidden", err)
+	}
+	queue, err := s.GetQueue("agent")
+	if err != nil {
+		t.Fatalf("GetQueue: %v", err)
+	}
+	if len(queue) != 2 || queue[0].ID != high.ID || queue[1].ID != normal.ID {
+		t.Errorf("queue = %v, want [%s %s]", chatIDs(queue), high.ID, normal.ID)
+	}
+
+	for _, want := range []string{high.ID, normal.ID} {
+		taken, err := s.TakeNextChat(agent.ID, "agent")
+		if err != nil {
+			t.Fatalf("TakeNextChat: %v", err)
+		}
+		if taken.ID != want || taken.Status != models.ChatStatusActive || taken.AgentID == nil || *taken.AgentID != agent.ID {
+			t.Errorf("taken chat %s: status %q, agent %v; want %s", taken.ID, taken.Status, taken.AgentID, want)
+		}
+	}
+	if _, err := s.TakeNextChat(agent.ID, "agent"); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("TakeNextChat on an empty queue: err = %v, want sql.ErrNoRows", err)
+	}
+}
+
+func TestChangePriority(t *testing.T) {
+	s, repos := newTestChatService(t)
+	chat, _, agent := createChat(t, s, repos)
+
+	escalated, err := s.EscalatePriority(chat.ID, agent.ID, "agent")
+	if err != nil {
+		t.Fatalf("EscalatePriority: %v", err)
+	}
+	if escalated.Priority != models.PriorityHigh {
+		t.Errorf("escalated priority = %q, want high", escalated.Priority)
+	}
+	stored, err := s.GetChat(chat.ID)
+	if err != nil {
+		t.Fatalf("GetChat: %v", err)
+	}
+	if stored.Priority != models.PriorityHigh {
+		t.Errorf("stored priority = %q, want high", stored.Priority)
+	}
+
+	for _, want := range []string{models.PriorityNormal, models.PriorityLow} {
+		chat, err := s.DeescalatePriority(chat.ID, agent.ID, "agent")
+		if err != nil {
+			t.Fatalf("DeescalatePriority: %v", err)
+		}
+		if chat.Priority != want {
+			t.Errorf("deescalated priority = %q, want %q", chat.Priority, want)
+		}
+	}
+	if _, err := s.DeescalatePriority(chat.ID, agent.ID, "agent"); !errors.Is(err, ErrInvalidInput) {
+		t.Errorf("deescalating the lowest priority: err = %v, want ErrInvalidInput", err)
+	}
+}
@@ -0,0 +1,137 @@
+package services
+
+import (
+	"fmt"
+
+	"cs-socket/internal/models"
+	"cs-socket/internal/websocket"
+)
+
+// AddReaction records the user's reaction to a message. Reacting twice with
+// the same emoji is a no-op. It returns the message's reactions.
+func (s *ChatService) AddReaction(chatID, messageID, userID, role, emoji string) ([]models.Reaction, error) {
+	if !s.allowedReaction(emoji) {
+		return nil, fmt.Errorf("%w: reaction %q is not allowed", ErrInvalidInput, emoji)
+	}
+
+	chat, message, err := s.reactableMessage(chatID, messageID, userID, role)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.repos.Messages.AddReaction(messageID, userID, emoji); err != nil {
+		return nil, err
+	}
+	if err := s.touchMessage(messageID); err != nil {
+		return nil, err
+	}
+
+	return s.notifyReactions(chat, message, "reaction_added", userID, emoji)
+}
+
+func (s *ChatService) RemoveReaction(chatID, messageID, userID, role, emoji string) ([]models.Reaction, error) {
+	chat, message, err := s.reactableMessage(chatID, messageID, userID, role)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.repos.Messages.RemoveReaction(messageID, userID, emoji); err != nil {
+		return nil, err
+	}
+	if err := s.touchMessage(messageID); err != nil {
+		return nil, err
+	}
+
+	return s.notifyReactions(chat, message, "reaction_removed", userID, emoji)
+}
+
+// attachReactions loads the reactions of the given messages in one query.
+// Deleted messages keep no reactions.
+func (s *ChatService) attachReactions(messages []models.Message) error {
+	if len(messages) == 0 {
+		return nil
+	}
+
+	ids := make([]string, 0, len(messages))
+	index := make(map[string]int, len(messages))
+	for i, message := range messages {
+		if message.DeletedAt == nil {
+			ids = append(ids, message.ID)
+			index[message.ID] = i
+		}
+	}
+
+	reactions, err := s.repos.Messages.Reactions(ids)
+	if err != nil {
+		return err
+	}
+	for id, list := range reactions {
+		messages[index[id]].Reactions = list
+	}
+	return nil
+}
+
+// reactableMessage loads a message the user can see and react to.
+func (s *ChatService) reactableMessage(chatID, messageID, userID, role string) (*models.Chat, *models.Message, error) {
+	chat, err := s.GetChat(chatID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !canAccessChat(chat, userID, role) {
+		return nil, nil, ErrForbidden
+	}
+
+	message, err := s.getMessage(messageID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if message.ChatID != chatID || (message.Visibility != models.VisibilityPublic && !isStaff(role)) {
+		return nil, nil, ErrForbidden
+	}
+	if message.DeletedAt != nil {
+		return nil, nil, fmt.Errorf("%w: message has been deleted", ErrInvalidTransition)
+	}
+
+	return chat, message, nil
+}
+
+// notifyReactions sends the message's current reactions to the chat's
+// participants who can see the message, and returns them.
+func (s *ChatService) notifyReactions(chat *models.Chat, message *models.Message, eventType, userID, emoji string) ([]models.Reaction, error) {
+	reactions, err := s.repos.Messages.Reactions([]string{message.ID})
+	if err != nil {
+		return nil, err
+	}
+	summary := reactions[message.ID]
+	if summary == nil {
+		summary = []models.Reaction{}
+	}
+
+	recipients := s.staffParticipants(chat)
+	if message.Visibility == models.VisibilityPublic {
+		recipients = append(recipients, chat.CustomerID)
+	}
+
+	s.hub.BroadcastToUsers(append(recipients, userID), websocket.Message{
+		Type:   eventType,
+		ChatID: chat.ID,
+		Data: map[string]interface{}{
+			"chatId":    chat.ID,
+			"messageId": message.ID,
+			"userId":    userID,
+			"emoji":     emoji,
+			"reactions": summary,
+		},
+	})
+
+	return summary, nil
+}
+
+func (s *ChatService) allowedReaction(emoji string) bool {
+	for _, allowed := range s.messages.Reactions {
+		if allowed == emoji {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,43 @@
+package services
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/google/uuid"
+)
+
+// MarkRead records that the user has read a chat up to and including a
+// message. Marking an older message than the last one read does nothing.
+func (s *ChatService) MarkRead(chatID, userID, role, messageID string) error {
+	chat, err := s.GetChat(chatID)
+	if err != nil {
+		return err
+	}
+	if !canAccessChat(chat, userID, role) {
+		return ErrForbidden
+	}
+	if _, err := uuid.Parse(messageID); err != nil {
+		return fmt.Errorf("%w: invalid message ID %q", ErrInvalidInput, messageID)
+	}
+
+	message, err := s.getMessage(messageID)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	if message == nil || message.ChatID != chatID {
+		return fmt.Errorf("%w: %s is not a message of this chat", ErrInvalidInput, messageID)
+	}
+
+	// Read positions count in the numbering the user sees. Customers can't
+	// see staff-only messages, which have no public number.
+	seq := &message.Seq
+	if !isStaff(role) {
+		seq = message.PublicSeq
+	}
+	if seq == nil {
+		return fmt.Errorf("%w: %s is not a message of this chat", ErrInvalidInput, messageID)
+	}
+
+	return s.repos.Chats.MarkRead(chatID, userID, *seq)
+}
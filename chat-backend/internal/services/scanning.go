@@ -0,0 +1,164 @@
+package services
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"cs-socket/internal/config"
+	"cs-socket/internal/models"
+	"cs-socket/internal/repository"
+	"cs-socket/internal/scanner"
+	"cs-socket/internal/storage"
+	"cs-socket/internal/websocket"
+)
+
+// scanBatchSize is how many attachments a single pass claims for scanning.
+const scanBatchSize = 20
+
+// ScanMonitor scans uploaded files for malware in the background. Files stay
+// quarantined until they are found clean; infected files can never be
+// downloaded.
+type ScanMonitor struct {
+	chatService *ChatService
+	store       storage.Storage
+	scanner     scanner.Scanner
+	config      config.ScannerConfig
+	wake        chan struct{}
+}
+
+func NewScanMonitor(chatService *ChatService, store storage.Storage, s scanner.Scanner, cfg config.ScannerConfig) *ScanMonitor {
+	return &ScanMonitor{
+		chatService: chatService,
+		store:       store,
+		scanner:     s,
+		config:      cfg,
+		wake:        make(chan struct{}, 1),
+	}
+}
+
+// Run scans pending files whenever an upload arrives, and retries the ones
+// that could not be scanned every retry interval, until ctx is done.
+func (m *ScanMonitor) Run(ctx context.Context) {
+	ticker := time.NewTicker(m.config.RetryInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := m.Check(ctx); err != nil {
+			log.Printf("Scan monitor: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-m.wake:
+		}
+	}
+}
+
+// Notify tells the monitor that a file is waiting to be scanned.
+func (m *ScanMonitor) Notify() {
+	select {
+	case m.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Check scans the pending files that are due, until none are left.
+func (m *ScanMonitor) Check(ctx context.Context) error {
+	for {
+		claimed, err := m.claim()
+		if err != nil {
+			return err
+		}
+		if len(claimed) == 0 {
+			return nil
+		}
+
+		for _, c := range claimed {
+			m.scan(ctx, c.AttachmentID, c.StorageKey, c.Attempts)
+		}
+	}
+}
+
+// claim picks pending files that have not been tried within the retry
+// interval and counts the attempt, so that other instances skip them. Only
+// files already sent with a message are picked, so that the scan result can
+// be announced in its chat.
+func (m *ScanMonitor) claim() ([]repository.ScanClaim, error) {
+	return m.chatService.repos.Messages.ClaimScans(m.config.RetryInterval, scanBatchSize)
+}
+
+func (m *ScanMonitor) scan(ctx context.Context, attachmentID, storageKey string, attempts int) {
+	result, err := m.scanFile(ctx, storageKey)
+	if err != nil {
+		if attempts < m.config.MaxAttempts {
+			log.Printf("Scan monitor: attachment %s (attempt %d): %v", attachmentID, attempts, err)
+			return
+		}
+		log.Printf("Scan monitor: giving up on attachment %s after %d attempts: %v", attachmentID, attempts, err)
+		m.record(attachmentID, models.ScanFailed, nil)
+		return
+	}
+
+	if result.Infected {
+		log.Printf("Scan monitor: attachment %s is infected with %s and has been quarantined", attachmentID, result.Signature)
+		m.record(attachmentID, models.ScanInfected, &result.Signature)
+		return
+	}
+	m.record(attachmentID, models.ScanClean, nil)
+}
+
+func (m *ScanMonitor) scanFile(ctx context.Context, storageKey string) (scanner.Result, error) {
+	content, err := m.store.Get(ctx, storageKey)
+	if err != nil {
+		return scanner.Result{}, err
+	}
+	defer content.Close()
+
+	return m.scanner.Scan(ctx, content)
+}
+
+// record saves the outcome of a scan and announces it in the chat, with
+// download URLs if the file is clean.
+func (m *ScanMonitor) record(attachmentID, status string, signature *string) {
+	attachment, err := m.chatService.repos.Messages.RecordScan(attachmentID, status, signature)
+	if err != nil {
+		log.Printf("Scan monitor: failed to record scan of attachment %s: %v", attachmentID, err)
+		return
+	}
+	if attachment.MessageID == nil {
+		return
+	}
+	if err := m.chatService.touchMessage(*attachment.MessageID); err != nil {
+		log.Printf("Scan monitor: failed to mark message of attachment %s as changed: %v", attachmentID, err)
+	}
+
+	message, err := m.chatService.getMessage(*attachment.MessageID)
+	if err != nil {
+		log.Printf("Scan monitor: failed to load message of attachment %s: %v", attachmentID, err)
+		return
+	}
+	// Deleted messages no longer show their attachments
+	if message.DeletedAt != nil {
+		return
+	}
+
+	if err := m.chatService.attachThumbnails([]*models.Attachment{attachment}); err != nil {
+		log.Printf("Scan monitor: failed to load thumbnails of attachment %s: %v", attachmentID, err)
+	}
+	m.chatService.signAttachment(attachment)
+
+	m.chatService.broadcastMessageEvent(message, func(string) websocket.Message {
+		return websocket.Message{
+			Type:   "attachment_scanned",
+			ChatID: message.ChatID,
+			Data: map[string]interface{}{
+				"chatId":     message.ChatID,
+				"messageId":  message.ID,
+				"attachment": attachment,
+			},
+		}
+	})
+}
@@ -0,0 +1,36 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+
+	"cs-socket/internal/models"
+)
+
+const (
+	defaultSearchLimit = 20
+	maxSearchLimit     = 100
+	maxSearchQuery     = 200
+)
+
+// SearchMessages finds messages by content in the chats the user may see, as
+// listed by GetChats, including archived ones. Customers only find public
+// messages; deleted messages are never found. The query accepts web search
+// syntax: quoted phrases, "or" and a leading "-" to exclude a word.
+func (s *ChatService) SearchMessages(userID, role string, filter models.MessageSearchFilter) ([]models.MessageSearchResult, error) {
+	filter.Query = strings.TrimSpace(filter.Query)
+	if filter.Query == "" {
+		return nil, fmt.Errorf("%w: q is required", ErrInvalidInput)
+	}
+	if len([]rune(filter.Query)) > maxSearchQuery {
+		return nil, fmt.Errorf("%w: q may be at most %d characters", ErrInvalidInput, maxSearchQuery)
+	}
+	if filter.Limit <= 0 || filter.Limit > maxSearchLimit {
+		filter.Limit = defaultSearchLimit
+	}
+	if filter.Offset < 0 {
+		filter.Offset = 0
+	}
+
+	return s.repos.Messages.Search(chatScope(userID, role), filter, isStaff(role))
+}
@@ -0,0 +1,327 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"cs-socket/internal/clock"
+	"cs-socket/internal/models"
+	"cs-socket/internal/repository"
+	"cs-socket/internal/websocket"
+)
+
+const (
+	slaMetricFirstResponse = "first_response"
+	slaMetricResolution    = "resolution"
+
+	slaLevelWarning = "warning"
+	slaLevelBreach  = "breach"
+)
+
+// SLAService tracks response times against SLA policies. Its monitor warns
+// the assigned agent and super-agents before a threshold is breached and
+// records every warning and breach for reporting.
+type SLAService struct {
+	repos repository.Repositories
+	hub   *websocket.Hub
+	clock clock.Clock
+}
+
+func NewSLAService(repos repository.Repositories, hub *websocket.Hub, clk clock.Clock) *SLAService {
+	return &SLAService{
+		repos: repos,
+		hub:   hub,
+		clock: clk,
+	}
+}
+
+func (s *SLAService) GetPolicies() ([]models.SLAPolicy, error) {
+	return s.repos.SLA.Policies()
+}
+
+func (s *SLAService) CreatePolicy(req models.SLAPolicyRequest) (*models.SLAPolicy, error) {
+	if err := validateSLAPolicy(req); err != nil {
+		return nil, err
+	}
+
+	policy := slaPolicy(req)
+	if err := s.repos.SLA.CreatePolicy(&policy); err != nil {
+		return nil, err
+	}
+	return &policy, nil
+}
+
+func (s *SLAService) UpdatePolicy(id string, req models.SLAPolicyRequest) (*models.SLAPolicy, error) {
+	if err := validateSLAPolicy(req); err != nil {
+		return nil, err
+	}
+
+	policy := slaPolicy(req)
+	policy.ID = id
+	if err := s.repos.SLA.UpdatePolicy(&policy); err != nil {
+		return nil, err
+	}
+	return &policy, nil
+}
+
+// slaPolicy returns the policy a request describes.
+func slaPolicy(req models.SLAPolicyRequest) models.SLAPolicy {
+	return models.SLAPolicy{
+		Name:                 req.Name,
+		Priority:             req.Priority,
+		Topic:                req.Topic,
+		FirstResponseWarning: req.FirstResponseWarning,
+		FirstResponseBreach:  req.FirstResponseBreach,
+		ResolutionWarning:    req.ResolutionWarning,
+		ResolutionBreach:     req.ResolutionBreach,
+	}
+}
+
+func (s *SLAService) DeletePolicy(id string) error {
+	return s.repos.SLA.DeletePolicy(id)
+}
+
+func (s *SLAService) GetPolicy(id string) (*models.SLAPolicy, error) {
+	return s.repos.SLA.GetPolicy(id)
+}
+
+// policyFor returns the most specific of the policies for a chat: one
+// matching both priority and topic wins over one matching either, which wins
+// over a catch-all policy, and older policies win ties. It returns nil if no
+// policy applies.
+func policyFor(policies []models.SLAPolicy, priority, topic string) *models.SLAPolicy {
+	var best *models.SLAPolicy
+	bestRank := -1
+	for i := range policies {
+		p := &policies[i]
+		if (p.Priority != nil && *p.Priority != priority) || (p.Topic != nil && *p.Topic != topic) {
+			continue
+		}
+
+		rank := 0
+		if p.Priority != nil {
+			rank += 2
+		}
+		if p.Topic != nil {
+			rank++
+		}
+		if rank > bestRank || (rank == bestRank && p.CreatedAt.Before(best.CreatedAt)) {
+			best, bestRank = p, rank
+		}
+	}
+	return best
+}
+
+// GetChatSLA measures a chat's response times from its messages.
+func (s *SLAService) GetChatSLA(chatID, userID, role string) (*models.ChatSLA, error) {
+	if !isStaff(role) {
+		return nil, ErrForbidden
+	}
+
+	chat, err := s.repos.Chats.Get(chatID)
+	if err != nil {
+		return nil, err
+	}
+	if !canAccessChat(chat, userID, role) {
+		return nil, ErrForbidden
+	}
+
+	replies, err := s.repos.SLA.Messages(chatID)
+	if err != nil {
+		return nil, err
+	}
+
+	sla := &models.ChatSLA{ChatID: chatID}
+	sla.FirstResponse, sla.AverageReply = responseTimes(chat.CreatedAt, replies)
+
+	end := chat.ResolvedAt
+	if end == nil {
+		end = chat.ClosedAt
+	}
+	if end != nil {
+		seconds := end.Sub(chat.CreatedAt).Seconds()
+		sla.Resolution = &seconds
+	}
+
+	policies, err := s.GetPolicies()
+	if err != nil {
+		return nil, err
+	}
+	sla.Policy = policyFor(policies, chat.Priority, chat.Topic)
+	if sla.Events, err = s.GetEvents(models.SLAEventFilter{ChatID: chatID}); err != nil {
+		return nil, err
+	}
+
+	return sla, nil
+}
+
+// GetEvents lists recorded SLA warnings and breaches, most recent first.
+func (s *SLAService) GetEvents(filter models.SLAEventFilter) ([]models.SLAEvent, error) {
+	return s.repos.SLA.Events(filter)
+}
+
+// Run checks open chats against their SLA policies every interval until ctx
+// is done.
+func (s *SLAService) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.Check(); err != nil {
+				log.Printf("SLA monitor: %v", err)
+			}
+		}
+	}
+}
+
+// Check runs a single pass over the open chats and raises every warning and
+// breach that has not been raised yet.
+func (s *SLAService) Check() error {
+	chats, err := s.repos.SLA.OpenChats()
+	if err != nil {
+		return err
+	}
+
+	policies, err := s.GetPolicies()
+	if err != nil {
+		return err
+	}
+
+	now := s.clock.Now()
+	for _, c := range chats {
+		policy := policyFor(policies, c.Priority, c.Topic)
+		if policy == nil {
+			continue
+		}
+
+		elapsed := elapsedSeconds(now, c.CreatedAt)
+		if !c.Responded {
+			s.raise(c.ID, c.AgentID, policy, slaMetricFirstResponse, elapsed,
+				policy.FirstResponseWarning, policy.FirstResponseBreach)
+		}
+		s.raise(c.ID, c.AgentID, policy, slaMetricResolution, elapsed,
+			policy.ResolutionWarning, policy.ResolutionBreach)
+	}
+
+	return nil
+}
+
+// raise records and announces the highest threshold crossed for a metric.
+// Each level is only raised once per chat.
+func (s *SLAService) raise(chatID string, agentID *string, policy *models.SLAPolicy, metric string, elapsed, warning, breach int) {
+	level, threshold := crossedSLALevel(elapsed, warning, breach)
+	if level == "" {
+		return
+	}
+
+	policyID := policy.ID
+	event := models.SLAEvent{
+		ChatID:    chatID,
+		PolicyID:  &policyID,
+		AgentID:   agentID,
+		Metric:    metric,
+		Level:     level,
+		Threshold: threshold,
+		Elapsed:   elapsed,
+	}
+	err := s.repos.SLA.RecordEvent(&event)
+	if err == repository.ErrDuplicate {
+		// Already raised; a breach also implies its warning
+		return
+	}
+	if err != nil {
+		log.Printf("SLA monitor: failed to record %s %s for chat %s: %v", metric, level, chatID, err)
+		return
+	}
+
+	eventType := "sla_warning"
+	if level == slaLevelBreach {
+		eventType = "sla_breached"
+	}
+	wsMessage := websocket.Message{
+		Type:   eventType,
+		ChatID: chatID,
+		Data:   event,
+	}
+	var recipients []string
+	if agentID != nil {
+		recipients = append(recipients, *agentID)
+	}
+	s.hub.BroadcastToUsersAndRole(recipients, "super-agent", wsMessage)
+}
+
+// crossedSLALevel returns the highest level whose threshold elapsed seconds
+// have reached, with that threshold, or "" if none. Thresholds of zero are
+// disabled.
+func crossedSLALevel(elapsed, warning, breach int) (string, int) {
+	if breach > 0 && elapsed >= breach {
+		return slaLevelBreach, breach
+	}
+	if warning > 0 && elapsed >= warning {
+		return slaLevelWarning, warning
+	}
+	return "", 0
+}
+
+// elapsedSeconds returns the whole seconds from since to now. A chat created
+// after now, by a clock running behind the database, has no elapsed time.
+func elapsedSeconds(now, since time.Time) int {
+	if !now.After(since) {
+		return 0
+	}
+	return int(now.Sub(since).Seconds())
+}
+
+// responseTimes returns the time from chat creation to the first staff reply
+// and the average time staff took to answer a customer, in seconds.
+func responseTimes(createdAt time.Time, messages []repository.SLAMessage) (first, average *float64) {
+	var waitingSince *time.Time
+	var total float64
+	var count int
+
+	for i, m := range messages {
+		if m.FromCustomer {
+			if waitingSince == nil {
+				waitingSince = &messages[i].At
+			}
+			continue
+		}
+
+		if first == nil {
+			seconds := m.At.Sub(createdAt).Seconds()
+			first = &seconds
+		}
+		if waitingSince != nil {
+			total += m.At.Sub(*waitingSince).Seconds()
+			count++
+			waitingSince = nil
+		}
+	}
+
+	if count > 0 {
+		avg := total / float64(count)
+		average = &avg
+	}
+	return first, average
+}
+
+// validateSLAPolicy checks the thresholds of a policy. Zero disables a
+// threshold, so a warning is only compared to a breach threshold that is set.
+func validateSLAPolicy(req models.SLAPolicyRequest) error {
+	for _, threshold := range []int{req.FirstResponseWarning, req.FirstResponseBreach, req.ResolutionWarning, req.ResolutionBreach} {
+		if threshold < 0 {
+			return fmt.Errorf("%w: thresholds must not be negative", ErrInvalidInput)
+		}
+	}
+	if (req.FirstResponseBreach > 0 && req.FirstResponseWarning > req.FirstResponseBreach) ||
+		(req.ResolutionBreach > 0 && req.ResolutionWarning > req.ResolutionBreach) {
+		return fmt.Errorf("%w: warning thresholds must not exceed breach thresholds", ErrInvalidInput)
+	}
+	return nil
+}
@@ -0,0 +1,223 @@
+package services
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"cs-socket/internal/clock"
+	"cs-socket/internal/models"
+	"cs-socket/internal/repository"
+)
+
+func TestCrossedSLALevel(t *testing.T) {
+	tests := []struct {
+		name            string
+		elapsed         int
+		warning, breach int
+		level           string
+		threshold       int
+	}{
+		{"before warning", 59, 60, 120, "", 0},
+		{"at warning", 60, 60, 120, slaLevelWarning, 60},
+		{"between", 90, 60, 120, slaLevelWarning, 60},
+		{"at breach", 120, 60, 120, slaLevelBreach, 120},
+		{"long after breach", 3600, 60, 120, slaLevelBreach, 120},
+		{"warning disabled", 90, 0, 120, "", 0},
+		{"breach disabled", 3600, 60, 0, slaLevelWarning, 60},
+		{"both disabled", 3600, 0, 0, "", 0},
+	}
+
+	for _, tt := range tests {
+		level, threshold := crossedSLALevel(tt.elapsed, tt.warning, tt.breach)
+		if level != tt.level || threshold != tt.threshold {
+			t.Errorf("%s: got %q at %d, want %q at %d", tt.name, level, threshold, tt.level, tt.threshold)
+		}
+	}
+}
+
+func TestElapsedSeconds(t *testing.T) {
+	createdAt := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name string
+		now  time.Time
+		want int
+	}{
+		{"partial second", createdAt.Add(59*time.Second + 999*time.Millisecond), 59},
+		{"clock behind", createdAt.Add(-time.Minute), 0},
+		// Instants compare the same whatever zone they are read in
+		{"other zone", createdAt.Add(2 * time.Minute).In(time.FixedZone("UTC+2", 2*60*60)), 120},
+	}
+
+	for _, tt := range tests {
+		if got := elapsedSeconds(tt.now, createdAt); got != tt.want {
+			t.Errorf("%s: elapsed = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestResponseTimes(t *testing.T) {
+	createdAt := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	at := func(seconds int) time.Time { return createdAt.Add(time.Duration(seconds) * time.Second) }
+	customer := func(seconds int) repository.SLAMessage {
+		return repository.SLAMessage{FromCustomer: true, At: at(seconds)}
+	}
+	staff := func(seconds int) repository.SLAMessage { return repository.SLAMessage{At: at(seconds)} }
+
+	tests := []struct {
+		name           string
+		messages       []repository.SLAMessage
+		first, average float64 // -1 for none
+	}{
+		{"no messages", nil, -1, -1},
+		{"customer only", []repository.SLAMessage{customer(5), customer(10)}, -1, -1},
+		{"one reply", []repository.SLAMessage{customer(5), staff(65)}, 65, 60},
+		// The wait starts at the first unanswered customer message
+		{"several questions", []repository.SLAMessage{customer(0), customer(30), staff(90)}, 90, 90},
+		{"two exchanges", []repository.SLAMessage{customer(0), staff(60), staff(70), customer(100), staff(130)}, 60, 45},
+		// A greeting before the customer writes is a first response but no reply
+		{"agent first", []repository.SLAMessage{staff(20), customer(40), staff(100)}, 20, 60},
+	}
+
+	for _, tt := range tests {
+		first, average := responseTimes(createdAt, tt.messages)
+		if !sameSeconds(first, tt.first) || !sameSeconds(average, tt.average) {
+			t.Errorf("%s: got first %v, average %v, want %v, %v",
+				tt.name, formatSeconds(first), formatSeconds(average), tt.first, tt.average)
+		}
+	}
+}
+
+func TestPolicyFor(t *testing.T) {
+	high, urgent, billing := models.PriorityHigh, models.PriorityUrgent, "billing"
+	start := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	policies := []models.SLAPolicy{
+		{ID: "catch-all", CreatedAt: start},
+		{ID: "high", Priority: &high, CreatedAt: start},
+		{ID: "billing", Topic: &billing, CreatedAt: start},
+		{ID: "high billing", Priority: &high, Topic: &billing, CreatedAt: start.Add(time.Minute)},
+		{ID: "older high billing", Priority: &high, Topic: &billing, CreatedAt: start},
+		{ID: "urgent", Priority: &urgent, CreatedAt: start},
+	}
+
+	tests := []struct {
+		priority, topic string
+		want            string
+	}{
+		{models.PriorityHigh, "billing", "older high billing"},
+		{models.PriorityHigh, "general", "high"},
+		{models.PriorityNormal, "billing", "billing"},
+		{models.PriorityNormal, "general", "catch-all"},
+		{models.PriorityUrgent, "billing", "urgent"},
+	}
+
+	for _, tt := range tests {
+		policy := policyFor(policies, tt.priority, tt.topic)
+		if policy == nil || policy.ID != tt.want {
+			t.Errorf("%s/%s: got %v, want %s", tt.priority, tt.topic, policy, tt.want)
+		}
+	}
+
+	if policy := policyFor(policies[1:2], models.PriorityLow, "general"); policy != nil {
+		t.Errorf("no matching policy: got %s", policy.ID)
+	}
+}
+
+func sameSeconds(got *float64, want float64) bool {
+	if got == nil {
+		return want == -1
+	}
+	return *got == want
+}
+
+func formatSeconds(seconds *float64) interface{} {
+	if seconds == nil {
+		return "none"
+	}
+	return *seconds
+}
+
+func TestSLACheck(t *testing.T) {
+	s, repos := newTestChatService(t)
+	chat, _, agent := createChat(t, s, repos)
+	clk := clock.NewFake(chat.CreatedAt.Add(90 * time.Second))
+	sla := NewSLAService(repos, s.hub, clk)
+
+	policy, err := sla.CreatePolicy(models.SLAPolicyRequest{
+		Name:                 "default",
+		FirstResponseWarning: 60,
+		FirstResponseBreach:  120,
+	})
+	if err != nil {
+		t.Fatalf("CreatePolicy: %v", err)
+	}
+
+	check := func(want ...string) {
+		t.Helper()
+		if err := sla.Check(); err != nil {
+			t.Fatalf("Check: %v", err)
+		}
+		events, err := sla.GetEvents(models.SLAEventFilter{ChatID: chat.ID})
+		if err != nil {
+			t.Fatalf("GetEvents: %v", err)
+		}
+		var levels []string
+		for _, event := range events {
+			if event.PolicyID == nil || *event.PolicyID != policy.ID || event.Metric != slaMetricFirstResponse {
+				t.Errorf("event = %+v", event)
+			}
+			levels = append(levels, event.Level)
+		}
+		if !reflect.DeepEqual(levels, want) {
+			t.Errorf("levels = %q, want %q", levels, want)
+		}
+	}
+
+	check(slaLevelWarning)
+	// Levels are raised once
+	check(slaLevelWarning)
+	clk.Advance(time.Minute)
+	check(slaLevelBreach, slaLevelWarning)
+
+	sendMessage(t, s, chat.ID, agent.ID, "sorry for the wait")
+	chatSLA, err := sla.GetChatSLA(chat.ID, agent.ID, "agent")
+	if err != nil {
+		t.Fatalf("GetChatSLA: %v", err)
+	}
+	if chatSLA.FirstResponse == nil || chatSLA.Policy == nil || chatSLA.Policy.ID != policy.ID || len(chatSLA.Events) != 2 {
+		t.Errorf("chat SLA = %+v", chatSLA)
+	}
+}
+
+func TestValidateSLAPolicy(t *testing.T) {
+	tests := []struct {
+		name    string
+		req     models.SLAPolicyRequest
+		wantErr bool
+	}{
+		{"all set", models.SLAPolicyRequest{FirstResponseWarning: 60, FirstResponseBreach: 120, ResolutionWarning: 900, ResolutionBreach: 1800}, false},
+		{"warning equals breach", models.SLAPolicyRequest{FirstResponseWarning: 120, FirstResponseBreach: 120}, false},
+		{"all disabled", models.SLAPolicyRequest{}, false},
+		{"warning without breach", models.SLAPolicyRequest{FirstResponseWarning: 60, ResolutionWarning: 900}, false},
+		{"breach without warning", models.SLAPolicyRequest{FirstResponseBreach: 120, ResolutionBreach: 1800}, false},
+		{"first response warning after breach", models.SLAPolicyRequest{FirstResponseWarning: 180, FirstResponseBreach: 120}, true},
+		{"resolution warning after breach", models.SLAPolicyRequest{ResolutionWarning: 2000, ResolutionBreach: 1800}, true},
+		{"negative warning", models.SLAPolicyRequest{FirstResponseWarning: -1, FirstResponseBreach: 120}, true},
+		{"negative breach", models.SLAPolicyRequest{ResolutionBreach: -1}, true},
+		{"negative breach below warning", models.SLAPolicyRequest{FirstResponseWarning: -5, FirstResponseBreach: -1}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateSLAPolicy(tt.req)
+			if tt.wantErr && !errors.Is(err, ErrInvalidInput) {
+				t.Errorf("err = %v, want ErrInvalidInput", err)
+			}
+			if !tt.wantErr && err != nil {
+				t.Errorf("err = %v, want nil", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,100 @@
+package services
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"time"
+
+	"cs-socket/internal/models"
+)
+
+const (
+	// maxSyncChanges is the most chats or messages a sync returns. A client
+	// that missed more than that is told to reload instead.
+	maxSyncChanges = 1000
+
+	// syncOverlap moves each cursor back in time, so that changes committed
+	// by transactions that were still running during a sync are picked up by
+	// the next one. Changes in the overlap are sent twice.
+	syncOverlap = 5 * time.Second
+)
+
+// Sync returns the chats and messages the user can see that changed since
+// the cursor of a previous sync, including archived chats and deleted
+// messages, and the cursor for the next sync. Without a cursor, or when too
+// much has changed, the result asks the client to reload everything.
+func (s *ChatService) Sync(userID, role, since string) (*models.SyncResult, error) {
+	// Taken before looking for changes, so that none fall between two syncs
+	now, err := s.repos.Chats.Now()
+	if err != nil {
+		return nil, err
+	}
+	next := now.Add(-syncOverlap)
+
+	result := &models.SyncResult{
+		Chats:    []models.Chat{},
+		Messages: []models.Message{},
+		Cursor:   encodeSyncCursor(next),
+	}
+	if since == "" {
+		result.Reset = true
+		return result, nil
+	}
+
+	sinceTime, err := decodeSyncCursor(since)
+	if err != nil {
+		return nil, err
+	}
+
+	scope := chatScope(userID, role)
+	chats, err := s.repos.Chats.Changed(scope, sinceTime, maxSyncChanges+1)
+	if err != nil {
+		return nil, err
+	}
+	messages, err := s.repos.Messages.Changed(scope, sinceTime, isStaff(role), maxSyncChanges+1)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(chats) > maxSyncChanges || len(messages) > maxSyncChanges {
+		result.Reset = true
+		return result, nil
+	}
+
+	for i := range chats {
+		hideStaffFields(&chats[i], role)
+	}
+	if err := s.attachLastMessages(chats, role); err != nil {
+		return nil, err
+	}
+	if err := s.attachReactions(messages); err != nil {
+		return nil, err
+	}
+	if err := s.attachAttachments(messages); err != nil {
+		return nil, err
+	}
+	hideStaffSeqs(messages, role)
+
+	result.Chats = chats
+	result.Messages = messages
+	return result, nil
+}
+
+// Sync cursors are opaque to clients; they hold a database instant in
+// microseconds.
+func encodeSyncCursor(t time.Time) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatInt(t.UnixMicro(), 10)))
+}
+
+func decodeSyncCursor(cursor string) (time.Time, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%w: invalid sync cursor", ErrInvalidInput)
+	}
+	micros, err := strconv.ParseInt(string(raw), 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%w: invalid sync cursor", ErrInvalidInput)
+	}
+	return time.UnixMicro(micros).UTC(), nil
+}
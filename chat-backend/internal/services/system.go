@@ -0,0 +1,45 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"cs-socket/internal/models"
+	"cs-socket/internal/repository"
+)
+
+// SystemUserID is the sender of the messages the server posts on its own,
+// such as idle nudges and CSAT prompts, so that they are not attributed to an
+// agent.
+const SystemUserID = "00000000-0000-0000-0000-000000000001"
+
+const systemRole = "system"
+
+// EnsureSystemUser creates the system user if it does not exist yet. Its
+// password hash is not a bcrypt hash, so nobody can log in as it.
+func EnsureSystemUser(users repository.UserRepository) error {
+	user, err := users.GetByID(SystemUserID)
+	if err == nil {
+		if user.Role != systemRole {
+			return fmt.Errorf("user %s exists but is not the system user", SystemUserID)
+		}
+		return nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+
+	_, err = users.Create(models.User{
+		ID:       SystemUserID,
+		Username: "system",
+		Email:    "system@localhost",
+		Password: "!",
+		Name:     "System",
+		Role:     systemRole,
+	})
+	if errors.Is(err, repository.ErrDuplicate) {
+		return errors.New("cannot create the system user: the username system or email system@localhost is taken")
+	}
+	return err
+}
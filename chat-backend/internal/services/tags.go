@@ -0,0 +1,172 @@
+package services
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"cs-socket/internal/models"
+	"cs-socket/internal/repository"
+	"cs-socket/internal/websocket"
+)
+
+// tagIntervals are the accepted statistics intervals.
+var tagIntervals = map[string]bool{
+	"day":   true,
+	"week":  true,
+	"month": true,
+}
+
+// GetTags returns the tag catalogue ordered by name. Customers do not see
+// tags.
+func (s *ChatService) GetTags(role string) ([]models.Tag, error) {
+	if !isStaff(role) {
+		return nil, ErrForbidden
+	}
+
+	return s.repos.Tags.List()
+}
+
+func (s *ChatService) CreateTag(role string, req models.TagRequest) (*models.Tag, error) {
+	if role != "super-agent" {
+		return nil, ErrForbidden
+	}
+
+	name, err := tagName(req.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	tag := &models.Tag{Name: name, Description: req.Description, Color: req.Color}
+	if err := s.repos.Tags.Create(tag); err != nil {
+		return nil, tagError(err, name)
+	}
+	return tag, nil
+}
+
+func (s *ChatService) UpdateTag(tagID, role string, req models.TagRequest) (*models.Tag, error) {
+	if role != "super-agent" {
+		return nil, ErrForbidden
+	}
+
+	name, err := tagName(req.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	tag := &models.Tag{ID: tagID, Name: name, Description: req.Description, Color: req.Color}
+	if err := s.repos.Tags.Update(tag); err != nil {
+		return nil, tagError(err, name)
+	}
+	return tag, nil
+}
+
+// DeleteTag removes a tag from the catalogue and from every chat carrying it.
+func (s *ChatService) DeleteTag(tagID, role string) error {
+	if role != "super-agent" {
+		return ErrForbidden
+	}
+
+	return s.repos.Tags.Delete(tagID)
+}
+
+// AddChatTag tags a chat with a catalogue tag. Tagging a chat twice is a
+// no-op. It returns the chat's tags.
+func (s *ChatService) AddChatTag(chatID, tagID, userID, role string) ([]string, error) {
+	chat, err := s.taggableChat(chatID, userID, role)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.repos.Tags.AddToChat(chatID, tagID, userID)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("%w: unknown tag", ErrInvalidInput)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return s.notifyChatTags(chat)
+}
+
+func (s *ChatService) RemoveChatTag(chatID, tagID, userID, role string) ([]string, error) {
+	chat, err := s.taggableChat(chatID, userID, role)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.repos.Tags.RemoveFromChat(chatID, tagID); err != nil {
+		return nil, err
+	}
+
+	return s.notifyChatTags(chat)
+}
+
+// GetTagStats counts the chats tagged per period with each tag, by when the
+// tag was added, so that sudden spikes in a topic stand out even when older
+// chats are tagged. Periods without tagging are omitted.
+func (s *ChatService) GetTagStats(role string, filter models.TagStatsFilter) ([]models.TagCount, error) {
+	if role != "super-agent" {
+		return nil, ErrForbidden
+	}
+
+	if filter.Interval == "" {
+		filter.Interval = "day"
+	}
+	if !tagIntervals[filter.Interval] {
+		return nil, fmt.Errorf("%w: interval must be day, week or month", ErrInvalidInput)
+	}
+
+	return s.repos.Tags.Stats(filter)
+}
+
+// taggableChat loads a chat the caller may tag: staff who can manage it.
+func (s *ChatService) taggableChat(chatID, userID, role string) (*models.Chat, error) {
+	if !isStaff(role) {
+		return nil, ErrForbidden
+	}
+
+	chat, err := s.GetChat(chatID)
+	if err != nil {
+		return nil, err
+	}
+	if !canManageChat(chat, userID, role) {
+		return nil, ErrForbidden
+	}
+	return chat, nil
+}
+
+// notifyChatTags reloads the tags of a chat and sends them to its staff.
+func (s *ChatService) notifyChatTags(chat *models.Chat) ([]string, error) {
+	updated, err := s.GetChat(chat.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	s.hub.BroadcastToUsersAndRole(s.staffParticipants(chat), "super-agent", websocket.Message{
+		Type:   "chat_tags_updated",
+		ChatID: chat.ID,
+		Data: map[string]interface{}{
+			"chatId": chat.ID,
+			"tags":   updated.Tags,
+		},
+	})
+
+	return updated.Tags, nil
+}
+
+func tagName(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", fmt.Errorf("%w: tag name is required", ErrInvalidInput)
+	}
+	return name, nil
+}
+
+// tagError turns a duplicate tag name into ErrConflict.
+func tagError(err error, name string) error {
+	if err == repository.ErrDuplicate {
+		return fmt.Errorf("%w: tag %q already exists", ErrConflict, name)
+	}
+	return err
+}
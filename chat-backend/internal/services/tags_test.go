@@ -0,0 +1,80 @@
+package services
+
+import (
+	"errors"
+	"testing"
+
+	"cs-socket/internal/models"
+)
+
+func TestTags(t *testing.T) {
+	s, repos := newTestChatService(t)
+	chat, _, agent := createChat(t, s, repos)
+	other := createUser(t, repos, "other", "agent")
+
+	if _, err := s.CreateTag("agent", models.TagRequest{Name: "billing"}); !errors.Is(err, ErrForbidden) {
+		t.Errorf("create by an agent: err = %v, want ErrForbidden", err)
+	}
+	billing, err := s.CreateTag("super-agent", models.TagRequest{Name: " billing "})
+	if err != nil {
+		t.Fatalf("CreateTag: %v", err)
+	}
+	if billing.Name != "billing" {
+		t.Errorf("tag name = %q, want billing", billing.Name)
+	}
+	if _, err := s.CreateTag("super-agent", models.TagRequest{Name: "billing"}); !errors.Is(err, ErrConflict) {
+		t.Errorf("duplicate tag: err = %v, want ErrConflict", err)
+	}
+	bug, err := s.CreateTag("super-agent", models.TagRequest{Name: "bug"})
+	if err != nil {
+		t.Fatalf("CreateTag: %v", err)
+	}
+	if _, err := s.UpdateTag(bug.ID, "super-agent", models.TagRequest{Name: "billing"}); !errors.Is(err, ErrConflict) {
+		t.Errorf("renaming onto another tag: err = %v, want ErrConflict", err)
+	}
+
+	if _, err := s.AddChatTag(chat.ID, billing.ID, other.ID, "agent"); !errors.Is(err, ErrForbidden) {
+		t.Errorf("tagging another agent's chat: err = %v, want ErrForbidden", err)
+	}
+	if _, err := s.AddChatTag(chat.ID, "unknown", agent.ID, "agent"); !errors.Is(err, ErrInvalidInput) {
+		t.Errorf("unknown tag: err = %v, want ErrInvalidInput", err)
+	}
+	for _, tag := range []*models.Tag{bug, billing, billing} {
+		if _, err := s.AddChatTag(chat.ID, tag.ID, agent.ID, "agent"); err != nil {
+			t.Fatalf("AddChatTag %s: %v", tag.Name, err)
+		}
+	}
+	loaded, err := s.GetChat(chat.ID)
+	if err != nil {
+		t.Fatalf("GetChat: %v", err)
+	}
+	if len(loaded.Tags) != 2 || loaded.Tags[0] != "billing" || loaded.Tags[1] != "bug" {
+		t.Errorf("chat tags = %v, want [billing bug]", loaded.Tags)
+	}
+
+	stats, err := s.GetTagStats("super-agent", models.TagStatsFilter{Interval: "week"})
+	if err != nil {
+		t.Fatalf("GetTagStats: %v", err)
+	}
+	if len(stats) != 2 || stats[0].Count != 1 || stats[1].Count != 1 {
+		t.Errorf("tag stats = %+v, want one chat for each tag", stats)
+	}
+	if _, err := s.GetTagStats("super-agent", models.TagStatsFilter{Interval: "year"}); !errors.Is(err, ErrInvalidInput) {
+		t.Errorf("unknown interval: err = %v, want ErrInvalidInput", err)
+	}
+
+	tags, err := s.RemoveChatTag(chat.ID, bug.ID, agent.ID, "agent")
+	if err != nil {
+		t.Fatalf("RemoveChatTag: %v", err)
+	}
+	if len(tags) != 1 || tags[0] != "billing" {
+		t.Errorf("tags after removing bug = %v, want [billing]", tags)
+	}
+
+	if err := s.DeleteTag(billing.ID, "super-agent"); err != nil {
+		t.Fatalf("DeleteTag: %v", err)
+	}
+	if loaded, _ := s.GetChat(chat.ID); len(loaded.Tags) != 0 {
+		t.Errorf("chat tags after deleting the tag = %v, want none", loaded.Tags)
+	}
+}
@@ -1,74 +1,64 @@
 package services
 
 import (
-	"database/sql"
+	"fmt"
 
 	"cs-socket/internal/models"
+	"cs-socket/internal/repository"
 )
 
 type UserService struct {
-	db *sql.DB
+	users repository.UserRepository
 }
 
-func NewUserService(db *sql.DB) *UserService {
+func NewUserService(users repository.UserRepository) *UserService {
 	return &UserService{
-		db: db,
+		users: users,
 	}
 }
 
+// GetUsers lists every user for super-agents, and only staff for others.
 func (s *UserService) GetUsers(role string) ([]models.User, error) {
-	var query string
-	var args []interface{}
+	return s.users.List(role != "admin" && role != "super-agent")
+}
 
-	if role == "admin" || role == "super-agent" {
-		query = `SELECT id, username, email, name, role, avatar, is_online, created_at, updated_at 
-				 FROM users ORDER BY name`
-	} else {
-		query = `SELECT id, username, email, name, role, avatar, is_online, created_at, updated_at 
-				 FROM users WHERE role IN ('agent', 'super-agent') ORDER BY name`
-	}
+func (s *UserService) UpdateUserStatus(userID string, isOnline bool) error {
+	return s.users.SetOnline(userID, isOnline)
+}
 
-	rows, err := s.db.Query(query, args...)
-	if err != nil {
-		return nil, err
+func (s *UserService) GetUserByID(userID string) (*models.User, error) {
+	return s.users.GetByID(userID)
+}
+
+// UpdateTier changes a customer's tier. Only super-agents may do this.
+func (s *UserService) UpdateTier(userID, tier, role string) (*models.User, error) {
+	if role != "super-agent" {
+		return nil, ErrForbidden
 	}
-	defer rows.Close()
 
-	var users []models.User
-	for rows.Next() {
-		var user models.User
-		err := rows.Scan(
-			&user.ID, &user.Username, &user.Email, &user.Name,
-			&user.Role, &user.Avatar, &user.IsOnline, &user.CreatedAt, &user.UpdatedAt,
-		)
-		if err != nil {
-			return nil, err
-		}
-		users = append(users, user)
+	switch tier {
+	case models.TierRegular, models.TierVIP, models.TierHighRoller:
+	default:
+		return nil, fmt.Errorf("%w: unknown tier %q", ErrInvalidInput, tier)
 	}
 
-	return users, nil
-}
+	if err := s.users.SetTier(userID, tier); err != nil {
+		return nil, err
+	}
 
-func (s *UserService) UpdateUserStatus(userID string, isOnline bool) error {
-	query := `UPDATE users SET is_online = $1, updated_at = CURRENT_TIMESTAMP WHERE id = $2`
-	_, err := s.db.Exec(query, isOnline, userID)
-	return err
+	return s.GetUserByID(userID)
 }
 
-func (s *UserService) GetUserByID(userID string) (*models.User, error) {
-	var user models.User
-	query := `SELECT id, username, email, name, role, avatar, is_online, created_at, updated_at 
-			  FROM users WHERE id = $1`
-
-	err := s.db.QueryRow(query, userID).Scan(
-		&user.ID, &user.Username, &user.Email, &user.Name,
-		&user.Role, &user.Avatar, &user.IsOnline, &user.CreatedAt, &user.UpdatedAt,
-	)
+// UpdateTeam assigns an agent or super-agent to a team, or removes them from
+// their team when team is nil. Only super-agents may do this.
+func (s *UserService) UpdateTeam(userID string, team *string, role string) (*models.User, error) {
+	if role != "super-agent" {
+		return nil, ErrForbidden
+	}
 
-	if err != nil {
+	if err := s.users.SetTeam(userID, team); err != nil {
 		return nil, err
 	}
 
-	return &user, nil
+	return s.GetUserByID(userID)
 }
@@ -0,0 +1,105 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+
+	"cs-socket/internal/models"
+	"cs-socket/internal/repository"
+)
+
+func TestGetUsers(t *testing.T) {
+	repos := repository.NewMemory()
+	createUser(t, repos, "carol", "customer")
+	createUser(t, repos, "bob", "agent")
+	createUser(t, repos, "alice", "super-agent")
+	// The system user is never listed
+	if err := EnsureSystemUser(repos.Users); err != nil {
+		t.Fatalf("EnsureSystemUser: %v", err)
+	}
+	s := NewUserService(repos.Users)
+
+	for _, tc := range []struct {
+		role string
+		want []string
+	}{
+		{"super-agent", []string{"alice", "bob", "carol"}},
+		{"agent", []string{"alice", "bob"}},
+		{"customer", []string{"alice", "bob"}},
+	} {
+		t.Run(tc.role, func(t *testing.T) {
+			users, err := s.GetUsers(tc.role)
+			if err != nil {
+				t.Fatalf("GetUsers: %v", err)
+			}
+			var names []string
+			for _, user := range users {
+				names = append(names, user.Name)
+			}
+			if len(names) != len(tc.want) {
+				t.Fatalf("names = %v, want %v", names, tc.want)
+			}
+			for i := range names {
+				if names[i] != tc.want[i] {
+					t.Fatalf("names = %v, want %v", names, tc.want)
+				}
+			}
+		})
+	}
+}
+
+func TestUpdateTier(t *testing.T) {
+	repos := repository.NewMemory()
+	customer := createUser(t, repos, "carol", "customer")
+	agent := createUser(t, repos, "bob", "agent")
+	s := NewUserService(repos.Users)
+
+	if _, err := s.UpdateTier(customer.ID, models.TierVIP, "agent"); err != ErrForbidden {
+		t.Errorf("agent: err = %v, want ErrForbidden", err)
+	}
+	if _, err := s.UpdateTier(customer.ID, "gold", "super-agent"); !errors.Is(err, ErrInvalidInput) {
+		t.Errorf("unknown tier: err = %v, want ErrInvalidInput", err)
+	}
+	if _, err := s.UpdateTier(agent.ID, models.TierVIP, "super-agent"); err != sql.ErrNoRows {
+		t.Errorf("agent tier: err = %v, want sql.ErrNoRows", err)
+	}
+
+	user, err := s.UpdateTier(customer.ID, models.TierVIP, "super-agent")
+	if err != nil {
+		t.Fatalf("UpdateTier: %v", err)
+	}
+	if user.Tier != models.TierVIP {
+		t.Errorf("tier = %q, want %q", user.Tier, models.TierVIP)
+	}
+}
+
+func TestUpdateTeam(t *testing.T) {
+	repos := repository.NewMemory()
+	customer := createUser(t, repos, "carol", "customer")
+	agent := createUser(t, repos, "bob", "agent")
+	s := NewUserService(repos.Users)
+	team := "payments"
+
+	if _, err := s.UpdateTeam(agent.ID, &team, "agent"); err != ErrForbidden {
+		t.Errorf("agent: err = %v, want ErrForbidden", err)
+	}
+	if _, err := s.UpdateTeam(customer.ID, &team, "super-agent"); err != sql.ErrNoRows {
+		t.Errorf("customer team: err = %v, want sql.ErrNoRows", err)
+	}
+
+	user, err := s.UpdateTeam(agent.ID, &team, "super-agent")
+	if err != nil {
+		t.Fatalf("UpdateTeam: %v", err)
+	}
+	if user.Team == nil || *user.Team != team {
+		t.Errorf("team = %v, want %q", user.Team, team)
+	}
+
+	if user, err = s.UpdateTeam(agent.ID, nil, "super-agent"); err != nil {
+		t.Fatalf("UpdateTeam: %v", err)
+	}
+	if user.Team != nil {
+		t.Errorf("team = %q, want none", *user.Team)
+	}
+}
@@ -0,0 +1,104 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLocalRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	l, err := NewLocal(filepath.Join(t.TempDir(), "uploads"))
+	if err != nil {
+		t.Fatalf("NewLocal: %v", err)
+	}
+
+	const key = "attachments/2026/03/file.txt"
+	for _, content := range []string{"first", "second, longer than the first"} {
+		if err := l.Put(ctx, key, strings.NewReader(content), int64(len(content)), "text/plain"); err != nil {
+			t.Fatalf("Put: %v", err)
+		}
+		if got := readObject(t, l, key); got != content {
+			t.Errorf("Get = %q, want %q", got, content)
+		}
+	}
+
+	// No temporary files are left behind
+	entries, err := os.ReadDir(filepath.Dir(l.path(key)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("directory holds %d entries, want only the object", len(entries))
+	}
+
+	if err := l.Delete(ctx, key); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, err := l.Get(ctx, key); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get after Delete: err = %v, want ErrNotFound", err)
+	}
+	if err := l.Delete(ctx, key); err != nil {
+		t.Errorf("Delete of a missing object: %v", err)
+	}
+}
+
+func TestLocalInvalidKeys(t *testing.T) {
+	ctx := context.Background()
+	parent := t.TempDir()
+	l, err := NewLocal(filepath.Join(parent, "uploads"))
+	if err != nil {
+		t.Fatalf("NewLocal: %v", err)
+	}
+	// A file outside the root that a bad key could reach
+	secret := filepath.Join(parent, "secret.txt")
+	if err := os.WriteFile(secret, []byte("secret"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	keys := []string{
+		"", "../secret.txt", "a/../../secret.txt", "..", ".", "a/./b", "a/..",
+		"/etc/passwd", "a//b", "a/", `..\secret.txt`, "a b", "a?b", "%2e%2e/secret.txt",
+	}
+	for _, key := range keys {
+		if err := l.Put(ctx, key, strings.NewReader("x"), 1, "text/plain"); err == nil {
+			t.Errorf("Put(%q) succeeded", key)
+		}
+		if _, err := l.Get(ctx, key); err == nil || errors.Is(err, ErrNotFound) {
+			t.Errorf("Get(%q): err = %v, want an invalid key error", key, err)
+		}
+		if err := l.Delete(ctx, key); err == nil {
+			t.Errorf("Delete(%q) succeeded", key)
+		}
+	}
+
+	if data, err := os.ReadFile(secret); err != nil || string(data) != "secret" {
+		t.Errorf("file outside the root was changed: %q, %v", data, err)
+	}
+	entries, err := os.ReadDir(parent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Errorf("parent directory holds %d entries, want the root and the secret", len(entries))
+	}
+}
+
+func readObject(t *testing.T, s Storage, key string) string {
+	t.Helper()
+
+	r, err := s.Get(context.Background(), key)
+	if err != nil {
+		t.Fatalf("Get(%q): %v", key, err)
+	}
+	defer r.Close()
+	data, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("reading %q: %v", key, err)
+	}
+	return string(data)
+}
@@ -0,0 +1,51 @@
+package storage
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestURLSigner(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 500, time.UTC)
+	s := NewURLSigner("test-secret", 15*time.Minute)
+
+	const resource = "att-1/small"
+	expires, signature := s.Sign(resource, now)
+	if want := time.Date(2026, 3, 1, 12, 15, 0, 0, time.UTC); !expires.Equal(want) {
+		t.Fatalf("expires = %v, want %v", expires, want)
+	}
+	unix := strconv.FormatInt(expires.Unix(), 10)
+	tampered := []byte(signature)
+	tampered[0] ^= 1
+
+	tests := []struct {
+		name              string
+		signer            *URLSigner
+		resource, expires string
+		signature         string
+		now               time.Time
+		valid             bool
+	}{
+		{"valid", s, resource, unix, signature, now, true},
+		{"at expiry", s, resource, unix, signature, expires, true},
+		{"expired", s, resource, unix, signature, expires.Add(time.Second), false},
+		{"tampered signature", s, resource, unix, string(tampered), now, false},
+		{"truncated signature", s, resource, unix, signature[:len(signature)-2], now, false},
+		{"empty signature", s, resource, unix, "", now, false},
+		{"extended expiry", s, resource, strconv.FormatInt(expires.Unix()+3600, 10), signature, now, false},
+		{"expiry not a number", s, resource, "soon", signature, now, false},
+		{"original of the thumbnail", s, "att-1", unix, signature, now, false},
+		{"other variant", s, "att-1/large", unix, signature, now, false},
+		{"other attachment", s, "att-2/small", unix, signature, now, false},
+		{"other secret", NewURLSigner("other-secret", 15*time.Minute), resource, unix, signature, now, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.signer.Verify(tt.resource, tt.expires, tt.signature, tt.now); got != tt.valid {
+				t.Errorf("Verify = %v, want %v", got, tt.valid)
+			}
+		})
+	}
+}
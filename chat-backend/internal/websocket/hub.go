@@ -4,6 +4,10 @@ import (
 	"encoding/json"
 	"log"
 	"net/http"
+	"sync"
+	"time"
+
+	"cs-socket/internal/config"
 
 	"github.com/gorilla/websocket"
 )
@@ -15,11 +19,21 @@ var upgrader = websocket.Upgrader{
 }
 
 type Hub struct {
+	// clients is written by Run and read by the Broadcast methods, which
+	// services call from their own goroutines
 	clients          map[*Client]bool
+	clientsMu        sync.Mutex
 	broadcast        chan []byte
 	register         chan *Client
 	unregister       chan *Client
 	statusUpdateFunc func(userID string, isOnline bool) error
+	handlers         map[string]InboundHandler
+	config           config.WebSocketConfig
+
+	// observers maps a chat ID to the users silently watching it. Observers
+	// receive staff-only chat events but are never announced to the customer.
+	observers   map[string]map[string]bool
+	observersMu sync.RWMutex
 }
 
 type Client struct {
@@ -31,6 +45,9 @@ type Client struct {
 	role     string
 }
 
+// InboundHandler processes a message of a registered type sent by a client.
+type InboundHandler func(userID, role string, msg Message)
+
 type Message struct {
 	Type     string      `json:"type"`
 	Data     interface{} `json:"data"`
@@ -39,12 +56,15 @@ type Message struct {
 	ChatID   string      `json:"chatId,omitempty"`
 }
 
-func NewHub() *Hub {
+func NewHub(cfg config.WebSocketConfig) *Hub {
 	return &Hub{
+		config:     cfg,
 		clients:    make(map[*Client]bool),
 		broadcast:  make(chan []byte),
 		register:   make(chan *Client),
 		unregister: make(chan *Client),
+		handlers:   make(map[string]InboundHandler),
+		observers:  make(map[string]map[string]bool),
 	}
 }
 
@@ -52,11 +72,21 @@ func (h *Hub) SetStatusUpdateFunc(fn func(userID string, isOnline bool) error) {
 	h.statusUpdateFunc = fn
 }
 
+// HandleFunc registers a handler for client messages of the given type.
+// Such messages are passed to the handler; messages of unregistered types
+// are dropped.
+// Handlers must be registered before the hub accepts connections.
+func (h *Hub) HandleFunc(msgType string, handler InboundHandler) {
+	h.handlers[msgType] = handler
+}
+
 func (h *Hub) Run() {
 	for {
 		select {
 		case client := <-h.register:
+			h.clientsMu.Lock()
 			h.clients[client] = true
+			h.clientsMu.Unlock()
 			log.Printf("Client connected: %s (%s)", client.username, client.userID)
 
 			// Update user status in database
@@ -78,9 +108,16 @@ func (h *Hub) Run() {
 			h.broadcastToOthers(client, notification)
 
 		case client := <-h.unregister:
-			if _, ok := h.clients[client]; ok {
+			h.clientsMu.Lock()
+			_, ok := h.clients[client]
+			if ok {
 				delete(h.clients, client)
 				close(client.send)
+			}
+			connected := h.isConnected(client.userID)
+			h.clientsMu.Unlock()
+
+			if ok {
 				log.Printf("Client disconnected: %s (%s)", client.username, client.userID)
 
 				// Update user status in database
@@ -88,6 +125,11 @@ func (h *Hub) Run() {
 					h.statusUpdateFunc(client.userID, false)
 				}
 
+				// Stop observing once the user's last connection is gone
+				if !connected {
+					h.removeObserver(client.userID)
+				}
+
 				// Notify other clients about the disconnection
 				notification := Message{
 					Type:     "user_disconnected",
@@ -103,75 +145,185 @@ func (h *Hub) Run() {
 			}
 
 		case message := <-h.broadcast:
-			for client := range h.clients {
-				select {
-				case client.send <- message:
-				default:
-					close(client.send)
-					delete(h.clients, client)
-				}
-			}
+			h.deliver(func(*Client) []byte { return message })
 		}
 	}
 }
 
-func (h *Hub) broadcastToOthers(sender *Client, message Message) {
+// deliver sends every client the data payload returns for it, skipping
+// clients it returns nil for. Clients whose send buffer is full are dropped.
+func (h *Hub) deliver(payload func(client *Client) []byte) {
+	h.clientsMu.Lock()
+	defer h.clientsMu.Unlock()
+
+	for client := range h.clients {
+		data := payload(client)
+		if data == nil {
+			continue
+		}
+		select {
+		case client.send <- data:
+		default:
+			close(client.send)
+			delete(h.clients, client)
+		}
+	}
+}
+
+// sendWhere sends a message to the clients for which match is true.
+func (h *Hub) sendWhere(message Message, match func(client *Client) bool) {
 	data, err := json.Marshal(message)
 	if err != nil {
 		log.Printf("Error marshaling message: %v", err)
 		return
 	}
 
-	for client := range h.clients {
-		if client != sender {
-			select {
-			case client.send <- data:
-			default:
-				close(client.send)
-				delete(h.clients, client)
-			}
+	h.deliver(func(client *Client) []byte {
+		if match(client) {
+			return data
 		}
-	}
+		return nil
+	})
 }
 
-func (h *Hub) BroadcastToChat(chatID string, message Message) {
-	data, err := json.Marshal(message)
+func (h *Hub) broadcastToOthers(sender *Client, message Message) {
+	h.sendWhere(message, func(client *Client) bool { return client != sender })
+}
+
+// BroadcastToChat sends a message to every connection of the given
+// participants of a chat and of the users observing it.
+func (h *Hub) BroadcastToChat(chatID string, participants []string, message Message) {
+	recipients := h.chatRecipients(chatID, participants)
+	h.sendWhere(message, func(client *Client) bool { return recipients[client.userID] })
+}
+
+// BroadcastToChatSplit is BroadcastToChat for events that carry details only
+// staff may see: agents and super-agents are sent staff, everyone else
+// customers.
+func (h *Hub) BroadcastToChatSplit(chatID string, participants []string, staff, customers Message) {
+	staffData, err := json.Marshal(staff)
+	if err != nil {
+		log.Printf("Error marshaling message: %v", err)
+		return
+	}
+	customerData, err := json.Marshal(customers)
 	if err != nil {
 		log.Printf("Error marshaling message: %v", err)
 		return
 	}
 
-	message.ChatID = chatID
-	// Broadcast to all clients for now - in a real implementation,
-	// you would filter by chat participants, but this ensures
-	// all users see updated chat lists when messages are sent
-	for client := range h.clients {
-		select {
-		case client.send <- data:
-		default:
-			close(client.send)
-			delete(h.clients, client)
+	recipients := h.chatRecipients(chatID, participants)
+	h.deliver(func(client *Client) []byte {
+		switch {
+		case !recipients[client.userID]:
+			return nil
+		case client.role == "agent" || client.role == "super-agent":
+			return staffData
 		}
+		return customerData
+	})
+}
+
+// chatRecipients returns the set of the given participants and the
+// observers of chatID.
+func (h *Hub) chatRecipients(chatID string, participants []string) map[string]bool {
+	recipients := make(map[string]bool, len(participants))
+	for _, id := range participants {
+		recipients[id] = true
+	}
+	for _, id := range h.Observers(chatID) {
+		recipients[id] = true
 	}
+	return recipients
 }
 
 func (h *Hub) BroadcastToUser(userID string, message Message) {
-	data, err := json.Marshal(message)
-	if err != nil {
-		log.Printf("Error marshaling message: %v", err)
-		return
+	h.sendWhere(message, func(client *Client) bool { return client.userID == userID })
+}
+
+// BroadcastToUsers sends a message to every connection of the given users.
+// Duplicate IDs are ignored.
+func (h *Hub) BroadcastToUsers(userIDs []string, message Message) {
+	recipients := make(map[string]bool, len(userIDs))
+	for _, id := range userIDs {
+		recipients[id] = true
 	}
 
+	h.sendWhere(message, func(client *Client) bool { return recipients[client.userID] })
+}
+
+// BroadcastToRole sends a message to every connected user with the given
+// role.
+func (h *Hub) BroadcastToRole(role string, message Message) {
+	h.sendWhere(message, func(client *Client) bool { return client.role == role })
+}
+
+// BroadcastToUsersAndRole sends a message once to every connection of the
+// given users and of every user with the given role.
+func (h *Hub) BroadcastToUsersAndRole(userIDs []string, role string, message Message) {
+	recipients := make(map[string]bool, len(userIDs))
+	for _, id := range userIDs {
+		recipients[id] = true
+	}
+
+	h.sendWhere(message, func(client *Client) bool { return recipients[client.userID] || client.role == role })
+}
+
+// Observe registers userID as a silent observer of chatID.
+func (h *Hub) Observe(chatID, userID string) {
+	h.observersMu.Lock()
+	defer h.observersMu.Unlock()
+
+	if h.observers[chatID] == nil {
+		h.observers[chatID] = make(map[string]bool)
+	}
+	h.observers[chatID][userID] = true
+}
+
+// Unobserve removes userID from the observers of chatID.
+func (h *Hub) Unobserve(chatID, userID string) {
+	h.observersMu.Lock()
+	defer h.observersMu.Unlock()
+
+	delete(h.observers[chatID], userID)
+	if len(h.observers[chatID]) == 0 {
+		delete(h.observers, chatID)
+	}
+}
+
+// Observers returns the IDs of the users currently observing chatID.
+func (h *Hub) Observers(chatID string) []string {
+	h.observersMu.RLock()
+	defer h.observersMu.RUnlock()
+
+	ids := make([]string, 0, len(h.observers[chatID]))
+	for id := range h.observers[chatID] {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+func (h *Hub) removeObserver(userID string) {
+	h.observersMu.Lock()
+	defer h.observersMu.Unlock()
+
+	for chatID, users := range h.observers {
+		delete(users, userID)
+		if len(users) == 0 {
+			delete(h.observers, chatID)
+		}
+	}
+}
+
+// isConnected reports whether userID has a connection left. The caller must
+// hold clientsMu.
+func (h *Hub) isConnected(userID string) bool {
 	for client := range h.clients {
 		if client.userID == userID {
-			select {
-			case client.send <- data:
-			default:
-				close(client.send)
-				delete(h.clients, client)
-			}
+			return true
 		}
 	}
+	return false
 }
 
 func (c *Client) readPump() {
@@ -180,6 +332,13 @@ func (c *Client) readPump() {
 		c.conn.Close()
 	}()
 
+	// Clients that stop answering pings are dropped
+	c.conn.SetReadLimit(c.hub.config.MaxMessageSize)
+	c.conn.SetReadDeadline(time.Now().Add(c.hub.config.PongTimeout))
+	c.conn.SetPongHandler(func(string) error {
+		return c.conn.SetReadDeadline(time.Now().Add(c.hub.config.PongTimeout))
+	})
+
 	for {
 		_, messageBytes, err := c.conn.ReadMessage()
 		if err != nil {
@@ -189,29 +348,43 @@ func (c *Client) readPump() {
 			break
 		}
 
-		var msg Message
-		if err := json.Unmarshal(messageBytes, &msg); err != nil {
-			log.Printf("Error unmarshaling message: %v", err)
-			continue
-		}
+		c.dispatch(messageBytes)
+	}
+}
 
-		// Set sender information
-		msg.UserID = c.userID
-		msg.Username = c.username
+// dispatch passes a message from the client to the handler of its type.
+// Messages of other types are dropped: clients may only send what a service
+// has registered for, never relay arbitrary frames to other users.
+func (c *Client) dispatch(messageBytes []byte) {
+	var msg Message
+	if err := json.Unmarshal(messageBytes, &msg); err != nil {
+		log.Printf("Error unmarshaling message: %v", err)
+		return
+	}
 
-		// Broadcast the message
-		if data, err := json.Marshal(msg); err == nil {
-			c.hub.broadcast <- data
-		}
+	handler, ok := c.hub.handlers[msg.Type]
+	if !ok {
+		log.Printf("Dropping message of unknown type %q from %s", msg.Type, c.userID)
+		return
 	}
+
+	// Set sender information
+	msg.UserID = c.userID
+	msg.Username = c.username
+	handler(c.userID, c.role, msg)
 }
 
 func (c *Client) writePump() {
-	defer c.conn.Close()
+	ticker := time.NewTicker(c.hub.config.PingInterval)
+	defer func() {
+		ticker.Stop()
+		c.conn.Close()
+	}()
 
 	for {
 		select {
 		case message, ok := <-c.send:
+			c.conn.SetWriteDeadline(time.Now().Add(c.hub.config.WriteTimeout))
 			if !ok {
 				c.conn.WriteMessage(websocket.CloseMessage, []byte{})
 				return
@@ -221,6 +394,12 @@ func (c *Client) writePump() {
 				log.Printf("WebSocket write error: %v", err)
 				return
 			}
+
+		case <-ticker.C:
+			c.conn.SetWriteDeadline(time.Now().Add(c.hub.config.WriteTimeout))
+			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
 		}
 	}
 }
@@ -229,7 +408,7 @@ func (h *Hub) HandleWebSocket(conn *websocket.Conn, userID, username, role strin
 	client := &Client{
 		hub:      h,
 		conn:     conn,
-		send:     make(chan []byte, 256),
+		send:     make(chan []byte, h.config.SendBuffer),
 		userID:   userID,
 		username: username,
 		role:     role,
@@ -0,0 +1,159 @@
+package websocket
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"testing"
+
+	"cs-socket/internal/config"
+)
+
+func newTestClient(h *Hub, userID, role string) *Client {
+	return &Client{hub: h, send: make(chan []byte, 256), userID: userID, username: userID, role: role}
+}
+
+// drain returns the types of the messages a client was sent.
+func drain(c *Client) []string {
+	var types []string
+	for {
+		select {
+		case data, ok := <-c.send:
+			if !ok {
+				return types
+			}
+			var msg Message
+			json.Unmarshal(data, &msg)
+			types = append(types, msg.Type)
+		default:
+			return types
+		}
+	}
+}
+
+// TestHubConcurrentBroadcasts registers and unregisters clients while other
+// goroutines broadcast, as services do. Run it with -race.
+func TestHubConcurrentBroadcasts(t *testing.T) {
+	h := NewHub(config.WebSocketConfig{})
+	go h.Run()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				c := newTestClient(h, fmt.Sprintf("user-%d-%d", i, j), "agent")
+				h.register <- c
+				h.BroadcastToChat("chat", []string{c.userID}, Message{Type: "new_message"})
+				h.BroadcastToChatSplit("chat", []string{c.userID}, Message{Type: "staff"}, Message{Type: "customer"})
+				h.BroadcastToUser(c.userID, Message{Type: "direct"})
+				h.BroadcastToUsers([]string{c.userID}, Message{Type: "direct"})
+				h.BroadcastToRole("agent", Message{Type: "role"})
+				h.BroadcastToUsersAndRole([]string{c.userID}, "super-agent", Message{Type: "mixed"})
+				h.unregister <- c
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestHubBroadcastToChatSplit(t *testing.T) {
+	h := NewHub(config.WebSocketConfig{})
+	go h.Run()
+
+	agent := newTestClient(h, "agent", "agent")
+	customer := newTestClient(h, "customer", "customer")
+	h.register <- agent
+	h.register <- customer
+	// The hub has handled both registrations once it takes the next message
+	h.broadcast <- []byte(`{"type":"sync"}`)
+	drain(agent)
+	drain(customer)
+
+	h.BroadcastToChatSplit("chat", []string{"agent", "customer"}, Message{Type: "staff"}, Message{Type: "customer"})
+	if got := drain(agent); len(got) != 1 || got[0] != "staff" {
+		t.Errorf("agent got %v, want the staff message", got)
+	}
+	if got := drain(customer); len(got) != 1 || got[0] != "customer" {
+		t.Errorf("customer got %v, want the customer message", got)
+	}
+}
+
+func TestHubBroadcastToChatParticipants(t *testing.T) {
+	h := NewHub(config.WebSocketConfig{})
+	go h.Run()
+
+	customerA := newTestClient(h, "customer-a", "customer")
+	agentA := newTestClient(h, "agent-a", "agent")
+	customerB := newTestClient(h, "customer-b", "customer")
+	agentB := newTestClient(h, "agent-b", "agent")
+	observer := newTestClient(h, "observer", "super-agent")
+	clients := []*Client{customerA, agentA, customerB, agentB, observer}
+	for _, c := range clients {
+		h.register <- c
+	}
+	h.broadcast <- []byte(`{"type":"sync"}`)
+	for _, c := range clients {
+		drain(c)
+	}
+	h.Observe("chat-a", observer.userID)
+
+	participants := []string{customerA.userID, agentA.userID}
+	h.BroadcastToChat("chat-a", participants, Message{Type: "new_message", ChatID: "chat-a"})
+	h.BroadcastToChatSplit("chat-a", participants, Message{Type: "staff"}, Message{Type: "customer"})
+
+	want := map[*Client][]string{
+		customerA: {"new_message", "customer"},
+		agentA:    {"new_message", "staff"},
+		observer:  {"new_message", "staff"},
+	}
+	for _, c := range clients {
+		if got := drain(c); fmt.Sprint(got) != fmt.Sprint(want[c]) {
+			t.Errorf("%s got %v, want %v", c.userID, got, want[c])
+		}
+	}
+}
+
+func TestHubDropsSlowClients(t *testing.T) {
+	h := NewHub(config.WebSocketConfig{})
+	go h.Run()
+
+	slow := &Client{hub: h, send: make(chan []byte), userID: "slow", role: "customer"}
+	h.register <- slow
+	h.broadcast <- []byte(`{"type":"sync"}`)
+
+	h.BroadcastToUser("slow", Message{Type: "direct"})
+	if _, ok := <-slow.send; ok {
+		t.Error("a client that could not take a message was not dropped")
+	}
+	// Its connection still unregisters without closing send again
+	h.unregister <- slow
+	h.broadcast <- []byte(`{"type":"sync"}`)
+}
+
+func TestClientDispatch(t *testing.T) {
+	h := NewHub(config.WebSocketConfig{})
+	// Without Run, anything relayed to everyone stays in the channel
+	h.broadcast = make(chan []byte, 1)
+
+	var handled []Message
+	h.HandleFunc("typing", func(userID, role string, msg Message) {
+		if userID != "carol" || role != "customer" {
+			t.Errorf("handler called for %s (%s)", userID, role)
+		}
+		handled = append(handled, msg)
+	})
+	c := newTestClient(h, "carol", "customer")
+
+	c.dispatch([]byte(`{"type":"typing","chatId":"chat","userId":"someone-else"}`))
+	c.dispatch([]byte(`{"type":"new_message","chatId":"chat","data":{"content":"spoofed"}}`))
+	c.dispatch([]byte(`not json`))
+
+	if len(handled) != 1 || handled[0].ChatID != "chat" || handled[0].UserID != "carol" {
+		t.Errorf("handled = %+v, want the typing message from carol", handled)
+	}
+	if len(h.broadcast) != 0 {
+		t.Errorf("a message of an unregistered type was relayed: %s", <-h.broadcast)
+	}
+}
@@ -1,14 +1,20 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"log"
-	"os"
+	"net/http"
 
+	"cs-socket/internal/clock"
 	"cs-socket/internal/config"
 	"cs-socket/internal/database"
 	"cs-socket/internal/handlers"
 	"cs-socket/internal/middleware"
+	"cs-socket/internal/repository"
+	"cs-socket/internal/scanner"
 	"cs-socket/internal/services"
+	"cs-socket/internal/storage"
 	"cs-socket/internal/websocket"
 
 	"github.com/gin-contrib/cors"
@@ -16,8 +22,14 @@ import (
 )
 
 func main() {
+	configFile := flag.String("config", "", "YAML or TOML config file (default $CONFIG_FILE)")
+	flag.Parse()
+
 	// Load configuration
-	cfg := config.Load()
+	cfg, err := config.Load(*configFile)
+	if err != nil {
+		log.Fatal(err)
+	}
 
 	// Connect to database
 	db, err := database.Connect(cfg.Database)
@@ -31,31 +43,83 @@ func main() {
 		log.Fatal("Failed to run migrations:", err)
 	}
 
-	// Seed database with initial data
-	if err := database.SeedDatabase(db); err != nil {
+	// Seed database with initial data, except in release mode
+	if err := database.SeedDatabase(db, cfg.Server); err != nil {
 		log.Printf("Warning: Failed to seed database: %v", err)
 	}
 
 	// Initialize WebSocket hub
-	hub := websocket.NewHub()
+	hub := websocket.NewHub(cfg.WebSocket)
 	go hub.Run()
 
+	// Attachment storage and signed download URLs
+	store, err := storage.New(cfg.Storage)
+	if err != nil {
+		log.Fatal("Failed to initialize storage:", err)
+	}
+	signer := storage.NewURLSigner(cfg.Attachments.URLSecret, cfg.Attachments.URLTTL)
+
 	// Initialize services
-	authService := services.NewAuthService(db, cfg.JWT.Secret)
-	chatService := services.NewChatService(db, hub)
-	userService := services.NewUserService(db)
+	repos := repository.NewPostgres(db)
+	authService := services.NewAuthService(repos.Users, cfg.JWT.Secret)
+	chatService := services.NewChatService(repos, hub, cfg.Priority, cfg.Messages, signer)
+	userService := services.NewUserService(repos.Users)
+	slaService := services.NewSLAService(repos, hub, clock.Real{})
+	csatService := services.NewCSATService(repos, hub)
+	cannedService := services.NewCannedService(chatService)
+
+	// Quarantine uploads until clamd has scanned them
+	var scanMonitor *services.ScanMonitor
+	if cfg.Scanner.Enabled {
+		clamd, err := scanner.NewClamd(cfg.Scanner.ClamdAddress, cfg.Scanner.Timeout)
+		if err != nil {
+			log.Fatal("Failed to initialize scanner:", err)
+		}
+		if err := clamd.Ping(context.Background()); err != nil {
+			log.Printf("Warning: clamd is not reachable, uploads stay quarantined until it is: %v", err)
+		}
+		scanMonitor = services.NewScanMonitor(chatService, store, clamd, cfg.Scanner)
+		go scanMonitor.Run(context.Background())
+	}
+	if !cfg.Scanner.Enabled && !cfg.Attachments.ServeUnscanned {
+		log.Println("Warning: SCANNER_ENABLED is off and ATTACHMENT_SERVE_UNSCANNED is false, so uploaded files cannot be downloaded")
+	}
+	attachmentService := services.NewAttachmentService(chatService, store, scanMonitor, cfg.Attachments)
 
 	// Set status update function for the hub
 	hub.SetStatusUpdateFunc(authService.UpdateUserStatus)
 
+	// Ratings can also be submitted over the socket
+	hub.HandleFunc("csat_submit", csatService.HandleSocketSubmit)
+
+	// Idle nudges and CSAT prompts are sent by the system user
+	if err := services.EnsureSystemUser(repos.Users); err != nil {
+		log.Fatal("Failed to create the system user:", err)
+	}
+
+	// Nudge and auto-resolve chats whose customer went quiet
+	if cfg.Idle.Enabled {
+		idleMonitor := services.NewIdleMonitor(chatService, cfg.Idle, clock.Real{})
+		go idleMonitor.Run(context.Background())
+	}
+
+	// Raise SLA warnings and breaches for open chats
+	if cfg.SLA.Enabled {
+		go slaService.Run(context.Background(), cfg.SLA.Interval)
+	}
+
 	// Initialize handlers
 	authHandler := handlers.NewAuthHandler(authService)
 	chatHandler := handlers.NewChatHandler(chatService)
 	userHandler := handlers.NewUserHandler(userService)
+	slaHandler := handlers.NewSLAHandler(slaService)
+	csatHandler := handlers.NewCSATHandler(csatService)
+	cannedHandler := handlers.NewCannedHandler(cannedService)
+	attachmentHandler := handlers.NewAttachmentHandler(attachmentService)
 	wsHandler := handlers.NewWebSocketHandler(hub, authService)
 
 	// Setup Gin
-	if cfg.Server.Mode == "production" {
+	if cfg.Server.Release() {
 		gin.SetMode(gin.ReleaseMode)
 	}
 	router := gin.Default()
@@ -79,20 +143,32 @@ func main() {
 	{
 		// Auth routes (public)
 		auth := api.Group("/auth")
+		if cfg.RateLimit.Enabled {
+			auth.Use(middleware.RateLimit(cfg.RateLimit.Auth, middleware.ByClientIP))
+		}
 		{
 			auth.POST("/login", authHandler.Login)
 			auth.POST("/register", authHandler.Register)
 			auth.POST("/logout", authHandler.Logout)
 		}
 
+		// File downloads are authorised by the signature in the URL
+		api.GET("/files/:id", attachmentHandler.Download)
+
 		// Protected routes (require authentication)
 		protected := api.Group("/")
 		protected.Use(middleware.AuthMiddleware(cfg.JWT.Secret))
+		if cfg.RateLimit.Enabled {
+			protected.Use(middleware.RateLimit(cfg.RateLimit.API, middleware.ByUser))
+		}
 		{
 			// User routes
 			protected.GET("/users/me", userHandler.GetMe)
 			protected.GET("/users", userHandler.GetUsers)
 			protected.PUT("/users/status", userHandler.UpdateStatus)
+			protected.GET("/users/me/mentions", chatHandler.GetMentions)
+			protected.PUT("/users/:id/tier", userHandler.UpdateTier)
+			protected.PUT("/users/:id/team", userHandler.UpdateTeam)
 
 			// Chat routes
 			chats := protected.Group("/chats")
@@ -102,12 +178,85 @@ func main() {
 				chats.GET("/:id", chatHandler.GetChat)
 				chats.POST("/:id/messages", chatHandler.SendMessage)
 				chats.GET("/:id/messages", chatHandler.GetMessages)
+				chats.POST("/:id/read", chatHandler.MarkRead)
+				chats.PUT("/:id/messages/:messageId", chatHandler.EditMessage)
+				chats.DELETE("/:id/messages/:messageId", chatHandler.DeleteMessage)
+				chats.GET("/:id/messages/:messageId/history", chatHandler.GetMessageHistory)
+				chats.POST("/:id/messages/:messageId/reactions", chatHandler.AddReaction)
+				chats.DELETE("/:id/messages/:messageId/reactions/:emoji", chatHandler.RemoveReaction)
+				chats.POST("/:id/whisper", chatHandler.SendWhisper)
+				chats.POST("/:id/notes", chatHandler.AddNote)
+				chats.POST("/:id/observe", chatHandler.ObserveChat)
+				chats.DELETE("/:id/observe", chatHandler.UnobserveChat)
 				chats.PUT("/:id/status", chatHandler.UpdateChatStatus)
+				chats.GET("/:id/status-history", chatHandler.GetStatusHistory)
+				chats.GET("/:id/sla", slaHandler.GetChatSLA)
+				chats.POST("/:id/priority/escalate", chatHandler.EscalatePriority)
+				chats.POST("/:id/priority/deescalate", chatHandler.DeescalatePriority)
+				chats.POST("/:id/escalate", chatHandler.EscalateChat)
+				chats.POST("/:id/csat", csatHandler.SubmitRating)
+				chats.POST("/:id/tags", chatHandler.AddChatTag)
+				chats.DELETE("/:id/tags/:tagId", chatHandler.RemoveChatTag)
+				chats.POST("/:id/attachments", attachmentHandler.Upload)
+				chats.GET("/:id/attachments/:attachmentId", attachmentHandler.GetAttachment)
+				chats.POST("/:id/canned-responses", cannedHandler.SendResponse)
+				chats.GET("/:id/canned-responses/:responseId/preview", cannedHandler.PreviewResponse)
 				chats.DELETE("/:id", chatHandler.DeleteChat)
 				chats.PUT("/:id/archive", chatHandler.ArchiveChat)
 				chats.PUT("/:id/unarchive", chatHandler.UnarchiveChat)
 			}
 
+			// Changes since a cursor, for reconnecting clients
+			protected.GET("/sync", chatHandler.Sync)
+
+			// Full-text search over messages
+			protected.GET("/search/messages", chatHandler.SearchMessages)
+
+			// Queue of unassigned chats, highest priority first
+			protected.GET("/queue", chatHandler.GetQueue)
+			protected.POST("/queue/next", chatHandler.TakeNextChat)
+
+			// Escalations to super-agents
+			escalations := protected.Group("/escalations")
+			{
+				escalations.GET("", chatHandler.GetEscalations)
+				escalations.POST("/:id/claim", chatHandler.ClaimEscalation)
+				escalations.POST("/:id/resolve", chatHandler.ResolveEscalation)
+			}
+
+			// SLA policies and reporting
+			sla := protected.Group("/sla")
+			{
+				sla.GET("/policies", slaHandler.GetPolicies)
+				sla.POST("/policies", slaHandler.CreatePolicy)
+				sla.PUT("/policies/:id", slaHandler.UpdatePolicy)
+				sla.DELETE("/policies/:id", slaHandler.DeletePolicy)
+				sla.GET("/breaches", slaHandler.GetBreaches)
+			}
+
+			// Tag catalogue and tag statistics
+			tags := protected.Group("/tags")
+			{
+				tags.GET("", chatHandler.GetTags)
+				tags.POST("", chatHandler.CreateTag)
+				tags.GET("/stats", chatHandler.GetTagStats)
+				tags.PUT("/:id", chatHandler.UpdateTag)
+				tags.DELETE("/:id", chatHandler.DeleteTag)
+			}
+
+			// Canned response library
+			canned := protected.Group("/canned-responses")
+			{
+				canned.GET("", cannedHandler.GetResponses)
+				canned.POST("", cannedHandler.CreateResponse)
+				canned.GET("/stats", cannedHandler.GetStats)
+				canned.PUT("/:id", cannedHandler.UpdateResponse)
+				canned.DELETE("/:id", cannedHandler.DeleteResponse)
+			}
+
+			// Customer satisfaction reporting
+			protected.GET("/csat/summary", csatHandler.GetSummary)
+
 			// Archived chats route
 			protected.GET("/archived-chats", chatHandler.GetArchivedChats)
 
@@ -127,12 +276,16 @@ func main() {
 		c.JSON(200, gin.H{"status": "ok"})
 	})
 
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = cfg.Server.Port
+	server := &http.Server{
+		Addr:              ":" + cfg.Server.Port,
+		Handler:           router,
+		ReadHeaderTimeout: cfg.Server.ReadHeaderTimeout,
+		ReadTimeout:       cfg.Server.ReadTimeout,
+		WriteTimeout:      cfg.Server.WriteTimeout,
+		IdleTimeout:       cfg.Server.IdleTimeout,
 	}
 
-	log.Printf("CS Socket Server starting on port %s", port)
+	log.Printf("CS Socket Server starting on port %s", cfg.Server.Port)
 	log.Printf("Environment: %s", cfg.Server.Mode)
-	log.Fatal(router.Run(":" + port))
+	log.Fatal(server.ListenAndServe())
 }